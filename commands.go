@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/archive"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/integrity"
+	"github.com/adrianliechti/wingman-chat/pkg/migrate"
+
+	"gopkg.in/yaml.v3"
+)
+
+func runMigrations(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print what the pending migrations would change")
+	status := fs.Bool("status", false, "list applied and pending migrations")
+	fs.Parse(args)
+
+	m := migrate.FromEnv()
+
+	if m == nil {
+		return errors.New("no store to migrate; set KEYS_PATH, CONNECTIONS_PATH or APIKEYS_PATH")
+	}
+
+	if !*status {
+		return m.Up(*dryRun, os.Stdout)
+	}
+
+	applied, err := m.Applied()
+
+	if err != nil {
+		return err
+	}
+
+	for _, r := range applied {
+		fmt.Printf("%d %s: applied %s\n", r.Version, r.Name, r.Applied.Format(time.RFC3339))
+	}
+
+	pending, err := m.Pending()
+
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range pending {
+		fmt.Printf("%d %s: pending\n", mig.Version, mig.Name)
+	}
+
+	return nil
+}
+
+func runArchive(args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New("usage: archive verify [-prev hash] file...")
+	}
+
+	fs := flag.NewFlagSet("archive verify", flag.ExitOnError)
+	prev := fs.String("prev", "", "hash of the record preceding the first file (empty for the first file ever)")
+	fs.Parse(args[1:])
+
+	hash := *prev
+
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+
+		if err != nil {
+			return err
+		}
+
+		last, n, err := archive.Verify(f, hash)
+		f.Close()
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		fmt.Printf("%s: %d records ok, last hash %s\n", path, n, last)
+
+		hash = last
+	}
+
+	return nil
+}
+
+func runIntegrity(args []string) error {
+	fs := flag.NewFlagSet("integrity", flag.ExitOnError)
+	dir := fs.String("dir", "dist", "directory of the built frontend")
+	check := fs.Bool("check", false, "verify the assets against the manifest instead of writing it")
+	fs.Parse(args)
+
+	dist := os.DirFS(*dir)
+
+	if *check {
+		_, problems := integrity.Check(dist)
+
+		for _, err := range problems {
+			fmt.Println(err)
+		}
+
+		if len(problems) > 0 {
+			return fmt.Errorf("%d integrity problems", len(problems))
+		}
+
+		return nil
+	}
+
+	manifest, err := integrity.Generate(dist)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(*dir, integrity.ManifestName), append(data, '\n'), 0o644)
+}
+
+func validate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Parse(args)
+
+	cfg, errs := config.Read()
+	errs = append(errs, config.Validate(cfg)...)
+
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(errs))
+	}
+
+	fmt.Println("configuration is valid")
+	return nil
+}
+
+func printConfig(args []string) error {
+	fs := flag.NewFlagSet("print-config", flag.ExitOnError)
+	format := fs.String("format", "yaml", "output format (yaml or json)")
+	public := fs.Bool("public", false, "print only what is served as /config.json")
+	fs.Parse(args)
+
+	cfg := config.Load()
+
+	switch {
+	case *public:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cfg)
+
+	case *format == "json":
+		// Round-trip through YAML so server-side fields hidden from /config.json
+		// are included as well.
+		data, err := yaml.Marshal(cfg)
+
+		if err != nil {
+			return err
+		}
+
+		var v any
+
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case *format == "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(cfg)
+	}
+
+	return fmt.Errorf("unknown format %q", *format)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listen returns the listener to serve on. A socket passed in by systemd socket
+// activation wins; otherwise LISTEN_ADDR is used ("host:port" or "unix:/path"),
+// falling back to all interfaces on PORT (default 8000).
+func listen() (net.Listener, error) {
+	if l, err := activationListener(); l != nil || err != nil {
+		return l, err
+	}
+
+	addr := os.Getenv("LISTEN_ADDR")
+
+	if addr == "" {
+		port := os.Getenv("PORT")
+
+		if port == "" {
+			port = "8000"
+		}
+
+		addr = ":" + port
+	}
+
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// A socket file left behind by a previous run would make bind fail.
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// activationListener picks up the first socket handed over by systemd
+// (sd_listen_fds), or returns nil when the process was not socket activated.
+//
+// https://www.freedesktop.org/software/systemd/man/latest/sd_listen_fds.html
+func activationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+
+	if err != nil || fds < 1 {
+		return nil, errors.New("LISTEN_FDS is not set or invalid")
+	}
+
+	// Activated sockets start at fd 3, right after stdin, stdout and stderr.
+	f := os.NewFile(3, "LISTEN_FD_3")
+	defer f.Close()
+
+	return net.FileListener(f)
+}
@@ -1,42 +1,581 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
 
+	"github.com/adrianliechti/wingman-chat/pkg/alert"
+	"github.com/adrianliechti/wingman-chat/pkg/anomaly"
+	"github.com/adrianliechti/wingman-chat/pkg/apikeys"
+	"github.com/adrianliechti/wingman-chat/pkg/archive"
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/azure"
+	"github.com/adrianliechti/wingman-chat/pkg/balancer"
+	"github.com/adrianliechti/wingman-chat/pkg/breaker"
+	"github.com/adrianliechti/wingman-chat/pkg/canary"
 	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/config/kube"
+	"github.com/adrianliechti/wingman-chat/pkg/config/kv"
+	"github.com/adrianliechti/wingman-chat/pkg/connections"
+	"github.com/adrianliechti/wingman-chat/pkg/cors"
+	"github.com/adrianliechti/wingman-chat/pkg/degrade"
+	"github.com/adrianliechti/wingman-chat/pkg/discovery"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/federation"
+	"github.com/adrianliechti/wingman-chat/pkg/guest"
+	"github.com/adrianliechti/wingman-chat/pkg/injection"
+	"github.com/adrianliechti/wingman-chat/pkg/integrity"
+	"github.com/adrianliechti/wingman-chat/pkg/keys"
+	"github.com/adrianliechti/wingman-chat/pkg/metrics"
+	"github.com/adrianliechti/wingman-chat/pkg/migrate"
+	"github.com/adrianliechti/wingman-chat/pkg/moderation"
+	"github.com/adrianliechti/wingman-chat/pkg/queue"
+	"github.com/adrianliechti/wingman-chat/pkg/realtime"
+	"github.com/adrianliechti/wingman-chat/pkg/redact"
+	"github.com/adrianliechti/wingman-chat/pkg/replica"
+	"github.com/adrianliechti/wingman-chat/pkg/secrets"
+	"github.com/adrianliechti/wingman-chat/pkg/security"
 	"github.com/adrianliechti/wingman-chat/pkg/server"
+	"github.com/adrianliechti/wingman-chat/pkg/server/admin"
+	"github.com/adrianliechti/wingman-chat/pkg/server/api"
+	"github.com/adrianliechti/wingman-chat/pkg/server/health"
+	"github.com/adrianliechti/wingman-chat/pkg/server/issues"
+	"github.com/adrianliechti/wingman-chat/pkg/statuspage"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+	"github.com/adrianliechti/wingman-chat/pkg/tokens"
 )
 
+// version is set at build time via -ldflags "-X main.version=...".
+var version = ""
+
+const usage = `Usage: server [command] [flags]
+
+Commands:
+  serve          run the HTTP server (default)
+  validate       check the configuration files and exit
+  print-config   print the effective configuration
+  migrate        apply pending store migrations (-dry-run, -status)
+  archive        verify archive files (archive verify [-prev hash] file...)
+  integrity      write the checksum manifest of the frontend assets (-dir, -check)
+  version        print the version
+`
+
 func main() {
-	cfg := config.Load()
+	command := "serve"
+	args := os.Args[1:]
+
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve", "validate", "print-config", "migrate":
+		if err := resolveSecrets(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var err error
+
+	switch command {
+	case "serve":
+		err = serve(args)
+	case "validate":
+		err = validate(args)
+	case "print-config":
+		err = printConfig(args)
+	case "migrate":
+		err = runMigrations(args)
+	case "archive":
+		err = runArchive(args)
+	case "integrity":
+		err = runIntegrity(args)
+	case "version":
+		fmt.Println(buildVersion())
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Parse(args)
+
+	// Background work stops when the server shuts down.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	probes := health.New()
+
+	if migrations := migrate.FromEnv(); migrations != nil {
+		if os.Getenv("MIGRATE_ON_STARTUP") != "false" {
+			if err := migrations.Up(false, os.Stdout); err != nil {
+				return err
+			}
+		}
+
+		probes.Startup("migrations", func(context.Context) error {
+			return migrations.Check()
+		})
+	}
+
+	store := config.NewStore(config.Load())
+
+	if err := store.KeepHistory(env.Int("CONFIG_HISTORY_SIZE", 10), os.Getenv("CONFIG_HISTORY_PATH")); err != nil {
+		return err
+	}
+
+	if backend, prefix := kv.FromEnv(); backend != nil {
+		kv.Watch(ctx, backend, prefix, store)
+	}
+
+	peer, err := federation.FromEnv(ctx, store)
+
+	if err != nil {
+		return err
+	}
+
+	node, err := replica.FromEnv(ctx, store, buildVersion())
+
+	if err != nil {
+		return err
+	}
+
+	var replicas *replica.Registry
+
+	if node == nil {
+		replicas = replica.NewRegistry()
+	}
+
+	url, platformTokens, err := upstream(ctx, node)
+
+	if err != nil {
+		return err
+	}
+
+	if err := config.TuneTransport(); err != nil {
+		return err
+	}
+
+	transport, err := config.PlatformTransport()
+
+	if err != nil {
+		return err
+	}
+
+	// A replica talks to its hub, which balances and adapts requests to the
+	// platform.
+	if node == nil {
+		if b := balancer.FromEnv(ctx, transport); b != nil {
+			probes.Upstream("replicas", b.Check, func() any { return b.Backends() })
+			transport = b
+		}
+
+		if os.Getenv("UPSTREAM_TYPE") == "azure" {
+			transport = azure.Transport(transport, os.Getenv("AZURE_API_VERSION"))
+		}
+	}
+
+	transport = platformTokens.Transport(transport)
+
+	discovery.FromEnv(ctx, store, url, platformTokens.Token, transport)
+
+	adminTokens := admin.TokensFromEnv()
 
-	url := config.PlatformURL()
-	token := config.PlatformToken()
+	dist, err := assets(probes)
 
+	if err != nil {
+		return err
+	}
+
+	kubeClient, selector, err := kube.FromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	// Tenants may as well come from Kubernetes only.
+	tenants, err := tenant.FromEnv(store.Config(), kubeClient != nil)
+
+	if err != nil {
+		return err
+	}
+
+	if kubeClient != nil {
+		w := kube.Watch(ctx, kubeClient, selector, store, tenants)
+		probes.Startup("kubernetes", w.Synced)
+	}
+
+	if _, err := auth.PublicURL(); err != nil {
+		return err
+	}
+
+	login, sessions, err := auth.LoginFromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	proxy, err := auth.ProxyFromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	bearer, err := auth.BearerFromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	// Keys and guest links belong to users, who must not be able to pass for
+	// another.
+	authenticated := login != nil || bearer != nil || proxy != nil
+
+	keyStore, err := keys.FromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	if keyStore != nil && !authenticated {
+		return errors.New("KEYS_SECRET: stored keys need users to authenticate; configure a login, JWT_ISSUER, AUTH_HEADER or AUTH_TRUSTED_PROXIES")
+	}
+
+	var apiKeys *apikeys.Store
+
+	if len(adminTokens[admin.RoleAdmin]) > 0 {
+		if apiKeys, err = apikeys.FromEnv(); err != nil {
+			return err
+		}
+	}
+
+	guests, err := guest.FromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	if guests != nil && !authenticated {
+		return errors.New("GUEST_LINKS: minting links needs users to authenticate; configure a login, JWT_ISSUER, AUTH_HEADER or AUTH_TRUSTED_PROXIES")
+	}
+
+	recorder, err := archive.FromEnv(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	notifier := alert.New(os.Getenv("ALERT_WEBHOOK_URL"))
+
+	monitor, err := security.FromEnv(notifier)
+
+	if err != nil {
+		return err
+	}
+
+	anomalies, err := anomaly.FromEnv(notifier)
+
+	if err != nil {
+		return err
+	}
+
+	canaries, err := canary.FromEnv(monitor)
+
+	if err != nil {
+		return err
+	}
+
+	moderator, err := moderation.FromEnv(url.String(), platformTokens.Token, transport)
+
+	if err != nil {
+		return err
+	}
+
+	screener, err := injection.FromEnv(monitor)
+
+	if err != nil {
+		return err
+	}
+
+	redactor, err := redact.FromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	status, err := statuspage.FromEnv(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if status != nil {
+		probes.Upstream("providers", status.Check, func() any { return status.Incidents() })
+	}
+
+	bodyLimit, err := api.BodyLimitFromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	responseCache, err := api.CacheFromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	corsPolicy, err := cors.FromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	conns, err := connections.FromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	smoothing, _ := time.ParseDuration(os.Getenv("STREAM_SMOOTHING"))
+	keepAlive, _ := time.ParseDuration(os.Getenv("STREAM_KEEPALIVE"))
+
+	handler, err := server.New(store, server.Options{
+		Prefix: env.String("PREFIX", "/api"),
+
+		URL:    url,
+		Tokens: platformTokens,
+
+		Transport: transport,
+
+		AdminTokens: adminTokens,
+
+		Dist: dist,
+
+		SkillsDir:   env.String("SKILLS_PATH", "skills"),
+		NotebookDir: env.String("NOTEBOOKS_PATH", "notebook"),
+
+		Tenants: tenants,
+
+		Login:    login,
+		Sessions: sessions,
+		Devices:  auth.DevicesFromEnv(),
+		Proxy:    proxy,
+		Bearer:   bearer,
+
+		Keys:      keyStore,
+		KeyHeader: os.Getenv("KEYS_HEADER"),
+
+		GeoIPHeader:    os.Getenv("GEOIP_HEADER"),
+		PlatformRegion: os.Getenv("WINGMAN_REGION"),
+
+		RateLimit: api.RateLimitFromEnv(),
+		Archive:   recorder,
+		Anomalies: anomalies,
+		Canaries:  canaries,
+		Moderator: moderator,
+		Injection: screener,
+		Security:  monitor,
+		Redactor:  redactor,
+
+		JSONRetries: env.Int("JSON_REPAIR_RETRIES", 0),
+		Realtime:    realtime.New(realtime.ConfigFromEnv()),
+
+		StreamSmoothing: smoothing,
+		StreamKeepAlive: keepAlive,
+
+		Retry:   api.RetryFromEnv(),
+		Breaker: breaker.FromEnv(),
+
+		BodyLimit: bodyLimit,
+
+		Cache: responseCache,
+		Queue: queue.FromEnv(),
+
+		Metrics: metrics.New(),
+
+		Status:      status,
+		Degradation: degrade.FromEnv(ctx, store, degrade.PlatformModels(url.String(), platformTokens.Token, transport)),
+
+		Headers: api.HeaderPolicyFromEnv(),
+
+		CORS: corsPolicy,
+
+		APIKeys: apiKeys,
+
+		Connections: conns,
+		Guests:      guests,
+
+		Peer: peer,
+
+		Replicas: replicas,
+		Replica:  node,
+
+		Health: probes,
+
+		Issues: issues.ConfigFromEnv(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, redirect, err := serverTLS()
+
+	if err != nil {
+		return err
+	}
+
+	l, err := listen()
+
+	if err != nil {
+		return err
+	}
+
+	s := server.TimeoutsFromEnv().Server(handler)
+
+	if tlsConfig != nil {
+		if addr := os.Getenv("TLS_REDIRECT_ADDR"); addr != "" {
+			go func() {
+				if err := http.ListenAndServe(addr, redirect); err != nil {
+					slog.Error("tls: redirect listener", "error", err)
+				}
+			}()
+		}
+
+		s.TLSConfig = tlsConfig
+	}
+
+	errs := make(chan error, 1)
+
+	go func() {
+		if tlsConfig == nil {
+			errs <- s.Serve(l)
+		} else {
+			errs <- s.ServeTLS(l, "", "")
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Requests in flight get a grace period to finish.
+	shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.Shutdown(shutdown)
+}
+
+// resolveSecrets replaces references to secrets managers in the environment
+// with the secrets. The platform tokens and TLS material are written to files
+// instead, renewed every SECRETS_REFRESH (default 5m), which are read again
+// on use.
+func resolveSecrets() error {
+	for _, key := range []string{"WINGMAN_TOKEN", "OPENAI_API_KEY"} {
+		if v := os.Getenv(key); secrets.IsRef(v) && os.Getenv("WINGMAN_TOKEN_FILE") == "" {
+			os.Setenv("WINGMAN_TOKEN_FILE", v)
+			os.Unsetenv(key)
+		}
+	}
+
+	files := []string{"WINGMAN_TOKEN_FILE", "WINGMAN_TLS_CERT", "WINGMAN_TLS_KEY", "WINGMAN_TLS_CA", "TLS_CERT", "TLS_KEY"}
+
+	return secrets.FromEnv().Resolve(context.Background(), files, os.Getenv("SECRETS_PATH"), env.Duration("SECRETS_REFRESH", 5*time.Minute))
+}
+
+// upstream returns the API the proxy forwards to and its tokens: the hub
+// for a replica, the platform otherwise.
+func upstream(ctx context.Context, node *replica.Node) (*url.URL, *tokens.Tokens, error) {
+	if node != nil {
+		return node.URL, tokens.New(node.Token), nil
+	}
+
+	t, err := tokens.FromEnv(ctx)
+	return config.PlatformURL(), t, err
+}
+
+// assets returns the frontend assets after verifying them: problems are
+// logged and fail the startup probe, or startup itself with
+// ASSETS_VERIFY=strict. ASSETS_SRI=true adds SRI hashes to index.html.
+func assets(probes *health.Handler) (fs.FS, error) {
 	dist := os.DirFS("dist")
 
-	port := os.Getenv("PORT")
-	prefix := os.Getenv("PREFIX")
+	if os.Getenv("ASSETS_VERIFY") == "off" {
+		return dist, nil
+	}
+
+	manifest, problems := integrity.Check(dist)
 
-	if port == "" {
-		port = "8000"
+	for _, err := range problems {
+		slog.Warn("assets: integrity", "error", err)
 	}
 
-	if prefix == "" {
-		prefix = "/api"
+	if len(problems) > 0 {
+		err := fmt.Errorf("assets: %d integrity problems, first: %w", len(problems), problems[0])
+
+		if os.Getenv("ASSETS_VERIFY") == "strict" {
+			return nil, err
+		}
+
+		probes.Startup("assets", func(context.Context) error {
+			return err
+		})
 	}
 
-	skillsDir := os.Getenv("SKILLS_PATH")
-	if skillsDir == "" {
-		skillsDir = "skills"
+	if os.Getenv("ASSETS_SRI") == "true" {
+		withSRI, err := integrity.WithSRI(dist, manifest)
+
+		if err != nil {
+			slog.Warn("assets: sri", "error", err)
+			return dist, nil
+		}
+
+		return withSRI, nil
 	}
 
-	notebookDir := os.Getenv("NOTEBOOKS_PATH")
-	if notebookDir == "" {
-		notebookDir = "notebook"
+	return dist, nil
+}
+
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+
+		if v := info.Main.Version; v != "" {
+			return v
+		}
 	}
 
-	handler := server.New(cfg, prefix, url, token, dist, skillsDir, notebookDir)
-	http.ListenAndServe(":"+port, handler)
+	return "dev"
 }
@@ -0,0 +1,97 @@
+// Package alert reports conditions operators should look at: it logs them
+// and posts them to a webhook.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// timeout bounds a webhook delivery.
+const timeout = 10 * time.Second
+
+type Alert struct {
+	Type    string `json:"type"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+
+	Time    time.Time      `json:"time"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Notifier delivers alerts in the background.
+type Notifier struct {
+	client *http.Client
+	url    string
+}
+
+// New posts alerts to url when it is set. The payload also carries the
+// message as "text", which Slack and Teams incoming webhooks display.
+func New(url string) *Notifier {
+	return &Notifier{
+		client: &http.Client{Timeout: timeout},
+		url:    url,
+	}
+}
+
+func (n *Notifier) Notify(a Alert) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+
+	slog.Warn("alert", "type", a.Type, "subject", a.Subject, "message", a.Message)
+
+	if n == nil || n.url == "" {
+		return
+	}
+
+	go func() {
+		if err := n.post(a); err != nil {
+			slog.Error("alert: webhook", "error", err)
+		}
+	}()
+}
+
+func (n *Notifier) post(a Alert) error {
+	body, err := json.Marshal(struct {
+		Alert
+		Text string `json:"text"`
+	}{a, "[" + a.Type + "] " + a.Message})
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("webhook error (%d): %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+// Package anomaly flags unusual usage per caller that may point to leaked
+// credentials or data exfiltration: token spikes against the caller's own
+// baseline, bulk requests outside business hours and mass downloads from
+// drives. Usage is counted in hourly windows; every finding is alerted once
+// per caller and hour.
+package anomaly
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/alert"
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// alpha weighs the last hour in the baseline of hourly tokens.
+const alpha = 0.1
+
+// forget is how long callers without usage are kept.
+const forget = 7 * 24 * time.Hour
+
+type Config struct {
+	// SpikeFactor flags hours using that many times the caller's usual
+	// tokens; MinTokens is the least tokens an hour must use to be flagged.
+	SpikeFactor float64
+	MinTokens   int
+
+	// BusinessStart and BusinessEnd bound the business hours of weekdays
+	// in Location; more than OffHoursRequests an hour outside them are
+	// flagged.
+	BusinessStart    int
+	BusinessEnd      int
+	Location         *time.Location
+	OffHoursRequests int
+
+	// Downloads is the number of drive downloads an hour that is flagged.
+	Downloads int
+}
+
+// DefaultConfig flags 5x spikes above 100k tokens, more than 100 requests
+// an hour outside 7-19 on weekdays, and more than 200 downloads an hour.
+func DefaultConfig() Config {
+	return Config{
+		SpikeFactor: 5,
+		MinTokens:   100_000,
+
+		BusinessStart:    7,
+		BusinessEnd:      19,
+		Location:         time.Local,
+		OffHoursRequests: 100,
+
+		Downloads: 200,
+	}
+}
+
+// FromEnv returns the detector with ANOMALY_DETECTION=true, reading the
+// ANOMALY_* thresholds over the defaults, or nil otherwise.
+func FromEnv(notifier *alert.Notifier) (*Detector, error) {
+	if !env.Bool("ANOMALY_DETECTION") {
+		return nil, nil
+	}
+
+	cfg := DefaultConfig()
+
+	if v, err := strconv.ParseFloat(os.Getenv("ANOMALY_SPIKE_FACTOR"), 64); err == nil && v > 0 {
+		cfg.SpikeFactor = v
+	}
+
+	cfg.MinTokens = env.Int("ANOMALY_MIN_TOKENS", cfg.MinTokens)
+	cfg.OffHoursRequests = env.Int("ANOMALY_OFF_HOURS_REQUESTS", cfg.OffHoursRequests)
+	cfg.Downloads = env.Int("ANOMALY_DOWNLOADS", cfg.Downloads)
+
+	if hours := os.Getenv("ANOMALY_BUSINESS_HOURS"); hours != "" {
+		start, end, _ := strings.Cut(hours, "-")
+
+		s, err1 := strconv.Atoi(start)
+		e, err2 := strconv.Atoi(end)
+
+		if err1 != nil || err2 != nil || s < 0 || e > 24 || s >= e {
+			return nil, fmt.Errorf("invalid ANOMALY_BUSINESS_HOURS %q, expected e.g. 7-19", hours)
+		}
+
+		cfg.BusinessStart, cfg.BusinessEnd = s, e
+	}
+
+	if tz := os.Getenv("ANOMALY_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Location = loc
+	}
+
+	return New(cfg, notifier), nil
+}
+
+type usage struct {
+	hour time.Time
+
+	tokens    int
+	requests  int
+	downloads int
+
+	// baseline is the moving average of hourly tokens before this hour.
+	baseline float64
+
+	alerted map[string]bool
+}
+
+type Detector struct {
+	config   Config
+	notifier *alert.Notifier
+
+	mu      sync.Mutex
+	callers map[string]*usage
+}
+
+func New(config Config, notifier *alert.Notifier) *Detector {
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+
+	return &Detector{
+		config:   config,
+		notifier: notifier,
+
+		callers: map[string]*usage{},
+	}
+}
+
+// Caller names the caller of r in alerts: the user, or the device or client
+// address of anonymous requests, within its tenant.
+func Caller(r *http.Request) string {
+	var prefix string
+
+	if t := tenant.FromContext(r.Context()); t != nil {
+		prefix = t.ID + "/"
+	}
+
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		return prefix + user.ID
+	}
+
+	if device := auth.DeviceFromContext(r.Context()); device != "" {
+		return prefix + "device:" + device
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return prefix + host
+}
+
+// Request counts a model request of caller.
+func (d *Detector) Request(caller string) {
+	d.observe(caller, func(u *usage, now time.Time) {
+		u.requests++
+
+		if !d.businessHours(now) && d.config.OffHoursRequests > 0 && u.requests > d.config.OffHoursRequests {
+			d.alert(u, caller, "off_hours", fmt.Sprintf("%s made %d model requests within an hour outside business hours", caller, u.requests), map[string]any{
+				"requests":  u.requests,
+				"threshold": d.config.OffHoursRequests,
+			})
+		}
+	})
+}
+
+// Tokens counts tokens used by caller.
+func (d *Detector) Tokens(caller string, tokens int) {
+	d.observe(caller, func(u *usage, now time.Time) {
+		u.tokens += tokens
+
+		threshold := max(float64(d.config.MinTokens), d.config.SpikeFactor*u.baseline)
+
+		if d.config.MinTokens > 0 && float64(u.tokens) > threshold {
+			d.alert(u, caller, "token_spike", fmt.Sprintf("%s used %d tokens within an hour, usually %.0f", caller, u.tokens, u.baseline), map[string]any{
+				"tokens":    u.tokens,
+				"baseline":  int(u.baseline),
+				"threshold": int(threshold),
+			})
+		}
+	})
+}
+
+// Download counts a file caller downloaded.
+func (d *Detector) Download(caller string) {
+	d.observe(caller, func(u *usage, now time.Time) {
+		u.downloads++
+
+		if d.config.Downloads > 0 && u.downloads > d.config.Downloads {
+			d.alert(u, caller, "mass_download", fmt.Sprintf("%s downloaded %d files within an hour", caller, u.downloads), map[string]any{
+				"downloads": u.downloads,
+				"threshold": d.config.Downloads,
+			})
+		}
+	})
+}
+
+// Watch counts the drive downloads below prefix.
+func (d *Detector) Watch(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/") + "/v1/drives/"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, prefix) && strings.HasSuffix(r.URL.Path, "/content") {
+			d.Download(Caller(r))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Detector) observe(caller string, f func(u *usage, now time.Time)) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	hour := now.Truncate(time.Hour)
+
+	u := d.callers[caller]
+
+	if u == nil {
+		u = &usage{hour: hour, alerted: map[string]bool{}}
+		d.callers[caller] = u
+
+		d.prune(now)
+	}
+
+	// Fold the past hours, idle ones included, into the baseline.
+	for i := 0; u.hour.Before(hour) && i < 24*7; i++ {
+		u.baseline = alpha*float64(u.tokens) + (1-alpha)*u.baseline
+
+		u.hour = u.hour.Add(time.Hour)
+		u.tokens, u.requests, u.downloads = 0, 0, 0
+		clear(u.alerted)
+	}
+
+	u.hour = hour
+
+	f(u, now)
+}
+
+func (d *Detector) prune(now time.Time) {
+	for caller, u := range d.callers {
+		if now.Sub(u.hour) > forget {
+			delete(d.callers, caller)
+		}
+	}
+}
+
+func (d *Detector) alert(u *usage, caller, kind, message string, details map[string]any) {
+	if u.alerted[kind] {
+		return
+	}
+
+	u.alerted[kind] = true
+
+	d.notifier.Notify(alert.Alert{
+		Type:    kind,
+		Subject: caller,
+		Message: message,
+
+		Details: details,
+	})
+}
+
+func (d *Detector) businessHours(t time.Time) bool {
+	t = t.In(d.config.Location)
+
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+
+	return t.Hour() >= d.config.BusinessStart && t.Hour() < d.config.BusinessEnd
+}
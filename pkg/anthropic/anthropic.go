@@ -0,0 +1,246 @@
+// Package anthropic serves OpenAI chat completions from Anthropic models,
+// through the Messages API or AWS Bedrock: requests, answers and streams are
+// translated on the way, so clients of the OpenAI API don't notice.
+package anthropic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Version is the Messages API version requested unless the client sets one.
+const Version = "2023-06-01"
+
+// Transport sends chat completions to the Messages API below the base URL of
+// the requests (everything before /v1), passing bearer tokens as API key.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return &adapter{
+		next:   next,
+		events: serverEvents,
+
+		request: func(out *http.Request, base string, body map[string]any) error {
+			data, err := json.Marshal(body)
+
+			if err != nil {
+				return err
+			}
+
+			setBody(out, data)
+
+			out.URL.Path = base + "/v1/messages"
+
+			if out.Header.Get("anthropic-version") == "" {
+				out.Header.Set("anthropic-version", Version)
+			}
+
+			if token, ok := strings.CutPrefix(out.Header.Get("Authorization"), "Bearer "); ok {
+				out.Header.Del("Authorization")
+				out.Header.Set("x-api-key", token)
+			}
+
+			return nil
+		},
+	}
+}
+
+// adapter translates chat completions into Messages requests and back.
+type adapter struct {
+	next http.RoundTripper
+
+	// request points out at the Messages API of the service and sets the
+	// translated body.
+	request func(out *http.Request, base string, body map[string]any) error
+
+	// events reads the events of a streamed answer.
+	events func(r io.Reader) func() ([]byte, error)
+}
+
+func (a *adapter) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+
+	base, op, _ := strings.Cut(r.URL.Path, "/v1/")
+
+	if r.Method != http.MethodPost || op != "chat/completions" {
+		return errorResponse(r, http.StatusNotFound, "not_supported", fmt.Sprintf("%s /v1/%s is not supported for this model", r.Method, op)), nil
+	}
+
+	var in map[string]any
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&in) != nil {
+		return errorResponse(r, http.StatusBadRequest, "invalid_request", "invalid JSON body"), nil
+	}
+
+	body, err := convertRequest(in)
+
+	if err != nil {
+		return errorResponse(r, http.StatusBadRequest, "invalid_request", err.Error()), nil
+	}
+
+	model, _ := in["model"].(string)
+	stream, _ := in["stream"].(bool)
+
+	options, _ := in["stream_options"].(map[string]any)
+	includeUsage, _ := options["include_usage"].(bool)
+
+	out := r.Clone(r.Context())
+	out.URL.RawPath = ""
+	out.URL.RawQuery = ""
+
+	// Answers are parsed here, so they must come uncompressed.
+	out.Header.Del("Accept-Encoding")
+	out.Header.Set("Content-Type", "application/json")
+
+	if err := a.request(out, base, body); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.next.RoundTrip(out)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		return convertError(r, resp), nil
+	}
+
+	header := http.Header{}
+
+	if id := resp.Header.Get("Request-Id"); id != "" {
+		header.Set("X-Request-Id", id)
+	}
+
+	if !stream {
+		defer resp.Body.Close()
+
+		data, err := convertMessage(resp.Body, model)
+
+		if err != nil {
+			return nil, fmt.Errorf("anthropic: invalid answer: %w", err)
+		}
+
+		header.Set("Content-Type", "application/json")
+
+		return response(r, http.StatusOK, header, data), nil
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer resp.Body.Close()
+		translateStream(pw, a.events(resp.Body), model, includeUsage)
+	}()
+
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+
+		Header: header,
+		Body:   pr,
+
+		ContentLength: -1,
+		Request:       r,
+	}, nil
+}
+
+func setBody(r *http.Request, data []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	r.ContentLength = int64(len(data))
+	r.Header.Del("Content-Length")
+}
+
+// convertError reports a failed request in the OpenAI error format, keeping
+// the status and Retry-After.
+func convertError(r *http.Request, resp *http.Response) *http.Response {
+	defer resp.Body.Close()
+
+	var body struct {
+		Message string `json:"message"`
+
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+
+	message := body.Error.Message
+
+	if message == "" {
+		message = body.Message
+	}
+
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+
+	code := body.Error.Type
+
+	if code == "" {
+		// Bedrock names the error in a header, e.g. ThrottlingException:http://...
+		code, _, _ = strings.Cut(resp.Header.Get("X-Amzn-ErrorType"), ":")
+	}
+
+	status := resp.StatusCode
+
+	// 529 (overloaded) isn't known to OpenAI clients.
+	if status == 529 {
+		status = http.StatusServiceUnavailable
+	}
+
+	result := errorResponse(r, status, code, message)
+
+	if retry := resp.Header.Get("Retry-After"); retry != "" {
+		result.Header.Set("Retry-After", retry)
+	}
+
+	return result
+}
+
+func errorResponse(r *http.Request, status int, code, message string) *http.Response {
+	data, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    http.StatusText(status),
+			"code":    code,
+		},
+	})
+
+	return response(r, status, http.Header{"Content-Type": {"application/json"}}, data)
+}
+
+func response(r *http.Request, status int, header http.Header, data []byte) *http.Response {
+	return &http.Response{
+		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode: status,
+
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+
+		Header: header,
+		Body:   io.NopCloser(bytes.NewReader(data)),
+
+		ContentLength: int64(len(data)),
+		Request:       r,
+	}
+}
@@ -0,0 +1,235 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chunks reads the data of a streamed answer, the chunks without their
+// timestamp.
+func chunks(t *testing.T, r io.Reader) []string {
+	t.Helper()
+
+	var result []string
+
+	next := serverEvents(r)
+
+	for {
+		data, err := next()
+
+		if err == io.EOF {
+			return result
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var v map[string]any
+
+		if json.Unmarshal(data, &v) == nil {
+			delete(v, "created")
+			data, _ = json.Marshal(v)
+		}
+
+		result = append(result, string(data))
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got *http.Request
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Clone(r.Context())
+
+		w.Header().Set("Request-Id", "req_1")
+		io.WriteString(w, `{"id": "msg_1", "content": [{"type": "text", "text": "Let me look."}, {"type": "tool_use", "id": "call_1", "name": "weather", "input": {"city": "Bern"}}], "stop_reason": "tool_use", "usage": {"input_tokens": 10, "output_tokens": 5, "cache_read_input_tokens": 20}}`)
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/anthropic/v1/chat/completions?x=1", strings.NewReader(`{"model": "claude", "messages": [{"role": "user", "content": "Weather?"}]}`))
+	req.Header.Set("Authorization", "Bearer sk-ant")
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if got.URL.Path != "/anthropic/v1/messages" || got.URL.RawQuery != "" {
+		t.Errorf("upstream URL = %s", got.URL)
+	}
+
+	if got.Header.Get("x-api-key") != "sk-ant" || got.Header.Get("Authorization") != "" {
+		t.Errorf("upstream credentials = %q, %q", got.Header.Get("x-api-key"), got.Header.Get("Authorization"))
+	}
+
+	if got.Header.Get("anthropic-version") != Version {
+		t.Errorf("anthropic-version = %q", got.Header.Get("anthropic-version"))
+	}
+
+	if resp.Header.Get("X-Request-Id") != "req_1" {
+		t.Errorf("X-Request-Id = %q", resp.Header.Get("X-Request-Id"))
+	}
+
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	delete(body, "created")
+
+	data, _ := json.Marshal(body)
+
+	if want := `{"choices":[{"finish_reason":"tool_calls","index":0,"message":{"content":"Let me look.","role":"assistant","tool_calls":[{"function":{"arguments":"{\"city\": \"Bern\"}","name":"weather"},"id":"call_1","type":"function"}]}}],"id":"msg_1","model":"claude","object":"chat.completion","usage":{"completion_tokens":5,"prompt_tokens":30,"total_tokens":35}}`; string(data) != want {
+		t.Errorf("answer:\n got %s\nwant %s", data, want)
+	}
+}
+
+func TestTransportStream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		for _, e := range []string{
+			`{"type": "message_start", "message": {"id": "msg_1", "usage": {"input_tokens": 10, "output_tokens": 1}}}`,
+			`{"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`,
+			`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hi"}}`,
+			`{"type": "ping"}`,
+			`{"type": "content_block_start", "index": 1, "content_block": {"type": "tool_use", "id": "call_1", "name": "weather"}}`,
+			`{"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": "{\"city\":"}}`,
+			`{"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": "\"Bern\"}"}}`,
+			`{"type": "message_delta", "delta": {"stop_reason": "tool_use"}, "usage": {"output_tokens": 7}}`,
+			`{"type": "message_stop"}`,
+		} {
+			io.WriteString(w, "event: x\ndata: "+e+"\n\n")
+		}
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	resp, err := client.Post(upstream.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model": "claude", "stream": true, "stream_options": {"include_usage": true}, "messages": [{"role": "user", "content": "Hi"}]}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+
+	want := []string{
+		`{"choices":[{"delta":{"content":"","role":"assistant"},"finish_reason":null,"index":0}],"id":"msg_1","model":"claude","object":"chat.completion.chunk"}`,
+		`{"choices":[{"delta":{"content":"Hi"},"finish_reason":null,"index":0}],"id":"msg_1","model":"claude","object":"chat.completion.chunk"}`,
+		`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"","name":"weather"},"id":"call_1","index":0,"type":"function"}]},"finish_reason":null,"index":0}],"id":"msg_1","model":"claude","object":"chat.completion.chunk"}`,
+		`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"{\"city\":"},"index":0}]},"finish_reason":null,"index":0}],"id":"msg_1","model":"claude","object":"chat.completion.chunk"}`,
+		`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"\"Bern\"}"},"index":0}]},"finish_reason":null,"index":0}],"id":"msg_1","model":"claude","object":"chat.completion.chunk"}`,
+		`{"choices":[{"delta":{},"finish_reason":"tool_calls","index":0}],"id":"msg_1","model":"claude","object":"chat.completion.chunk"}`,
+		`{"choices":[],"id":"msg_1","model":"claude","object":"chat.completion.chunk","usage":{"completion_tokens":7,"prompt_tokens":10,"total_tokens":17}}`,
+		`[DONE]`,
+	}
+
+	if got := chunks(t, resp.Body); !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks:\n got %s\nwant %s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// A stream that ends before message_stop must not look complete.
+func TestTransportStreamTruncated(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"type\": \"message_start\", \"message\": {\"id\": \"msg_1\"}}\n\n")
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	resp, err := client.Post(upstream.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model": "claude", "stream": true, "messages": [{"role": "user", "content": "Hi"}]}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if _, err := io.ReadAll(resp.Body); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(529)
+		io.WriteString(w, `{"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`)
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+
+		status int
+		code   string
+		retry  string
+	}{
+		{"overloaded", http.MethodPost, "/v1/chat/completions", `{"messages": [{"role": "user", "content": "Hi"}]}`, http.StatusServiceUnavailable, "overloaded_error", "30"},
+		{"embeddings", http.MethodPost, "/v1/embeddings", `{}`, http.StatusNotFound, "not_supported", ""},
+		{"method", http.MethodGet, "/v1/chat/completions", ``, http.StatusNotFound, "not_supported", ""},
+		{"invalid JSON", http.MethodPost, "/v1/chat/completions", `{`, http.StatusBadRequest, "invalid_request", ""},
+		{"no messages", http.MethodPost, "/v1/chat/completions", `{"messages": []}`, http.StatusBadRequest, "invalid_request", ""},
+	} {
+		req, _ := http.NewRequest(tc.method, upstream.URL+tc.path, strings.NewReader(tc.body))
+		resp, err := client.Do(req)
+
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		var body struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status || body.Error.Code != tc.code {
+			t.Errorf("%s: %d %q, want %d %q", tc.name, resp.StatusCode, body.Error.Code, tc.status, tc.code)
+		}
+
+		if got := resp.Header.Get("Retry-After"); got != tc.retry {
+			t.Errorf("%s: Retry-After = %q, want %q", tc.name, got, tc.retry)
+		}
+	}
+}
+
+func TestFinishReason(t *testing.T) {
+	for reason, want := range map[string]string{
+		"end_turn":      "stop",
+		"stop_sequence": "stop",
+		"max_tokens":    "length",
+		"tool_use":      "tool_calls",
+		"refusal":       "content_filter",
+	} {
+		if got := finishReason(reason); got == nil || *got != want {
+			t.Errorf("finishReason(%q) = %v, want %q", reason, got, want)
+		}
+	}
+
+	if got := finishReason(""); got != nil {
+		t.Errorf("finishReason(\"\") = %q, want nil", *got)
+	}
+}
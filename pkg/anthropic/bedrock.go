@@ -0,0 +1,232 @@
+package anthropic
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/sigv4"
+)
+
+// BedrockVersion is the Messages API version of Anthropic models on Bedrock.
+const BedrockVersion = "bedrock-2023-05-31"
+
+// maxEventMessage bounds a message of a Bedrock event stream.
+const maxEventMessage = 16 << 20
+
+// Bedrock sends chat completions to Anthropic models on AWS Bedrock below
+// the base URL of the requests, e.g. https://bedrock-runtime.us-east-1.amazonaws.com.
+// Bearer tokens are passed on as Bedrock API keys; requests without are
+// signed with creds for region, which is taken from the host when empty.
+func Bedrock(next http.RoundTripper, region string, creds sigv4.Credentials) http.RoundTripper {
+	return &adapter{
+		next:   next,
+		events: eventStream,
+
+		request: func(out *http.Request, base string, body map[string]any) error {
+			model, _ := body["model"].(string)
+			stream, _ := body["stream"].(bool)
+
+			delete(body, "model")
+			delete(body, "stream")
+
+			body["anthropic_version"] = BedrockVersion
+
+			data, err := json.Marshal(body)
+
+			if err != nil {
+				return err
+			}
+
+			setBody(out, data)
+
+			op := "invoke"
+
+			if stream {
+				op = "invoke-with-response-stream"
+
+				out.Header.Set("Accept", "application/vnd.amazon.eventstream")
+				out.Header.Set("X-Amzn-Bedrock-Accept", "application/json")
+			} else {
+				out.Header.Set("Accept", "application/json")
+			}
+
+			// Model ids such as anthropic.claude-3-5-haiku-20241022-v1:0
+			// are sent with the colon escaped.
+			out.URL.Path = base + "/model/" + model + "/" + op
+			out.URL.RawPath = base + "/model/" + strings.ReplaceAll(url.PathEscape(model), ":", "%3A") + "/" + op
+
+			if out.Header.Get("Authorization") != "" {
+				return nil
+			}
+
+			signRegion := region
+
+			if signRegion == "" {
+				signRegion = hostRegion(out.URL.Hostname())
+			}
+
+			sigv4.Sign(out, data, creds, signRegion, "bedrock", time.Now())
+
+			return nil
+		},
+	}
+}
+
+// hostRegion reads the region of endpoints like
+// bedrock-runtime.eu-central-1.amazonaws.com.
+func hostRegion(host string) string {
+	parts := strings.Split(host, ".")
+
+	if len(parts) > 2 && strings.HasPrefix(parts[0], "bedrock") {
+		return parts[1]
+	}
+
+	return ""
+}
+
+// eventStream reads the Anthropic events of a Bedrock response stream,
+// encoded as AWS event stream messages carrying them as base64 "bytes".
+// Exceptions are returned as error events.
+func eventStream(r io.Reader) func() ([]byte, error) {
+	br := bufio.NewReader(r)
+
+	return func() ([]byte, error) {
+		for {
+			headers, payload, err := readEventMessage(br)
+
+			if err != nil {
+				return nil, err
+			}
+
+			switch headers[":message-type"] {
+			case "event":
+				if headers[":event-type"] != "chunk" {
+					continue
+				}
+
+				var chunk struct {
+					Bytes string `json:"bytes"`
+				}
+
+				if err := json.Unmarshal(payload, &chunk); err != nil {
+					return nil, err
+				}
+
+				return base64.StdEncoding.DecodeString(chunk.Bytes)
+
+			case "exception", "error":
+				var body struct {
+					Message string `json:"message"`
+				}
+
+				json.Unmarshal(payload, &body)
+
+				if body.Message == "" {
+					body.Message = headers[":exception-type"] + headers[":error-code"]
+				}
+
+				return json.Marshal(map[string]any{
+					"type":  "error",
+					"error": map[string]any{"type": headers[":exception-type"], "message": body.Message},
+				})
+			}
+		}
+	}
+}
+
+// readEventMessage reads a message of an AWS event stream: a prelude with
+// the lengths, the headers, the payload and a checksum.
+func readEventMessage(r *bufio.Reader) (map[string]string, []byte, error) {
+	prelude := make([]byte, 12)
+
+	if _, err := io.ReadFull(r, prelude); err != nil {
+		return nil, nil, err
+	}
+
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headerLength := binary.BigEndian.Uint32(prelude[4:8])
+
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, nil, errors.New("event stream: prelude checksum mismatch")
+	}
+
+	if total > maxEventMessage || total < 16+headerLength {
+		return nil, nil, fmt.Errorf("event stream: invalid message length %d", total)
+	}
+
+	rest := make([]byte, total-12)
+
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(prelude)
+	crc.Write(rest[:len(rest)-4])
+
+	if crc.Sum32() != binary.BigEndian.Uint32(rest[len(rest)-4:]) {
+		return nil, nil, errors.New("event stream: message checksum mismatch")
+	}
+
+	headers, err := parseEventHeaders(rest[:headerLength])
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return headers, rest[headerLength : len(rest)-4], nil
+}
+
+// parseEventHeaders returns the string headers of a message.
+func parseEventHeaders(data []byte) (map[string]string, error) {
+	headers := map[string]string{}
+
+	// sizes are the lengths of the fixed-size value types.
+	sizes := map[byte]int{0: 0, 1: 0, 2: 1, 3: 2, 4: 4, 5: 8, 8: 8, 9: 16}
+
+	for len(data) > 0 {
+		n := int(data[0])
+
+		if len(data) < 2+n {
+			return nil, errors.New("event stream: truncated header")
+		}
+
+		name := string(data[1 : 1+n])
+		kind := data[1+n]
+		data = data[2+n:]
+
+		size, fixed := sizes[kind]
+
+		if !fixed {
+			// Byte arrays (6) and strings (7) are prefixed with their length.
+			if kind != 6 && kind != 7 || len(data) < 2 {
+				return nil, fmt.Errorf("event stream: invalid header %q", name)
+			}
+
+			size = int(binary.BigEndian.Uint16(data))
+			data = data[2:]
+		}
+
+		if len(data) < size {
+			return nil, errors.New("event stream: truncated header")
+		}
+
+		if kind == 7 {
+			headers[name] = string(data[:size])
+		}
+
+		data = data[size:]
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,238 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/adrianliechti/wingman-chat/pkg/sigv4"
+)
+
+// eventMessage encodes an AWS event stream message with string headers.
+func eventMessage(headers map[string]string, payload string) []byte {
+	var h bytes.Buffer
+
+	for name, value := range headers {
+		h.WriteByte(byte(len(name)))
+		h.WriteString(name)
+		h.WriteByte(7)
+		binary.Write(&h, binary.BigEndian, uint16(len(value)))
+		h.WriteString(value)
+	}
+
+	var m bytes.Buffer
+
+	binary.Write(&m, binary.BigEndian, uint32(16+h.Len()+len(payload)))
+	binary.Write(&m, binary.BigEndian, uint32(h.Len()))
+	binary.Write(&m, binary.BigEndian, crc32.ChecksumIEEE(m.Bytes()))
+
+	m.Write(h.Bytes())
+	m.WriteString(payload)
+
+	binary.Write(&m, binary.BigEndian, crc32.ChecksumIEEE(m.Bytes()))
+
+	return m.Bytes()
+}
+
+// chunkMessage carries an Anthropic event the way Bedrock streams it.
+func chunkMessage(event string) []byte {
+	payload, _ := json.Marshal(map[string]string{"bytes": base64.StdEncoding.EncodeToString([]byte(event))})
+
+	return eventMessage(map[string]string{
+		":message-type": "event",
+		":event-type":   "chunk",
+		":content-type": "application/json",
+	}, string(payload))
+}
+
+func TestEventStream(t *testing.T) {
+	var stream bytes.Buffer
+
+	stream.Write(chunkMessage(`{"type": "message_start"}`))
+	stream.Write(eventMessage(map[string]string{":message-type": "event", ":event-type": "metadata"}, `{}`))
+	stream.Write(chunkMessage(`{"type": "message_stop"}`))
+	stream.Write(eventMessage(map[string]string{":message-type": "exception", ":exception-type": "throttlingException"}, `{"message": "Too many requests"}`))
+
+	next := eventStream(&stream)
+
+	for _, want := range []string{
+		`{"type": "message_start"}`,
+		`{"type": "message_stop"}`,
+		`{"error":{"message":"Too many requests","type":"throttlingException"},"type":"error"}`,
+	} {
+		data, err := next()
+
+		if err != nil || string(data) != want {
+			t.Errorf("event = %s, %v, want %s", data, err, want)
+		}
+	}
+
+	if _, err := next(); err != io.EOF {
+		t.Errorf("err = %v, want EOF", err)
+	}
+}
+
+func TestReadEventMessageErrors(t *testing.T) {
+	valid := chunkMessage(`{"type": "ping"}`)
+
+	// A prelude announcing more than maxEventMessage bytes.
+	large := binary.BigEndian.AppendUint32(nil, maxEventMessage+1)
+	large = binary.BigEndian.AppendUint32(large, 0)
+	large = binary.BigEndian.AppendUint32(large, crc32.ChecksumIEEE(large))
+
+	corrupt := func(i int) []byte {
+		m := bytes.Clone(valid)
+		m[i] ^= 0xff
+
+		return m
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"prelude", corrupt(2), "prelude checksum mismatch"},
+		{"payload", corrupt(len(valid) - 6), "message checksum mismatch"},
+		{"truncated", valid[:len(valid)-1], "unexpected EOF"},
+		{"length", large, "invalid message length"},
+	} {
+		_, _, err := readEventMessage(bufio.NewReader(bytes.NewReader(tc.data)))
+
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestParseEventHeaders(t *testing.T) {
+	// A bool, an int32, a byte array and a string (types 0, 4, 6 and 7):
+	// only the strings are kept.
+	data := []byte{
+		4, 'f', 'l', 'a', 'g', 0,
+		3, 'n', 'u', 'm', 4, 0, 0, 0, 42,
+		3, 'r', 'a', 'w', 6, 0, 2, 0xca, 0xfe,
+		4, 't', 'y', 'p', 'e', 7, 0, 5, 'c', 'h', 'u', 'n', 'k',
+	}
+
+	headers, err := parseEventHeaders(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]string{"type": "chunk"}; !reflect.DeepEqual(headers, want) {
+		t.Errorf("headers = %v, want %v", headers, want)
+	}
+
+	for _, data := range [][]byte{
+		{9, 'x'},
+		{1, 'x', 7, 0, 5, 'a'},
+		{1, 'x', 42, 0},
+	} {
+		if _, err := parseEventHeaders(data); err == nil {
+			t.Errorf("parseEventHeaders(%v) succeeded", data)
+		}
+	}
+}
+
+func TestHostRegion(t *testing.T) {
+	for host, want := range map[string]string{
+		"bedrock-runtime.eu-central-1.amazonaws.com":      "eu-central-1",
+		"bedrock-runtime-fips.us-east-1.amazonaws.com":    "us-east-1",
+		"bedrock.example.com":                             "example",
+		"proxy.example.com":                               "",
+		"localhost":                                       "",
+		"bedrock-runtime.us-west-2.vpce.amazonaws.com.cn": "us-west-2",
+	} {
+		if got := hostRegion(host); got != want {
+			t.Errorf("hostRegion(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestBedrock(t *testing.T) {
+	creds := sigv4.Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "secret"}
+
+	for _, tc := range []struct {
+		name   string
+		auth   string
+		stream bool
+
+		uri  string
+		sign bool
+	}{
+		{"signed", "", false, "/model/anthropic.claude-3-5-haiku-20241022-v1%3A0/invoke", true},
+		{"api key", "Bearer key", false, "/model/anthropic.claude-3-5-haiku-20241022-v1%3A0/invoke", false},
+		{"stream", "", true, "/model/anthropic.claude-3-5-haiku-20241022-v1%3A0/invoke-with-response-stream", true},
+	} {
+		var uri, auth string
+		var body map[string]any
+
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uri = r.RequestURI
+			auth = r.Header.Get("Authorization")
+			json.NewDecoder(r.Body).Decode(&body)
+
+			if tc.stream {
+				w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+				w.Write(chunkMessage(`{"type": "message_start", "message": {"id": "msg_1"}}`))
+				w.Write(chunkMessage(`{"type": "content_block_delta", "delta": {"type": "text_delta", "text": "Hi"}}`))
+				w.Write(chunkMessage(`{"type": "message_stop"}`))
+
+				return
+			}
+
+			io.WriteString(w, `{"id": "msg_1", "content": [{"type": "text", "text": "Hi"}], "stop_reason": "end_turn"}`)
+		}))
+
+		client := &http.Client{Transport: Bedrock(http.DefaultTransport, "eu-central-1", creds)}
+
+		req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/v1/chat/completions", strings.NewReader(`{"model": "anthropic.claude-3-5-haiku-20241022-v1:0", "stream": `+strconv.FormatBool(tc.stream)+`, "messages": [{"role": "user", "content": "Hi"}]}`))
+
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+
+		resp, err := client.Do(req)
+
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		upstream.Close()
+
+		if err != nil || !strings.Contains(string(data), `"content":"Hi"`) {
+			t.Errorf("%s: answer = %s, %v", tc.name, data, err)
+		}
+
+		if uri != tc.uri {
+			t.Errorf("%s: upstream URI = %s, want %s", tc.name, uri, tc.uri)
+		}
+
+		if signed := strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") && strings.Contains(auth, "/eu-central-1/bedrock/aws4_request"); signed != tc.sign {
+			t.Errorf("%s: Authorization = %q", tc.name, auth)
+		}
+
+		if !tc.sign && auth != tc.auth {
+			t.Errorf("%s: Authorization = %q, want %q", tc.name, auth, tc.auth)
+		}
+
+		// The model is in the path and the version in the body.
+		if body["model"] != nil || body["stream"] != nil || body["anthropic_version"] != BedrockVersion {
+			t.Errorf("%s: body = %v", tc.name, body)
+		}
+	}
+}
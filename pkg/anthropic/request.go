@@ -0,0 +1,318 @@
+package anthropic
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// defaultMaxTokens is used when the client sets no limit, which the Messages
+// API requires.
+const defaultMaxTokens = 4096
+
+// convertRequest translates the body of a chat completion into that of a
+// Messages request.
+func convertRequest(in map[string]any) (map[string]any, error) {
+	out := map[string]any{
+		"model":      in["model"],
+		"max_tokens": defaultMaxTokens,
+	}
+
+	var system []string
+	var messages []map[string]any
+
+	// The Messages API wants user and assistant turns to alternate, so
+	// consecutive blocks of a role, e.g. several tool results, are merged.
+	add := func(role string, blocks []map[string]any) {
+		if len(blocks) == 0 {
+			return
+		}
+
+		if n := len(messages); n > 0 && messages[n-1]["role"] == role {
+			messages[n-1]["content"] = append(messages[n-1]["content"].([]map[string]any), blocks...)
+			return
+		}
+
+		messages = append(messages, map[string]any{"role": role, "content": blocks})
+	}
+
+	list, _ := in["messages"].([]any)
+
+	for i, v := range list {
+		m, _ := v.(map[string]any)
+		role, _ := m["role"].(string)
+
+		switch role {
+		case "system", "developer":
+			if text := textOf(m["content"]); text != "" {
+				system = append(system, text)
+			}
+
+		case "user":
+			blocks, err := contentBlocks(m["content"])
+
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+
+			add("user", blocks)
+
+		case "assistant":
+			var blocks []map[string]any
+
+			if text := textOf(m["content"]); text != "" {
+				blocks = append(blocks, map[string]any{"type": "text", "text": text})
+			}
+
+			calls, _ := m["tool_calls"].([]any)
+
+			for _, c := range calls {
+				call, _ := c.(map[string]any)
+				function, _ := call["function"].(map[string]any)
+
+				args, _ := function["arguments"].(string)
+				input := map[string]any{}
+
+				json.Unmarshal([]byte(args), &input)
+
+				blocks = append(blocks, map[string]any{
+					"type":  "tool_use",
+					"id":    call["id"],
+					"name":  function["name"],
+					"input": input,
+				})
+			}
+
+			add("assistant", blocks)
+
+		case "tool":
+			add("user", []map[string]any{{
+				"type":        "tool_result",
+				"tool_use_id": m["tool_call_id"],
+				"content":     textOf(m["content"]),
+			}})
+
+		default:
+			return nil, fmt.Errorf("messages[%d]: unsupported role %q", i, role)
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("messages: at least one user message is required")
+	}
+
+	out["messages"] = messages
+
+	if len(system) > 0 {
+		out["system"] = strings.Join(system, "\n\n")
+	}
+
+	for _, key := range []string{"max_completion_tokens", "max_tokens"} {
+		if n, ok := in[key].(float64); ok && n > 0 {
+			out["max_tokens"] = int(n)
+			break
+		}
+	}
+
+	// OpenAI temperatures range up to 2, Anthropic ones up to 1.
+	if t, ok := in["temperature"].(float64); ok {
+		out["temperature"] = min(t, 1)
+	}
+
+	if p, ok := in["top_p"].(float64); ok {
+		out["top_p"] = p
+	}
+
+	switch stop := in["stop"].(type) {
+	case string:
+		out["stop_sequences"] = []string{stop}
+	case []any:
+		out["stop_sequences"] = stop
+	}
+
+	if stream, _ := in["stream"].(bool); stream {
+		out["stream"] = true
+	}
+
+	if user, _ := in["user"].(string); user != "" {
+		out["metadata"] = map[string]any{"user_id": user}
+	}
+
+	if tools, _ := in["tools"].([]any); len(tools) > 0 {
+		var converted []map[string]any
+
+		for _, t := range tools {
+			tool, _ := t.(map[string]any)
+			function, _ := tool["function"].(map[string]any)
+
+			if tool["type"] != "function" || function == nil {
+				continue
+			}
+
+			schema := function["parameters"]
+
+			if schema == nil {
+				schema = map[string]any{"type": "object"}
+			}
+
+			tool = map[string]any{
+				"name":         function["name"],
+				"input_schema": schema,
+			}
+
+			if description, _ := function["description"].(string); description != "" {
+				tool["description"] = description
+			}
+
+			converted = append(converted, tool)
+		}
+
+		out["tools"] = converted
+
+		if choice := toolChoice(in["tool_choice"]); choice != nil {
+			if parallel, ok := in["parallel_tool_calls"].(bool); ok && !parallel {
+				choice["disable_parallel_tool_use"] = true
+			}
+
+			out["tool_choice"] = choice
+		}
+	}
+
+	return out, nil
+}
+
+func toolChoice(v any) map[string]any {
+	switch v := v.(type) {
+	case string:
+		switch v {
+		case "auto":
+			return map[string]any{"type": "auto"}
+		case "required":
+			return map[string]any{"type": "any"}
+		case "none":
+			return map[string]any{"type": "none"}
+		}
+
+	case map[string]any:
+		if function, _ := v["function"].(map[string]any); function != nil {
+			return map[string]any{"type": "tool", "name": function["name"]}
+		}
+	}
+
+	return map[string]any{"type": "auto"}
+}
+
+// textOf joins the text of a message content, given as string or parts.
+func textOf(content any) string {
+	switch content := content.(type) {
+	case string:
+		return content
+
+	case []any:
+		var texts []string
+
+		for _, p := range content {
+			part, _ := p.(map[string]any)
+
+			if text, _ := part["text"].(string); text != "" {
+				texts = append(texts, text)
+			}
+		}
+
+		return strings.Join(texts, "\n")
+	}
+
+	return ""
+}
+
+// contentBlocks translates the content of a user message.
+func contentBlocks(content any) ([]map[string]any, error) {
+	parts, ok := content.([]any)
+
+	if !ok {
+		if text := textOf(content); text != "" {
+			return []map[string]any{{"type": "text", "text": text}}, nil
+		}
+
+		return nil, nil
+	}
+
+	var blocks []map[string]any
+
+	for _, p := range parts {
+		part, _ := p.(map[string]any)
+
+		switch part["type"] {
+		case "text":
+			if text, _ := part["text"].(string); text != "" {
+				blocks = append(blocks, map[string]any{"type": "text", "text": text})
+			}
+
+		case "image_url":
+			image, _ := part["image_url"].(map[string]any)
+			url, _ := image["url"].(string)
+
+			source := map[string]any{"type": "url", "url": url}
+
+			if mediaType, data, ok := parseDataURL(url); ok {
+				source = map[string]any{"type": "base64", "media_type": mediaType, "data": data}
+			}
+
+			blocks = append(blocks, map[string]any{"type": "image", "source": source})
+
+		case "file":
+			file, _ := part["file"].(map[string]any)
+			url, _ := file["file_data"].(string)
+
+			mediaType, data, ok := parseDataURL(url)
+
+			switch {
+			case ok && mediaType == "application/pdf":
+				blocks = append(blocks, map[string]any{
+					"type":   "document",
+					"source": map[string]any{"type": "base64", "media_type": mediaType, "data": data},
+				})
+
+			case ok && strings.HasPrefix(mediaType, "text/"):
+				text, err := base64.StdEncoding.DecodeString(data)
+
+				if err != nil {
+					return nil, fmt.Errorf("invalid file data: %w", err)
+				}
+
+				blocks = append(blocks, map[string]any{
+					"type":   "document",
+					"source": map[string]any{"type": "text", "media_type": "text/plain", "data": string(text)},
+				})
+
+			default:
+				return nil, fmt.Errorf("unsupported file type %q", mediaType)
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported content type %q", part["type"])
+		}
+	}
+
+	return blocks, nil
+}
+
+// parseDataURL splits a base64 data URL into media type and data.
+func parseDataURL(url string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+
+	if !ok {
+		return "", "", false
+	}
+
+	meta, data, ok := strings.Cut(rest, ",")
+
+	if !ok {
+		return "", "", false
+	}
+
+	mediaType, ok := strings.CutSuffix(meta, ";base64")
+
+	return mediaType, data, ok
+}
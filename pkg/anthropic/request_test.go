@@ -0,0 +1,128 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// convert translates a chat completion given as JSON, returning the
+// Messages request as JSON.
+func convert(t *testing.T, in string) (string, error) {
+	t.Helper()
+
+	var body map[string]any
+
+	if err := json.Unmarshal([]byte(in), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := convertRequest(body)
+
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(out)
+
+	return string(data), nil
+}
+
+func TestConvertRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			"system and defaults",
+			`{"model": "claude", "messages": [{"role": "system", "content": "Be brief."}, {"role": "developer", "content": [{"type": "text", "text": "Be kind."}]}, {"role": "user", "content": "Hi"}]}`,
+			`{"max_tokens":4096,"messages":[{"content":[{"text":"Hi","type":"text"}],"role":"user"}],"model":"claude","system":"Be brief.\n\nBe kind."}`,
+		},
+		{
+			"options",
+			`{"model": "claude", "messages": [{"role": "user", "content": "Hi"}], "max_completion_tokens": 100, "temperature": 1.5, "top_p": 0.9, "stop": "END", "stream": true, "user": "alice"}`,
+			`{"max_tokens":100,"messages":[{"content":[{"text":"Hi","type":"text"}],"role":"user"}],"metadata":{"user_id":"alice"},"model":"claude","stop_sequences":["END"],"stream":true,"temperature":1,"top_p":0.9}`,
+		},
+		{
+			// Tool results of one turn become one user message.
+			"tool calls",
+			`{"model": "claude", "messages": [
+				{"role": "user", "content": "Weather?"},
+				{"role": "assistant", "content": null, "tool_calls": [
+					{"id": "a", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":\"Bern\"}"}},
+					{"id": "b", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":\"Basel\"}"}}
+				]},
+				{"role": "tool", "tool_call_id": "a", "content": "sunny"},
+				{"role": "tool", "tool_call_id": "b", "content": [{"type": "text", "text": "rainy"}]}
+			]}`,
+			`{"max_tokens":4096,"messages":[{"content":[{"text":"Weather?","type":"text"}],"role":"user"},{"content":[{"id":"a","input":{"city":"Bern"},"name":"weather","type":"tool_use"},{"id":"b","input":{"city":"Basel"},"name":"weather","type":"tool_use"}],"role":"assistant"},{"content":[{"content":"sunny","tool_use_id":"a","type":"tool_result"},{"content":"rainy","tool_use_id":"b","type":"tool_result"}],"role":"user"}],"model":"claude"}`,
+		},
+		{
+			"tools",
+			`{"model": "claude", "messages": [{"role": "user", "content": "Hi"}], "tool_choice": "required", "parallel_tool_calls": false, "tools": [
+				{"type": "function", "function": {"name": "weather", "description": "Tells the weather.", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}},
+				{"type": "function", "function": {"name": "time"}},
+				{"type": "web_search"}
+			]}`,
+			`{"max_tokens":4096,"messages":[{"content":[{"text":"Hi","type":"text"}],"role":"user"}],"model":"claude","tool_choice":{"disable_parallel_tool_use":true,"type":"any"},"tools":[{"description":"Tells the weather.","input_schema":{"properties":{"city":{"type":"string"}},"type":"object"},"name":"weather"},{"input_schema":{"type":"object"},"name":"time"}]}`,
+		},
+		{
+			"images and files",
+			`{"model": "claude", "messages": [{"role": "user", "content": [
+				{"type": "text", "text": "Compare"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}},
+				{"type": "image_url", "image_url": {"url": "https://example.com/cat.jpg"}},
+				{"type": "file", "file": {"file_data": "data:application/pdf;base64,JVBERi0="}},
+				{"type": "file", "file": {"file_data": "data:text/markdown;base64,IyBIaQ=="}}
+			]}]}`,
+			`{"max_tokens":4096,"messages":[{"content":[{"text":"Compare","type":"text"},{"source":{"data":"iVBORw0KGgo=","media_type":"image/png","type":"base64"},"type":"image"},{"source":{"type":"url","url":"https://example.com/cat.jpg"},"type":"image"},{"source":{"data":"JVBERi0=","media_type":"application/pdf","type":"base64"},"type":"document"},{"source":{"data":"# Hi","media_type":"text/plain","type":"text"},"type":"document"}],"role":"user"}],"model":"claude"}`,
+		},
+	} {
+		got, err := convert(t, tc.in)
+
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConvertRequestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{`{"messages": []}`, "at least one user message"},
+		{`{"messages": [{"role": "system", "content": "Be brief."}]}`, "at least one user message"},
+		{`{"messages": [{"role": "critic", "content": "Hi"}]}`, `messages[0]: unsupported role "critic"`},
+		{`{"messages": [{"role": "user", "content": [{"type": "input_audio"}]}]}`, `unsupported content type "input_audio"`},
+		{`{"messages": [{"role": "user", "content": [{"type": "file", "file": {"file_data": "data:application/zip;base64,UEs="}}]}]}`, `unsupported file type "application/zip"`},
+	} {
+		if _, err := convert(t, tc.in); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.in, err, tc.want)
+		}
+	}
+}
+
+func TestToolChoice(t *testing.T) {
+	for _, tc := range []struct {
+		in   any
+		want map[string]any
+	}{
+		{"auto", map[string]any{"type": "auto"}},
+		{"none", map[string]any{"type": "none"}},
+		{"required", map[string]any{"type": "any"}},
+		{map[string]any{"type": "function", "function": map[string]any{"name": "weather"}}, map[string]any{"type": "tool", "name": "weather"}},
+		{nil, map[string]any{"type": "auto"}},
+	} {
+		if got := toolChoice(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("toolChoice(%v) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// message is a Messages API answer.
+type message struct {
+	ID         string  `json:"id"`
+	Content    []block `json:"content"`
+	StopReason string  `json:"stop_reason"`
+	Usage      usage   `json:"usage"`
+}
+
+type block struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+func (u usage) openAI() *completionUsage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+
+	return &completionUsage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+	}
+}
+
+// completion is a chat completion, or a chunk of a streamed one.
+type completion struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []choice `json:"choices"`
+
+	Usage *completionUsage `json:"usage,omitempty"`
+}
+
+type choice struct {
+	Index int `json:"index"`
+
+	Message *delta `json:"message,omitempty"`
+	Delta   *delta `json:"delta,omitempty"`
+
+	FinishReason *string `json:"finish_reason"`
+}
+
+type delta struct {
+	Role      string     `json:"role,omitempty"`
+	Content   *string    `json:"content,omitempty"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+}
+
+type toolCall struct {
+	Index *int   `json:"index,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Type  string `json:"type,omitempty"`
+
+	Function function `json:"function"`
+}
+
+type function struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type completionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// finishReason maps a stop reason to the OpenAI finish reason.
+func finishReason(reason string) *string {
+	var s string
+
+	switch reason {
+	case "":
+		return nil
+	case "max_tokens":
+		s = "length"
+	case "tool_use":
+		s = "tool_calls"
+	case "refusal":
+		s = "content_filter"
+	default:
+		s = "stop"
+	}
+
+	return &s
+}
+
+// convertMessage translates a Messages answer into a chat completion of
+// model.
+func convertMessage(r io.Reader, model string) ([]byte, error) {
+	var m message
+
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+
+	var text string
+	var calls []toolCall
+
+	for _, b := range m.Content {
+		switch b.Type {
+		case "text":
+			text += b.Text
+
+		case "tool_use":
+			calls = append(calls, toolCall{
+				ID:   b.ID,
+				Type: "function",
+
+				Function: function{Name: b.Name, Arguments: string(b.Input)},
+			})
+		}
+	}
+
+	result := &delta{Role: "assistant", ToolCalls: calls}
+
+	if text != "" || len(calls) == 0 {
+		result.Content = &text
+	}
+
+	return json.Marshal(completion{
+		ID:      m.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+
+		Choices: []choice{{Message: result, FinishReason: finishReason(m.StopReason)}},
+
+		Usage: m.Usage.openAI(),
+	})
+}
@@ -0,0 +1,204 @@
+package anthropic
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// event is a streamed Messages API event.
+type event struct {
+	Type string `json:"type"`
+
+	Index int `json:"index"`
+
+	Message *message `json:"message"`
+
+	ContentBlock *block `json:"content_block"`
+
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+
+	Usage *usage `json:"usage"`
+
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// serverEvents reads the data of server-sent events.
+func serverEvents(r io.Reader) func() ([]byte, error) {
+	br := bufio.NewReader(r)
+
+	return func() ([]byte, error) {
+		var data []byte
+
+		for {
+			line, err := br.ReadString('\n')
+			line = strings.TrimRight(line, "\r\n")
+
+			if value, ok := strings.CutPrefix(line, "data:"); ok {
+				data = append(data, strings.TrimPrefix(value, " ")...)
+			}
+
+			if line == "" && len(data) > 0 {
+				return data, nil
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// translateStream writes the events read by next as chat completion chunks
+// of model to w, ending with the usage when includeUsage is set.
+func translateStream(w *io.PipeWriter, next func() ([]byte, error), model string, includeUsage bool) {
+	chunk := completion{
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   model,
+	}
+
+	var total usage
+
+	// tools maps the content blocks of tool calls to their index.
+	tools := map[int]int{}
+
+	send := func(d delta, reason *string) error {
+		c := chunk
+		c.Choices = []choice{{Delta: &d, FinishReason: reason}}
+
+		return writeEvent(w, c)
+	}
+
+	var reason *string
+
+	for {
+		data, err := next()
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+
+			w.CloseWithError(err)
+			return
+		}
+
+		var e event
+
+		if err := json.Unmarshal(data, &e); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+
+		switch e.Type {
+		case "message_start":
+			if e.Message != nil {
+				chunk.ID = e.Message.ID
+				total = e.Message.Usage
+			}
+
+			empty := ""
+			err = send(delta{Role: "assistant", Content: &empty}, nil)
+
+		case "content_block_start":
+			if b := e.ContentBlock; b != nil && b.Type == "tool_use" {
+				index := len(tools)
+				tools[e.Index] = index
+
+				err = send(delta{ToolCalls: []toolCall{{
+					Index: &index,
+					ID:    b.ID,
+					Type:  "function",
+
+					Function: function{Name: b.Name},
+				}}}, nil)
+			}
+
+		case "content_block_delta":
+			switch e.Delta.Type {
+			case "text_delta":
+				err = send(delta{Content: &e.Delta.Text}, nil)
+
+			case "input_json_delta":
+				index := tools[e.Index]
+
+				err = send(delta{ToolCalls: []toolCall{{
+					Index: &index,
+
+					Function: function{Arguments: e.Delta.PartialJSON},
+				}}}, nil)
+			}
+
+		case "message_delta":
+			reason = finishReason(e.Delta.StopReason)
+
+			if e.Usage != nil {
+				total.OutputTokens = e.Usage.OutputTokens
+			}
+
+		case "message_stop":
+			if reason == nil {
+				reason = finishReason("end_turn")
+			}
+
+			err = send(delta{}, reason)
+
+			if err == nil && includeUsage {
+				c := chunk
+				c.Choices = []choice{}
+				c.Usage = total.openAI()
+
+				err = writeEvent(w, c)
+			}
+
+			if err == nil {
+				_, err = io.WriteString(w, "data: [DONE]\n\n")
+			}
+
+			w.CloseWithError(err)
+			return
+
+		case "error":
+			message := "upstream error"
+
+			if e.Error != nil {
+				message = e.Error.Message
+			}
+
+			writeEvent(w, map[string]any{"error": map[string]any{"message": message, "type": "upstream_error"}})
+			w.CloseWithError(fmt.Errorf("anthropic: %s", message))
+
+			return
+		}
+
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+
+	return err
+}
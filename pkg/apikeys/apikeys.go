@@ -0,0 +1,295 @@
+// Package apikeys manages API keys that let scripts use the /api proxy
+// without signing in.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// keyPrefix marks API keys, telling them apart from JWTs and admin tokens.
+const keyPrefix = "wk_"
+
+var ErrNotFound = errors.New("api key not found")
+
+// Key describes an API key. Only a hash of the secret is kept.
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Hint is the beginning of the secret, to recognize a key in listings.
+	Hint string `json:"hint"`
+	Hash string `json:"hash,omitempty"`
+
+	// Models restricts the models the key may use; empty allows all.
+	Models []string `json:"models,omitempty"`
+
+	// RateLimit is the number of requests allowed per minute; 0 is unlimited.
+	RateLimit int `json:"rateLimit,omitempty"`
+
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (k *Key) Expired() bool {
+	return k.Expires != nil && time.Now().After(*k.Expires)
+}
+
+// AllowsModel reports whether the key may use model.
+func (k *Key) AllowsModel(model string) bool {
+	return len(k.Models) == 0 || slices.Contains(k.Models, model)
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Store keeps keys in a JSON file (or only in memory when path is empty).
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	keys []*Key
+
+	windows map[string]*window
+}
+
+// FromEnv opens the store in APIKEYS_PATH (default apikeys.json).
+func FromEnv() (*Store, error) {
+	return NewStore(env.String("APIKEYS_PATH", "apikeys.json"))
+}
+
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+
+		windows: make(map[string]*window),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.keys); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Create mints a key and returns its secret, which is not retrievable later.
+func (s *Store) Create(k Key) (string, *Key, error) {
+	secret := keyPrefix + randomString(32)
+	hash := hashSecret(secret)
+
+	k.ID = randomString(8)
+	k.Hint = secret[:len(keyPrefix)+4]
+	k.Hash = hash
+	k.Created = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, &k)
+
+	if err := s.save(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return "", nil, err
+	}
+
+	return secret, public(&k), nil
+}
+
+// List returns all keys without their hashes.
+func (s *Store) List() []*Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]*Key, 0, len(s.keys))
+
+	for _, k := range s.keys {
+		result = append(result, public(k))
+	}
+
+	return result
+}
+
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.keys, func(k *Key) bool { return k.ID == id })
+
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	keys := s.keys
+	s.keys = slices.Delete(slices.Clone(keys), i, i+1)
+
+	if err := s.save(); err != nil {
+		s.keys = keys
+		return err
+	}
+
+	delete(s.windows, id)
+
+	return nil
+}
+
+// Lookup returns the valid, unexpired key for secret.
+func (s *Store) Lookup(secret string) *Key {
+	hash := hashSecret(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 && !k.Expired() {
+			return public(k)
+		}
+	}
+
+	return nil
+}
+
+// Allow counts a request against the key's rate limit.
+func (s *Store) Allow(k *Key) bool {
+	if k.RateLimit <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.windows[k.ID]
+
+	if w == nil || time.Since(w.start) >= time.Minute {
+		w = &window{start: time.Now()}
+		s.windows[k.ID] = w
+	}
+
+	if w.count >= k.RateLimit {
+		return false
+	}
+
+	w.count++
+
+	return true
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+type contextKey int
+
+const keyKey contextKey = iota
+
+func WithKey(ctx context.Context, k *Key) context.Context {
+	return context.WithValue(ctx, keyKey, k)
+}
+
+// FromContext returns the API key the request was made with, or nil.
+func FromContext(ctx context.Context) *Key {
+	k, _ := ctx.Value(keyKey).(*Key)
+	return k
+}
+
+// Identify authenticates requests below prefix that carry an API key as
+// bearer token. The caller becomes the user "apikey:<id>"; unknown, expired
+// or throttled keys are rejected. The key is removed from the request so it
+// isn't mistaken for another kind of token further down.
+func (s *Store) Identify(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/") + "/"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok || !strings.HasPrefix(secret, keyPrefix) || !strings.HasPrefix(r.URL.Path, prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		k := s.Lookup(secret)
+
+		if k == nil {
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+
+		if !s.Allow(k) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		r.Header.Del("Authorization")
+
+		ctx := WithKey(r.Context(), k)
+		ctx = auth.WithUser(ctx, &auth.User{ID: "apikey:" + k.ID, Name: k.Name}, auth.SourceAPIKey)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func public(k *Key) *Key {
+	c := *k
+	c.Hash = ""
+
+	return &c
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
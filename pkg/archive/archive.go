@@ -0,0 +1,427 @@
+// Package archive keeps a tamper-evident record of the model interactions
+// proxied by the server, for deployments that must retain them. Records are
+// appended to one JSONL file per UTC day and chained by hash: each record
+// carries the hash of the one before, so removing, reordering or editing a
+// record breaks the chain. Closed days are exported, e.g. to an S3 bucket
+// with object lock.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/sigv4"
+)
+
+// exportInterval is how often closed days are looked for.
+const exportInterval = time.Hour
+
+// Record is an archived interaction.
+type Record struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+
+	Tenant string `json:"tenant,omitempty"`
+	User   string `json:"user,omitempty"`
+	Device string `json:"device,omitempty"`
+
+	// OwnKey is set when the request was sent with the user's own upstream
+	// key rather than the shared one.
+	OwnKey bool `json:"ownKey,omitempty"`
+
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Model  string `json:"model,omitempty"`
+	Status int    `json:"status"`
+
+	// Upstream is the entry of upstreams.yaml the request was sent to, empty
+	// for the platform; Region is the region it was served in, when the
+	// residency policy decided one.
+	Upstream string `json:"upstream,omitempty"`
+	Region   string `json:"region,omitempty"`
+
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response string          `json:"response,omitempty"`
+
+	// Truncated is set when the response exceeded the recorded size.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Prev is the hash of the previous record; Hash is the SHA-256 of this
+	// record's line with an empty hash. It is always the last field.
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// Exporter stores a closed day's file.
+type Exporter interface {
+	Export(ctx context.Context, name string, data []byte) error
+}
+
+// head is the end of the chain, kept when exported files are removed.
+type head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+type Archive struct {
+	dir      string
+	exporter Exporter
+
+	mu   sync.Mutex
+	head head
+	day  string
+	file *os.File
+}
+
+// FromEnv returns the archive in ARCHIVE_PATH, exporting to ARCHIVE_S3_URL
+// in ARCHIVE_S3_REGION, if set, with a retention of ARCHIVE_RETENTION_DAYS,
+// until ctx is done. It returns nil when ARCHIVE_PATH is not set.
+func FromEnv(ctx context.Context) (*Archive, error) {
+	dir := os.Getenv("ARCHIVE_PATH")
+
+	if dir == "" {
+		return nil, nil
+	}
+
+	var exporter Exporter
+
+	if u := os.Getenv("ARCHIVE_S3_URL"); u != "" {
+		days, _ := strconv.Atoi(os.Getenv("ARCHIVE_RETENTION_DAYS"))
+		creds := sigv4.FromEnv()
+
+		s3, err := NewS3(u, os.Getenv("ARCHIVE_S3_REGION"), creds.AccessKey, creds.SecretKey, creds.SessionToken, time.Duration(days)*24*time.Hour)
+
+		if err != nil {
+			return nil, err
+		}
+
+		exporter = s3
+	}
+
+	a, err := New(dir, exporter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.Run(ctx)
+
+	return a, nil
+}
+
+// New appends to the files in dir, continuing the chain where it ended.
+// With an exporter, the files of past days are exported and removed.
+func New(dir string, exporter Exporter) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		dir:      dir,
+		exporter: exporter,
+	}
+
+	h, err := a.readHead()
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.head = h
+
+	return a, nil
+}
+
+// Record appends r to the chain, filling in its sequence, time and hashes.
+func (a *Archive) Record(r *Record) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	r.Time = r.Time.UTC()
+	r.Seq = a.head.Seq + 1
+	r.Prev = a.head.Hash
+	r.Hash = ""
+
+	line, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(line)
+	r.Hash = hex.EncodeToString(sum[:])
+
+	line, err = json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	day := r.Time.Format(time.DateOnly)
+
+	if a.file == nil || a.day != day {
+		if a.file != nil {
+			a.file.Close()
+		}
+
+		f, err := os.OpenFile(filepath.Join(a.dir, day+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+
+		if err != nil {
+			a.file = nil
+			return err
+		}
+
+		a.file = f
+		a.day = day
+	}
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	a.head = head{Seq: r.Seq, Hash: r.Hash}
+
+	return nil
+}
+
+// Run exports closed days in the background until ctx is done.
+func (a *Archive) Run(ctx context.Context) {
+	if a.exporter == nil {
+		return
+	}
+
+	go func() {
+		for {
+			if err := a.export(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("archive: export", "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(exportInterval):
+			}
+		}
+	}()
+}
+
+func (a *Archive) export(ctx context.Context) error {
+	days, err := a.days()
+
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Format(time.DateOnly)
+
+	for _, day := range days {
+		if day >= today {
+			continue
+		}
+
+		path := filepath.Join(a.dir, day+".jsonl")
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		if err := a.exporter.Export(ctx, day+".jsonl", data); err != nil {
+			return fmt.Errorf("%s: %w", day, err)
+		}
+
+		if err := a.removeDay(day, data); err != nil {
+			return err
+		}
+
+		slog.Info("archive: exported", "day", day)
+	}
+
+	return nil
+}
+
+// removeDay deletes an exported file, first saving its last record as head
+// when it's the newest file, so the chain can be continued.
+func (a *Archive) removeDay(day string, data []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if day == a.day && a.file != nil {
+		a.file.Close()
+
+		a.file = nil
+		a.day = ""
+	}
+
+	days, err := a.days()
+
+	if err != nil {
+		return err
+	}
+
+	if days[len(days)-1] == day {
+		h, err := lastRecord(data)
+
+		if err != nil {
+			return err
+		}
+
+		data, _ := json.Marshal(h)
+
+		tmp := filepath.Join(a.dir, "head.json.tmp")
+
+		if err := os.WriteFile(tmp, data, 0o600); err != nil {
+			return err
+		}
+
+		if err := os.Rename(tmp, filepath.Join(a.dir, "head.json")); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(filepath.Join(a.dir, day+".jsonl"))
+}
+
+// days returns the days with a local file, oldest first.
+func (a *Archive) days() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(a.dir, "*.jsonl"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var days []string
+
+	for _, m := range matches {
+		day := strings.TrimSuffix(filepath.Base(m), ".jsonl")
+
+		if _, err := time.Parse(time.DateOnly, day); err == nil {
+			days = append(days, day)
+		}
+	}
+
+	slices.Sort(days)
+
+	return days, nil
+}
+
+// readHead finds the end of the chain: the last record of the newest file,
+// or the head saved when it was exported.
+func (a *Archive) readHead() (head, error) {
+	days, err := a.days()
+
+	if err != nil {
+		return head{}, err
+	}
+
+	if len(days) > 0 {
+		data, err := os.ReadFile(filepath.Join(a.dir, days[len(days)-1]+".jsonl"))
+
+		if err != nil {
+			return head{}, err
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			return lastRecord(data)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(a.dir, "head.json"))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return head{}, nil
+	}
+
+	if err != nil {
+		return head{}, err
+	}
+
+	var h head
+
+	if err := json.Unmarshal(data, &h); err != nil {
+		return head{}, fmt.Errorf("archive: head.json: %w", err)
+	}
+
+	return h, nil
+}
+
+func lastRecord(data []byte) (head, error) {
+	data = bytes.TrimSpace(data)
+
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+
+	var r Record
+
+	if err := json.Unmarshal(data, &r); err != nil {
+		return head{}, fmt.Errorf("archive: last record: %w", err)
+	}
+
+	return head{Seq: r.Seq, Hash: r.Hash}, nil
+}
+
+// Verify checks the chain of the records read from r, which must continue
+// from the record with hash prev ("" for the first record ever). It returns
+// the hash of the last record and the number of records.
+func Verify(r io.Reader, prev string) (string, int, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 64<<20)
+
+	var n int
+
+	for s.Scan() {
+		line := s.Bytes()
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var rec Record
+
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return prev, n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+
+		if rec.Prev != prev {
+			return prev, n, fmt.Errorf("record %d (seq %d): chain broken, previous hash is %q, expected %q", n+1, rec.Seq, rec.Prev, prev)
+		}
+
+		field := []byte(`"hash":"` + rec.Hash + `"}`)
+
+		if !bytes.HasSuffix(line, field) {
+			return prev, n, fmt.Errorf("record %d (seq %d): hash is not the last field", n+1, rec.Seq)
+		}
+
+		unsigned := bytes.Clone(bytes.TrimSuffix(line, field))
+		sum := sha256.Sum256(append(unsigned, `"hash":""}`...))
+
+		if hex.EncodeToString(sum[:]) != rec.Hash {
+			return prev, n, fmt.Errorf("record %d (seq %d): hash mismatch, record was modified", n+1, rec.Seq)
+		}
+
+		prev = rec.Hash
+		n++
+	}
+
+	return prev, n, s.Err()
+}
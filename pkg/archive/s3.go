@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/sigv4"
+)
+
+var _ Exporter = (*S3)(nil)
+
+// S3 uploads files to an S3 compatible bucket, signed with AWS Signature
+// Version 4. With a retention, objects are locked in compliance mode, so
+// nobody can delete or overwrite them before it ends (the bucket needs
+// object lock enabled).
+type S3 struct {
+	client *http.Client
+
+	url    *url.URL
+	region string
+
+	credentials sigv4.Credentials
+
+	retention time.Duration
+}
+
+// NewS3 uploads below rawURL, which names the bucket and an optional key
+// prefix in path style (https://s3.eu-central-1.amazonaws.com/bucket/prefix).
+func NewS3(rawURL, region, accessKey, secretKey, sessionToken string, retention time.Duration) (*S3, error) {
+	u, err := url.Parse(strings.TrimRight(rawURL, "/") + "/")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("archive: s3 url %q names no bucket", rawURL)
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3{
+		client: http.DefaultClient,
+
+		url:    u,
+		region: region,
+
+		credentials: sigv4.Credentials{
+			AccessKey:    accessKey,
+			SecretKey:    secretKey,
+			SessionToken: sessionToken,
+		},
+
+		retention: retention,
+	}, nil
+}
+
+func (s *S3) Export(ctx context.Context, name string, data []byte) error {
+	u := s.url.JoinPath(name)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	md5sum := md5.Sum(data)
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum[:]))
+
+	if s.retention > 0 {
+		req.Header.Set("X-Amz-Object-Lock-Mode", "COMPLIANCE")
+		req.Header.Set("X-Amz-Object-Lock-Retain-Until-Date", time.Now().Add(s.retention).UTC().Format(time.RFC3339))
+	}
+
+	sigv4.Sign(req, data, s.credentials, s.region, "s3", time.Now())
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("s3 error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+// Package auth resolves the identity of the caller and carries it through the
+// request context.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+type User struct {
+	ID     string   `json:"id"`
+	Email  string   `json:"email,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// InGroup reports whether the user is a member of any of the given groups.
+func (u *User) InGroup(groups ...string) bool {
+	if u == nil {
+		return false
+	}
+
+	for _, g := range groups {
+		if slices.Contains(u.Groups, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Login signs users in with an identity provider (OIDC or SAML).
+type Login interface {
+	// Attach registers the /auth/* endpoints.
+	Attach(mux *http.ServeMux)
+
+	// Wrap requires a signed-in user.
+	Wrap(next http.Handler) http.Handler
+}
+
+// LoginFromEnv returns the login configured with OIDC_ISSUER,
+// BASIC_AUTH_USERS or SAML_IDP_METADATA_URL / SAML_IDP_SSO_URL, in this
+// order, and the sessions it signs users in with, or nil for both when none
+// is set.
+func LoginFromEnv() (Login, *Sessions, error) {
+	if os.Getenv("OIDC_ISSUER") == "" && os.Getenv("BASIC_AUTH_USERS") == "" && os.Getenv("SAML_IDP_METADATA_URL") == "" && os.Getenv("SAML_IDP_SSO_URL") == "" {
+		return nil, nil, nil
+	}
+
+	sessions, err := sessionsFromEnv()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		login, err := NewOIDC(OIDCConfig{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       os.Getenv("OIDC_SCOPES"),
+			GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		}, sessions)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return login, sessions, nil
+	}
+
+	if users := env.List("BASIC_AUTH_USERS"); len(users) > 0 {
+		login, err := NewBasic(users, sessions)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return login, sessions, nil
+	}
+
+	login, err := NewSAML(SAMLConfig{
+		BaseURL: os.Getenv("SAML_BASE_URL"),
+
+		EntityID: os.Getenv("SAML_ENTITY_ID"),
+		ACSURL:   os.Getenv("SAML_ACS_URL"),
+
+		MetadataURL: os.Getenv("SAML_IDP_METADATA_URL"),
+
+		IdPEntityID: os.Getenv("SAML_IDP_ENTITY_ID"),
+		SSOURL:      os.Getenv("SAML_IDP_SSO_URL"),
+		Certificate: os.Getenv("SAML_IDP_CERTIFICATE"),
+
+		GroupsAttribute: os.Getenv("SAML_GROUPS_ATTRIBUTE"),
+	}, sessions)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return login, sessions, nil
+}
+
+// Source is how the caller was identified.
+type Source int
+
+const (
+	SourceLogin Source = iota + 1
+	SourceBearer
+	SourceProxy
+	SourceAPIKey
+	SourceGuest
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	sourceKey
+	deviceKey
+)
+
+// WithUser attaches the caller, identified by source, to ctx.
+func WithUser(ctx context.Context, user *User, source Source) context.Context {
+	ctx = context.WithValue(ctx, userKey, user)
+	return context.WithValue(ctx, sourceKey, source)
+}
+
+// UserFromContext returns the caller, or nil for anonymous requests.
+func UserFromContext(ctx context.Context) *User {
+	if user, ok := ctx.Value(userKey).(*User); ok {
+		return user
+	}
+
+	return nil
+}
+
+// SourceFromContext returns how the caller was identified, or 0 for
+// anonymous requests.
+func SourceFromContext(ctx context.Context) Source {
+	source, _ := ctx.Value(sourceKey).(Source)
+	return source
+}
+
+// Authenticated returns the caller if they proved who they are: signed in,
+// presented a token or API key, or were announced by a trusted proxy. Guests
+// only hold a link and don't count.
+func Authenticated(ctx context.Context) *User {
+	switch SourceFromContext(ctx) {
+	case SourceLogin, SourceBearer, SourceProxy, SourceAPIKey:
+		return UserFromContext(ctx)
+	}
+
+	return nil
+}
+
+// identityHeaders are the headers an authenticating proxy announces the
+// user in.
+var identityHeaders = []string{
+	"X-Forwarded-User",
+	"X-Forwarded-Email",
+	"X-Forwarded-Preferred-Username",
+	"X-Forwarded-Groups",
+}
+
+// forwardedHeaders are the headers a reverse proxy tells the scheme and
+// host the client used in, which decide secure cookies and redirect URLs.
+var forwardedHeaders = []string{
+	"X-Forwarded-Proto",
+	"X-Forwarded-Host",
+}
+
+// userFromHeaders reads the user id from userHeader, falling back to the
+// email.
+func userFromHeaders(h http.Header, userHeader string) *User {
+	user := &User{
+		ID:    h.Get(userHeader),
+		Email: h.Get("X-Forwarded-Email"),
+		Name:  h.Get("X-Forwarded-Preferred-Username"),
+	}
+
+	if user.ID == "" {
+		user.ID = user.Email
+	}
+
+	if user.ID == "" {
+		return nil
+	}
+
+	for _, g := range strings.Split(h.Get("X-Forwarded-Groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			user.Groups = append(user.Groups, g)
+		}
+	}
+
+	return user
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Basic signs users in with HTTP basic authentication against bcrypt hashes
+// and keeps them signed in with a session cookie, so the browser asks only
+// once and the installed web app, which fetches without credentials, keeps
+// working.
+type Basic struct {
+	users    map[string][]byte
+	sessions *Sessions
+
+	// dummy is compared for unknown users, so they take as long as known
+	// ones.
+	dummy []byte
+}
+
+// NewBasic accepts users given as "name:bcrypt-hash" (as printed by
+// "htpasswd -nB name").
+func NewBasic(users []string, sessions *Sessions) (*Basic, error) {
+	b := &Basic{
+		users:    map[string][]byte{},
+		sessions: sessions,
+	}
+
+	for _, u := range users {
+		name, hash, _ := strings.Cut(u, ":")
+
+		if name == "" {
+			return nil, errors.New("basic auth: invalid user " + u)
+		}
+
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, errors.New("basic auth: invalid bcrypt hash for " + name)
+		}
+
+		b.users[name] = []byte(hash)
+	}
+
+	dummy, err := bcrypt.GenerateFromPassword([]byte(randomString()), bcrypt.DefaultCost)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b.dummy = dummy
+
+	return b, nil
+}
+
+func (b *Basic) Attach(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/logout", b.handleLogout)
+	mux.HandleFunc("GET /auth/me", handleMe)
+}
+
+// Wrap requires a session, or valid credentials that start one, for every
+// request except the assets a browser fetches without credentials (web app
+// manifest and icons).
+func (b *Basic) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if user := b.sessions.User(r); user != nil {
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user, SourceLogin)))
+			return
+		}
+
+		if name, password, ok := r.BasicAuth(); ok && b.check(name, password) {
+			user := &User{
+				ID:   name,
+				Name: name,
+			}
+
+			if strings.Contains(name, "@") {
+				user.Email = name
+			}
+
+			if err := b.sessions.Issue(w, r, user); err != nil {
+				slog.Error("auth: session store", "error", err)
+				http.Error(w, "session store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			// The credentials are not meant for the platform.
+			r.Header.Del("Authorization")
+
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user, SourceLogin)))
+			return
+		}
+
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="Wingman", charset="UTF-8"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func (b *Basic) check(name, password string) bool {
+	hash, ok := b.users[name]
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(b.dummy, []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// handleLogout ends the session. Browsers keep sending cached credentials
+// until they are closed, so this only signs out where they aren't cached.
+func (b *Basic) handleLogout(w http.ResponseWriter, r *http.Request) {
+	b.sessions.Clear(w, r)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
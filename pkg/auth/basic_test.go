@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasic(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	b, err := NewBasic([]string{"alice:" + string(hash)}, NewSessions("secret", time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user *User
+	var source Source
+	var authorization string
+
+	handler := b.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = UserFromContext(r.Context())
+		source = SourceFromContext(r.Context())
+		authorization = r.Header.Get("Authorization")
+	}))
+
+	serve := func(path, name, password string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		user, source, authorization = nil, 0, ""
+
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+
+		if name != "" {
+			r.SetBasicAuth(name, password)
+		}
+
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		return rec
+	}
+
+	for name, creds := range map[string][2]string{
+		"wrong password": {"alice", "guess"},
+		"unknown user":   {"mallory", "password"},
+		"none":           {"", ""},
+	} {
+		if rec := serve("/api/v1/models", creds[0], creds[1]); rec.Code != http.StatusUnauthorized || user != nil {
+			t.Errorf("%s: status = %d, user = %+v", name, rec.Code, user)
+		}
+	}
+
+	if rec := serve("/manifest.json", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("manifest: status = %d, want 200", rec.Code)
+	}
+
+	rec := serve("/api/v1/models", "alice", "password")
+
+	if rec.Code != http.StatusOK || user == nil || user.ID != "alice" || source != SourceLogin {
+		t.Fatalf("status = %d, user = %+v from %d", rec.Code, user, source)
+	}
+
+	if authorization != "" {
+		t.Error("the credentials were passed on")
+	}
+
+	// The session started with the credentials signs in without them.
+	if rec := serve("/api/v1/models", "", "", rec.Result().Cookies()...); rec.Code != http.StatusOK || user == nil || user.ID != "alice" {
+		t.Errorf("session: status = %d, user = %+v", rec.Code, user)
+	}
+}
+
+func TestNewBasicRejectsPlainPasswords(t *testing.T) {
+	if _, err := NewBasic([]string{"alice:password"}, NewSessions("secret", time.Hour)); err == nil {
+		t.Error("a password that is no bcrypt hash was accepted")
+	}
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Bearer authenticates API clients with JWT bearer tokens.
+type Bearer struct {
+	verifier    *Verifier
+	groupsClaim string
+}
+
+type BearerConfig struct {
+	// JWKSURL defaults to the jwks_uri announced by the issuer.
+	JWKSURL string
+
+	// Issuer and Audience are required: without them any token signed by
+	// the key set, e.g. one the IdP issued to another app, would pass.
+	Issuer   string
+	Audience string
+
+	// GroupsClaim defaults to "groups".
+	GroupsClaim string
+}
+
+// BearerFromEnv returns the bearer configured with JWT_ISSUER, JWT_AUDIENCE,
+// JWT_JWKS_URL and JWT_GROUPS_CLAIM, or nil when neither JWT_ISSUER nor
+// JWT_JWKS_URL is set.
+func BearerFromEnv() (*Bearer, error) {
+	cfg := BearerConfig{
+		JWKSURL:     os.Getenv("JWT_JWKS_URL"),
+		Issuer:      os.Getenv("JWT_ISSUER"),
+		Audience:    os.Getenv("JWT_AUDIENCE"),
+		GroupsClaim: os.Getenv("JWT_GROUPS_CLAIM"),
+	}
+
+	if cfg.JWKSURL == "" && cfg.Issuer == "" {
+		return nil, nil
+	}
+
+	return NewBearer(cfg)
+}
+
+func NewBearer(cfg BearerConfig) (*Bearer, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("jwt: issuer is required")
+	}
+
+	if cfg.Audience == "" {
+		return nil, errors.New("jwt: audience is required")
+	}
+
+	if cfg.JWKSURL == "" {
+		metadata, err := discover(cfg.Issuer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.JWKSURL = metadata.JWKSURI
+	}
+
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	return &Bearer{
+		verifier:    NewVerifier(cfg.JWKSURL, cfg.Issuer, cfg.Audience),
+		groupsClaim: cfg.GroupsClaim,
+	}, nil
+}
+
+// Identify attaches the user of a valid bearer token to the request context.
+// Requests carrying an invalid token are rejected; requests without one are
+// passed on, to be signed in otherwise or turned away by Require.
+func (b *Bearer) Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := b.verifier.Verify(r.Context(), token)
+
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), claims.User(b.groupsClaim), SourceBearer)))
+	})
+}
+
+// Require answers requests below prefix with 401 unless a user was
+// identified.
+func (b *Bearer) Require(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/") + "/"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix) && UserFromContext(r.Context()) == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
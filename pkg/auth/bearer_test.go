@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerIdentify(t *testing.T) {
+	i := newIssuer(t)
+
+	b, err := NewBearer(BearerConfig{Issuer: i.URL, Audience: "api"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user *User
+	var source Source
+
+	handler := b.Identify(b.Require("/api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = UserFromContext(r.Context())
+		source = SourceFromContext(r.Context())
+	})))
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		status int
+		user   string
+	}{
+		{"valid", i.token(i.claims("api")), http.StatusOK, "alice"},
+		{"invalid", i.token(i.claims("other")), http.StatusUnauthorized, ""},
+		{"missing", "", http.StatusUnauthorized, ""},
+	} {
+		user, source = nil, 0
+
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/models", nil)
+
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d", tc.name, rec.Code, tc.status)
+		}
+
+		if tc.user != "" && (user == nil || user.ID != tc.user || source != SourceBearer) {
+			t.Errorf("%s: user = %+v from %d", tc.name, user, source)
+		}
+	}
+}
+
+func TestBearerFromEnvRequiresIssuerAndAudience(t *testing.T) {
+	i := newIssuer(t)
+
+	for _, tc := range []struct {
+		name                   string
+		jwks, issuer, audience string
+	}{
+		{"jwks only", i.URL + "/jwks", "", ""},
+		{"no audience", "", i.URL, ""},
+		{"no issuer", i.URL + "/jwks", "", "api"},
+	} {
+		t.Setenv("JWT_JWKS_URL", tc.jwks)
+		t.Setenv("JWT_ISSUER", tc.issuer)
+		t.Setenv("JWT_AUDIENCE", tc.audience)
+
+		if _, err := BearerFromEnv(); err == nil {
+			t.Errorf("%s: bearer tokens would pass without an issuer or audience check", tc.name)
+		}
+	}
+}
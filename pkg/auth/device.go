@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/ratelimit"
+)
+
+const deviceCookie = "wingman_device"
+
+// deviceTTL is the lifetime of device cookies, the most browsers allow.
+const deviceTTL = 400 * 24 * time.Hour
+
+// Devices gives every browser a signed device cookie, a stable anonymous id
+// to attribute requests to where users don't sign in.
+type Devices struct {
+	secret []byte
+
+	// limit is the number of new devices issued a minute per client
+	// address, so ids can't be minted to dodge limits.
+	limit   int
+	limiter *ratelimit.Limiter
+}
+
+// DevicesFromEnv returns the devices with DEVICE_TRACKING=true, signed with
+// DEVICE_SECRET (default SESSION_SECRET) and issued DEVICE_ISSUE_LIMIT
+// (default 10) times a minute per address, or nil otherwise.
+func DevicesFromEnv() *Devices {
+	if !env.Bool("DEVICE_TRACKING") {
+		return nil
+	}
+
+	return NewDevices(env.String("DEVICE_SECRET", os.Getenv("SESSION_SECRET")), env.Int("DEVICE_ISSUE_LIMIT", 10))
+}
+
+// NewDevices signs cookies with secret; an empty secret generates a random
+// one, which gives every browser a new id on restart and across replicas.
+func NewDevices(secret string, limit int) *Devices {
+	key := []byte(secret)
+
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+
+	return &Devices{
+		secret: key,
+
+		limit:   limit,
+		limiter: ratelimit.New(),
+	}
+}
+
+// DeviceFromContext returns the device id of the caller, or "" if the
+// request carried no valid device cookie.
+func DeviceFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(deviceKey).(string)
+	return id
+}
+
+// Identify attaches the device id of the cookie to the request context, and
+// issues a cookie to browsers without one. A new id only applies from the
+// next request on, which carries the cookie.
+func (d *Devices) Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := d.device(r); id != "" {
+			r = r.WithContext(context.WithValue(r.Context(), deviceKey, id))
+		} else if d.limiter.Take(remoteHost(r), d.limit, 1) == 0 {
+			id := randomString()
+			setCookie(w, r, deviceCookie, id+"."+d.mac(id), deviceTTL)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Devices) device(r *http.Request) string {
+	c, err := r.Cookie(deviceCookie)
+
+	if err != nil {
+		return ""
+	}
+
+	id, mac, ok := strings.Cut(c.Value, ".")
+
+	if !ok || id == "" || !hmac.Equal([]byte(mac), []byte(d.mac(id))) {
+		return ""
+	}
+
+	return id
+}
+
+func (d *Devices) mac(id string) string {
+	h := hmac.New(sha256.New, d.secret)
+	h.Write([]byte("device\x00" + id))
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,401 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leeway tolerates clock skew between the issuer and this server.
+const leeway = time.Minute
+
+// refreshInterval limits how often an unknown key id triggers a JWKS reload.
+const refreshInterval = time.Minute
+
+// Claims are the decoded claims of a verified token.
+type Claims map[string]any
+
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim that may be a single string or a list of strings.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var result []string
+
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(v), 0), true
+}
+
+// User maps standard OIDC claims to a user; groups are read from groupsClaim.
+func (c Claims) User(groupsClaim string) *User {
+	user := &User{
+		ID:     c.String("sub"),
+		Email:  c.String("email"),
+		Name:   c.String("name"),
+		Groups: c.Strings(groupsClaim),
+	}
+
+	if user.Name == "" {
+		user.Name = c.String("preferred_username")
+	}
+
+	return user
+}
+
+// Verifier checks signed JWTs against the keys published at a JWKS URL and
+// validates issuer, audience and lifetime.
+type Verifier struct {
+	client *http.Client
+
+	jwksURL  string
+	issuer   string
+	audience string
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+
+	// loading is closed when the running key set reload ends.
+	loading chan struct{}
+}
+
+// NewVerifier returns a verifier for tokens of issuer and audience.
+func NewVerifier(jwksURL, issuer, audience string) *Verifier {
+	return &Verifier{
+		client: http.DefaultClient,
+
+		jwksURL:  jwksURL,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, errors.New("jwt: malformed signature")
+	}
+
+	key, err := v.key(ctx, header.Kid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	if exp, ok := claims.time("exp"); !ok || now.After(exp.Add(leeway)) {
+		return nil, errors.New("jwt: token expired")
+	}
+
+	if nbf, ok := claims.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return nil, errors.New("jwt: token not yet valid")
+	}
+
+	if v.issuer != "" && claims.String("iss") != v.issuer {
+		return nil, errors.New("jwt: unexpected issuer")
+	}
+
+	if v.audience != "" && !slices.Contains(claims.Strings("aud"), v.audience) {
+		return nil, errors.New("jwt: unexpected audience")
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+
+	if err != nil {
+		return errors.New("jwt: malformed segment")
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("jwt: malformed segment")
+	}
+
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("jwt: unsupported algorithm %q", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case strings.HasPrefix(alg, "RS"):
+			if rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil {
+				return nil
+			}
+		case strings.HasPrefix(alg, "PS"):
+			if rsa.VerifyPSS(k, hash, digest, signature, nil) == nil {
+				return nil
+			}
+		default:
+			return fmt.Errorf("jwt: algorithm %q does not match key", alg)
+		}
+
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("jwt: algorithm %q does not match key", alg)
+		}
+
+		size := (k.Curve.Params().BitSize + 7) / 8
+
+		if len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("jwt: invalid signature")
+}
+
+// key returns the public key for kid, reloading the key set when the id is
+// unknown (keys rotate) but at most once per refreshInterval. The reload runs
+// outside the lock, so a slow issuer holds up only the tokens waiting for it.
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+
+	if key, ok := v.lookup(kid); ok {
+		v.mu.Unlock()
+		return key, nil
+	}
+
+	if loading := v.loading; loading != nil {
+		v.mu.Unlock()
+
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
+		if key, ok := v.lookup(kid); ok {
+			return key, nil
+		}
+
+		return nil, errors.New("jwt: unknown signing key")
+	}
+
+	if time.Since(v.fetched) < refreshInterval {
+		v.mu.Unlock()
+		return nil, errors.New("jwt: unknown signing key")
+	}
+
+	loading := make(chan struct{})
+	v.loading = loading
+
+	v.mu.Unlock()
+
+	keys, err := fetchKeys(ctx, v.client, v.jwksURL)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.fetched = time.Now()
+	v.loading = nil
+	close(loading)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v.keys = keys
+
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, errors.New("jwt: unknown signing key")
+}
+
+func (v *Verifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := v.keys[kid]; ok {
+		return key, true
+	}
+
+	// Tokens without a kid are accepted when the set holds a single key.
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+func fetchKeys(ctx context.Context, client *http.Client, url string) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("jwt: fetching keys failed (" + resp.Status + ")")
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+
+			N string `json:"n"`
+			E string `json:"e"`
+
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+
+			if err1 != nil || err2 != nil {
+				continue
+			}
+
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+
+		case "EC":
+			var curve elliptic.Curve
+
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+
+			if err1 != nil || err2 != nil {
+				continue
+			}
+
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	return keys, nil
+}
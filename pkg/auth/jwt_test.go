@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OpenID provider publishing the key it signs tokens with.
+type testIssuer struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+	mux *http.ServeMux
+}
+
+func newIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, _ := testKey(t)
+
+	i := &testIssuer{
+		key: key,
+		mux: http.NewServeMux(),
+	}
+
+	i.Server = httptest.NewServer(i.mux)
+	t.Cleanup(i.Close)
+
+	i.mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(providerMetadata{
+			Issuer:                i.URL,
+			AuthorizationEndpoint: i.URL + "/authorize",
+			TokenEndpoint:         i.URL + "/token",
+			JWKSURI:               i.URL + "/jwks",
+		})
+	})
+
+	i.mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	return i
+}
+
+// claims returns valid claims for alice with the given audience.
+func (i *testIssuer) claims(audience string) map[string]any {
+	return map[string]any{
+		"iss":    i.URL,
+		"aud":    audience,
+		"sub":    "alice",
+		"email":  "alice@example.com",
+		"groups": []string{"admins"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func (i *testIssuer) sign(header, claims map[string]any) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+
+	if err != nil {
+		panic(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (i *testIssuer) token(claims map[string]any) string {
+	return i.sign(map[string]any{"alg": "RS256", "kid": "k1"}, claims)
+}
+
+func TestVerify(t *testing.T) {
+	i := newIssuer(t)
+	v := NewVerifier(i.URL+"/jwks", i.URL, "client")
+
+	claims, err := v.Verify(context.Background(), i.token(i.claims("client")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user := claims.User("groups"); user.ID != "alice" || len(user.Groups) != 1 || user.Groups[0] != "admins" {
+		t.Errorf("user = %+v", user)
+	}
+
+	if _, err := v.Verify(context.Background(), i.sign(map[string]any{"alg": "RS256"}, i.claims("client"))); err != nil {
+		t.Errorf("a token without kid, with a single key in the set: %v", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	i := newIssuer(t)
+	v := NewVerifier(i.URL+"/jwks", i.URL, "client")
+
+	with := func(name string, value any) map[string]any {
+		c := i.claims("client")
+		c[name] = value
+
+		return c
+	}
+
+	valid := i.token(i.claims("client"))
+	parts := strings.Split(valid, ".")
+
+	forged, _ := json.Marshal(with("sub", "admin"))
+	header, _ := json.Marshal(map[string]any{"alg": "HS256", "kid": "k1"})
+
+	// HS256 keyed with the public key, which anyone can fetch.
+	public, _ := x509.MarshalPKIXPublicKey(&i.key.PublicKey)
+	hs := base64.RawURLEncoding.EncodeToString(header) + "." + parts[1]
+	mac := hmac.New(sha256.New, public)
+	mac.Write([]byte(hs))
+
+	none, _ := json.Marshal(map[string]any{"alg": "none"})
+
+	for name, token := range map[string]string{
+		"expired":         i.token(with("exp", time.Now().Add(-time.Hour).Unix())),
+		"no expiry":       i.token(with("exp", nil)),
+		"not yet valid":   i.token(with("nbf", time.Now().Add(time.Hour).Unix())),
+		"other issuer":    i.token(with("iss", "https://evil.example.com")),
+		"other audience":  i.token(i.claims("another-client")),
+		"unknown key":     i.sign(map[string]any{"alg": "RS256", "kid": "k2"}, i.claims("client")),
+		"modified claims": parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2],
+		"alg none":        base64.RawURLEncoding.EncodeToString(none) + "." + parts[1] + ".",
+		"hs256":           hs + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)),
+		"malformed":       "not.a.token",
+	} {
+		if _, err := v.Verify(context.Background(), token); err == nil {
+			t.Errorf("%s: verified", name)
+		}
+	}
+}
+
+func TestVerifySlowReload(t *testing.T) {
+	i := newIssuer(t)
+	v := NewVerifier(i.URL+"/jwks", i.URL, "client")
+
+	if _, err := v.Verify(context.Background(), i.token(i.claims("client"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The issuer hangs on the next key set reload.
+	entered, release := make(chan struct{}), make(chan struct{})
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	v.jwksURL = slow.URL
+	v.fetched = time.Time{}
+
+	go v.Verify(context.Background(), i.sign(map[string]any{"alg": "RS256", "kid": "k2"}, i.claims("client")))
+
+	<-entered
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := v.Verify(context.Background(), i.token(i.claims("client")))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a token of a known key waited for the reload")
+	}
+}
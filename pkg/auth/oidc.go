@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const loginCookie = "wingman_login"
+
+// loginTTL bounds how long a login round trip through the IdP may take.
+const loginTTL = 10 * time.Minute
+
+// OIDC signs users in with the authorization code flow (with PKCE) and keeps
+// them signed in with a session cookie.
+type OIDC struct {
+	client *http.Client
+
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       string
+	groupsClaim  string
+
+	authURL   string
+	tokenURL  string
+	logoutURL string
+
+	verifier *Verifier
+	sessions *Sessions
+}
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL defaults to <external url>/auth/callback.
+	RedirectURL string
+
+	// Scopes defaults to "openid profile email".
+	Scopes string
+
+	// GroupsClaim defaults to "groups".
+	GroupsClaim string
+}
+
+func NewOIDC(cfg OIDCConfig, sessions *Sessions) (*OIDC, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("oidc: issuer is required")
+	}
+
+	if cfg.ClientID == "" {
+		return nil, errors.New("oidc: client_id is required")
+	}
+
+	if cfg.Scopes == "" {
+		cfg.Scopes = "openid profile email"
+	}
+
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	metadata, err := discover(cfg.Issuer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDC{
+		client: http.DefaultClient,
+
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		redirectURL:  cfg.RedirectURL,
+		scopes:       cfg.Scopes,
+		groupsClaim:  cfg.GroupsClaim,
+
+		authURL:   metadata.AuthorizationEndpoint,
+		tokenURL:  metadata.TokenEndpoint,
+		logoutURL: metadata.EndSessionEndpoint,
+
+		verifier: NewVerifier(metadata.JWKSURI, metadata.Issuer, cfg.ClientID),
+		sessions: sessions,
+	}, nil
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func discover(issuer string) (*providerMetadata, error) {
+	resp, err := http.Get(strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("oidc: discovery failed (" + resp.Status + ")")
+	}
+
+	var metadata providerMetadata
+
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, err
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is incomplete")
+	}
+
+	return &metadata, nil
+}
+
+func (o *OIDC) Attach(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/login", o.handleLogin)
+	mux.HandleFunc("GET /auth/callback", o.handleCallback)
+	mux.HandleFunc("GET /auth/logout", o.handleLogout)
+	mux.HandleFunc("GET /auth/me", handleMe)
+}
+
+func (o *OIDC) Wrap(next http.Handler) http.Handler {
+	return requireSession(o.sessions, next)
+}
+
+// requireSession requires a session (or a user identified earlier, e.g. by a
+// bearer token) for every request except the login endpoints and the assets a
+// browser fetches without credentials (web app manifest and icons). Page
+// navigations are redirected to the login, everything else is answered with
+// 401.
+func requireSession(sessions *Sessions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if user := sessions.User(r); user != nil {
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user, SourceLogin)))
+			return
+		}
+
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, "/auth/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// isPublicPath reports paths served without a session: the login itself,
+// the assets browsers fetch without credentials, and the admin API, which
+// requires its own token.
+func isPublicPath(p string) bool {
+	return strings.HasPrefix(p, "/auth/") || strings.HasPrefix(p, "/admin/") || p == "/manifest.json" || strings.HasPrefix(p, "/icon_")
+}
+
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+	Expires  int64  `json:"exp"`
+}
+
+func (o *OIDC) handleLogin(w http.ResponseWriter, r *http.Request) {
+	state := loginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString(),
+		Redirect: localRedirect(r.URL.Query().Get("redirect")),
+		Expires:  time.Now().Add(loginTTL).Unix(),
+	}
+
+	setCookie(w, r, loginCookie, o.sessions.sign(state), loginTTL)
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", o.clientID)
+	query.Set("redirect_uri", o.redirectURI(r))
+	query.Set("scope", o.scopes)
+	query.Set("state", state.State)
+	query.Set("nonce", state.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	http.Redirect(w, r, o.authURL+separator(o.authURL)+query.Encode(), http.StatusFound)
+}
+
+func (o *OIDC) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if e := query.Get("error"); e != "" {
+		http.Error(w, "login failed: "+e+" "+query.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+
+	var state loginState
+
+	c, err := r.Cookie(loginCookie)
+
+	if err != nil || o.sessions.verify(c.Value, &state) != nil || time.Now().Unix() > state.Expires || query.Get("state") != state.State {
+		http.Error(w, "login failed: invalid state", http.StatusBadRequest)
+		return
+	}
+
+	setCookie(w, r, loginCookie, "", -1)
+
+	idToken, err := o.exchange(r, query.Get("code"), state.Verifier)
+
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := o.verifier.Verify(r.Context(), idToken)
+
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if claims.String("nonce") != state.Nonce {
+		http.Error(w, "login failed: invalid nonce", http.StatusUnauthorized)
+		return
+	}
+
+	if err := o.sessions.Issue(w, r, claims.User(o.groupsClaim)); err != nil {
+		slog.Error("auth: session store", "error", err)
+		http.Error(w, "login failed: session store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+// exchange redeems the authorization code and returns the ID token.
+func (o *OIDC) exchange(r *http.Request, code, verifier string) (string, error) {
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", o.redirectURI(r))
+	data.Set("client_id", o.clientID)
+	data.Set("code_verifier", verifier)
+
+	if o.clientSecret != "" {
+		data.Set("client_secret", o.clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, o.tokenURL, strings.NewReader(data.Encode()))
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := o.client.Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("token exchange failed (" + resp.Status + "): " + strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		IDToken string `json:"id_token"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+
+	if result.IDToken == "" {
+		return "", errors.New("token exchange returned no id_token")
+	}
+
+	return result.IDToken, nil
+}
+
+func (o *OIDC) handleLogout(w http.ResponseWriter, r *http.Request) {
+	o.sessions.Clear(w, r)
+
+	if o.logoutURL == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("client_id", o.clientID)
+	query.Set("post_logout_redirect_uri", ExternalURL(r)+"/")
+
+	http.Redirect(w, r, o.logoutURL+separator(o.logoutURL)+query.Encode(), http.StatusFound)
+}
+
+func (o *OIDC) redirectURI(r *http.Request) string {
+	if o.redirectURL != "" {
+		return o.redirectURL
+	}
+
+	return ExternalURL(r) + "/auth/callback"
+}
+
+// handleMe returns the signed-in user.
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	user := UserFromContext(r.Context())
+
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// localRedirect only allows local paths, so the login can't be abused as an
+// open redirect. Browsers read backslashes as slashes, so "/\evil.com" (or
+// its escaped form) would leave the site as well as "//evil.com".
+func localRedirect(redirect string) string {
+	u, err := url.Parse(redirect)
+
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "/"
+	}
+
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(u.Path, "//") {
+		return "/"
+	}
+
+	if strings.Contains(redirect, `\`) || strings.Contains(u.Path, `\`) {
+		return "/"
+	}
+
+	return redirect
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func separator(u string) string {
+	if strings.Contains(u, "?") {
+		return "&"
+	}
+
+	return "?"
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLocalRedirect(t *testing.T) {
+	for redirect, want := range map[string]string{
+		"/":                   "/",
+		"/chat/123?tab=x#top": "/chat/123?tab=x#top",
+		"":                    "/",
+		"chat":                "/",
+		"//evil.com":          "/",
+		"///evil.com":         "/",
+		`/\evil.com`:          "/",
+		`/\/evil.com`:         "/",
+		"/%5Cevil.com":        "/",
+		"/%5cevil.com":        "/",
+		"/%2F%2Fevil.com":     "/",
+		"https://evil.com":    "/",
+		"javascript:alert(1)": "/",
+		"/\t/evil.com":        "/",
+		"/\n/evil.com":        "/",
+		"http:/evil.com":      "/",
+		"/chat%2Fpath/ok":     "/chat%2Fpath/ok",
+		"/user@evil.com/path": "/user@evil.com/path",
+		"https:///evil.com":   "/",
+		" //evil.com":         "/",
+	} {
+		if got := localRedirect(redirect); got != want {
+			t.Errorf("localRedirect(%q) = %q, want %q", redirect, got, want)
+		}
+	}
+}
+
+// oidcLogin starts a login and returns the login cookie and the query of the
+// redirect to the provider.
+func oidcLogin(t *testing.T, o *OIDC) (*http.Cookie, url.Values) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	o.handleLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/login?redirect=/chat/1", nil))
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+
+	if err != nil || rec.Code != http.StatusFound {
+		t.Fatalf("login: status = %d, location = %v", rec.Code, err)
+	}
+
+	return rec.Result().Cookies()[0], location.Query()
+}
+
+func TestOIDCLogin(t *testing.T) {
+	i := newIssuer(t)
+
+	// The provider remembers the challenge of the authorization request and
+	// the nonce to put into the ID token.
+	var challenge, nonce string
+
+	i.mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+		if r.PostFormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := i.claims("client")
+		claims["nonce"] = nonce
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": i.token(claims)})
+	})
+
+	o, err := NewOIDC(OIDCConfig{
+		Issuer:      i.URL,
+		ClientID:    "client",
+		RedirectURL: "https://chat.example.com/auth/callback",
+	}, NewSessions("secret", time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback := func(cookie *http.Cookie, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/auth/callback?"+query, nil)
+
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		o.handleCallback(rec, r)
+
+		return rec
+	}
+
+	cookie, query := oidcLogin(t, o)
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("redirect_uri") != "https://chat.example.com/auth/callback" {
+		t.Errorf("authorization request = %v", query)
+	}
+
+	challenge, nonce = query.Get("code_challenge"), query.Get("nonce")
+	state := query.Get("state")
+
+	for name, tc := range map[string]struct {
+		cookie *http.Cookie
+		query  string
+		status int
+	}{
+		"no login cookie": {nil, "code=code&state=" + state, http.StatusBadRequest},
+		"other state":     {cookie, "code=code&state=other", http.StatusBadRequest},
+		"other code":      {cookie, "code=stolen&state=" + state, http.StatusUnauthorized},
+	} {
+		if rec := callback(tc.cookie, tc.query); rec.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, tc.status)
+		}
+	}
+
+	// A code redeemed with another login's verifier fails PKCE.
+	other, otherQuery := oidcLogin(t, o)
+
+	if rec := callback(other, "code=code&state="+otherQuery.Get("state")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("other verifier: status = %d, want 401", rec.Code)
+	}
+
+	nonce = "replayed"
+
+	if rec := callback(cookie, "code=code&state="+state); rec.Code != http.StatusUnauthorized {
+		t.Errorf("other nonce: status = %d, want 401", rec.Code)
+	}
+
+	nonce = query.Get("nonce")
+
+	rec := callback(cookie, "code=code&state="+state)
+
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/chat/1" {
+		t.Fatalf("status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	var session *http.Cookie
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			session = c
+		}
+	}
+
+	if session == nil {
+		t.Fatal("no session was issued")
+	}
+
+	if user := o.sessions.User(withCookie(session)); user == nil || user.ID != "alice" {
+		t.Errorf("user = %+v, want alice", user)
+	}
+}
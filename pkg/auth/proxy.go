@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Proxy trusts the identity headers of an authenticating reverse proxy, but
+// only on connections from the proxy's networks; everyone else's are
+// stripped.
+type Proxy struct {
+	header  string
+	trusted []netip.Prefix
+
+	// Optional lets requests without identity headers, or from other
+	// networks, through anonymously instead of turning them away.
+	Optional bool
+}
+
+// ProxyFromEnv returns the proxy reading AUTH_HEADER from
+// AUTH_TRUSTED_PROXIES, or nil when neither is set. With trusted proxies
+// alone their identity headers are optional.
+func ProxyFromEnv() (*Proxy, error) {
+	header, trusted := os.Getenv("AUTH_HEADER"), env.List("AUTH_TRUSTED_PROXIES")
+
+	if header == "" && len(trusted) == 0 {
+		return nil, nil
+	}
+
+	p, err := NewProxy(header, trusted)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.Optional = header == ""
+
+	return p, nil
+}
+
+// NewProxy reads the user from header (default X-Forwarded-User) on requests
+// from the trusted networks (CIDRs or addresses, default loopback).
+func NewProxy(header string, trusted []string) (*Proxy, error) {
+	if header == "" {
+		header = "X-Forwarded-User"
+	}
+
+	if len(trusted) == 0 {
+		trusted = []string{"127.0.0.0/8", "::1/128"}
+	}
+
+	p := &Proxy{
+		header: header,
+	}
+
+	for _, s := range trusted {
+		prefix, err := netip.ParsePrefix(s)
+
+		if err != nil {
+			addr, err := netip.ParseAddr(s)
+
+			if err != nil {
+				return nil, errors.New("auth: invalid trusted proxy " + s)
+			}
+
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+
+		p.trusted = append(p.trusted, prefix.Masked())
+	}
+
+	return p, nil
+}
+
+// Require answers requests from other networks with 403 and requests
+// without the identity header with 401, unless the caller was already
+// identified otherwise (e.g. by an API key).
+func (p *Proxy) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !p.isTrusted(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		user := userFromHeaders(r.Header, p.header)
+
+		if user == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user, SourceProxy)))
+	})
+}
+
+// Identify attaches the user announced by the proxy, if any, to requests
+// from the trusted networks, unless the caller was already identified
+// otherwise.
+func (p *Proxy) Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r.Context()) == nil && p.isTrusted(r.RemoteAddr) {
+			if user := userFromHeaders(r.Header, p.header); user != nil {
+				r = r.WithContext(WithUser(r.Context(), user, SourceProxy))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Strip removes the identity and forwarding headers, and the other headers
+// only the proxy may set (e.g. the GeoIP header), from requests that don't come from the
+// trusted networks, so nothing downstream mistakes them for the proxy's. A
+// nil Proxy trusts no one.
+func (p *Proxy) Strip(next http.Handler, headers ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p == nil || !p.isTrusted(r.RemoteAddr) {
+			for _, header := range identityHeaders {
+				r.Header.Del(header)
+			}
+
+			for _, header := range forwardedHeaders {
+				r.Header.Del(header)
+			}
+
+			for _, header := range headers {
+				r.Header.Del(header)
+			}
+
+			if p != nil {
+				r.Header.Del(p.header)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isTrusted reports whether the connection comes from a trusted network.
+// Connections over a Unix socket have no address and are local by nature.
+func (p *Proxy) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+
+	if err != nil {
+		return remoteAddr == "" || remoteAddr == "@"
+	}
+
+	addr, err := netip.ParseAddr(host)
+
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// identify runs r through the proxy like the server does and returns the
+// user the handler saw and the header it was left with.
+func identify(p *Proxy, wrap func(http.Handler) http.Handler, r *http.Request) (*User, Source, http.Header, int) {
+	var user *User
+	var source Source
+	var header http.Header
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = UserFromContext(r.Context())
+		source = SourceFromContext(r.Context())
+		header = r.Header.Clone()
+	})
+
+	handler := http.Handler(next)
+
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+
+	rec := httptest.NewRecorder()
+	p.Strip(handler).ServeHTTP(rec, r)
+
+	return user, source, header, rec.Code
+}
+
+func spoofed(remoteAddr string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/models", nil)
+	r.RemoteAddr = remoteAddr
+
+	r.Header.Set("X-Forwarded-User", "admin")
+	r.Header.Set("X-Forwarded-Email", "admin@example.com")
+	r.Header.Set("X-Forwarded-Groups", "admins")
+
+	return r
+}
+
+func TestStripWithoutProxy(t *testing.T) {
+	var p *Proxy
+
+	user, _, header, _ := identify(p, nil, spoofed("203.0.113.7:1234"))
+
+	if user != nil {
+		t.Fatalf("user = %+v, want none", user)
+	}
+
+	for _, name := range identityHeaders {
+		if header.Get(name) != "" {
+			t.Errorf("%s was not stripped", name)
+		}
+	}
+}
+
+func TestOptionalProxyIgnoresUntrusted(t *testing.T) {
+	p, err := NewProxy("", []string{"10.0.0.0/8"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Optional = true
+
+	user, _, header, code := identify(p, p.Identify, spoofed("203.0.113.7:1234"))
+
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+
+	if user != nil {
+		t.Fatalf("user = %+v, want none", user)
+	}
+
+	if header.Get("X-Forwarded-Groups") != "" {
+		t.Error("X-Forwarded-Groups was not stripped")
+	}
+}
+
+func TestOptionalProxyTrusted(t *testing.T) {
+	p, err := NewProxy("", []string{"10.0.0.0/8"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Optional = true
+
+	user, source, _, _ := identify(p, p.Identify, spoofed("10.1.2.3:1234"))
+
+	if user == nil || user.ID != "admin" || !user.InGroup("admins") {
+		t.Fatalf("user = %+v, want admin in admins", user)
+	}
+
+	if source != SourceProxy {
+		t.Errorf("source = %d, want SourceProxy", source)
+	}
+
+	user, _, _, _ = identify(p, p.Identify, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if user != nil {
+		t.Errorf("user = %+v without headers, want none", user)
+	}
+}
+
+func TestRequiredProxy(t *testing.T) {
+	p, err := NewProxy("X-Auth-User", []string{"10.0.0.1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := spoofed("203.0.113.7:1234")
+	r.Header.Set("X-Auth-User", "admin")
+
+	if _, _, header, code := identify(p, p.Require, r); code != http.StatusForbidden {
+		t.Errorf("untrusted: status = %d, want 403", code)
+	} else if header != nil {
+		t.Error("untrusted request reached the handler")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+
+	if _, _, _, code := identify(p, p.Require, r); code != http.StatusUnauthorized {
+		t.Errorf("without header: status = %d, want 401", code)
+	}
+
+	r.Header.Set("X-Auth-User", "alice")
+
+	if user, _, _, code := identify(p, p.Require, r); code != http.StatusOK || user == nil || user.ID != "alice" {
+		t.Errorf("trusted: status = %d, user = %+v, want alice", code, user)
+	}
+}
+
+func TestAuthenticated(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	for source, want := range map[Source]bool{
+		SourceLogin:  true,
+		SourceBearer: true,
+		SourceProxy:  true,
+		SourceAPIKey: true,
+		SourceGuest:  false,
+	} {
+		ctx := WithUser(r.Context(), &User{ID: "u"}, source)
+
+		if got := Authenticated(ctx) != nil; got != want {
+			t.Errorf("source %d: authenticated = %v, want %v", source, got, want)
+		}
+	}
+
+	if Authenticated(r.Context()) != nil {
+		t.Error("anonymous request is authenticated")
+	}
+}
+
+func TestStripProxyHeaders(t *testing.T) {
+	p, err := NewProxy("", []string{"10.0.0.0/8"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		proxy      *Proxy
+		remoteAddr string
+		want       string
+	}{
+		{"untrusted", p, "203.0.113.7:1234", ""},
+		{"trusted", p, "10.0.0.2:1234", "CH"},
+		{"no proxy", nil, "10.0.0.2:1234", ""},
+	} {
+		// A client picking the region of the residency policy itself.
+		r := spoofed(tc.remoteAddr)
+		r.Header.Set("CF-IPCountry", "CH")
+
+		var country string
+
+		tc.proxy.Strip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			country = r.Header.Get("CF-IPCountry")
+		}), "CF-IPCountry").ServeHTTP(httptest.NewRecorder(), r)
+
+		if country != tc.want {
+			t.Errorf("%s: country = %q, want %q", tc.name, country, tc.want)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package auth
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	nsSAML      = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsSAMLP     = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	bindingPOST = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	bindingGET  = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+)
+
+// SAML signs users in as a SAML 2.0 service provider (SP-initiated, HTTP
+// redirect binding for requests, HTTP POST binding for responses) and keeps
+// them signed in with a session cookie. Assertions must be signed; encrypted
+// assertions are not supported.
+type SAML struct {
+	entityID string
+	acsURL   string
+
+	idpEntityID string
+	ssoURL      string
+	certs       []*x509.Certificate
+
+	groupsAttribute string
+
+	sessions *Sessions
+}
+
+type SAMLConfig struct {
+	// BaseURL is the address users reach the app at, e.g.
+	// https://chat.example.com. The service provider URLs are never taken
+	// from the request, whose host headers the client controls.
+	BaseURL string
+
+	// EntityID defaults to <base url>/auth/saml/metadata.
+	EntityID string
+
+	// ACSURL defaults to <base url>/auth/saml/acs.
+	ACSURL string
+
+	// MetadataURL is the IdP's metadata, providing entity id, SSO endpoint
+	// and signing certificates. Alternatively set the three explicitly.
+	MetadataURL string
+
+	IdPEntityID string
+	SSOURL      string
+
+	// Certificate is a PEM file with the IdP's signing certificate(s).
+	Certificate string
+
+	// GroupsAttribute defaults to "groups".
+	GroupsAttribute string
+}
+
+func NewSAML(cfg SAMLConfig, sessions *Sessions) (*SAML, error) {
+	s := &SAML{
+		entityID: cfg.EntityID,
+		acsURL:   cfg.ACSURL,
+
+		idpEntityID: cfg.IdPEntityID,
+		ssoURL:      cfg.SSOURL,
+
+		groupsAttribute: cfg.GroupsAttribute,
+
+		sessions: sessions,
+	}
+
+	if s.groupsAttribute == "" {
+		s.groupsAttribute = "groups"
+	}
+
+	if base := strings.TrimSuffix(cfg.BaseURL, "/"); base != "" {
+		if u, err := url.Parse(base); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, errors.New("saml: base url must be an absolute url")
+		}
+
+		if s.entityID == "" {
+			s.entityID = base + "/auth/saml/metadata"
+		}
+
+		if s.acsURL == "" {
+			s.acsURL = base + "/auth/saml/acs"
+		}
+	}
+
+	if s.entityID == "" || s.acsURL == "" {
+		return nil, errors.New("saml: base url, or entity id and acs url, are required")
+	}
+
+	if cfg.MetadataURL != "" {
+		if err := s.loadMetadata(cfg.MetadataURL); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Certificate != "" {
+		data, err := os.ReadFile(cfg.Certificate)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			cert, err := x509.ParseCertificate(block.Bytes)
+
+			if err != nil {
+				return nil, err
+			}
+
+			s.certs = append(s.certs, cert)
+		}
+	}
+
+	if s.idpEntityID == "" || s.ssoURL == "" || len(s.certs) == 0 {
+		return nil, errors.New("saml: idp entity id, sso url and signing certificate are required")
+	}
+
+	return s, nil
+}
+
+type idpMetadata struct {
+	EntityID string `xml:"entityID,attr"`
+
+	IDPSSODescriptor struct {
+		KeyDescriptors []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+
+		SingleSignOnServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+}
+
+func (s *SAML) loadMetadata(metadataURL string) error {
+	resp, err := http.Get(metadataURL)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("saml: fetching metadata failed (" + resp.Status + ")")
+	}
+
+	var metadata idpMetadata
+
+	if err := xml.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return err
+	}
+
+	if s.idpEntityID == "" {
+		s.idpEntityID = metadata.EntityID
+	}
+
+	for _, sso := range metadata.IDPSSODescriptor.SingleSignOnServices {
+		if sso.Binding == bindingGET && s.ssoURL == "" {
+			s.ssoURL = sso.Location
+		}
+	}
+
+	for _, kd := range metadata.IDPSSODescriptor.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+
+		for _, c := range kd.Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(c), ""))
+
+			if err != nil {
+				return err
+			}
+
+			cert, err := x509.ParseCertificate(der)
+
+			if err != nil {
+				return err
+			}
+
+			s.certs = append(s.certs, cert)
+		}
+	}
+
+	return nil
+}
+
+func (s *SAML) Attach(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/login", s.handleLogin)
+	mux.HandleFunc("POST /auth/saml/acs", s.handleACS)
+	mux.HandleFunc("GET /auth/saml/metadata", s.handleMetadata)
+	mux.HandleFunc("GET /auth/logout", s.handleLogout)
+	mux.HandleFunc("GET /auth/me", handleMe)
+}
+
+func (s *SAML) Wrap(next http.Handler) http.Handler {
+	return requireSession(s.sessions, next)
+}
+
+// handleMetadata describes this service provider to the IdP.
+func (s *SAML) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="%s" entityID="%s">
+  <md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="%s">
+    <md:NameIDFormat>urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified</md:NameIDFormat>
+    <md:AssertionConsumerService Binding="%s" Location="%s" index="0" isDefault="true"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>
+`, nsMetadata, escapeXML(s.entityID), nsSAMLP, bindingPOST, escapeXML(s.acsURL))
+}
+
+type samlLoginState struct {
+	RequestID string `json:"request"`
+	Relay     string `json:"relay"`
+	Redirect  string `json:"redirect"`
+	Expires   int64  `json:"exp"`
+}
+
+func (s *SAML) handleLogin(w http.ResponseWriter, r *http.Request) {
+	state := samlLoginState{
+		RequestID: "_" + randomString(),
+		Relay:     randomString(),
+		Redirect:  localRedirect(r.URL.Query().Get("redirect")),
+		Expires:   time.Now().Add(loginTTL).Unix(),
+	}
+
+	// The IdP posts the response back cross-site, so the state cookie must
+	// be sent on that POST; browsers only allow this for secure cookies.
+	c := &http.Cookie{
+		Name:     loginCookie,
+		Value:    s.sessions.sign(state),
+		Path:     "/",
+		MaxAge:   int(loginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if c.Secure {
+		c.SameSite = http.SameSiteNoneMode
+	}
+
+	http.SetCookie(w, c)
+
+	request := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s"><saml:Issuer>%s</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`,
+		nsSAMLP, nsSAML, state.RequestID, time.Now().UTC().Format(time.RFC3339), escapeXML(s.ssoURL), escapeXML(s.acsURL), bindingPOST, escapeXML(s.entityID))
+
+	var buf bytes.Buffer
+
+	fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	fw.Write([]byte(request))
+	fw.Close()
+
+	query := url.Values{}
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	query.Set("RelayState", state.Relay)
+
+	http.Redirect(w, r, s.ssoURL+separator(s.ssoURL)+query.Encode(), http.StatusFound)
+}
+
+func (s *SAML) handleACS(w http.ResponseWriter, r *http.Request) {
+	var state samlLoginState
+
+	c, err := r.Cookie(loginCookie)
+
+	// Only responses to our own requests are accepted (no IdP-initiated login).
+	if err != nil || s.sessions.verify(c.Value, &state) != nil || time.Now().Unix() > state.Expires || r.PostFormValue("RelayState") != state.Relay {
+		http.Error(w, "login failed: invalid state", http.StatusBadRequest)
+		return
+	}
+
+	setCookie(w, r, loginCookie, "", -1)
+
+	data, err := base64.StdEncoding.DecodeString(r.PostFormValue("SAMLResponse"))
+
+	if err != nil {
+		http.Error(w, "login failed: malformed response", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.parseResponse(data, state.RequestID)
+
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.sessions.Issue(w, r, user); err != nil {
+		slog.Error("auth: session store", "error", err)
+		http.Error(w, "login failed: session store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+func (s *SAML) handleLogout(w http.ResponseWriter, r *http.Request) {
+	s.sessions.Clear(w, r)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+type samlResponse struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+
+	Assertions []samlAssertion `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+}
+
+type samlAssertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+
+	Issuer string `xml:"Issuer"`
+
+	Subject struct {
+		NameID string `xml:"NameID"`
+
+		Confirmations []struct {
+			Method string `xml:"Method,attr"`
+
+			Data struct {
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+				Recipient    string    `xml:"Recipient,attr"`
+				InResponseTo string    `xml:"InResponseTo,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+
+	Conditions struct {
+		NotBefore    time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+
+		Audiences []string `xml:"AudienceRestriction>Audience"`
+	} `xml:"Conditions"`
+
+	Attributes []struct {
+		Name         string   `xml:"Name,attr"`
+		FriendlyName string   `xml:"FriendlyName,attr"`
+		Values       []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+}
+
+// parseResponse verifies a SAML response and returns the user it asserts.
+// Whatever is read comes from the canonical bytes of the signed element.
+func (s *SAML) parseResponse(data []byte, requestID string) (*User, error) {
+	root, err := parseXML(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !root.is(nsSAMLP, "Response") {
+		return nil, errors.New("not a SAML response")
+	}
+
+	if d := root.attr("Destination"); d != "" && d != s.acsURL {
+		return nil, errors.New("response is meant for another destination")
+	}
+
+	status := root.child(nsSAMLP, "Status")
+
+	if status == nil || status.child(nsSAMLP, "StatusCode") == nil || status.child(nsSAMLP, "StatusCode").attr("Value") != statusSuccess {
+		return nil, errors.New("identity provider reported an error")
+	}
+
+	var assertions []*xmlNode
+
+	for _, e := range root.elements() {
+		if e.is(nsSAML, "EncryptedAssertion") {
+			return nil, errors.New("encrypted assertions are not supported")
+		}
+
+		if e.is(nsSAML, "Assertion") {
+			assertions = append(assertions, e)
+		}
+	}
+
+	if len(assertions) != 1 {
+		return nil, errors.New("exactly one assertion is required")
+	}
+
+	var assertion samlAssertion
+
+	if root.child(nsDSig, "Signature") != nil {
+		signed, err := verifyEnveloped(root, s.certs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var response samlResponse
+
+		if err := xml.Unmarshal(signed, &response); err != nil {
+			return nil, err
+		}
+
+		if len(response.Assertions) != 1 {
+			return nil, errors.New("exactly one assertion is required")
+		}
+
+		assertion = response.Assertions[0]
+	} else {
+		signed, err := verifyEnveloped(assertions[0], s.certs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := xml.Unmarshal(signed, &assertion); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkAssertion(&assertion, requestID); err != nil {
+		return nil, err
+	}
+
+	return s.user(&assertion), nil
+}
+
+func (s *SAML) checkAssertion(a *samlAssertion, requestID string) error {
+	now := time.Now()
+
+	if a.Issuer != s.idpEntityID {
+		return errors.New("unexpected issuer")
+	}
+
+	if c := a.Conditions; (!c.NotBefore.IsZero() && now.Add(leeway).Before(c.NotBefore)) || (!c.NotOnOrAfter.IsZero() && !now.Before(c.NotOnOrAfter.Add(leeway))) {
+		return errors.New("assertion is not valid at this time")
+	}
+
+	if len(a.Conditions.Audiences) > 0 && !slices.Contains(a.Conditions.Audiences, s.entityID) {
+		return errors.New("assertion is meant for another audience")
+	}
+
+	for _, c := range a.Subject.Confirmations {
+		if c.Method != "urn:oasis:names:tc:SAML:2.0:cm:bearer" {
+			continue
+		}
+
+		if c.Data.InResponseTo != requestID || c.Data.Recipient != s.acsURL {
+			continue
+		}
+
+		if c.Data.NotOnOrAfter.IsZero() || !now.Before(c.Data.NotOnOrAfter.Add(leeway)) {
+			continue
+		}
+
+		if a.Subject.NameID == "" {
+			return errors.New("assertion has no subject")
+		}
+
+		return nil
+	}
+
+	return errors.New("assertion has no valid bearer confirmation")
+}
+
+// user maps the subject and common attribute names to a user.
+func (s *SAML) user(a *samlAssertion) *User {
+	values := func(names ...string) []string {
+		for _, attr := range a.Attributes {
+			if slices.Contains(names, attr.Name) || slices.Contains(names, attr.FriendlyName) {
+				return attr.Values
+			}
+		}
+
+		return nil
+	}
+
+	first := func(names ...string) string {
+		if v := values(names...); len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+
+		return ""
+	}
+
+	return &User{
+		ID:     strings.TrimSpace(a.Subject.NameID),
+		Email:  first("email", "mail", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress", "urn:oid:0.9.2342.19200300.100.1.3"),
+		Name:   first("name", "displayName", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name", "urn:oid:2.16.840.1.113730.3.1.241"),
+		Groups: values(s.groupsAttribute),
+	}
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
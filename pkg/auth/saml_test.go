@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testEntityID = "https://chat.example.com/auth/saml/metadata"
+	testACSURL   = "https://chat.example.com/auth/saml/acs"
+	testIdP      = "https://idp.example.com"
+	testRequest  = "_request"
+)
+
+func testKey(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, cert
+}
+
+func testSAML(certs ...*x509.Certificate) *SAML {
+	return &SAML{
+		entityID: testEntityID,
+		acsURL:   testACSURL,
+
+		idpEntityID: testIdP,
+		certs:       certs,
+
+		groupsAttribute: "groups",
+	}
+}
+
+// assertionBody is the content of an assertion for subject, in canonical
+// form so the test can sign it without a canonicalizer of its own.
+func assertionBody(subject string) string {
+	expires := time.Now().Add(5 * time.Minute).UTC().Format(time.RFC3339)
+
+	return `<saml:Subject><saml:NameID>` + subject + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+		`<saml:SubjectConfirmationData InResponseTo="` + testRequest + `" NotOnOrAfter="` + expires + `" Recipient="` + testACSURL + `"></saml:SubjectConfirmationData>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions><saml:AudienceRestriction><saml:Audience>` + testEntityID + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>`
+}
+
+var testAlgorithms = map[crypto.Hash][2]string{
+	crypto.SHA1:   {"http://www.w3.org/2000/09/xmldsig#rsa-sha1", "http://www.w3.org/2000/09/xmldsig#sha1"},
+	crypto.SHA256: {"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256", "http://www.w3.org/2001/04/xmlenc#sha256"},
+}
+
+// signAssertion returns an assertion with the given id and body, signed by
+// key with an enveloped signature.
+func signAssertion(key *rsa.PrivateKey, hash crypto.Hash, id, body string) string {
+	open := `<saml:Assertion xmlns:saml="` + nsSAML + `" ID="` + id + `" IssueInstant="2024-01-01T00:00:00Z" Version="2.0">`
+	issuer := `<saml:Issuer>` + testIdP + `</saml:Issuer>`
+
+	digest := hashOf(hash, open+issuer+body+`</saml:Assertion>`)
+
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + nsDSig + `">` +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="` + testAlgorithms[hash][0] + `"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnveloped + `"></ds:Transform>` +
+		`<ds:Transform Algorithm="` + algExcC14N + `"></ds:Transform>` +
+		`</ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + testAlgorithms[hash][1] + `"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest) + `</ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo>`
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, hash, hashOf(hash, signedInfo))
+
+	if err != nil {
+		panic(err)
+	}
+
+	signature := `<ds:Signature xmlns:ds="` + nsDSig + `">` + signedInfo +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(sig) + `</ds:SignatureValue></ds:Signature>`
+
+	return open + issuer + signature + body + `</saml:Assertion>`
+}
+
+func hashOf(hash crypto.Hash, data string) []byte {
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(data))
+		return sum[:]
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	return sum[:]
+}
+
+func response(content string) []byte {
+	return []byte(`<samlp:Response xmlns:samlp="` + nsSAMLP + `" xmlns:saml="` + nsSAML + `" ID="_response" Version="2.0" Destination="` + testACSURL + `">` +
+		`<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"/></samlp:Status>` +
+		content + `</samlp:Response>`)
+}
+
+func TestParseResponse(t *testing.T) {
+	key, cert := testKey(t)
+
+	user, err := testSAML(cert).parseResponse(response(signAssertion(key, crypto.SHA256, "_a1", assertionBody("alice"))), testRequest)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ID != "alice" {
+		t.Errorf("user = %q, want alice", user.ID)
+	}
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	key, cert := testKey(t)
+	other, _ := testKey(t)
+
+	signed := signAssertion(key, crypto.SHA256, "_a1", assertionBody("alice"))
+	signature := signed[strings.Index(signed, "<ds:Signature") : strings.Index(signed, "</ds:Signature>")+len("</ds:Signature>")]
+
+	evil := func(id, signature string) string {
+		return `<saml:Assertion ID="` + id + `" IssueInstant="2024-01-01T00:00:00Z" Version="2.0">` +
+			`<saml:Issuer>` + testIdP + `</saml:Issuer>` + signature + assertionBody("admin") + `</saml:Assertion>`
+	}
+
+	for name, content := range map[string]string{
+		"unsigned": evil("_evil", ""),
+
+		"modified subject": strings.Replace(signed, "<saml:NameID>alice<", "<saml:NameID>admin<", 1),
+
+		"forged with another key": signAssertion(other, crypto.SHA256, "_a1", assertionBody("admin")),
+
+		"sha-1": signAssertion(key, crypto.SHA1, "_a1", assertionBody("alice")),
+
+		// The signature of the genuine assertion, copied into a forged one.
+		"copied signature":         evil("_evil", signature),
+		"copied signature same id": evil("_a1", signature),
+
+		// The genuine assertion hidden next to, or inside, a forged one.
+		"wrapped beside":     evil("_evil", "") + `<samlp:Extensions>` + signed + `</samlp:Extensions>`,
+		"wrapped inside":     evil("_a1", `<ds:Object xmlns:ds="`+nsDSig+`">`+signed+`</ds:Object>`),
+		"two assertions":     signed + evil("_evil", ""),
+		"two assertions too": evil("_evil", "") + signed,
+	} {
+		if user, err := testSAML(cert).parseResponse(response(content), testRequest); err == nil {
+			t.Errorf("%s: accepted as %q", name, user.ID)
+		}
+	}
+}
+
+func TestParseResponseChecksRequest(t *testing.T) {
+	key, cert := testKey(t)
+
+	data := response(signAssertion(key, crypto.SHA256, "_a1", assertionBody("alice")))
+
+	if _, err := testSAML(cert).parseResponse(data, "_another"); err == nil {
+		t.Error("a response to another request was accepted")
+	}
+
+	s := testSAML(cert)
+	s.acsURL = "https://other.example.com/auth/saml/acs"
+
+	if _, err := s.parseResponse(data, testRequest); err == nil {
+		t.Error("a response for another service provider was accepted")
+	}
+}
+
+func TestNewSAMLNeedsURLs(t *testing.T) {
+	cfg := SAMLConfig{
+		IdPEntityID: testIdP,
+		SSOURL:      "https://idp.example.com/sso",
+	}
+
+	if _, err := NewSAML(cfg, nil); err == nil {
+		t.Error("NewSAML without service provider urls succeeded")
+	}
+
+	cfg.BaseURL = "chat.example.com"
+
+	if _, err := NewSAML(cfg, nil); err == nil {
+		t.Error("NewSAML with a relative base url succeeded")
+	}
+}
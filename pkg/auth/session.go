@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+const sessionCookie = "wingman_session"
+
+// touchInterval limits how often the last use of a tracked session is
+// written.
+const touchInterval = time.Minute
+
+// Sessions issues and reads signed session cookies carrying the user, or,
+// when tracked, the id of a session kept in a store.
+type Sessions struct {
+	secret []byte
+	ttl    time.Duration
+
+	store SessionStore
+	idle  time.Duration
+}
+
+// NewSessions signs cookies with secret; an empty secret generates a random
+// one, which invalidates sessions on restart and across replicas.
+func NewSessions(secret string, ttl time.Duration) *Sessions {
+	key := []byte(secret)
+
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+
+	return &Sessions{
+		secret: key,
+		ttl:    ttl,
+	}
+}
+
+// sessionsFromEnv signs cookies with SESSION_SECRET, valid for SESSION_TTL
+// (default 12h), and tracks them in SESSION_STORE, if set, ending them after
+// SESSION_IDLE_TIMEOUT.
+func sessionsFromEnv() (*Sessions, error) {
+	s := NewSessions(os.Getenv("SESSION_SECRET"), env.Duration("SESSION_TTL", 12*time.Hour))
+
+	if config := os.Getenv("SESSION_STORE"); config != "" {
+		store, err := NewSessionStore(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.Track(store, env.Duration("SESSION_IDLE_TIMEOUT", 0))
+	}
+
+	return s, nil
+}
+
+// Track keeps sessions in store, so they can be listed and revoked, and
+// ends them after idle time without requests (0 disables this). Cookies
+// issued before are no longer accepted.
+func (s *Sessions) Track(store SessionStore, idle time.Duration) {
+	s.store = store
+	s.idle = idle
+}
+
+// Tracked reports whether sessions are kept on the server.
+func (s *Sessions) Tracked() bool {
+	return s.store != nil
+}
+
+type sessionPayload struct {
+	ID      string `json:"sid,omitempty"`
+	User    *User  `json:"user,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+// Issue sets the session cookie for user.
+func (s *Sessions) Issue(w http.ResponseWriter, r *http.Request, user *User) error {
+	now := time.Now()
+
+	payload := sessionPayload{
+		User:    user,
+		Expires: now.Add(s.ttl).Unix(),
+	}
+
+	if s.store != nil {
+		session := &Session{
+			ID:   randomString(),
+			User: user,
+
+			Address:   remoteHost(r),
+			UserAgent: r.UserAgent(),
+
+			Created: now,
+			Seen:    now,
+			Expires: now.Add(s.ttl),
+		}
+
+		if err := s.store.Put(session); err != nil {
+			return err
+		}
+
+		payload.ID = session.ID
+		payload.User = nil
+	}
+
+	setCookie(w, r, sessionCookie, s.sign(payload), s.ttl)
+
+	return nil
+}
+
+// User returns the user of a valid session cookie, or nil.
+func (s *Sessions) User(r *http.Request) *User {
+	c, err := r.Cookie(sessionCookie)
+
+	if err != nil {
+		return nil
+	}
+
+	var payload sessionPayload
+
+	if err := s.verify(c.Value, &payload); err != nil {
+		return nil
+	}
+
+	if time.Now().Unix() > payload.Expires {
+		return nil
+	}
+
+	if s.store == nil {
+		return payload.User
+	}
+
+	if payload.ID == "" {
+		return nil
+	}
+
+	session, err := s.store.Get(payload.ID)
+
+	if err != nil {
+		slog.Error("auth: session store", "error", err)
+		return nil
+	}
+
+	if session == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	if s.idle > 0 && now.Sub(session.Seen) > s.idle {
+		s.store.Delete(session.ID)
+		return nil
+	}
+
+	if now.Sub(session.Seen) >= touchInterval {
+		session.Seen = now
+
+		if err := s.store.Put(session); err != nil {
+			slog.Error("auth: session store", "error", err)
+		}
+	}
+
+	return session.User
+}
+
+// Clear ends the session and removes its cookie.
+func (s *Sessions) Clear(w http.ResponseWriter, r *http.Request) {
+	if s.store != nil {
+		var payload sessionPayload
+
+		if c, err := r.Cookie(sessionCookie); err == nil && s.verify(c.Value, &payload) == nil && payload.ID != "" {
+			s.store.Delete(payload.ID)
+		}
+	}
+
+	setCookie(w, r, sessionCookie, "", -1)
+}
+
+// List returns the active sessions of tracked sessions, newest first.
+func (s *Sessions) List() ([]*Session, error) {
+	if s.store == nil {
+		return nil, errors.New("sessions are not tracked")
+	}
+
+	sessions, err := s.store.List()
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	sessions = slices.DeleteFunc(sessions, func(session *Session) bool {
+		return s.idle > 0 && now.Sub(session.Seen) > s.idle
+	})
+
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return b.Created.Compare(a.Created)
+	})
+
+	return sessions, nil
+}
+
+// Revoke ends a tracked session.
+func (s *Sessions) Revoke(id string) error {
+	if s.store == nil {
+		return errors.New("sessions are not tracked")
+	}
+
+	return s.store.Delete(id)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// sign encodes v as base64url(JSON) followed by its HMAC-SHA256.
+func (s *Sessions) sign(v any) string {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	return payload + "." + s.mac(payload)
+}
+
+func (s *Sessions) verify(value string, v any) error {
+	payload, mac, ok := strings.Cut(value, ".")
+
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.mac(payload))) {
+		return errors.New("invalid signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func (s *Sessions) mac(payload string) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// setCookie writes an HttpOnly cookie for the whole site; a negative ttl
+// deletes it.
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string, ttl time.Duration) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if ttl < 0 {
+		c.MaxAge = -1
+	} else {
+		c.MaxAge = int(ttl.Seconds())
+	}
+
+	http.SetCookie(w, c)
+}
+
+func isHTTPS(r *http.Request) bool {
+	if u, _ := PublicURL(); u != nil {
+		return u.Scheme == "https"
+	}
+
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// PublicURL returns PUBLIC_URL, the origin clients reach us at, or nil when
+// it is unset.
+func PublicURL() (*url.URL, error) {
+	s := os.Getenv("PUBLIC_URL")
+
+	if s == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(strings.TrimRight(s, "/"))
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+		return nil, errors.New("PUBLIC_URL: must be an origin like https://chat.example.com")
+	}
+
+	return u, nil
+}
+
+// ExternalURL returns the scheme and host the client used to reach us:
+// PUBLIC_URL when it is set, otherwise the request's, as forwarded by a
+// trusted proxy (Strip drops the forwarding headers of anyone else).
+func ExternalURL(r *http.Request) string {
+	if u, _ := PublicURL(); u != nil {
+		return u.Scheme + "://" + u.Host
+	}
+
+	scheme := "http"
+
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+
+	host := r.Host
+
+	if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+		host = h
+	}
+
+	return scheme + "://" + host
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issue returns the session cookie sessions set for user.
+func issue(t *testing.T, sessions *Sessions, user *User) *http.Cookie {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+
+	if err := sessions.Issue(rec, httptest.NewRequest(http.MethodGet, "/", nil), user); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			return c
+		}
+	}
+
+	t.Fatal("no session cookie")
+	return nil
+}
+
+func withCookie(c *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(c)
+
+	return r
+}
+
+func TestSessions(t *testing.T) {
+	sessions := NewSessions("secret", time.Hour)
+	c := issue(t, sessions, &User{ID: "alice"})
+
+	if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie = %+v", c)
+	}
+
+	if user := sessions.User(withCookie(c)); user == nil || user.ID != "alice" {
+		t.Fatalf("user = %+v, want alice", user)
+	}
+
+	// Another user's payload with alice's MAC.
+	_, mac, _ := strings.Cut(c.Value, ".")
+	payload, _, _ := strings.Cut(sessions.sign(sessionPayload{User: &User{ID: "admin"}, Expires: time.Now().Add(time.Hour).Unix()}), ".")
+
+	if user := sessions.User(withCookie(&http.Cookie{Name: sessionCookie, Value: payload + "." + mac})); user != nil {
+		t.Errorf("a modified cookie signed in %+v", user)
+	}
+
+	if user := NewSessions("other", time.Hour).User(withCookie(c)); user != nil {
+		t.Errorf("a cookie of another secret signed in %+v", user)
+	}
+}
+
+func TestSessionsExpire(t *testing.T) {
+	sessions := NewSessions("secret", time.Hour)
+
+	c := &http.Cookie{
+		Name:  sessionCookie,
+		Value: sessions.sign(sessionPayload{User: &User{ID: "alice"}, Expires: time.Now().Add(-time.Second).Unix()}),
+	}
+
+	if user := sessions.User(withCookie(c)); user != nil {
+		t.Errorf("an expired session signed in %+v", user)
+	}
+}
+
+func TestExternalURL(t *testing.T) {
+	p, err := NewProxy("", []string{"10.0.0.0/8"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		publicURL  string
+		remoteAddr string
+		want       string
+	}{
+		{"untrusted", "", "203.0.113.7:1234", "http://chat.local"},
+		{"trusted", "", "10.0.0.2:1234", "https://chat.example.com"},
+		{"public url", "https://chat.example.com/", "203.0.113.7:1234", "https://chat.example.com"},
+	} {
+		t.Setenv("PUBLIC_URL", tc.publicURL)
+
+		// Only a trusted proxy may tell the scheme and host; a client
+		// could point the login redirect at its own host otherwise.
+		r := httptest.NewRequest(http.MethodGet, "http://chat.local/auth/login", nil)
+		r.RemoteAddr = tc.remoteAddr
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "chat.example.com")
+
+		var got string
+		var secure bool
+
+		p.Strip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, secure = ExternalURL(r), isHTTPS(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+
+		if got != tc.want {
+			t.Errorf("%s: ExternalURL = %q, want %q", tc.name, got, tc.want)
+		}
+
+		if want := strings.HasPrefix(tc.want, "https:"); secure != want {
+			t.Errorf("%s: isHTTPS = %v, want %v", tc.name, secure, want)
+		}
+	}
+
+	for _, s := range []string{"chat.example.com", "ftp://chat.example.com", "https://chat.example.com/chat"} {
+		t.Setenv("PUBLIC_URL", s)
+
+		if _, err := PublicURL(); err == nil {
+			t.Errorf("PUBLIC_URL %q was accepted", s)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/redis"
+)
+
+// Session is a sign-in kept on the server, so it can be listed and revoked.
+type Session struct {
+	ID   string `json:"id"`
+	User *User  `json:"user"`
+
+	Address   string `json:"address,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+
+	Created time.Time `json:"created"`
+	Seen    time.Time `json:"seen"`
+	Expires time.Time `json:"expires"`
+}
+
+// SessionStore keeps sessions until they expire.
+type SessionStore interface {
+	Get(id string) (*Session, error)
+	Put(s *Session) error
+	Delete(id string) error
+	List() ([]*Session, error)
+}
+
+// NewSessionStore returns the store named by config: "memory", or a Redis
+// URL for sessions shared by several instances.
+func NewSessionStore(config string) (SessionStore, error) {
+	if config == "memory" {
+		return NewMemorySessions(), nil
+	}
+
+	if strings.HasPrefix(config, "redis://") || strings.HasPrefix(config, "rediss://") {
+		client, err := redis.New(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRedisSessions(client), nil
+	}
+
+	return nil, errors.New("sessions: unsupported store " + config)
+}
+
+var _ SessionStore = (*MemorySessions)(nil)
+
+// MemorySessions keeps the sessions of a single instance; they are lost on
+// restart.
+type MemorySessions struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func NewMemorySessions() *MemorySessions {
+	return &MemorySessions{
+		sessions: map[string]Session{},
+	}
+}
+
+func (m *MemorySessions) Get(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+
+	if !ok || time.Now().After(s.Expires) {
+		return nil, nil
+	}
+
+	return &s, nil
+}
+
+func (m *MemorySessions) Put(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	for id, s := range m.sessions {
+		if now.After(s.Expires) {
+			delete(m.sessions, id)
+		}
+	}
+
+	m.sessions[s.ID] = *s
+
+	return nil
+}
+
+func (m *MemorySessions) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+func (m *MemorySessions) List() ([]*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []*Session
+
+	for _, s := range m.sessions {
+		if time.Now().Before(s.Expires) {
+			result = append(result, &s)
+		}
+	}
+
+	return result, nil
+}
+
+var _ SessionStore = (*RedisSessions)(nil)
+
+// redisPrefix namespaces the session keys.
+const redisPrefix = "wingman:session:"
+
+// RedisSessions keeps sessions in Redis, expiring with them.
+type RedisSessions struct {
+	client *redis.Client
+}
+
+func NewRedisSessions(client *redis.Client) *RedisSessions {
+	return &RedisSessions{
+		client: client,
+	}
+}
+
+func (r *RedisSessions) Get(id string) (*Session, error) {
+	reply, err := r.client.Do("GET", redisPrefix+id)
+
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	return decodeSession(reply)
+}
+
+func (r *RedisSessions) Put(s *Session) error {
+	ttl := time.Until(s.Expires).Milliseconds()
+
+	if ttl <= 0 {
+		return r.Delete(s.ID)
+	}
+
+	data, err := json.Marshal(s)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Do("SET", redisPrefix+s.ID, string(data), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+func (r *RedisSessions) Delete(id string) error {
+	_, err := r.client.Do("DEL", redisPrefix+id)
+	return err
+}
+
+func (r *RedisSessions) List() ([]*Session, error) {
+	var result []*Session
+
+	cursor := "0"
+
+	for {
+		reply, err := r.client.Do("SCAN", cursor, "MATCH", redisPrefix+"*", "COUNT", "200")
+
+		if err != nil {
+			return nil, err
+		}
+
+		items, _ := reply.([]any)
+
+		if len(items) != 2 {
+			return nil, errors.New("sessions: unexpected scan reply")
+		}
+
+		cursor, _ = items[0].(string)
+		keys, _ := items[1].([]any)
+
+		if len(keys) > 0 {
+			args := []string{"MGET"}
+
+			for _, k := range keys {
+				key, _ := k.(string)
+				args = append(args, key)
+			}
+
+			reply, err := r.client.Do(args...)
+
+			if err != nil {
+				return nil, err
+			}
+
+			values, _ := reply.([]any)
+
+			for _, v := range values {
+				// Expired between SCAN and MGET.
+				if v == nil {
+					continue
+				}
+
+				s, err := decodeSession(v)
+
+				if err != nil {
+					return nil, err
+				}
+
+				result = append(result, s)
+			}
+		}
+
+		if cursor == "0" || cursor == "" {
+			return result, nil
+		}
+	}
+}
+
+func decodeSession(reply any) (*Session, error) {
+	data, ok := reply.(string)
+
+	if !ok {
+		return nil, errors.New("sessions: unexpected reply")
+	}
+
+	var s Session
+
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackedSessions(t *testing.T) {
+	sessions := NewSessions("secret", time.Hour)
+
+	// A cookie from before sessions were tracked carries the user itself.
+	untracked := issue(t, sessions, &User{ID: "alice"})
+
+	sessions.Track(NewMemorySessions(), time.Hour)
+
+	if user := sessions.User(withCookie(untracked)); user != nil {
+		t.Errorf("an untracked cookie signed in %+v", user)
+	}
+
+	c := issue(t, sessions, &User{ID: "alice"})
+
+	if user := sessions.User(withCookie(c)); user == nil || user.ID != "alice" {
+		t.Fatalf("user = %+v, want alice", user)
+	}
+
+	list, err := sessions.List()
+
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List = %v, %v", list, err)
+	}
+
+	if err := sessions.Revoke(list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if user := sessions.User(withCookie(c)); user != nil {
+		t.Errorf("a revoked session signed in %+v", user)
+	}
+}
+
+func TestIdleSessions(t *testing.T) {
+	store := NewMemorySessions()
+
+	sessions := NewSessions("secret", time.Hour)
+	sessions.Track(store, time.Minute)
+
+	c := issue(t, sessions, &User{ID: "alice"})
+
+	list, _ := sessions.List()
+	list[0].Seen = time.Now().Add(-2 * time.Minute)
+	store.Put(list[0])
+
+	if user := sessions.User(withCookie(c)); user != nil {
+		t.Errorf("an idle session signed in %+v", user)
+	}
+}
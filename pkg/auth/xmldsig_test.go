@@ -0,0 +1,32 @@
+package auth
+
+import "testing"
+
+func TestParseXMLRejects(t *testing.T) {
+	for name, data := range map[string]string{
+		"mismatched end tag":  `<a><b></a></b>`,
+		"mismatched prefix":   `<x:a xmlns:x="urn:x" xmlns:y="urn:x"></y:a>`,
+		"unclosed":            `<a><b></b>`,
+		"two roots":           `<a></a><b></b>`,
+		"doctype":             `<!DOCTYPE a [<!ENTITY x "y">]><a>&x;</a>`,
+		"end without a start": `</a>`,
+	} {
+		if _, err := parseXML([]byte(data)); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	root, err := parseXML([]byte(`<x:a xmlns:x="urn:x" xmlns:unused="urn:u" b="2" a="1"><x:b/>text &amp; more<c xmlns="urn:c"/></x:a>`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<x:a xmlns:x="urn:x" a="1" b="2"><x:b></x:b>text &amp; more<c xmlns="urn:c"></c></x:a>`
+
+	if got := string(canonicalize(root, nil, nil)); got != want {
+		t.Errorf("canonicalize = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,197 @@
+// Package azure adapts OpenAI style requests to the layout of Azure OpenAI:
+// model requests go to the deployment named like the model, every request
+// carries the api-version, and API keys are sent as api-key header.
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/buffers"
+)
+
+// DefaultAPIVersion is the api-version sent unless another one is set.
+const DefaultAPIVersion = "2024-10-21"
+
+// spoolMemory is how much of a body read to find its model is held in
+// memory; the rest of large uploads is spooled to disk.
+const spoolMemory = 1 << 20
+
+// deployments are the operations (below /v1) served per deployment.
+var deployments = map[string]bool{
+	"/chat/completions":     true,
+	"/completions":          true,
+	"/embeddings":           true,
+	"/audio/speech":         true,
+	"/audio/transcriptions": true,
+	"/audio/translations":   true,
+	"/images/generations":   true,
+}
+
+// Transport rewrites the requests to next into the Azure layout. The base URL
+// is everything before /v1, with or without a trailing /openai.
+func Transport(next http.RoundTripper, apiVersion string) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if apiVersion == "" {
+		apiVersion = DefaultAPIVersion
+	}
+
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		base, op, ok := strings.Cut(r.URL.Path, "/v1/")
+
+		if !ok {
+			return next.RoundTrip(r)
+		}
+
+		op = "/" + op
+		base = strings.TrimSuffix(strings.TrimSuffix(base, "/"), "/openai") + "/openai"
+
+		r = r.Clone(r.Context())
+
+		query := r.URL.Query()
+		query.Set("api-version", apiVersion)
+
+		switch {
+		case deployments[op]:
+			model, err := requestModel(r)
+
+			if err != nil {
+				return errorResponse(r, http.StatusBadRequest, err.Error()), nil
+			}
+
+			r.URL.Path = base + "/deployments/" + model + op
+
+		case op == "/realtime":
+			if model := query.Get("model"); model != "" {
+				query.Set("deployment", model)
+				query.Del("model")
+			}
+
+			r.URL.Path = base + op
+
+		default:
+			r.URL.Path = base + op
+		}
+
+		r.URL.RawPath = ""
+		r.URL.RawQuery = query.Encode()
+
+		// API keys go into their own header; Entra ID tokens (JWTs) stay
+		// bearer tokens.
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && !isJWT(token) {
+			r.Header.Del("Authorization")
+			r.Header.Set("api-key", token)
+		}
+
+		return next.RoundTrip(r)
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func isJWT(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// requestModel reads the model of a JSON or multipart request, leaving the
+// body to be sent.
+func requestModel(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", errors.New("model is required")
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	spool, err := buffers.Spool(r.Body, spoolMemory)
+	r.Body.Close()
+
+	if err != nil {
+		return "", err
+	}
+
+	// The body is sent by the time the request is done.
+	context.AfterFunc(r.Context(), func() { spool.Close() })
+
+	r.Body = io.NopCloser(spool.Reader())
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(spool.Reader()), nil
+	}
+
+	r.ContentLength = spool.Size()
+	r.Header.Set("Content-Length", strconv.FormatInt(spool.Size(), 10))
+
+	var model string
+
+	switch mediaType {
+	case "application/json":
+		var body struct {
+			Model string `json:"model"`
+		}
+
+		json.NewDecoder(spool.Reader()).Decode(&body)
+		model = body.Model
+
+	case "multipart/form-data":
+		form := multipart.NewReader(spool.Reader(), params["boundary"])
+
+		for {
+			part, err := form.NextPart()
+
+			if err != nil {
+				break
+			}
+
+			if part.FormName() == "model" {
+				value, _ := io.ReadAll(io.LimitReader(part, 256))
+				model = strings.TrimSpace(string(value))
+
+				break
+			}
+		}
+	}
+
+	if model == "" || strings.ContainsAny(model, "/?#") {
+		return "", errors.New("model is required")
+	}
+
+	return model, nil
+}
+
+func errorResponse(r *http.Request, status int, message string) *http.Response {
+	data, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    http.StatusText(status),
+		},
+	})
+
+	return &http.Response{
+		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode: status,
+
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Body:   io.NopCloser(bytes.NewReader(data)),
+
+		ContentLength: int64(len(data)),
+		Request:       r,
+	}
+}
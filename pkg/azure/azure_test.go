@@ -0,0 +1,186 @@
+package azure
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// multipartBody encodes a transcription upload with the model after the
+// file, as clients send it.
+func multipartBody(model string) (string, string) {
+	var buf bytes.Buffer
+
+	mw := multipart.NewWriter(&buf)
+
+	w, _ := mw.CreateFormFile("file", "speech.mp3")
+	w.Write(bytes.Repeat([]byte{0xff}, 64<<10))
+
+	mw.WriteField("model", model)
+	mw.Close()
+
+	return buf.String(), mw.FormDataContentType()
+}
+
+func TestTransport(t *testing.T) {
+	upload, uploadType := multipartBody(" whisper ")
+
+	for _, tc := range []struct {
+		name string
+		url  string
+
+		contentType string
+		body        string
+		auth        string
+
+		want     string
+		wantAuth string
+		wantKey  string
+	}{
+		{
+			name:        "chat",
+			url:         "https://example.openai.azure.com/v1/chat/completions",
+			contentType: "application/json",
+			body:        `{"model": "gpt-4o", "messages": []}`,
+			auth:        "Bearer sk-key",
+			want:        "https://example.openai.azure.com/openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21",
+			wantKey:     "sk-key",
+		},
+		{
+			// Entra ID tokens stay bearer tokens.
+			name:        "entra id",
+			url:         "https://example.openai.azure.com/openai/v1/embeddings?user=alice",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"model": "text-embedding-3-small", "input": "Hi"}`,
+			auth:        "Bearer eyJhbGciOiJSUzI1NiJ9.e30.c2ln",
+			want:        "https://example.openai.azure.com/openai/deployments/text-embedding-3-small/embeddings?api-version=2024-10-21&user=alice",
+			wantAuth:    "Bearer eyJhbGciOiJSUzI1NiJ9.e30.c2ln",
+		},
+		{
+			name:        "multipart",
+			url:         "https://example.openai.azure.com/v1/audio/transcriptions",
+			contentType: uploadType,
+			body:        upload,
+			want:        "https://example.openai.azure.com/openai/deployments/whisper/audio/transcriptions?api-version=2024-10-21",
+		},
+		{
+			name: "realtime",
+			url:  "https://example.openai.azure.com/v1/realtime?model=gpt-4o-realtime",
+			want: "https://example.openai.azure.com/openai/realtime?api-version=2024-10-21&deployment=gpt-4o-realtime",
+		},
+		{
+			name: "models",
+			url:  "https://example.openai.azure.com/openai/v1/models?api-version=preview",
+			want: "https://example.openai.azure.com/openai/models?api-version=2024-10-21",
+		},
+		{
+			name: "outside /v1",
+			url:  "https://example.openai.azure.com/health",
+			want: "https://example.openai.azure.com/health",
+		},
+	} {
+		var got *http.Request
+		var body []byte
+
+		transport := Transport(roundTripper(func(r *http.Request) (*http.Response, error) {
+			got = r
+
+			if r.Body != nil {
+				body, _ = io.ReadAll(r.Body)
+			}
+
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+		}), "")
+
+		req, _ := http.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body))
+
+		if tc.body == "" {
+			req.Body = http.NoBody
+		}
+
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+
+		resp, err := transport.RoundTrip(req)
+
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: %v, %v", tc.name, resp, err)
+			continue
+		}
+
+		if u := got.URL.String(); u != tc.want {
+			t.Errorf("%s: URL = %s, want %s", tc.name, u, tc.want)
+		}
+
+		if a := got.Header.Get("Authorization"); a != tc.wantAuth {
+			t.Errorf("%s: Authorization = %q, want %q", tc.name, a, tc.wantAuth)
+		}
+
+		if k := got.Header.Get("api-key"); k != tc.wantKey {
+			t.Errorf("%s: api-key = %q, want %q", tc.name, k, tc.wantKey)
+		}
+
+		// The body read to find the model is sent as it came.
+		if string(body) != tc.body {
+			t.Errorf("%s: body changed, %d bytes, want %d", tc.name, len(body), len(tc.body))
+		}
+	}
+}
+
+func TestTransportModelRequired(t *testing.T) {
+	upload, uploadType := multipartBody("")
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"no body", "application/json", ""},
+		{"no model", "application/json", `{"messages": []}`},
+		{"invalid JSON", "application/json", `{`},
+		{"path in model", "application/json", `{"model": "../admin"}`},
+		{"no model field", uploadType, upload},
+		{"text", "text/plain", "gpt-4o"},
+	} {
+		called := false
+
+		transport := Transport(roundTripper(func(r *http.Request) (*http.Response, error) {
+			called = true
+			return nil, nil
+		}), "")
+
+		req, _ := http.NewRequest(http.MethodPost, "https://example.openai.azure.com/v1/chat/completions", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+
+		if tc.body == "" {
+			req.Body = http.NoBody
+		}
+
+		resp, err := transport.RoundTrip(req)
+
+		if err != nil || resp.StatusCode != http.StatusBadRequest || called {
+			t.Errorf("%s: %v, %v, sent %v", tc.name, resp, err, called)
+		}
+	}
+}
+
+func TestIsJWT(t *testing.T) {
+	for token, want := range map[string]bool{
+		"eyJhbGciOiJSUzI1NiJ9.e30.c2ln": true,
+		"eyJhbGciOiJSUzI1NiJ9.e30":      false,
+		"sk-proj-abc.def.ghi":           false,
+		"0123456789abcdef":              false,
+	} {
+		if got := isJWT(token); got != want {
+			t.Errorf("isJWT(%q) = %v, want %v", token, got, want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package balancer spreads the requests to the platform over its replicas:
+// round-robin over the healthy ones, failing over to the next when one
+// errors, times out or answers 5xx, and checking the failed ones until they
+// recover.
+package balancer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+type Balancer struct {
+	next http.RoundTripper
+
+	primary  *url.URL
+	backends []*backend
+
+	counter atomic.Uint64
+}
+
+type backend struct {
+	url  *url.URL
+	down atomic.Bool
+}
+
+// New balances the requests for urls[0], the URL clients are configured
+// with, over all of urls, sending them with next.
+func New(urls []*url.URL, next http.RoundTripper) *Balancer {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	b := &Balancer{
+		next:    next,
+		primary: urls[0],
+	}
+
+	for _, u := range urls {
+		b.backends = append(b.backends, &backend{url: u})
+	}
+
+	return b
+}
+
+// FromEnv balances over the platform URLs, checking the failed ones every
+// WINGMAN_HEALTH_INTERVAL (default 10s) until ctx is done, or returns nil
+// when there is only one.
+func FromEnv(ctx context.Context, next http.RoundTripper) *Balancer {
+	urls := config.PlatformURLs()
+
+	if len(urls) < 2 {
+		return nil
+	}
+
+	b := New(urls, next)
+	b.Watch(ctx, env.Duration("WINGMAN_HEALTH_INTERVAL", 10*time.Second))
+
+	return b
+}
+
+// RoundTrip sends r to the next healthy replica. Failed attempts are
+// repeated on the others as long as the body can be sent again; when all
+// replicas are down, all are tried.
+func (b *Balancer) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host != b.primary.Host {
+		return b.next.RoundTrip(r)
+	}
+
+	candidates := b.candidates()
+
+	// Only bodies that can be read again can go to another replica.
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		candidates = candidates[:1]
+	}
+
+	var resp *http.Response
+	var err error
+
+	for i, be := range candidates {
+		out := r.Clone(r.Context())
+
+		if i > 0 && r.GetBody != nil {
+			if out.Body, err = r.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		out.URL = b.target(be.url, r.URL)
+		out.Host = ""
+
+		resp, err = b.next.RoundTrip(out)
+
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+
+		// The client went away; that says nothing about the replica.
+		if r.Context().Err() != nil {
+			return resp, err
+		}
+
+		if err == nil {
+			be.markDown(errors.New("answered " + resp.Status))
+		} else {
+			be.markDown(err)
+		}
+
+		if i < len(candidates)-1 && resp != nil {
+			resp.Body.Close()
+		}
+	}
+
+	return resp, err
+}
+
+// candidates returns the replicas to try: the healthy ones in turn, then
+// those that are down.
+func (b *Balancer) candidates() []*backend {
+	var healthy, down []*backend
+
+	for _, be := range b.backends {
+		if be.down.Load() {
+			down = append(down, be)
+		} else {
+			healthy = append(healthy, be)
+		}
+	}
+
+	if n := len(healthy); n > 1 {
+		start := int(b.counter.Add(1) % uint64(n))
+		healthy = slices.Concat(healthy[start:], healthy[:start])
+	}
+
+	return append(healthy, down...)
+}
+
+// target moves u from the primary URL to base.
+func (b *Balancer) target(base, u *url.URL) *url.URL {
+	t := *u
+
+	t.Scheme = base.Scheme
+	t.Host = base.Host
+	t.Path = base.Path + strings.TrimPrefix(u.Path, b.primary.Path)
+
+	if u.RawPath != "" {
+		t.RawPath = base.EscapedPath() + strings.TrimPrefix(u.RawPath, b.primary.EscapedPath())
+	}
+
+	return &t
+}
+
+func (be *backend) markDown(err error) {
+	if !be.down.Swap(true) {
+		slog.Warn("balancer: replica down", "host", be.url.Host, "error", err)
+	}
+}
+
+// Watch checks the replicas that are down every interval until ctx is done
+// and puts them back in rotation when /v1/models answers below 500.
+func (b *Balancer) Watch(ctx context.Context, interval time.Duration) {
+	client := &http.Client{Transport: b.next, Timeout: 10 * time.Second}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			for _, be := range b.backends {
+				if !be.down.Load() {
+					continue
+				}
+
+				if err := check(ctx, client, be.url); err != nil {
+					continue
+				}
+
+				if be.down.Swap(false) {
+					slog.Info("balancer: replica up", "host", be.url.Host)
+				}
+			}
+		}
+	}()
+}
+
+func check(ctx context.Context, client *http.Client, u *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.JoinPath("v1", "models").String(), nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return errors.New("answered " + resp.Status)
+	}
+
+	return nil
+}
+
+// Backend is the state of a replica.
+type Backend struct {
+	URL  string `json:"url"`
+	Down bool   `json:"down"`
+}
+
+// Backends reports the replicas and whether they are down.
+func (b *Balancer) Backends() []Backend {
+	result := make([]Backend, 0, len(b.backends))
+
+	for _, be := range b.backends {
+		result = append(result, Backend{URL: be.url.Redacted(), Down: be.down.Load()})
+	}
+
+	return result
+}
+
+// Check fails when all replicas are down.
+func (b *Balancer) Check(context.Context) error {
+	for _, be := range b.backends {
+		if !be.down.Load() {
+			return nil
+		}
+	}
+
+	return errors.New("all replicas are down")
+}
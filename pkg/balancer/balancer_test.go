@@ -0,0 +1,191 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replicas answers requests by host with the status of the replica, and
+// records what each one received.
+type replicas struct {
+	mu       sync.Mutex
+	status   map[string]int
+	received []string
+}
+
+func (rs *replicas) RoundTrip(r *http.Request) (*http.Response, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var body []byte
+
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+
+	rs.received = append(rs.received, r.URL.Host+r.URL.Path+" "+string(body))
+
+	status, ok := rs.status[r.URL.Host]
+
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+
+	return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+func (rs *replicas) set(host string, status int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.status[host] = status
+}
+
+func newBalancer(t *testing.T, status map[string]int) (*Balancer, *replicas) {
+	t.Helper()
+
+	rs := &replicas{status: status}
+
+	var urls []*url.URL
+
+	for _, s := range []string{"http://a:8080/api", "http://b:8080", "http://c:8080/wingman"} {
+		u, _ := url.Parse(s)
+		urls = append(urls, u)
+	}
+
+	return New(urls, rs), rs
+}
+
+func send(t *testing.T, b *Balancer, target, body string) *http.Response {
+	t.Helper()
+
+	r, _ := http.NewRequest(http.MethodPost, target, bytes.NewReader([]byte(body)))
+	resp, err := b.RoundTrip(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func TestRoundRobin(t *testing.T) {
+	b, rs := newBalancer(t, map[string]int{"a:8080": 200, "b:8080": 200, "c:8080": 200})
+
+	for range 3 {
+		send(t, b, "http://a:8080/api/v1/models", "")
+	}
+
+	want := map[string]bool{"a:8080/api/v1/models ": true, "b:8080/v1/models ": true, "c:8080/wingman/v1/models ": true}
+
+	for _, got := range rs.received {
+		if !want[got] {
+			t.Errorf("unexpected request %q", got)
+		}
+
+		delete(want, got)
+	}
+
+	if len(want) != 0 {
+		t.Errorf("replicas without requests: %v", want)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status map[string]int
+		want   int
+		down   int
+	}{
+		// The first request starts with b.
+		{"5xx", map[string]int{"a:8080": 200, "b:8080": 502, "c:8080": 500}, 200, 2},
+		{"unreachable", map[string]int{"a:8080": 200}, 200, 2},
+		{"4xx is an answer", map[string]int{"a:8080": 404, "b:8080": 404, "c:8080": 404}, 404, 0},
+		{"all down", map[string]int{"a:8080": 503, "b:8080": 503, "c:8080": 503}, 503, 3},
+	} {
+		b, rs := newBalancer(t, tc.status)
+
+		if resp := send(t, b, "http://a:8080/api/v1/chat/completions", "{}"); resp.StatusCode != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+
+		// Every attempt got the whole body.
+		for _, got := range rs.received {
+			if !strings.HasSuffix(got, " {}") {
+				t.Errorf("%s: replica received %q", tc.name, got)
+			}
+		}
+
+		down := 0
+
+		for _, be := range b.Backends() {
+			if be.Down {
+				down++
+			}
+		}
+
+		if down != tc.down {
+			t.Errorf("%s: %d replicas down, want %d", tc.name, down, tc.down)
+		}
+
+		if err := b.Check(context.Background()); (err != nil) != (tc.down == 3) {
+			t.Errorf("%s: check = %v", tc.name, err)
+		}
+	}
+}
+
+func TestNoFailoverWithoutGetBody(t *testing.T) {
+	b, rs := newBalancer(t, map[string]int{"a:8080": 502, "b:8080": 502, "c:8080": 502})
+
+	// A body that can't be read again only goes to one replica.
+	r, _ := http.NewRequest(http.MethodPost, "http://a:8080/api/v1/audio/transcriptions", io.MultiReader(strings.NewReader("audio")))
+	b.RoundTrip(r)
+
+	if len(rs.received) != 1 {
+		t.Errorf("requests = %q, want one", rs.received)
+	}
+}
+
+func TestOtherHosts(t *testing.T) {
+	b, rs := newBalancer(t, map[string]int{"a:8080": 502, "other:443": 200})
+
+	send(t, b, "http://other:443/v1/models", "")
+
+	if len(rs.received) != 1 || rs.received[0] != "other:443/v1/models " {
+		t.Errorf("requests = %q, want other hosts passed on", rs.received)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	b, rs := newBalancer(t, map[string]int{"a:8080": 502, "b:8080": 200, "c:8080": 200})
+
+	send(t, b, "http://a:8080/api/v1/models", "")
+	send(t, b, "http://a:8080/api/v1/models", "")
+	send(t, b, "http://a:8080/api/v1/models", "")
+
+	if !b.backends[0].down.Load() {
+		t.Fatal("the failing replica isn't down")
+	}
+
+	rs.set("a:8080", 200)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b.Watch(ctx, 10*time.Millisecond)
+
+	for deadline := time.Now().Add(5 * time.Second); b.backends[0].down.Load(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the recovered replica wasn't put back")
+		}
+	}
+}
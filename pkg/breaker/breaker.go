@@ -0,0 +1,218 @@
+// Package breaker stops sending requests to an upstream that keeps failing:
+// once too many requests of a window fail, callers are turned away right
+// away until, after a cooldown, a single probe request gets through again.
+package breaker
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// ErrOpen is returned by Allow while the breaker is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+type Breaker struct {
+	// Threshold is the share of failed requests (0 to 1) in a window that
+	// opens the breaker, once MinRequests were made in it.
+	Threshold   float64
+	MinRequests int
+
+	Window   time.Duration
+	Cooldown time.Duration
+
+	mu sync.Mutex
+
+	state  string
+	opened time.Time
+
+	// probing is set while the probe of the half-open breaker is out.
+	probing bool
+
+	started  time.Time
+	requests int
+	failures int
+
+	lastError string
+}
+
+// New opens when half of at least 20 requests in a minute fail and probes
+// again after 30 seconds.
+func New() *Breaker {
+	return &Breaker{
+		Threshold:   0.5,
+		MinRequests: 20,
+
+		Window:   time.Minute,
+		Cooldown: 30 * time.Second,
+
+		state: StateClosed,
+	}
+}
+
+// FromEnv returns the breaker with BREAKER_ENABLED=true, reading
+// BREAKER_THRESHOLD, BREAKER_MIN_REQUESTS, BREAKER_WINDOW and
+// BREAKER_COOLDOWN over the defaults, or nil otherwise.
+func FromEnv() *Breaker {
+	if !env.Bool("BREAKER_ENABLED") {
+		return nil
+	}
+
+	b := New()
+
+	if v, err := strconv.ParseFloat(os.Getenv("BREAKER_THRESHOLD"), 64); err == nil && v > 0 && v <= 1 {
+		b.Threshold = v
+	}
+
+	if n := env.Int("BREAKER_MIN_REQUESTS", 0); n > 0 {
+		b.MinRequests = n
+	}
+
+	b.Window = env.Duration("BREAKER_WINDOW", b.Window)
+	b.Cooldown = env.Duration("BREAKER_COOLDOWN", b.Cooldown)
+
+	return b
+}
+
+// Allow reports ErrOpen when a request should not be sent; otherwise done
+// must be called with the outcome of the request, nil when it succeeded.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.opened) < b.Cooldown {
+			return nil, ErrOpen
+		}
+
+		b.state = StateHalfOpen
+		b.probing = false
+
+		fallthrough
+
+	case StateHalfOpen:
+		if b.probing {
+			return nil, ErrOpen
+		}
+
+		b.probing = true
+
+		return b.probed, nil
+	}
+
+	return b.record, nil
+}
+
+// probed decides about the breaker with the outcome of its probe.
+func (b *Breaker) probed(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if err != nil {
+		b.lastError = err.Error()
+		b.open()
+
+		return
+	}
+
+	b.state = StateClosed
+	b.reset()
+}
+
+// record counts a request sent while closed; those finishing after the
+// breaker opened don't count.
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		b.lastError = err.Error()
+	}
+
+	if b.state != StateClosed {
+		return
+	}
+
+	if time.Since(b.started) > b.Window {
+		b.reset()
+	}
+
+	b.requests++
+
+	if err != nil {
+		b.failures++
+	}
+
+	if b.requests >= b.MinRequests && float64(b.failures) >= b.Threshold*float64(b.requests) {
+		b.open()
+	}
+}
+
+func (b *Breaker) open() {
+	b.state = StateOpen
+	b.opened = time.Now()
+
+	b.reset()
+}
+
+func (b *Breaker) reset() {
+	b.started = time.Now()
+	b.requests = 0
+	b.failures = 0
+}
+
+// RetryIn is the time until an open breaker lets a probe through.
+func (b *Breaker) RetryIn() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != StateOpen {
+		return 0
+	}
+
+	return max(b.Cooldown-time.Since(b.opened), 0)
+}
+
+// Status is the state of the breaker and the requests of its window.
+type Status struct {
+	State string `json:"state"`
+
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+
+	LastError string `json:"lastError,omitempty"`
+
+	// RetryIn is the number of seconds until an open breaker probes again.
+	RetryIn int `json:"retryIn,omitempty"`
+}
+
+func (b *Breaker) Status() Status {
+	retryIn := b.RetryIn()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Status{
+		State: b.state,
+
+		Requests: b.requests,
+		Failures: b.failures,
+
+		LastError: b.lastError,
+
+		RetryIn: int(retryIn.Round(time.Second).Seconds()),
+	}
+}
@@ -0,0 +1,147 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errUpstream = errors.New("upstream failed")
+
+// send makes a request through b, failing with err.
+func send(t *testing.T, b *Breaker, err error) {
+	t.Helper()
+
+	done, allowErr := b.Allow()
+
+	if allowErr != nil {
+		t.Fatalf("request turned away: %v", allowErr)
+	}
+
+	done(err)
+}
+
+func testBreaker() *Breaker {
+	b := New()
+	b.MinRequests = 4
+
+	return b
+}
+
+func TestOpens(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		outcomes []error
+		want     string
+	}{
+		{"too few requests", []error{errUpstream, errUpstream, errUpstream}, StateClosed},
+		{"below the threshold", []error{nil, nil, nil, errUpstream}, StateClosed},
+		{"at the threshold", []error{nil, nil, errUpstream, errUpstream}, StateOpen},
+		{"all failed", []error{errUpstream, errUpstream, errUpstream, errUpstream}, StateOpen},
+	} {
+		b := testBreaker()
+
+		for _, err := range tc.outcomes {
+			send(t, b, err)
+		}
+
+		if s := b.Status(); s.State != tc.want {
+			t.Errorf("%s: state = %s, want %s", tc.name, s.State, tc.want)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	b := testBreaker()
+
+	send(t, b, errUpstream)
+	send(t, b, errUpstream)
+
+	// Failures of a past window don't count.
+	b.started = time.Now().Add(-2 * b.Window)
+
+	send(t, b, errUpstream)
+	send(t, b, nil)
+
+	if s := b.Status(); s.State != StateClosed || s.Requests != 2 || s.Failures != 1 {
+		t.Errorf("status = %+v, want the new window only", s)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		probe error
+		want  string
+	}{
+		{"succeeds", nil, StateClosed},
+		{"fails", errUpstream, StateOpen},
+	} {
+		b := testBreaker()
+
+		for range 4 {
+			send(t, b, errUpstream)
+		}
+
+		if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+			t.Fatalf("%s: open breaker let a request through: %v", tc.name, err)
+		}
+
+		if s := b.Status(); s.RetryIn != 30 || s.LastError != errUpstream.Error() {
+			t.Errorf("%s: status = %+v", tc.name, s)
+		}
+
+		// After the cooldown a single probe gets through.
+		b.opened = time.Now().Add(-b.Cooldown)
+
+		probed, err := b.Allow()
+
+		if err != nil {
+			t.Fatalf("%s: probe turned away: %v", tc.name, err)
+		}
+
+		if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+			t.Errorf("%s: a second request got through with the probe", tc.name)
+		}
+
+		probed(tc.probe)
+
+		if s := b.Status(); s.State != tc.want {
+			t.Errorf("%s: state = %s, want %s", tc.name, s.State, tc.want)
+		}
+	}
+}
+
+func TestLateOutcomes(t *testing.T) {
+	b := testBreaker()
+
+	// A request sent while closed finishes after the breaker opened.
+	late, _ := b.Allow()
+
+	for range 4 {
+		send(t, b, errUpstream)
+	}
+
+	late(nil)
+
+	if s := b.Status(); s.State != StateOpen || s.Requests != 0 {
+		t.Errorf("status = %+v, want the late request not counted", s)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	if b := FromEnv(); b != nil {
+		t.Error("breaker enabled by default")
+	}
+
+	t.Setenv("BREAKER_ENABLED", "true")
+	t.Setenv("BREAKER_THRESHOLD", "1.5")
+	t.Setenv("BREAKER_MIN_REQUESTS", "5")
+	t.Setenv("BREAKER_COOLDOWN", "10s")
+
+	b := FromEnv()
+
+	if b == nil || b.Threshold != 0.5 || b.MinRequests != 5 || b.Window != time.Minute || b.Cooldown != 10*time.Second {
+		t.Errorf("breaker = %+v", b)
+	}
+}
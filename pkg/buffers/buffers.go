@@ -0,0 +1,200 @@
+// Package buffers keeps the memory of proxied bodies bounded: copies share
+// pooled buffers, bodies that must be read ahead are spooled to disk beyond
+// a threshold, and Stats reports what is in use.
+package buffers
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// size is the size of pooled buffers, that of io.Copy.
+const size = 32 << 10
+
+var pool = sync.Pool{
+	New: func() any {
+		b := make([]byte, size)
+		return &b
+	},
+}
+
+var (
+	pooled atomic.Int64
+
+	spooledMemory atomic.Int64
+	spooledDisk   atomic.Int64
+	spools        atomic.Int64
+)
+
+// Pool lends the buffers of httputil.ReverseProxy.
+var Pool = bufferPool{}
+
+type bufferPool struct{}
+
+func (bufferPool) Get() []byte {
+	pooled.Add(1)
+	return *pool.Get().(*[]byte)
+}
+
+func (bufferPool) Put(b []byte) {
+	if cap(b) != size {
+		return
+	}
+
+	pooled.Add(-1)
+
+	b = b[:size]
+	pool.Put(&b)
+}
+
+// Copy is io.Copy with a pooled buffer.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	b := Pool.Get()
+	defer Pool.Put(b)
+
+	return io.CopyBuffer(dst, src, b)
+}
+
+// Spooled is a body read ahead: up to its memory limit in memory, the rest in
+// a temporary file.
+type Spooled struct {
+	mu sync.Mutex
+
+	head []byte
+	file *os.File
+
+	size int64
+	disk int64
+}
+
+// Spool reads r, keeping at most memory bytes in memory.
+func Spool(r io.Reader, memory int64) (*Spooled, error) {
+	head, err := io.ReadAll(io.LimitReader(r, memory))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spooled{head: head, size: int64(len(head))}
+	spooledMemory.Add(int64(len(head)))
+	spools.Add(1)
+
+	if int64(len(head)) < memory {
+		return s, nil
+	}
+
+	f, err := os.CreateTemp("", "wingman-spool-*")
+
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// The file goes away with its last descriptor.
+	os.Remove(f.Name())
+
+	s.file = f
+
+	n, err := Copy(f, r)
+
+	s.size += n
+	s.disk = n
+
+	spooledDisk.Add(n)
+
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Size is the length of the body.
+func (s *Spooled) Size() int64 {
+	return s.size
+}
+
+// Reader reads the body from its start.
+func (s *Spooled) Reader() io.Reader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	head := bytes.NewReader(s.head)
+
+	if s.file == nil {
+		return head
+	}
+
+	return io.MultiReader(head, io.NewSectionReader(s.file, 0, s.disk))
+}
+
+// Close releases the memory and file of the spool; readers still open fail.
+func (s *Spooled) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.head == nil && s.file == nil {
+		return nil
+	}
+
+	spooledMemory.Add(-int64(len(s.head)))
+	spooledDisk.Add(-s.disk)
+	spools.Add(-1)
+
+	s.head = nil
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+// Stats are the memory the process and its bodies use.
+type Stats struct {
+	// HeapAlloc is the memory of live objects, HeapSys that obtained for
+	// the heap and Sys all obtained from the system.
+	HeapAlloc uint64 `json:"heapAlloc"`
+	HeapSys   uint64 `json:"heapSys"`
+	Sys       uint64 `json:"sys"`
+
+	NumGC      uint32 `json:"numGC"`
+	Goroutines int    `json:"goroutines"`
+
+	// PooledBuffers are the copy buffers in use.
+	PooledBuffers int64 `json:"pooledBuffers"`
+
+	// Spools are the bodies read ahead, with the bytes held in memory and
+	// on disk.
+	Spools        int64 `json:"spools"`
+	SpooledMemory int64 `json:"spooledMemory"`
+	SpooledDisk   int64 `json:"spooledDisk"`
+}
+
+func ReadStats() Stats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return Stats{
+		HeapAlloc: m.HeapAlloc,
+		HeapSys:   m.HeapSys,
+		Sys:       m.Sys,
+
+		NumGC:      m.NumGC,
+		Goroutines: runtime.NumGoroutine(),
+
+		PooledBuffers: pooled.Load(),
+
+		Spools:        spools.Load(),
+		SpooledMemory: spooledMemory.Load(),
+		SpooledDisk:   spooledDisk.Load(),
+	}
+}
@@ -0,0 +1,193 @@
+// Package cache keeps answers of the upstream that are the same for the same
+// request, like the model listing, speech and embeddings, in memory or in
+// Redis for several instances.
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/redis"
+)
+
+// Entry is a cached answer.
+type Entry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+
+	for name, values := range e.Header {
+		n += int64(len(name))
+
+		for _, v := range values {
+			n += int64(len(v))
+		}
+	}
+
+	return n
+}
+
+// Store keeps entries until they expire.
+type Store interface {
+	// Get returns the entry of key, or nil.
+	Get(key string) (*Entry, error)
+	Put(key string, e *Entry, ttl time.Duration) error
+}
+
+// NewStore returns the store named by config: "memory", holding up to size
+// bytes, or a Redis URL.
+func NewStore(config string, size int64) (Store, error) {
+	if config == "memory" {
+		return NewMemory(size), nil
+	}
+
+	if strings.HasPrefix(config, "redis://") || strings.HasPrefix(config, "rediss://") {
+		client, err := redis.New(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRedis(client), nil
+	}
+
+	return nil, errors.New("cache: unsupported store " + config)
+}
+
+var _ Store = (*Memory)(nil)
+
+// Memory keeps the entries of a single instance, the least recently used
+// going first when they exceed the size.
+type Memory struct {
+	mu sync.Mutex
+
+	size int64
+	used int64
+
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	entry   *Entry
+	expires time.Time
+}
+
+func NewMemory(size int64) *Memory {
+	return &Memory{
+		size: size,
+
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (m *Memory) Get(key string) (*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+
+	if !ok {
+		return nil, nil
+	}
+
+	e := el.Value.(*memoryEntry)
+
+	if time.Now().After(e.expires) {
+		m.remove(el)
+		return nil, nil
+	}
+
+	m.order.MoveToFront(el)
+
+	return e.entry, nil
+}
+
+func (m *Memory) Put(key string, entry *Entry, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+
+	size := entry.size()
+
+	if size > m.size {
+		return nil
+	}
+
+	for m.used+size > m.size {
+		m.remove(m.order.Back())
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, entry: entry, expires: time.Now().Add(ttl)})
+	m.used += size
+
+	return nil
+}
+
+func (m *Memory) remove(el *list.Element) {
+	e := m.order.Remove(el).(*memoryEntry)
+
+	delete(m.entries, e.key)
+	m.used -= e.entry.size()
+}
+
+var _ Store = (*Redis)(nil)
+
+// redisPrefix namespaces the cache keys.
+const redisPrefix = "wingman:cache:"
+
+// Redis keeps entries in Redis, which expires them; its maxmemory policy
+// bounds the size.
+type Redis struct {
+	client *redis.Client
+}
+
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{
+		client: client,
+	}
+}
+
+func (r *Redis) Get(key string) (*Entry, error) {
+	reply, err := r.client.Do("GET", redisPrefix+key)
+
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	data, _ := reply.(string)
+
+	var e Entry
+
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func (r *Redis) Put(key string, e *Entry, ttl time.Duration) error {
+	data, err := json.Marshal(e)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Do("SET", redisPrefix+key, string(data), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
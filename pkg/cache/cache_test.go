@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func entry(body string) *Entry {
+	return &Entry{Status: 200, Body: []byte(body)}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(10)
+
+	m.Put("a", entry("aaaa"), time.Hour)
+	m.Put("b", entry("bbbb"), time.Hour)
+
+	// Using a makes b the least recently used, which goes first.
+	if e, _ := m.Get("a"); e == nil || string(e.Body) != "aaaa" {
+		t.Fatalf("a = %v", e)
+	}
+
+	m.Put("c", entry("cccc"), time.Hour)
+
+	if e, _ := m.Get("b"); e != nil {
+		t.Error("b was kept beyond the size")
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if e, _ := m.Get(key); e == nil {
+			t.Errorf("%s was dropped", key)
+		}
+	}
+
+	m.Put("big", entry("more than ten bytes"), time.Hour)
+
+	if e, _ := m.Get("big"); e != nil {
+		t.Error("an entry larger than the cache was kept")
+	}
+
+	if m.used > m.size {
+		t.Errorf("used = %d of %d", m.used, m.size)
+	}
+}
+
+func TestMemoryExpires(t *testing.T) {
+	m := NewMemory(10)
+
+	m.Put("a", entry("aaaa"), -time.Second)
+
+	if e, _ := m.Get("a"); e != nil {
+		t.Error("an expired entry was returned")
+	}
+
+	if m.used != 0 {
+		t.Errorf("used = %d after the entry expired", m.used)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	if _, err := NewStore("memory", 10); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewStore("memcached://localhost", 10); err == nil {
+		t.Error("an unsupported store was accepted")
+	}
+}
@@ -0,0 +1,140 @@
+// Package canary is a prompt injection tripwire. Operators plant canary
+// strings in system prompts and documents the models read; they have no
+// business leaving in tool calls, so a tool call or scraped URL carrying
+// one points to an injected instruction exfiltrating context. Such requests
+// are reported as security events, and blocked and alerted unless the
+// policy of the canary category says otherwise.
+package canary
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/security"
+)
+
+// minLength keeps canaries from matching by chance.
+const minLength = 8
+
+// maxScanned bounds the part of a request body that is scanned.
+const maxScanned = 1 << 20
+
+// toolPaths are the endpoints (below the prefix) tools call out through.
+var toolPaths = []string{
+	"/v1/mcp",
+	"/v1/extract",
+	"/v1/search",
+	"/v1/research",
+}
+
+// defaultAction applies without a policy for canaries.
+var defaultAction = security.Action{Block: true, Notify: true}
+
+type Tripwire struct {
+	canaries []string
+	monitor  *security.Monitor
+}
+
+// FromEnv returns the tripwire of the comma separated CANARY_STRINGS, or nil
+// when there are none.
+func FromEnv(monitor *security.Monitor) (*Tripwire, error) {
+	canaries := env.List("CANARY_STRINGS")
+
+	if len(canaries) == 0 {
+		return nil, nil
+	}
+
+	return New(canaries, monitor)
+}
+
+func New(canaries []string, monitor *security.Monitor) (*Tripwire, error) {
+	for _, c := range canaries {
+		if len(c) < minLength {
+			return nil, fmt.Errorf("canary: %q is shorter than %d characters", c, minLength)
+		}
+	}
+
+	return &Tripwire{
+		canaries: canaries,
+		monitor:  monitor,
+	}, nil
+}
+
+// Find returns the first canary in s, or "".
+func (t *Tripwire) Find(s string) string {
+	if t == nil {
+		return ""
+	}
+
+	for _, c := range t.canaries {
+		if strings.Contains(s, c) {
+			return c
+		}
+	}
+
+	return ""
+}
+
+// Trip reports a canary found in where of r, and returns whether to block
+// the request.
+func (t *Tripwire) Trip(r *http.Request, canary, where string) bool {
+	return t.monitor.Report(r, security.Canary, []string{security.Canary}, "found in "+where+": "+canary, defaultAction).Block
+}
+
+// Guard blocks tool calls below prefix (MCP servers, scraping, search and
+// research) whose URL or body carries a canary.
+func (t *Tripwire) Guard(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, prefix)
+
+		if !ok || !isToolPath(path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query, _ := url.QueryUnescape(r.URL.RawQuery)
+
+		if c := t.Find(r.URL.RawQuery + "\n" + query); c != "" && t.Trip(r, c, "the URL") {
+			http.Error(w, "request blocked", http.StatusForbidden)
+			return
+		}
+
+		if r.Body != nil && r.Body != http.NoBody {
+			head, err := io.ReadAll(io.LimitReader(r.Body, maxScanned))
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			// Form fields may be URL encoded.
+			decoded, _ := url.QueryUnescape(string(head))
+
+			if c := t.Find(string(head) + "\n" + decoded); c != "" && t.Trip(r, c, "the body") {
+				http.Error(w, "request blocked", http.StatusForbidden)
+				return
+			}
+
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isToolPath(path string) bool {
+	return slices.ContainsFunc(toolPaths, func(p string) bool { return path == p || strings.HasPrefix(path, p+"/") })
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -1,16 +1,43 @@
+// Package config describes the deployment configuration served to the client
+// and assembles it from YAML files in the working directory and environment
+// variable overrides.
 package config
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Load builds a Config by reading YAML files and applying environment variable overrides.
+// Load builds a Config by reading YAML files and applying environment variable
+// overrides. Problems found in the files are logged.
 func Load() *Config {
+	cfg, errs := Read()
+
+	for _, err := range errs {
+		slog.Warn("config", "error", err)
+	}
+
+	return cfg
+}
+
+// Read is like Load but returns the problems found in the YAML files instead of
+// logging them.
+func Read() (*Config, []error) {
 	cfg := &Config{
 		Title:      envOrDefault("TITLE", "Wingman AI"),
 		Disclaimer: os.Getenv("DISCLAIMER"),
@@ -24,27 +51,112 @@ func Load() *Config {
 		cfg.Bridge = &Bridge{URL: bridgeURL}
 	}
 
-	loadConfigFiles(cfg)
+	errs := loadConfigFiles(fromDir(""), cfg)
+
 	applyEnvOverrides(cfg)
 
-	return cfg
+	return cfg, errs
+}
+
+// Overlay returns a copy of base with the YAML files found in dir applied on
+// top, e.g. for per-tenant configuration directories.
+func Overlay(base *Config, dir string) (*Config, []error) {
+	cfg, err := Clone(base)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	return cfg, loadConfigFiles(fromDir(dir), cfg)
+}
+
+// OverlayFiles is like Overlay but takes the YAML files from memory, keyed by
+// file name (e.g. the data of a Kubernetes ConfigMap).
+func OverlayFiles(base *Config, files map[string][]byte) (*Config, []error) {
+	cfg, err := Clone(base)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	return cfg, loadConfigFiles(fromFiles(files), cfg)
+}
+
+// loadConfigFiles decodes the YAML files into cfg and returns the problems
+// found on the way. Files are decoded strictly: unknown keys are reported, but
+// the remaining settings of the file still apply.
+func loadConfigFiles(read configReader, cfg *Config) []error {
+	var errs []error
+
+	collect := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	collect(loadYAML(read, "tools.yaml", &cfg.Tools))
+	collect(loadYAML(read, "models.yaml", &cfg.Models))
+	collect(loadYAML(read, "aliases.yaml", &cfg.Aliases))
+	collect(loadYAML(read, "drives.yaml", &cfg.Drives))
+	collect(loadYAML(read, "databases.yaml", &cfg.Databases))
+	collect(loadYAML(read, "upstreams.yaml", &cfg.Upstreams))
+	collect(loadYAML(read, "routes.yaml", &cfg.Routes))
+	collect(loadYAML(read, "hooks.yaml", &cfg.Hooks))
+	collect(loadYAML(read, "degradation.yaml", &cfg.Degradation))
+	collect(loadYAML(read, "backgrounds.yaml", &cfg.Backgrounds))
+	collect(loadYAML(read, "flags.yaml", &cfg.Flags))
+	collect(loadYAML(read, "roles.yaml", &cfg.Roles))
+
+	collect(loadYAMLPtr(read, "branding.yaml", &cfg.Branding))
+	collect(loadYAMLPtr(read, "chat.yaml", &cfg.Chat))
+	collect(loadYAMLPtr(read, "tts.yaml", &cfg.TTS))
+	collect(loadYAMLPtr(read, "notebook.yaml", &cfg.Notebook))
+	collect(loadYAMLPtr(read, "translator.yaml", &cfg.Translator))
+	collect(loadYAMLPtr(read, "vision.yaml", &cfg.Vision))
+	collect(loadYAMLPtr(read, "text.yaml", &cfg.Text))
+	collect(loadYAMLPtr(read, "extractor.yaml", &cfg.Extractor))
+	collect(loadYAMLPtr(read, "internet.yaml", &cfg.Internet))
+	collect(loadYAMLPtr(read, "renderer.yaml", &cfg.Renderer))
+	collect(loadYAMLPtr(read, "repository.yaml", &cfg.Repository))
+	collect(loadYAMLPtr(read, "network.yaml", &cfg.Network))
+	collect(loadYAMLPtr(read, "residency.yaml", &cfg.Residency))
+
+	collect(loadYAML(read, "elicitation.yaml", &cfg.Elicitation))
+
+	syncElicitation(cfg)
+
+	return errs
+}
+
+// syncElicitation keeps the elicitation flags of the internet and renderer
+// features, which the UI reads, and their policies in step.
+func syncElicitation(cfg *Config) {
+	apply := func(id string, flag *bool) {
+		policy, ok := cfg.Elicitation[id]
+
+		switch {
+		case ok:
+			*flag = policy != ElicitNever
+		case *flag:
+			setElicitation(cfg, id, ElicitAlways)
+		}
+	}
+
+	if cfg.Internet != nil {
+		apply("internet", &cfg.Internet.Elicitation)
+	}
+
+	if cfg.Renderer != nil {
+		apply("renderer", &cfg.Renderer.Elicitation)
+	}
 }
 
-func loadConfigFiles(cfg *Config) {
-	loadYAML("tools.yaml", &cfg.Tools)
-	loadYAML("models.yaml", &cfg.Models)
-	loadYAML("drives.yaml", &cfg.Drives)
-	loadYAML("backgrounds.yaml", &cfg.Backgrounds)
+func setElicitation(cfg *Config, id, policy string) {
+	if cfg.Elicitation == nil {
+		cfg.Elicitation = map[string]string{}
+	}
 
-	loadYAMLPtr("chat.yaml", &cfg.Chat)
-	loadYAMLPtr("notebook.yaml", &cfg.Notebook)
-	loadYAMLPtr("translator.yaml", &cfg.Translator)
-	loadYAMLPtr("vision.yaml", &cfg.Vision)
-	loadYAMLPtr("text.yaml", &cfg.Text)
-	loadYAMLPtr("extractor.yaml", &cfg.Extractor)
-	loadYAMLPtr("internet.yaml", &cfg.Internet)
-	loadYAMLPtr("renderer.yaml", &cfg.Renderer)
-	loadYAMLPtr("repository.yaml", &cfg.Repository)
+	cfg.Elicitation[id] = policy
 }
 
 func applyEnvOverrides(cfg *Config) {
@@ -68,7 +180,7 @@ func applyEnvOverrides(cfg *Config) {
 		envOverride("INTERNET_SEARCHER", &i.Searcher)
 		envOverride("INTERNET_RESEARCHER", &i.Researcher)
 		if envBool("INTERNET_ELICITATION") {
-			i.Elicitation = true
+			setElicitation(cfg, "internet", ElicitAlways)
 		}
 	})
 
@@ -76,7 +188,7 @@ func applyEnvOverrides(cfg *Config) {
 		envOverride("RENDERER_MODEL", &r.Model)
 		envOverride("RENDERER_DISCLAIMER", &r.Disclaimer)
 		if envBool("RENDERER_ELICITATION") {
-			r.Elicitation = true
+			setElicitation(cfg, "renderer", ElicitAlways)
 		}
 	})
 
@@ -134,6 +246,8 @@ func applyEnvOverrides(cfg *Config) {
 	}
 
 	withFeature("TELEMETRY_ENABLED", &cfg.Telemetry, nil)
+
+	syncElicitation(cfg)
 }
 
 // PlatformToken returns the API token from environment variables.
@@ -149,13 +263,101 @@ func PlatformToken() string {
 
 // PlatformURL returns the platform API base URL from environment variables.
 func PlatformURL() *url.URL {
-	if u := urlFromEnv("WINGMAN_URL", "OPENAI_BASE_URL"); u != nil {
-		return u
+	return PlatformURLs()[0]
+}
+
+// PlatformURLs returns the base URLs of the replicas of the platform, given
+// comma-separated in WINGMAN_URL; the first is the one clients address.
+func PlatformURLs() []*url.URL {
+	for _, key := range []string{"WINGMAN_URL", "OPENAI_BASE_URL"} {
+		var urls []*url.URL
+
+		for _, val := range strings.Split(os.Getenv(key), ",") {
+			if u := parseBaseURL(strings.TrimSpace(val)); u != nil {
+				urls = append(urls, u)
+			}
+		}
+
+		if len(urls) > 0 {
+			return urls
+		}
 	}
 
 	panic("WINGMAN_URL is not set or invalid")
 }
 
+// PlatformTransport returns the HTTP transport for the platform. It presents
+// the client certificate in WINGMAN_TLS_CERT / WINGMAN_TLS_KEY (mutual TLS)
+// and trusts the CAs in WINGMAN_TLS_CA instead of the system roots, when set.
+// The certificate is read again for every new connection, so rotated files
+// are picked up without a restart.
+func PlatformTransport() (http.RoundTripper, error) {
+	certFile := os.Getenv("WINGMAN_TLS_CERT")
+	keyFile := os.Getenv("WINGMAN_TLS_KEY")
+	caFile := os.Getenv("WINGMAN_TLS_CA")
+
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return http.DefaultTransport, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("WINGMAN_TLS_CERT and WINGMAN_TLS_KEY must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+
+		var mu sync.Mutex
+
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if c, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+				cert = c
+			} else {
+				slog.Error("config: client certificate", "error", err)
+			}
+
+			return &cert, nil
+		}
+	}
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("WINGMAN_TLS_CA contains no certificates")
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c := transport.TLSClientConfig; c != nil {
+		tlsConfig.ClientSessionCache = c.ClientSessionCache
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
 // helpers
 
 func envBool(key string) bool {
@@ -206,17 +408,117 @@ func ensurePtr[T any](p *T) *T {
 	return p
 }
 
-func loadYAML[T any](filename string, target *T) {
-	if data, err := os.ReadFile(filename); err == nil {
-		yaml.Unmarshal(data, target)
+func loadYAML[T any](read configReader, filename string, target *T) error {
+	var errs []error
+
+	for _, f := range read(filename) {
+		if err := Decode(f.data, target); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.name, err))
+		}
 	}
+
+	return errors.Join(errs...)
+}
+
+func loadYAMLPtr[T any](read configReader, filename string, target **T) error {
+	var errs []error
+
+	for _, f := range read(filename) {
+		*target = ensurePtr(*target)
+
+		if err := Decode(f.data, *target); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
-func loadYAMLPtr[T any](filename string, target **T) {
-	if data, err := os.ReadFile(filename); err == nil {
-		*target = new(T)
-		yaml.Unmarshal(data, *target)
+// Decode expands environment placeholders and decodes YAML data into target,
+// rejecting keys that don't map to a config field (e.g. a misspelled
+// "embeder:"). An empty document is not an error.
+func Decode(data []byte, target any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(expandEnv(data)))
+	dec.KnownFields(true)
+
+	if err := dec.Decode(target); err != nil && !errors.Is(err, io.EOF) {
+		return err
 	}
+
+	return nil
+}
+
+type configFile struct {
+	name string
+	data []byte
+}
+
+// configReader returns the contents of filename followed by its profile
+// variant (models.yaml → models.prod.yaml for PROFILE=prod), skipping files
+// that don't exist. Decoding them in order overlays the profile on the base:
+// fields set in the profile win, lists are replaced as a whole.
+type configReader func(filename string) []configFile
+
+// fromDir reads the files from dir.
+func fromDir(dir string) configReader {
+	return func(filename string) []configFile {
+		var result []configFile
+
+		for _, name := range profileNames(filepath.Join(dir, filename)) {
+			if data, err := os.ReadFile(name); err == nil {
+				result = append(result, configFile{name, data})
+			}
+		}
+
+		return result
+	}
+}
+
+// fromFiles reads the files from memory.
+func fromFiles(files map[string][]byte) configReader {
+	return func(filename string) []configFile {
+		var result []configFile
+
+		for _, name := range profileNames(filename) {
+			if data, ok := files[name]; ok {
+				result = append(result, configFile{name, data})
+			}
+		}
+
+		return result
+	}
+}
+
+func profileNames(filename string) []string {
+	names := []string{filename}
+
+	if profile := os.Getenv("PROFILE"); profile != "" {
+		ext := filepath.Ext(filename)
+		names = append(names, strings.TrimSuffix(filename, ext)+"."+profile+ext)
+	}
+
+	return names
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} and ${VAR:-default} placeholders with values from
+// the environment. Placeholders for unset variables without a default are left
+// untouched, so prompts that happen to contain "${...}" survive unchanged.
+func expandEnv(data []byte) []byte {
+	return envPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		m := envPattern.FindSubmatch(match)
+
+		if val, ok := os.LookupEnv(string(m[1])); ok {
+			return []byte(val)
+		}
+
+		if m[2] != nil {
+			return m[3]
+		}
+
+		return match
+	})
 }
 
 func urlFromEnv(keys ...string) *url.URL {
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// DeprecationNotice is how long before its deprecation date a model carries
+// a warning.
+const DeprecationNotice = 30 * 24 * time.Hour
+
+// ApplyDeprecations returns c as of now: models past their deprecation date
+// are dropped and aliased to their replacement, if any, and those whose date
+// is within DeprecationNotice carry a warning. c is returned as it is when
+// no model is deprecated.
+func (c *Config) ApplyDeprecations(now time.Time) *Config {
+	if !slices.ContainsFunc(c.Models, func(m Model) bool { return m.Deprecation != "" }) {
+		return c
+	}
+
+	result := *c
+
+	result.Models = nil
+	result.Aliases = slices.Clone(c.Aliases)
+
+	for _, m := range c.Models {
+		date, err := time.Parse(time.DateOnly, m.Deprecation)
+
+		if err != nil {
+			result.Models = append(result.Models, m)
+			continue
+		}
+
+		if !now.Before(date) {
+			aliased := slices.ContainsFunc(result.Aliases, func(a Alias) bool { return a.ID == m.ID })
+
+			if m.Replacement != "" && !aliased {
+				result.Aliases = append(result.Aliases, Alias{ID: m.ID, Target: m.Replacement, Sunset: m.Deprecation})
+			}
+
+			continue
+		}
+
+		if date.Sub(now) <= DeprecationNotice {
+			m.Warning = fmt.Sprintf("%s is retired on %s", m.ID, m.Deprecation)
+
+			if m.Replacement != "" {
+				m.Warning += fmt.Sprintf(" and replaced by %s", m.Replacement)
+			}
+		}
+
+		result.Models = append(result.Models, m)
+	}
+
+	return &result
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Change is a single difference between two configurations. Path uses the
+// YAML key names, e.g. "models[2].name"; From or To is nil when the value was
+// added or removed.
+type Change struct {
+	Path string `json:"path"`
+	From any    `json:"from,omitempty"`
+	To   any    `json:"to,omitempty"`
+}
+
+// Clone returns a deep copy of cfg.
+func Clone(cfg *Config) (*Config, error) {
+	data, err := yaml.Marshal(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(Config)
+
+	if err := yaml.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Diff lists the changes from a to b, including server-side only settings.
+func Diff(a, b *Config) ([]Change, error) {
+	va, err := generic(a)
+
+	if err != nil {
+		return nil, err
+	}
+
+	vb, err := generic(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	diffValue("", va, vb, &changes)
+
+	return changes, nil
+}
+
+// generic converts cfg into plain maps and slices keyed by YAML names.
+func generic(cfg *Config) (any, error) {
+	data, err := yaml.Marshal(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result any
+
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func diffValue(path string, a, b any, changes *[]Change) {
+	switch va := a.(type) {
+	case map[string]any:
+		if vb, ok := b.(map[string]any); ok {
+			keys := map[string]bool{}
+
+			for k := range va {
+				keys[k] = true
+			}
+
+			for k := range vb {
+				keys[k] = true
+			}
+
+			sorted := make([]string, 0, len(keys))
+
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+
+			sort.Strings(sorted)
+
+			for _, k := range sorted {
+				p := k
+
+				if path != "" {
+					p = path + "." + k
+				}
+
+				diffValue(p, va[k], vb[k], changes)
+			}
+
+			return
+		}
+
+	case []any:
+		if vb, ok := b.([]any); ok {
+			for i := range max(len(va), len(vb)) {
+				var x, y any
+
+				if i < len(va) {
+					x = va[i]
+				}
+
+				if i < len(vb) {
+					y = vb[i]
+				}
+
+				diffValue(fmt.Sprintf("%s[%d]", path, i), x, y, changes)
+			}
+
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, Change{
+			Path: path,
+			From: a,
+			To:   b,
+		})
+	}
+}
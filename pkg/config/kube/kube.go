@@ -0,0 +1,450 @@
+// Package kube keeps the configuration in sync with labeled ConfigMaps and
+// Secrets of a Kubernetes namespace, so GitOps tooling manages it without
+// file mounts. Each data key is read like the file of that name (models.yaml,
+// tools.yaml, branding.yaml, ...); objects annotated with the tenant
+// annotation configure that tenant instead, like a tenant directory.
+package kube
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// TenantAnnotation assigns an object to a tenant.
+const TenantAnnotation = "wingman-chat/tenant"
+
+// retryDelay is how long a watcher waits after a failed request.
+const retryDelay = 10 * time.Second
+
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client talks to the Kubernetes API server.
+type Client struct {
+	client *http.Client
+
+	url       string
+	tokenFile string
+
+	Namespace string
+}
+
+// InCluster returns a client authenticated as the pod's service account,
+// watching the pod's namespace.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes: not running in a cluster")
+	}
+
+	ca, err := os.ReadFile(serviceAccount + "/ca.crt")
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("kubernetes: invalid ca.crt")
+	}
+
+	namespace, err := os.ReadFile(serviceAccount + "/namespace")
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &Client{
+		client: &http.Client{Transport: transport},
+
+		url:       "https://" + net.JoinHostPort(host, port),
+		tokenFile: serviceAccount + "/token",
+
+		Namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// FromEnv returns the client configured with CONFIG_KUBERNETES=true or
+// CONFIG_KUBERNETES_URL (e.g. kubectl proxy; else the pod's service account)
+// in CONFIG_KUBERNETES_NAMESPACE, along with the label selector of the
+// ConfigMaps and Secrets (CONFIG_KUBERNETES_SELECTOR, default
+// "wingman-chat/config"), or nil when neither is set.
+func FromEnv() (*Client, string, error) {
+	u := os.Getenv("CONFIG_KUBERNETES_URL")
+
+	if u == "" && os.Getenv("CONFIG_KUBERNETES") != "true" {
+		return nil, "", nil
+	}
+
+	selector := os.Getenv("CONFIG_KUBERNETES_SELECTOR")
+
+	if selector == "" {
+		selector = "wingman-chat/config"
+	}
+
+	namespace := os.Getenv("CONFIG_KUBERNETES_NAMESPACE")
+
+	if u != "" {
+		if namespace == "" {
+			namespace = "default"
+		}
+
+		return NewClient(u, namespace), selector, nil
+	}
+
+	client, err := InCluster()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if namespace != "" {
+		client.Namespace = namespace
+	}
+
+	return client, selector, nil
+}
+
+// NewClient returns a client of the API server at url without credentials,
+// e.g. one served by "kubectl proxy".
+func NewClient(url, namespace string) *Client {
+	return &Client{
+		client: http.DefaultClient,
+
+		url: strings.TrimRight(url, "/"),
+
+		Namespace: namespace,
+	}
+}
+
+type object struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+
+	Data map[string]string `json:"data"`
+}
+
+type objectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+
+	Items []object `json:"items"`
+}
+
+type event struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+func (c *Client) request(ctx context.Context, resource string, query url.Values) (*http.Response, error) {
+	u := c.url + "/api/v1/namespaces/" + url.PathEscape(c.Namespace) + "/" + resource + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Projected service account tokens are rotated, so read it every time.
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("kubernetes error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+// Watch follows the ConfigMaps and Secrets matching selector until ctx is
+// done. Every change overlays their files on the configuration store had
+// when Watch was called and publishes the result, replacing other runtime
+// changes. Tenant objects are put into tenants, which may be nil.
+func Watch(ctx context.Context, c *Client, selector string, store *config.Store, tenants *tenant.Registry) *Watcher {
+	w := &Watcher{
+		client:   c,
+		selector: selector,
+
+		base:    store.Config(),
+		store:   store,
+		tenants: tenants,
+
+		objects: map[string]map[string]object{},
+		applied: map[string]bool{},
+	}
+
+	go w.run(ctx, "configmaps")
+	go w.run(ctx, "secrets")
+
+	return w
+}
+
+type Watcher struct {
+	client   *Client
+	selector string
+
+	base    *config.Config
+	store   *config.Store
+	tenants *tenant.Registry
+
+	mu      sync.Mutex
+	objects map[string]map[string]object
+	applied map[string]bool
+	last    *config.Config
+}
+
+// Synced reports whether the objects were read at least once.
+func (w *Watcher) Synced(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, resource := range []string{"configmaps", "secrets"} {
+		if _, ok := w.objects[resource]; !ok {
+			return errors.New("kubernetes " + resource + " not read yet")
+		}
+	}
+
+	return nil
+}
+
+// run lists the resource, then watches it from there; when the watch ends
+// or fails it starts over.
+func (w *Watcher) run(ctx context.Context, resource string) {
+	for ctx.Err() == nil {
+		if err := w.follow(ctx, resource); err != nil && ctx.Err() == nil {
+			slog.Warn("config: kubernetes", "resource", resource, "error", err)
+			sleep(ctx, retryDelay)
+		}
+	}
+}
+
+func (w *Watcher) follow(ctx context.Context, resource string) error {
+	resp, err := w.client.request(ctx, resource, url.Values{"labelSelector": {w.selector}})
+
+	if err != nil {
+		return err
+	}
+
+	var list objectList
+
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	objects := map[string]object{}
+
+	for _, o := range list.Items {
+		objects[o.Metadata.Name] = o
+	}
+
+	w.set(resource, objects)
+
+	resp, err = w.client.request(ctx, resource, url.Values{
+		"labelSelector":   {w.selector},
+		"resourceVersion": {list.Metadata.ResourceVersion},
+		"watch":           {"true"},
+		"timeoutSeconds":  {"300"},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 16<<20)
+
+	for scanner.Scan() {
+		var e event
+
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+
+		if e.Type == "ERROR" {
+			// Usually 410 Gone: the version is too old, so list again.
+			return nil
+		}
+
+		var o object
+
+		if err := json.Unmarshal(e.Object, &o); err != nil {
+			return err
+		}
+
+		switch e.Type {
+		case "ADDED", "MODIFIED":
+			objects[o.Metadata.Name] = o
+		case "DELETED":
+			delete(objects, o.Metadata.Name)
+		default:
+			continue
+		}
+
+		w.set(resource, objects)
+	}
+
+	return scanner.Err()
+}
+
+func (w *Watcher) set(resource string, objects map[string]object) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.objects[resource] = maps.Clone(objects)
+	w.apply()
+}
+
+// apply overlays the objects in name order (ConfigMaps before Secrets of
+// the same name) and publishes the result.
+func (w *Watcher) apply() {
+	type source struct {
+		name   string
+		tenant string
+		files  map[string][]byte
+	}
+
+	var sources []source
+
+	for _, resource := range []string{"configmaps", "secrets"} {
+		for _, o := range w.objects[resource] {
+			files := map[string][]byte{}
+
+			for k, v := range o.Data {
+				if resource == "secrets" {
+					data, err := base64.StdEncoding.DecodeString(v)
+
+					if err != nil {
+						slog.Warn("config: kubernetes secret", "secret", o.Metadata.Name, "key", k, "error", err)
+						continue
+					}
+
+					files[k] = data
+				} else {
+					files[k] = []byte(v)
+				}
+			}
+
+			sources = append(sources, source{
+				name:   resource + "/" + o.Metadata.Name,
+				tenant: o.Metadata.Annotations[TenantAnnotation],
+				files:  files,
+			})
+		}
+	}
+
+	slices.SortStableFunc(sources, func(a, b source) int {
+		_, x, _ := strings.Cut(a.name, "/")
+		_, y, _ := strings.Cut(b.name, "/")
+
+		return strings.Compare(x, y)
+	})
+
+	cfg := w.base
+	tenants := map[string][]map[string][]byte{}
+
+	for _, s := range sources {
+		if s.tenant != "" {
+			tenants[s.tenant] = append(tenants[s.tenant], s.files)
+			continue
+		}
+
+		next, errs := config.OverlayFiles(cfg, s.files)
+
+		for _, err := range errs {
+			slog.Warn("config: kubernetes", "resource", s.name, "error", err)
+		}
+
+		if next != nil {
+			cfg = next
+		}
+	}
+
+	if !reflect.DeepEqual(cfg, w.last) {
+		w.last = cfg
+
+		w.store.Update("kubernetes", func(c *config.Config) {
+			*c = *cfg
+		})
+	}
+
+	if w.tenants == nil {
+		if len(tenants) > 0 {
+			slog.Warn("config: kubernetes: tenant objects ignored, multi-tenancy is disabled")
+		}
+
+		return
+	}
+
+	for id, files := range tenants {
+		for _, err := range w.tenants.Put(id, cfg, files...) {
+			slog.Warn("config: kubernetes tenant", "tenant", id, "error", err)
+		}
+
+		w.applied[id] = true
+	}
+
+	for id := range w.applied {
+		if _, ok := tenants[id]; !ok {
+			w.tenants.Remove(id)
+			delete(w.applied, id)
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,86 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ Backend = (*Consul)(nil)
+
+// Consul reads keys through the KV HTTP API using blocking queries, so changes
+// are seen as soon as Consul commits them.
+//
+// https://developer.hashicorp.com/consul/api-docs/features/blocking
+type Consul struct {
+	client *http.Client
+
+	url   string
+	token string
+}
+
+func NewConsul(url, token string) *Consul {
+	return &Consul{
+		client: http.DefaultClient,
+
+		url:   strings.TrimRight(url, "/"),
+		token: token,
+	}
+}
+
+func (c *Consul) Fetch(ctx context.Context, key string, index uint64) ([]byte, uint64, error) {
+	query := url.Values{}
+	query.Set("raw", "true")
+	query.Set("index", strconv.FormatUint(index, 10))
+	query.Set("wait", "5m")
+
+	ctx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/v1/kv/"+key+"?"+query.Encode(), nil)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer resp.Body.Close()
+
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	// Consul documents that the index may go backwards; start over when it does.
+	if next < index {
+		next = 0
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, next, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("consul error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	data, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, next, nil
+}
@@ -0,0 +1,108 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ Backend = (*Etcd)(nil)
+
+// pollInterval is how often etcd keys are checked for a new revision.
+const pollInterval = 5 * time.Second
+
+// Etcd reads keys through the etcd v3 JSON gateway, polling the key's
+// mod_revision.
+//
+// https://etcd.io/docs/latest/dev-guide/api_grpc_gateway/
+type Etcd struct {
+	client *http.Client
+
+	url string
+}
+
+func NewEtcd(url string) *Etcd {
+	return &Etcd{
+		client: http.DefaultClient,
+
+		url: strings.TrimRight(url, "/"),
+	}
+}
+
+func (e *Etcd) Fetch(ctx context.Context, key string, index uint64) ([]byte, uint64, error) {
+	for {
+		data, next, err := e.get(ctx, key)
+
+		if err != nil || next != index {
+			return data, next, err
+		}
+
+		sleep(ctx, pollInterval)
+
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+func (e *Etcd) get(ctx context.Context, key string) ([]byte, uint64, error) {
+	body, _ := json.Marshal(map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(key)),
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/v3/kv/range", bytes.NewReader(body))
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("etcd error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	// int64 fields are encoded as strings by the gateway
+	var result struct {
+		Kvs []struct {
+			Value       string `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+
+	if len(result.Kvs) == 0 {
+		return nil, 0, nil
+	}
+
+	kv := result.Kvs[0]
+
+	data, err := base64.StdEncoding.DecodeString(kv.Value)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	revision, _ := strconv.ParseUint(kv.ModRevision, 10, 64)
+
+	return data, revision, nil
+}
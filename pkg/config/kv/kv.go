@@ -0,0 +1,118 @@
+// Package kv keeps the model and tool catalogs in sync with a key/value store
+// shared by a fleet of instances. Values use the same YAML format as
+// models.yaml and tools.yaml and replace the file-based catalogs whenever they
+// change.
+package kv
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+)
+
+// retryDelay is how long a watcher waits after a failed fetch.
+const retryDelay = 10 * time.Second
+
+// Backend reads a single key from the store.
+type Backend interface {
+	// Fetch returns the value of key as soon as its modification index differs
+	// from index. Backends block (long poll) or poll until then. A nil value
+	// means the key does not exist.
+	Fetch(ctx context.Context, key string, index uint64) ([]byte, uint64, error)
+}
+
+// FromEnv returns the backend configured via CONFIG_CONSUL_URL or
+// CONFIG_ETCD_URL along with the key prefix (CONFIG_KV_PREFIX, default
+// "wingman-chat"), or nil when neither is set.
+func FromEnv() (Backend, string) {
+	prefix := strings.Trim(os.Getenv("CONFIG_KV_PREFIX"), "/")
+
+	if prefix == "" {
+		prefix = "wingman-chat"
+	}
+
+	if u := os.Getenv("CONFIG_CONSUL_URL"); u != "" {
+		return NewConsul(u, os.Getenv("CONSUL_HTTP_TOKEN")), prefix
+	}
+
+	if u := os.Getenv("CONFIG_ETCD_URL"); u != "" {
+		return NewEtcd(u), prefix
+	}
+
+	return nil, ""
+}
+
+// Watch follows <prefix>/models and <prefix>/tools until ctx is done and
+// publishes every change to store.
+func Watch(ctx context.Context, b Backend, prefix string, store *config.Store) {
+	go watch(ctx, b, prefix+"/models", func(data []byte) error {
+		var models []config.Model
+
+		if err := config.Decode(data, &models); err != nil {
+			return err
+		}
+
+		store.Update("kv: "+prefix+"/models", func(c *config.Config) {
+			c.Models = models
+		})
+
+		return nil
+	})
+
+	go watch(ctx, b, prefix+"/tools", func(data []byte) error {
+		var tools []config.Tool
+
+		if err := config.Decode(data, &tools); err != nil {
+			return err
+		}
+
+		store.Update("kv: "+prefix+"/tools", func(c *config.Config) {
+			c.Tools = tools
+		})
+
+		return nil
+	})
+}
+
+func watch(ctx context.Context, b Backend, key string, apply func([]byte) error) {
+	var index uint64
+
+	for ctx.Err() == nil {
+		data, next, err := b.Fetch(ctx, key, index)
+
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("config: kv", "key", key, "error", err)
+			}
+
+			sleep(ctx, retryDelay)
+			continue
+		}
+
+		if next == index {
+			continue
+		}
+
+		index = next
+
+		// A missing key leaves the current catalog untouched.
+		if data == nil {
+			continue
+		}
+
+		if err := apply(data); err != nil {
+			slog.Warn("config: kv", "key", key, "error", err)
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
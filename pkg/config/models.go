@@ -1,15 +1,29 @@
 package config
 
+import (
+	"slices"
+	"strings"
+)
+
+// Config is the effective deployment configuration. It is served to the client
+// as /config.json; fields tagged json:"-" stay on the server.
 type Config struct {
 	Title      string   `json:"title,omitempty" yaml:"title,omitempty"`
 	Disclaimer string   `json:"disclaimer,omitempty" yaml:"disclaimer,omitempty"`
 	Bridge     *Bridge  `json:"bridge,omitempty" yaml:"bridge,omitempty"`
 	Support    *Support `json:"support,omitempty" yaml:"support,omitempty"`
 
-	Tools  []Tool  `json:"tools,omitempty" yaml:"tools,omitempty"`
-	Models []Model `json:"models,omitempty" yaml:"models,omitempty"`
+	Branding *Branding `json:"branding,omitempty" yaml:"branding,omitempty"`
+
+	Tools   []Tool  `json:"tools,omitempty" yaml:"tools,omitempty"`
+	Models  []Model `json:"models,omitempty" yaml:"models,omitempty"`
+	Aliases []Alias `json:"aliases,omitempty" yaml:"aliases,omitempty"`
 
-	Drives []Drive `json:"drives,omitempty" yaml:"drives,omitempty"`
+	Drives    []Drive    `json:"drives,omitempty" yaml:"drives,omitempty"`
+	Databases []Database `json:"-" yaml:"databases,omitempty"`
+	Upstreams []Upstream `json:"-" yaml:"upstreams,omitempty"`
+	Routes    []Route    `json:"-" yaml:"routes,omitempty"`
+	Hooks     []Hook     `json:"-" yaml:"hooks,omitempty"`
 
 	TTS *TTS `json:"tts,omitempty" yaml:"tts,omitempty"`
 	STT *STT `json:"stt,omitempty" yaml:"stt,omitempty"`
@@ -34,25 +48,102 @@ type Config struct {
 	Telemetry *Telemetry `json:"telemetry,omitempty" yaml:"telemetry,omitempty"`
 
 	Backgrounds map[string][]Background `json:"backgrounds,omitempty" yaml:"backgrounds,omitempty"`
+
+	Elicitation map[string]string `json:"elicitation,omitempty" yaml:"elicitation,omitempty"`
+
+	Flags []Flag `json:"-" yaml:"flags,omitempty"`
+	Roles []Role `json:"-" yaml:"roles,omitempty"`
+
+	Network *Network `json:"-" yaml:"network,omitempty"`
+
+	Residency *Residency `json:"residency,omitempty" yaml:"residency,omitempty"`
+
+	Degradation []Degradation `json:"-" yaml:"degradation,omitempty"`
+
+	// Degraded are the features turned off while their services fail; set
+	// by the server.
+	Degraded []Outage `json:"degraded,omitempty" yaml:"-"`
+}
+
+// Elicitation policies decide when the user confirms a tool call
+// (elicitation.yaml). The policies are keyed by tool id (e.g. sql, internet),
+// <id>/<tool> for a single tool of a server, or * for all.
+const (
+	// ElicitAlways asks before every call.
+	ElicitAlways = "always"
+	// ElicitSession asks before the first call of a session.
+	ElicitSession = "session"
+	// ElicitNever calls without asking.
+	ElicitNever = "never"
+)
+
+// ElicitationPolicy returns the policy for the tool name of tool id, the
+// most specific key winning; tools not listed are called without asking.
+func (c *Config) ElicitationPolicy(id, name string) string {
+	for _, key := range []string{id + "/" + name, id, "*"} {
+		if policy, ok := c.Elicitation[key]; ok {
+			return policy
+		}
+	}
+
+	return ElicitNever
 }
 
+// Support links the UI to a help desk or support page.
 type Support struct {
 	URL string `json:"url,omitempty" yaml:"url,omitempty"`
 }
 
+// Branding white-labels the UI without rebuilding the frontend
+// (branding.yaml). Logos and icons are URLs (with scheme) or paths to files
+// on the server; they replace the bundled logo_*.svg and icon_* assets.
+// Titles and Disclaimers translate the title and disclaimer, keyed by
+// language tag (e.g. de or de-CH).
+type Branding struct {
+	Logo     string `json:"logo,omitempty" yaml:"logo,omitempty"`
+	LogoDark string `json:"logoDark,omitempty" yaml:"logoDark,omitempty"`
+	Icon     string `json:"icon,omitempty" yaml:"icon,omitempty"`
+	IconDark string `json:"iconDark,omitempty" yaml:"iconDark,omitempty"`
+
+	Color           string `json:"color,omitempty" yaml:"color,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty" yaml:"backgroundColor,omitempty"`
+
+	Links []Link `json:"links,omitempty" yaml:"links,omitempty"`
+
+	PrivacyURL string `json:"privacyUrl,omitempty" yaml:"privacyUrl,omitempty"`
+	TermsURL   string `json:"termsUrl,omitempty" yaml:"termsUrl,omitempty"`
+	ImprintURL string `json:"imprintUrl,omitempty" yaml:"imprintUrl,omitempty"`
+
+	Titles      map[string]string `json:"-" yaml:"titles,omitempty"`
+	Disclaimers map[string]string `json:"-" yaml:"disclaimers,omitempty"`
+}
+
+// Link is a labeled footer link.
+type Link struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	URL  string `json:"url,omitempty" yaml:"url,omitempty"`
+}
+
+// Tool is an MCP server offered to the model (tools.yaml).
 type Tool struct {
 	ID          string `json:"id,omitempty" yaml:"id,omitempty"`
 	URL         string `json:"url,omitempty" yaml:"url,omitempty"`
 	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
 	Description string `json:"description,omitempty" yaml:"description,omitempty"`
 	Icon        string `json:"icon,omitempty" yaml:"icon,omitempty"`
+
+	// Federated marks tools taken over from a peer instance; calls to them
+	// are proxied there.
+	Federated bool `json:"-" yaml:"federated,omitempty"`
 }
 
+// ModelTools narrows the tools available to a single model.
 type ModelTools struct {
 	Enabled  []string `json:"enabled,omitempty" yaml:"enabled,omitempty"`
 	Disabled []string `json:"disabled,omitempty" yaml:"disabled,omitempty"`
 }
 
+// Model is an entry of the model picker (models.yaml).
 type Model struct {
 	ID               string      `json:"id,omitempty" yaml:"id,omitempty"`
 	Name             string      `json:"name,omitempty" yaml:"name,omitempty"`
@@ -64,35 +155,207 @@ type Model struct {
 	Verbosity        string      `json:"verbosity,omitempty" yaml:"verbosity,omitempty"`
 	CompactThreshold *int        `json:"compactThreshold,omitempty" yaml:"compactThreshold,omitempty"`
 	Tools            *ModelTools `json:"tools,omitempty" yaml:"tools,omitempty"`
+
+	// Deprecation (YYYY-MM-DD) is the date the model is retired; from then
+	// on requests for it go to Replacement. Warning is set for clients as
+	// the date approaches.
+	Deprecation string `json:"deprecation,omitempty" yaml:"deprecation,omitempty"`
+	Replacement string `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	Warning     string `json:"warning,omitempty" yaml:"-"`
+
+	// Defaults injected by the /api proxy when the client leaves them unset.
+	Temperature     *float64 `json:"-" yaml:"temperature,omitempty"`
+	TopP            *float64 `json:"-" yaml:"topP,omitempty"`
+	MaxTokens       *int     `json:"-" yaml:"maxTokens,omitempty"`
+	ReasoningEffort string   `json:"-" yaml:"reasoningEffort,omitempty"`
+
+	// Limits per caller (user or API key) enforced by the /api proxy; they
+	// replace the deployment-wide defaults, and a negative value lifts them.
+	RequestsPerMinute int `json:"-" yaml:"requestsPerMinute,omitempty"`
+	TokensPerMinute   int `json:"-" yaml:"tokensPerMinute,omitempty"`
+
+	// Schema is the JSON schema the answers of a structured-output model
+	// must satisfy; the /api proxy requests it as response format and
+	// validates chat completions, asking the model up to SchemaRetries times
+	// to repair invalid answers.
+	Schema        map[string]any `json:"-" yaml:"schema,omitempty"`
+	SchemaRetries int            `json:"-" yaml:"schemaRetries,omitempty"`
+
+	// Upstream names the entry of upstreams.yaml serving the model; the
+	// platform when unset.
+	Upstream string `json:"-" yaml:"upstream,omitempty"`
+
+	// Federated marks models taken over from a peer instance; requests for
+	// them are proxied there.
+	Federated bool `json:"-" yaml:"federated,omitempty"`
+
+	// Discovered marks models listed by the platform rather than
+	// configured.
+	Discovered bool `json:"-" yaml:"discovered,omitempty"`
 }
 
+// Alias redirects requests for a model id, e.g. of a retired model that saved
+// chats still name, to Target (aliases.yaml). Sunset (YYYY-MM-DD) tells
+// clients when the id goes away.
+type Alias struct {
+	ID     string `json:"id,omitempty" yaml:"id,omitempty"`
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
+	Sunset string `json:"sunset,omitempty" yaml:"sunset,omitempty"`
+}
+
+// Upstream is an OpenAI compatible API besides the platform (upstreams.yaml),
+// e.g. an Azure OpenAI endpoint or an Ollama server, that models are routed
+// to. Token is sent as bearer token and Headers with every request. Type
+// "azure" adapts requests to Azure OpenAI, using APIVersion; "anthropic" and
+// "bedrock" translate chat completions for Anthropic models, on Bedrock
+// signed for Region unless a token is set.
+type Upstream struct {
+	ID      string            `json:"-" yaml:"id,omitempty"`
+	Type    string            `json:"-" yaml:"type,omitempty"`
+	URL     string            `json:"-" yaml:"url,omitempty"`
+	Token   string            `json:"-" yaml:"token,omitempty"`
+	Headers map[string]string `json:"-" yaml:"headers,omitempty"`
+
+	APIVersion string `json:"-" yaml:"apiVersion,omitempty"`
+	Region     string `json:"-" yaml:"region,omitempty"`
+
+	// Regions maps regions of residency.yaml (e.g. eu) to the upstreams
+	// serving their requests in place of this one.
+	Regions map[string]string `json:"-" yaml:"regions,omitempty"`
+
+	// Location is the region of residency.yaml the upstream serves in.
+	Location string `json:"-" yaml:"location,omitempty"`
+}
+
+// Route sends the requests for a path below the prefix (routes.yaml) to
+// Upstream (an id of upstreams.yaml; the platform when empty) and/or to
+// another path, Rewrite. A Path ending in /* matches all paths below it; a
+// Rewrite ending in /* then takes the rest. Routes of models win over them.
+type Route struct {
+	Path     string `json:"-" yaml:"path,omitempty"`
+	Upstream string `json:"-" yaml:"upstream,omitempty"`
+	Rewrite  string `json:"-" yaml:"rewrite,omitempty"`
+}
+
+// Match reports whether the route applies to path and returns the path the
+// request is sent to.
+func (r Route) Match(path string) (string, bool) {
+	rest := ""
+
+	if prefix, ok := strings.CutSuffix(r.Path, "/*"); ok {
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			return "", false
+		}
+
+		rest = strings.TrimPrefix(path, prefix)
+	} else if path != r.Path {
+		return "", false
+	}
+
+	if r.Rewrite == "" {
+		return path, true
+	}
+
+	if prefix, ok := strings.CutSuffix(r.Rewrite, "/*"); ok {
+		return prefix + rest, true
+	}
+
+	return r.Rewrite, true
+}
+
+// Hook transforms the JSON bodies of proxied model requests, or of their
+// answers that aren't streamed (hooks.yaml), in order. Paths (below the
+// prefix, /* as in routes) and Models narrow where it applies; everywhere
+// when empty. System is prepended as a system message to chat completions
+// and to the instructions of responses, Set sets fields, Remove drops fields
+// by dotted path (through arrays, e.g. choices.message.reasoning_content),
+// and URL posts the body to a service that returns the one to go on with.
+type Hook struct {
+	Name string `json:"-" yaml:"name,omitempty"`
+
+	// On is "request" (the default) or "response".
+	On string `json:"-" yaml:"on,omitempty"`
+
+	Paths  []string `json:"-" yaml:"paths,omitempty"`
+	Models []string `json:"-" yaml:"models,omitempty"`
+
+	System string         `json:"-" yaml:"system,omitempty"`
+	Set    map[string]any `json:"-" yaml:"set,omitempty"`
+	Remove []string       `json:"-" yaml:"remove,omitempty"`
+
+	URL string `json:"-" yaml:"url,omitempty"`
+}
+
+// Hook stages.
+const (
+	HookRequest  = "request"
+	HookResponse = "response"
+)
+
+// Applies reports whether the hook runs at stage for model on path.
+func (h Hook) Applies(stage, path, model string) bool {
+	on := h.On
+
+	if on == "" {
+		on = HookRequest
+	}
+
+	if on != stage {
+		return false
+	}
+
+	if len(h.Models) > 0 && !slices.Contains(h.Models, model) {
+		return false
+	}
+
+	if len(h.Paths) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(h.Paths, func(p string) bool {
+		_, ok := Route{Path: p}.Match(path)
+		return ok
+	})
+}
+
+// TTS configures text-to-speech (tts.yaml); Voices maps voice ids to display
+// names. Preview is the sentence spoken in voice previews. Formats lists the
+// audio formats the upstream produces natively; others are transcoded.
 type TTS struct {
-	Model  string            `json:"model,omitempty" yaml:"model,omitempty"`
-	Voices map[string]string `json:"voices,omitempty" yaml:"voices,omitempty"`
+	Model   string            `json:"model,omitempty" yaml:"model,omitempty"`
+	Voices  map[string]string `json:"voices,omitempty" yaml:"voices,omitempty"`
+	Preview string            `json:"-" yaml:"preview,omitempty"`
+	Formats []string          `json:"-" yaml:"formats,omitempty"`
 }
 
+// STT configures speech-to-text.
 type STT struct {
 	Model string `json:"model,omitempty" yaml:"model,omitempty"`
 }
 
+// Voice configures realtime voice conversations.
 type Voice struct {
 	Model       string `json:"model,omitempty" yaml:"model,omitempty"`
 	Transcriber string `json:"transcriber,omitempty" yaml:"transcriber,omitempty"`
 }
 
+// Vision lists the file types sent to the model as images (vision.yaml).
 type Vision struct {
 	Files []string `json:"files,omitempty" yaml:"files,omitempty"`
 }
 
+// Text lists the file types read as plain text (text.yaml).
 type Text struct {
 	Files []string `json:"files,omitempty" yaml:"files,omitempty"`
 }
 
+// Extractor configures document-to-text extraction (extractor.yaml).
 type Extractor struct {
 	Model string   `json:"model,omitempty" yaml:"model,omitempty"`
 	Files []string `json:"files,omitempty" yaml:"files,omitempty"`
 }
 
+// Internet configures web search, scraping and research (internet.yaml).
 type Internet struct {
 	Searcher    string `json:"searcher,omitempty" yaml:"searcher,omitempty"`
 	Scraper     string `json:"scraper,omitempty" yaml:"scraper,omitempty"`
@@ -100,21 +363,29 @@ type Internet struct {
 	Elicitation bool   `json:"elicitation,omitempty" yaml:"elicitation,omitempty"`
 }
 
+// Renderer configures image generation (renderer.yaml). Disclaimers
+// translate the disclaimer, keyed by language tag.
 type Renderer struct {
 	Model       string `json:"model,omitempty" yaml:"model,omitempty"`
 	Disclaimer  string `json:"disclaimer,omitempty" yaml:"disclaimer,omitempty"`
 	Elicitation bool   `json:"elicitation,omitempty" yaml:"elicitation,omitempty"`
+
+	Disclaimers map[string]string `json:"-" yaml:"disclaimers,omitempty"`
 }
 
+// Artifacts enables the per-conversation artifacts workspace.
 type Artifacts struct{}
 
+// Repository configures retrieval over uploaded files (repository.yaml).
 type Repository struct {
 	Embedder  string `json:"embedder,omitempty" yaml:"embedder,omitempty"`
 	Extractor string `json:"extractor,omitempty" yaml:"extractor,omitempty"`
 }
 
+// Memory enables cross-conversation memory.
 type Memory struct{}
 
+// Notebook configures the notebook and its output styles (notebook.yaml).
 type Notebook struct {
 	Model         string                 `json:"model,omitempty" yaml:"model,omitempty"`
 	Renderer      string                 `json:"renderer,omitempty" yaml:"renderer,omitempty"`
@@ -126,27 +397,34 @@ type Notebook struct {
 	Architectures []NotebookArchitecture `json:"architectures,omitempty" yaml:"architectures,omitempty"`
 }
 
+// Chat configures conversation behavior (chat.yaml).
 type Chat struct {
 	Instructions  string `json:"instructions,omitempty" yaml:"instructions,omitempty"`
 	RetentionDays *int   `json:"retentionDays,omitempty" yaml:"retentionDays,omitempty"`
 	Summarizer    string `json:"summarizer,omitempty" yaml:"summarizer,omitempty"`
 	Optimizer     string `json:"optimizer,omitempty" yaml:"optimizer,omitempty"`
 
+	// Ephemeral keeps chats in memory only; set for guests.
+	Ephemeral bool `json:"ephemeral,omitempty" yaml:"-"`
+
 	Compaction     *Compaction     `json:"compaction,omitempty" yaml:"compaction,omitempty"`
 	Classification *Classification `json:"classification,omitempty" yaml:"classification,omitempty"`
 	Categories     []Category      `json:"categories,omitempty" yaml:"categories,omitempty"`
 	Risks          []Risk          `json:"risks,omitempty" yaml:"risks,omitempty"`
 }
 
+// Compaction summarizes older turns once the estimated token budget is exceeded.
 type Compaction struct {
 	Threshold int `json:"threshold,omitempty" yaml:"threshold,omitempty"`
 }
 
+// Classification selects the model used to classify prompts into categories and risks.
 type Classification struct {
 	Model     string  `json:"model,omitempty" yaml:"model,omitempty"`
 	Threshold float64 `json:"threshold,omitempty" yaml:"threshold,omitempty"`
 }
 
+// Category is a prompt category that may require user consent.
 type Category struct {
 	Name        string  `json:"name,omitempty" yaml:"name,omitempty"`
 	Description string  `json:"description,omitempty" yaml:"description,omitempty"`
@@ -154,6 +432,7 @@ type Category struct {
 	Threshold   float64 `json:"threshold,omitempty" yaml:"threshold,omitempty"`
 }
 
+// Risk is a prompt risk reported to the user with the given severity.
 type Risk struct {
 	Name        string  `json:"name,omitempty" yaml:"name,omitempty"`
 	Description string  `json:"description,omitempty" yaml:"description,omitempty"`
@@ -162,53 +441,272 @@ type Risk struct {
 	Threshold   float64 `json:"threshold,omitempty" yaml:"threshold,omitempty"`
 }
 
+// Translator configures the translate mode (translator.yaml).
 type Translator struct {
 	Model     string   `json:"model,omitempty" yaml:"model,omitempty"`
 	Files     []string `json:"files,omitempty" yaml:"files,omitempty"`
 	Languages []string `json:"languages,omitempty" yaml:"languages,omitempty"`
 }
 
+// Telemetry enables forwarding of client OpenTelemetry signals.
 type Telemetry struct{}
 
+// Background is a wallpaper image offered in a background pack (backgrounds.yaml).
 type Background struct {
 	URL string `json:"url,omitempty" yaml:"url,omitempty"`
 }
 
+// NotebookSlide is a slide deck style of the notebook.
 type NotebookSlide struct {
 	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
 	Prompt string `json:"prompt,omitempty" yaml:"prompt,omitempty"`
 }
 
+// NotebookPodcast is a podcast format of the notebook.
 type NotebookPodcast struct {
 	Name   string   `json:"name,omitempty" yaml:"name,omitempty"`
 	Prompt string   `json:"prompt,omitempty" yaml:"prompt,omitempty"`
 	Voices []string `json:"voices,omitempty" yaml:"voices,omitempty"`
 }
 
+// NotebookReport is a report style of the notebook.
 type NotebookReport struct {
 	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
 	Prompt string `json:"prompt,omitempty" yaml:"prompt,omitempty"`
 }
 
+// NotebookInfographic is an infographic style of the notebook.
 type NotebookInfographic struct {
 	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
 	Prompt string `json:"prompt,omitempty" yaml:"prompt,omitempty"`
 }
 
+// NotebookProcess is a process diagram style of the notebook.
 type NotebookProcess struct {
 	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
 	Prompt string `json:"prompt,omitempty" yaml:"prompt,omitempty"`
 }
 
+// NotebookArchitecture is an architecture diagram style of the notebook.
 type NotebookArchitecture struct {
 	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
 	Prompt string `json:"prompt,omitempty" yaml:"prompt,omitempty"`
 }
 
+// Flag is a feature flag evaluated per user and served as /flags.json
+// (flags.yaml). Users and Groups always get the flag; everyone else is
+// rolled out by Percentage when set, or by Enabled otherwise.
+type Flag struct {
+	Name       string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Enabled    bool     `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Percentage *int     `json:"percentage,omitempty" yaml:"percentage,omitempty"`
+	Users      []string `json:"users,omitempty" yaml:"users,omitempty"`
+	Groups     []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+// Role grants the users and groups it lists (everyone when both are empty)
+// access to models, tools and features (roles.yaml). Empty lists and "*"
+// grant all. Once roles are configured, access is limited to what the roles
+// of a user grant.
+type Role struct {
+	Name   string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Users  []string `json:"users,omitempty" yaml:"users,omitempty"`
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+
+	Models   []string `json:"models,omitempty" yaml:"models,omitempty"`
+	Tools    []string `json:"tools,omitempty" yaml:"tools,omitempty"`
+	Features []string `json:"features,omitempty" yaml:"features,omitempty"`
+}
+
+// Network restricts the client addresses served (network.yaml). Entries are
+// CIDRs or single addresses.
+type Network struct {
+	// Allow, when not empty, only admits the listed networks; Deny rejects
+	// networks and wins over Allow.
+	Allow []string `json:"-" yaml:"allow,omitempty"`
+	Deny  []string `json:"-" yaml:"deny,omitempty"`
+
+	// API replaces the rules for requests below the API prefix when set.
+	API *NetworkRules `json:"-" yaml:"api,omitempty"`
+
+	// TrustedProxies are the networks of reverse proxies whose
+	// X-Forwarded-For header names the client.
+	TrustedProxies []string `json:"-" yaml:"trustedProxies,omitempty"`
+}
+
+type NetworkRules struct {
+	Allow []string `json:"-" yaml:"allow,omitempty"`
+	Deny  []string `json:"-" yaml:"deny,omitempty"`
+}
+
+// Residency decides the region requests to the upstreams are served in
+// (residency.yaml): Region for all of them when set, else the region whose
+// Countries include the user's country, as told by the GeoIP header.
+// Regions, Upstreams and Storage make it a policy the server enforces; it is
+// served to the client as is.
+type Residency struct {
+	Region string `json:"region,omitempty" yaml:"region,omitempty"`
+
+	// Countries maps regions to ISO 3166 country codes (e.g. eu: [DE, FR]).
+	Countries map[string][]string `json:"-" yaml:"countries,omitempty"`
+
+	// Regions are those requests may be served in, by the location of the
+	// upstream; all when empty.
+	Regions []string `json:"regions,omitempty" yaml:"regions,omitempty"`
+
+	// Upstreams are the entries of upstreams.yaml, or platform and
+	// federation, requests may be sent to; all when empty.
+	Upstreams []string `json:"upstreams,omitempty" yaml:"upstreams,omitempty"`
+
+	// Storage are the stores of this server that may keep prompts and
+	// answers (archive, cache, or none for neither); all when empty.
+	Storage []string `json:"storage,omitempty" yaml:"storage,omitempty"`
+}
+
+// Upstreams the residency policy names besides the entries of
+// upstreams.yaml, and the stores it governs.
+const (
+	PlatformUpstream   = "platform"
+	FederationUpstream = "federation"
+
+	ArchiveStorage = "archive"
+	CacheStorage   = "cache"
+	NoStorage      = "none"
+)
+
+// PermitsUpstream reports whether requests may be sent to upstream id,
+// serving in region.
+func (r *Residency) PermitsUpstream(id, region string) bool {
+	if r == nil {
+		return true
+	}
+
+	if len(r.Upstreams) > 0 && !slices.Contains(r.Upstreams, id) {
+		return false
+	}
+
+	return len(r.Regions) == 0 || slices.Contains(r.Regions, region)
+}
+
+// PermitsStorage reports whether prompts and answers may be kept in store.
+func (r *Residency) PermitsStorage(store string) bool {
+	return r == nil || len(r.Storage) == 0 || slices.Contains(r.Storage, store)
+}
+
+// RegionOf returns the region of country, or "".
+func (r *Residency) RegionOf(country string) string {
+	if r == nil {
+		return ""
+	}
+
+	for region, countries := range r.Countries {
+		if slices.ContainsFunc(countries, func(c string) bool { return strings.EqualFold(c, country) }) {
+			return region
+		}
+	}
+
+	return ""
+}
+
+// Degradation turns a feature off while the service behind it fails
+// (degradation.yaml), so its parts of the UI are hidden instead of failing
+// request by request. URL is probed (any status below 500 passes); without
+// it, the platform must list the models the feature is configured with.
+// Message tells users about the outage.
+type Degradation struct {
+	Feature string `json:"-" yaml:"feature,omitempty"`
+	URL     string `json:"-" yaml:"url,omitempty"`
+	Message string `json:"-" yaml:"message,omitempty"`
+}
+
+// Outage is a feature turned off by its degradation.
+type Outage struct {
+	Feature string `json:"feature"`
+	Message string `json:"message,omitempty"`
+}
+
+// HasFeature reports whether the feature name is configured.
+func (c *Config) HasFeature(name string) bool {
+	switch name {
+	case "internet":
+		return c.Internet != nil
+	case "renderer":
+		return c.Renderer != nil
+	case "translator":
+		return c.Translator != nil
+	case "voice":
+		return c.Voice != nil
+	case "tts":
+		return c.TTS != nil
+	case "stt":
+		return c.STT != nil
+	}
+
+	return false
+}
+
+// WithoutFeature turns the feature name off; c must be a copy the caller
+// owns.
+func (c *Config) WithoutFeature(name string) {
+	switch name {
+	case "internet":
+		c.Internet = nil
+	case "renderer":
+		c.Renderer = nil
+	case "translator":
+		c.Translator = nil
+	case "voice":
+		c.Voice = nil
+	case "tts":
+		c.TTS = nil
+	case "stt":
+		c.STT = nil
+	}
+}
+
+// FeatureModels returns the models the feature name is configured with.
+func (c *Config) FeatureModels(name string) []string {
+	var models []string
+
+	switch name {
+	case "internet":
+		if c.Internet != nil {
+			models = []string{c.Internet.Searcher, c.Internet.Scraper, c.Internet.Researcher}
+		}
+	case "renderer":
+		if c.Renderer != nil {
+			models = []string{c.Renderer.Model}
+		}
+	case "translator":
+		if c.Translator != nil {
+			models = []string{c.Translator.Model}
+		}
+	case "voice":
+		if c.Voice != nil {
+			models = []string{c.Voice.Model, c.Voice.Transcriber}
+		}
+	case "tts":
+		if c.TTS != nil {
+			models = []string{c.TTS.Model}
+		}
+	case "stt":
+		if c.STT != nil {
+			models = []string{c.STT.Model}
+		}
+	}
+
+	return slices.DeleteFunc(models, func(m string) bool { return m == "" })
+}
+
+// Features are the names roles grant features by.
+var Features = []string{"internet", "renderer", "translator", "voice", "tts", "stt", "guests"}
+
+// Bridge points the client at an MCP bridge.
 type Bridge struct {
 	URL string `json:"url,omitempty" yaml:"url,omitempty"`
 }
 
+// Drive is a cloud or local document source (drives.yaml).
 type Drive struct {
 	ID   string `json:"id,omitempty" yaml:"id,omitempty"`
 	Type string `json:"-" yaml:"type,omitempty"`
@@ -220,6 +718,20 @@ type Drive struct {
 	Auth *DriveAuth `json:"-" yaml:"auth,omitempty"`
 }
 
+// Database is a PostgreSQL database exposed read-only through the built-in
+// sql tool (databases.yaml). MaxRows (default 200) caps query results and
+// Timeout (default 30s) bounds each statement.
+type Database struct {
+	ID          string `json:"id,omitempty" yaml:"id,omitempty"`
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	DSN         string `json:"-" yaml:"dsn,omitempty"`
+
+	MaxRows int    `json:"-" yaml:"maxRows,omitempty"`
+	Timeout string `json:"-" yaml:"timeout,omitempty"`
+}
+
+// DriveAuth configures the On-Behalf-Of token exchange for a drive.
 type DriveAuth struct {
 	Issuer       string `json:"-" yaml:"issuer,omitempty"`
 	ClientID     string `json:"-" yaml:"client_id,omitempty"`
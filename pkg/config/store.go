@@ -0,0 +1,208 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Store holds the effective configuration and allows parts of it to be
+// replaced at runtime (e.g. catalogs watched in a KV store or changes applied
+// through the admin API). Readers always get a consistent snapshot that must
+// not be modified.
+type Store struct {
+	mu  sync.RWMutex
+	cfg *Config
+
+	version int
+	history []Version
+
+	historyDir   string
+	historyLimit int
+}
+
+// Version is an entry of the configuration history.
+type Version struct {
+	Version int       `json:"version" yaml:"version"`
+	Time    time.Time `json:"time" yaml:"time"`
+	Source  string    `json:"source" yaml:"source"`
+
+	Config *Config `json:"-" yaml:"config"`
+}
+
+func NewStore(cfg *Config) *Store {
+	return &Store{
+		cfg: cfg,
+	}
+}
+
+// Config returns the current snapshot.
+func (s *Store) Config() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cfg
+}
+
+// KeepHistory records the last limit effective configurations, starting with
+// the current one. When dir is set, versions are also written there as YAML
+// and the history of previous runs is picked up again.
+func (s *Store) KeepHistory(limit int, dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.historyLimit = limit
+	s.historyDir = dir
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+
+		history, err := readHistory(dir)
+
+		if err != nil {
+			return err
+		}
+
+		s.history = history
+
+		if len(history) > 0 {
+			s.version = history[len(history)-1].Version
+		}
+	}
+
+	return s.record("startup")
+}
+
+// History returns the recorded versions, oldest first.
+func (s *Store) History() []Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Version(nil), s.history...)
+}
+
+// Update applies fn to a shallow copy of the current snapshot and publishes the
+// result. fn must replace the fields it changes rather than mutate shared
+// slices, maps or pointers in place. source describes the change in the history.
+func (s *Store) Update(source string, fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := *s.cfg
+	fn(&next)
+
+	s.cfg = &next
+
+	if err := s.record(source); err != nil {
+		slog.Error("config: history", "error", err)
+	}
+}
+
+// Rollback publishes the configuration of the given history version again.
+func (s *Store) Rollback(version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.history {
+		if v.Version != version {
+			continue
+		}
+
+		s.cfg = v.Config
+
+		return s.record(fmt.Sprintf("rollback to %d", version))
+	}
+
+	return fmt.Errorf("version %d not found", version)
+}
+
+func (s *Store) record(source string) error {
+	if s.historyLimit <= 0 {
+		return nil
+	}
+
+	s.version++
+
+	v := Version{
+		Version: s.version,
+		Time:    time.Now().UTC(),
+		Source:  source,
+
+		Config: s.cfg,
+	}
+
+	s.history = append(s.history, v)
+
+	var dropped []Version
+
+	if len(s.history) > s.historyLimit {
+		n := len(s.history) - s.historyLimit
+		dropped, s.history = s.history[:n], s.history[n:]
+	}
+
+	if s.historyDir == "" {
+		return nil
+	}
+
+	for _, d := range dropped {
+		os.Remove(historyFile(s.historyDir, d.Version))
+	}
+
+	data, err := yaml.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(historyFile(s.historyDir, v.Version), data, 0o600)
+}
+
+func historyFile(dir string, version int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d.yaml", version))
+}
+
+func readHistory(dir string) ([]Version, error) {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Version
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".yaml") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+
+		if err != nil {
+			return nil, err
+		}
+
+		var v Version
+
+		if err := yaml.Unmarshal(data, &v); err != nil || v.Config == nil {
+			slog.Warn("config: history: skipping", "file", e.Name())
+			continue
+		}
+
+		result = append(result, v)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	return result, nil
+}
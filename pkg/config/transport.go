@@ -0,0 +1,192 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TuneTransport applies the TRANSPORT_* settings to http.DefaultTransport,
+// which the platform transport and those of peers and other upstreams derive
+// from, so it must run before they are created:
+//
+//   - TRANSPORT_MAX_IDLE_CONNS (default 100) and
+//     TRANSPORT_MAX_IDLE_CONNS_PER_HOST (default 32) bound the connections
+//     kept for reuse, TRANSPORT_MAX_CONNS_PER_HOST those open at once (0 is
+//     unlimited)
+//   - TRANSPORT_IDLE_CONN_TIMEOUT (default 90s) closes unused connections,
+//     TRANSPORT_KEEP_ALIVE (default 30s) is the TCP keep-alive interval
+//   - TRANSPORT_TLS_SESSION_CACHE (default 64) is the number of TLS sessions
+//     kept for resumption, 0 disables it
+//   - TRANSPORT_DNS_CACHE_TTL (e.g. 1m) caches resolved addresses
+func TuneTransport() error {
+	t, ok := http.DefaultTransport.(*http.Transport)
+
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+
+	number := func(key string, value *int) {
+		if s := os.Getenv(key); s != "" {
+			n, err := strconv.Atoi(s)
+
+			if err != nil || n < 0 {
+				errs = append(errs, fmt.Errorf("%s: invalid number %q", key, s))
+				return
+			}
+
+			*value = n
+		}
+	}
+
+	duration := func(key string, value *time.Duration) {
+		if s := os.Getenv(key); s != "" {
+			d, err := time.ParseDuration(s)
+
+			if err != nil || d < 0 {
+				errs = append(errs, fmt.Errorf("%s: invalid duration %q", key, s))
+				return
+			}
+
+			*value = d
+		}
+	}
+
+	maxIdle := 100
+	maxIdlePerHost := 32
+	maxPerHost := 0
+	sessions := 64
+
+	idleTimeout := 90 * time.Second
+	keepAlive := 30 * time.Second
+
+	var dnsTTL time.Duration
+
+	number("TRANSPORT_MAX_IDLE_CONNS", &maxIdle)
+	number("TRANSPORT_MAX_IDLE_CONNS_PER_HOST", &maxIdlePerHost)
+	number("TRANSPORT_MAX_CONNS_PER_HOST", &maxPerHost)
+	number("TRANSPORT_TLS_SESSION_CACHE", &sessions)
+
+	duration("TRANSPORT_IDLE_CONN_TIMEOUT", &idleTimeout)
+	duration("TRANSPORT_KEEP_ALIVE", &keepAlive)
+	duration("TRANSPORT_DNS_CACHE_TTL", &dnsTTL)
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	t.MaxIdleConns = maxIdle
+	t.MaxIdleConnsPerHost = maxIdlePerHost
+	t.MaxConnsPerHost = maxPerHost
+	t.IdleConnTimeout = idleTimeout
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: keepAlive,
+	}
+
+	t.DialContext = dialer.DialContext
+
+	if dnsTTL > 0 {
+		t.DialContext = (&dnsCache{dialer: dialer, ttl: dnsTTL, hosts: map[string]*dnsEntry{}}).DialContext
+	}
+
+	if sessions > 0 {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+
+		t.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(sessions)
+	}
+
+	return nil
+}
+
+// dnsCache resolves host names at most once per ttl. Connections try the
+// addresses in turn, starting with the next one each time; the last known
+// addresses are used while lookups fail.
+type dnsCache struct {
+	dialer *net.Dialer
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+	next    int
+}
+
+func (c *dnsCache) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil || net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := c.lookup(ctx, host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+
+	for _, addr := range addrs {
+		if conn, err = c.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// lookup returns the addresses of host, rotated for each call.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e := c.hosts[host]
+	c.mu.Unlock()
+
+	if e == nil || time.Now().After(e.expires) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+
+		if err != nil && e == nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+
+		if err == nil {
+			e = &dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+			c.hosts[host] = e
+		} else {
+			slog.Warn("config: dns lookup failed, using cached addresses", "host", host, "error", err)
+			e.expires = time.Now().Add(c.ttl)
+		}
+
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(e.addrs) == 0 {
+		return nil, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+
+	start := e.next % len(e.addrs)
+	e.next++
+
+	return append(e.addrs[start:len(e.addrs):len(e.addrs)], e.addrs[:start]...), nil
+}
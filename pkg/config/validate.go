@@ -0,0 +1,412 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/jsonschema"
+)
+
+// Validate reports semantic problems that strict YAML decoding can't catch,
+// such as missing or duplicate ids.
+func Validate(cfg *Config) []error {
+	var errs []error
+
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	upstreams := map[string]bool{}
+
+	for i, u := range cfg.Upstreams {
+		if u.ID == "" {
+			fail("upstreams[%d]: id is required", i)
+		} else if upstreams[u.ID] {
+			fail("upstreams[%d]: duplicate id %q", i, u.ID)
+		}
+
+		upstreams[u.ID] = true
+
+		if parsed, err := url.Parse(u.URL); err != nil || parsed.Host == "" {
+			fail("upstreams[%d]: invalid url %q", i, u.URL)
+		}
+
+		switch u.Type {
+		case "", "openai", "azure", "anthropic", "bedrock":
+		default:
+			fail("upstreams[%d]: unknown type %q", i, u.Type)
+		}
+	}
+
+	for i, u := range cfg.Upstreams {
+		for region, id := range u.Regions {
+			if !upstreams[id] {
+				fail("upstreams[%d]: unknown upstream %q for region %q", i, id, region)
+			}
+		}
+	}
+
+	if res := cfg.Residency; res != nil {
+		countries := map[string]string{}
+
+		for _, region := range slices.Sorted(maps.Keys(res.Countries)) {
+			for _, c := range res.Countries[region] {
+				c = strings.ToUpper(c)
+
+				if len(c) != 2 {
+					fail("residency: invalid country %q of region %q", c, region)
+				} else if other, ok := countries[c]; ok && other != region {
+					fail("residency: country %q is in regions %q and %q", c, other, region)
+				}
+
+				countries[c] = region
+			}
+		}
+
+		if res.Region != "" && len(res.Regions) > 0 && !slices.Contains(res.Regions, res.Region) {
+			fail("residency: region %q is not among the permitted regions", res.Region)
+		}
+
+		for _, id := range res.Upstreams {
+			if !upstreams[id] && id != PlatformUpstream && id != FederationUpstream {
+				fail("residency: unknown upstream %q", id)
+			}
+		}
+
+		for _, store := range res.Storage {
+			switch store {
+			case ArchiveStorage, CacheStorage, NoStorage:
+			default:
+				fail("residency: unknown storage %q", store)
+			}
+		}
+
+		// Upstreams the policy forbids would only fail at request time.
+		permitted := func(id string) bool {
+			i := slices.IndexFunc(cfg.Upstreams, func(u Upstream) bool { return u.ID == id })
+
+			if i < 0 {
+				return true
+			}
+
+			if regional, ok := cfg.Upstreams[i].Regions[res.Region]; ok && res.Region != "" {
+				if j := slices.IndexFunc(cfg.Upstreams, func(u Upstream) bool { return u.ID == regional }); j >= 0 {
+					i = j
+				}
+			}
+
+			return res.PermitsUpstream(cfg.Upstreams[i].ID, cfg.Upstreams[i].Location)
+		}
+
+		for i, m := range cfg.Models {
+			if m.Upstream != "" && !permitted(m.Upstream) {
+				fail("models[%d]: upstream %q is not permitted by residency.yaml", i, m.Upstream)
+			}
+		}
+
+		for i, rt := range cfg.Routes {
+			if rt.Upstream != "" && !permitted(rt.Upstream) {
+				fail("routes[%d]: upstream %q is not permitted by residency.yaml", i, rt.Upstream)
+			}
+		}
+	}
+
+	routes := map[string]bool{}
+
+	for i, rt := range cfg.Routes {
+		if !strings.HasPrefix(rt.Path, "/") {
+			fail("routes[%d]: path must start with /", i)
+		} else if routes[rt.Path] {
+			fail("routes[%d]: duplicate path %q", i, rt.Path)
+		}
+
+		routes[rt.Path] = true
+
+		if rt.Upstream == "" && rt.Rewrite == "" {
+			fail("routes[%d]: upstream or rewrite is required", i)
+		}
+
+		if rt.Upstream != "" && !upstreams[rt.Upstream] {
+			fail("routes[%d]: unknown upstream %q", i, rt.Upstream)
+		}
+
+		if rt.Rewrite != "" && !strings.HasPrefix(rt.Rewrite, "/") {
+			fail("routes[%d]: rewrite must start with /", i)
+		}
+
+		if strings.HasSuffix(rt.Rewrite, "/*") && !strings.HasSuffix(rt.Path, "/*") {
+			fail("routes[%d]: rewrite ends in /* but path does not", i)
+		}
+	}
+
+	degradations := map[string]bool{}
+
+	for i, d := range cfg.Degradation {
+		switch d.Feature {
+		case "internet", "renderer", "translator", "voice", "tts", "stt":
+		default:
+			fail("degradation[%d]: unknown feature %q", i, d.Feature)
+		}
+
+		if degradations[d.Feature] {
+			fail("degradation[%d]: duplicate feature %q", i, d.Feature)
+		}
+
+		degradations[d.Feature] = true
+
+		if d.URL != "" {
+			if u, err := url.Parse(d.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("degradation[%d]: invalid url %q", i, d.URL)
+			}
+		}
+	}
+
+	for i, h := range cfg.Hooks {
+		if h.On != "" && h.On != HookRequest && h.On != HookResponse {
+			fail("hooks[%d]: on must be %q or %q", i, HookRequest, HookResponse)
+		}
+
+		if h.System == "" && len(h.Set) == 0 && len(h.Remove) == 0 && h.URL == "" {
+			fail("hooks[%d]: system, set, remove or url is required", i)
+		}
+
+		if h.System != "" && h.On == HookResponse {
+			fail("hooks[%d]: system only applies to requests", i)
+		}
+
+		for _, p := range h.Paths {
+			if !strings.HasPrefix(p, "/") {
+				fail("hooks[%d]: path %q must start with /", i, p)
+			}
+		}
+
+		if h.URL != "" {
+			if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("hooks[%d]: invalid url %q", i, h.URL)
+			}
+		}
+	}
+
+	models := map[string]bool{}
+
+	for i, m := range cfg.Models {
+		if m.ID == "" {
+			fail("models[%d]: id is required", i)
+		} else if models[m.ID] {
+			fail("models[%d]: duplicate id %q", i, m.ID)
+		}
+
+		models[m.ID] = true
+
+		if m.Schema != nil {
+			if _, err := jsonschema.Compile(m.Schema); err != nil {
+				fail("models[%d]: invalid schema: %v", i, err)
+			}
+		}
+
+		if m.SchemaRetries < 0 {
+			fail("models[%d]: schemaRetries must not be negative", i)
+		}
+
+		if m.Upstream != "" && !upstreams[m.Upstream] {
+			fail("models[%d]: unknown upstream %q", i, m.Upstream)
+		}
+
+		if m.Deprecation != "" {
+			if _, err := time.Parse(time.DateOnly, m.Deprecation); err != nil {
+				fail("models[%d]: invalid deprecation %q, want YYYY-MM-DD", i, m.Deprecation)
+			}
+		}
+	}
+
+	for i, m := range cfg.Models {
+		if m.Replacement == "" {
+			continue
+		}
+
+		if m.Replacement == m.ID || !models[m.Replacement] {
+			fail("models[%d]: unknown replacement %q", i, m.Replacement)
+		}
+
+		if m.Deprecation == "" {
+			fail("models[%d]: replacement requires a deprecation date", i)
+		}
+	}
+
+	aliases := map[string]string{}
+
+	for i, a := range cfg.Aliases {
+		if a.ID == "" || a.Target == "" {
+			fail("aliases[%d]: id and target are required", i)
+			continue
+		}
+
+		if _, ok := aliases[a.ID]; ok {
+			fail("aliases[%d]: duplicate id %q", i, a.ID)
+		}
+
+		aliases[a.ID] = a.Target
+
+		if a.Sunset != "" {
+			if _, err := time.Parse(time.DateOnly, a.Sunset); err != nil {
+				fail("aliases[%d]: invalid sunset %q, want YYYY-MM-DD", i, a.Sunset)
+			}
+		}
+	}
+
+	for id := range aliases {
+		seen := map[string]bool{id: true}
+
+		for next, ok := aliases[id]; ok; next, ok = aliases[next] {
+			if seen[next] {
+				fail("aliases: %q redirects in a loop", id)
+				break
+			}
+
+			seen[next] = true
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(cfg.Elicitation)) {
+		switch policy := cfg.Elicitation[key]; policy {
+		case ElicitAlways, ElicitSession, ElicitNever:
+		default:
+			fail("elicitation: %s: unknown policy %q, want always, session or never", key, policy)
+		}
+	}
+
+	tools := map[string]bool{}
+
+	for i, t := range cfg.Tools {
+		if t.ID == "" {
+			fail("tools[%d]: id is required", i)
+		} else if tools[t.ID] {
+			fail("tools[%d]: duplicate id %q", i, t.ID)
+		}
+
+		tools[t.ID] = true
+
+		if t.URL != "" {
+			if u, err := url.Parse(t.URL); err != nil || u.Host == "" {
+				fail("tools[%d]: invalid url %q", i, t.URL)
+			}
+		}
+	}
+
+	drives := map[string]bool{}
+
+	for i, d := range cfg.Drives {
+		if d.ID == "" {
+			fail("drives[%d]: id is required", i)
+		} else if drives[d.ID] {
+			fail("drives[%d]: duplicate id %q", i, d.ID)
+		}
+
+		drives[d.ID] = true
+
+		switch d.Type {
+		case "", "local":
+			if d.Path == "" {
+				fail("drives[%d]: path is required for local drives", i)
+			}
+		case "onedrive":
+		case "sharepoint":
+			if d.URL == "" {
+				fail("drives[%d]: url is required for sharepoint drives", i)
+			}
+		default:
+			fail("drives[%d]: unknown type %q", i, d.Type)
+		}
+	}
+
+	databases := map[string]bool{}
+
+	for i, d := range cfg.Databases {
+		if d.ID == "" {
+			fail("databases[%d]: id is required", i)
+		} else if databases[d.ID] {
+			fail("databases[%d]: duplicate id %q", i, d.ID)
+		}
+
+		databases[d.ID] = true
+
+		if d.DSN == "" {
+			fail("databases[%d]: dsn is required", i)
+		}
+
+		if d.Timeout != "" {
+			if _, err := time.ParseDuration(d.Timeout); err != nil {
+				fail("databases[%d]: invalid timeout %q", i, d.Timeout)
+			}
+		}
+	}
+
+	flags := map[string]bool{}
+
+	for i, f := range cfg.Flags {
+		if f.Name == "" {
+			fail("flags[%d]: name is required", i)
+		} else if flags[f.Name] {
+			fail("flags[%d]: duplicate name %q", i, f.Name)
+		}
+
+		flags[f.Name] = true
+
+		if f.Percentage != nil && (*f.Percentage < 0 || *f.Percentage > 100) {
+			fail("flags[%d]: percentage must be between 0 and 100", i)
+		}
+	}
+
+	for i, r := range cfg.Roles {
+		if r.Name == "" {
+			fail("roles[%d]: name is required", i)
+		}
+
+		for _, f := range r.Features {
+			if f != "*" && !slices.Contains(Features, f) {
+				fail("roles[%d]: unknown feature %q", i, f)
+			}
+		}
+	}
+
+	if n := cfg.Network; n != nil {
+		check := func(name string, list []string) {
+			for i, s := range list {
+				if _, err := ParsePrefix(s); err != nil {
+					fail("network.%s[%d]: invalid network %q", name, i, s)
+				}
+			}
+		}
+
+		check("allow", n.Allow)
+		check("deny", n.Deny)
+		check("trustedProxies", n.TrustedProxies)
+
+		if n.API != nil {
+			check("api.allow", n.API.Allow)
+			check("api.deny", n.API.Deny)
+		}
+	}
+
+	return errs
+}
+
+// ParsePrefix parses a CIDR or a single address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
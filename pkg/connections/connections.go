@@ -0,0 +1,389 @@
+// Package connections keeps the OAuth tokens of accounts users link (Google,
+// Microsoft, Atlassian), so built-in tools can act on their behalf.
+package connections
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/keys"
+)
+
+var ErrNotConnected = errors.New("account not connected")
+
+// Provider is an OAuth 2.0 authorization server users can connect to.
+type Provider struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	AuthURL  string `json:"-"`
+	TokenURL string `json:"-"`
+
+	ClientID     string `json:"-"`
+	ClientSecret string `json:"-"`
+
+	Scopes string `json:"-"`
+
+	// Params are added to the authorization request, e.g. to ask for a
+	// refresh token.
+	Params url.Values `json:"-"`
+}
+
+// Google, Microsoft and Atlassian return the well-known providers with their
+// default scopes; empty scopes keep the defaults.
+func Google(clientID, clientSecret, scopes string) *Provider {
+	return &Provider{
+		ID:   "google",
+		Name: "Google",
+
+		AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL: "https://oauth2.googleapis.com/token",
+
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+
+		Scopes: withDefault(scopes, "openid email"),
+		Params: url.Values{"access_type": {"offline"}, "prompt": {"consent"}},
+	}
+}
+
+// Microsoft uses the "common" tenant unless one is given.
+func Microsoft(tenant, clientID, clientSecret, scopes string) *Provider {
+	tenant = withDefault(tenant, "common")
+
+	return &Provider{
+		ID:   "microsoft",
+		Name: "Microsoft",
+
+		AuthURL:  "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/authorize",
+		TokenURL: "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/token",
+
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+
+		Scopes: withDefault(scopes, "openid email offline_access User.Read"),
+	}
+}
+
+func Atlassian(clientID, clientSecret, scopes string) *Provider {
+	return &Provider{
+		ID:   "atlassian",
+		Name: "Atlassian",
+
+		AuthURL:  "https://auth.atlassian.com/authorize",
+		TokenURL: "https://auth.atlassian.com/oauth/token",
+
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+
+		Scopes: withDefault(scopes, "read:me read:jira-work write:jira-work offline_access"),
+		Params: url.Values{"audience": {"api.atlassian.com"}, "prompt": {"consent"}},
+	}
+}
+
+// Token is a linked account's credential.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+
+	// Account names the linked account (usually its email), when known.
+	Account string `json:"account,omitempty"`
+
+	Connected time.Time `json:"connected"`
+}
+
+// Manager stores tokens per user and provider, encrypted with the keys
+// store, and refreshes them when they expire.
+type Manager struct {
+	client *http.Client
+
+	providers []*Provider
+	store     *keys.Store
+
+	mu sync.Mutex
+}
+
+// FromEnv returns the manager of the providers configured with
+// GOOGLE_CLIENT_ID, MICROSOFT_CLIENT_ID and ATLASSIAN_CLIENT_ID, keeping
+// tokens in CONNECTIONS_PATH (default connections) sealed with
+// CONNECTIONS_SECRET, or nil when there are none.
+func FromEnv() (*Manager, error) {
+	var providers []*Provider
+
+	if id := os.Getenv("GOOGLE_CLIENT_ID"); id != "" {
+		providers = append(providers, Google(id, os.Getenv("GOOGLE_CLIENT_SECRET"), os.Getenv("GOOGLE_SCOPES")))
+	}
+
+	if id := os.Getenv("MICROSOFT_CLIENT_ID"); id != "" {
+		providers = append(providers, Microsoft(os.Getenv("MICROSOFT_TENANT_ID"), id, os.Getenv("MICROSOFT_CLIENT_SECRET"), os.Getenv("MICROSOFT_SCOPES")))
+	}
+
+	if id := os.Getenv("ATLASSIAN_CLIENT_ID"); id != "" {
+		providers = append(providers, Atlassian(id, os.Getenv("ATLASSIAN_CLIENT_SECRET"), os.Getenv("ATLASSIAN_SCOPES")))
+	}
+
+	if len(providers) == 0 {
+		return nil, nil
+	}
+
+	return NewManager(env.String("CONNECTIONS_PATH", "connections"), os.Getenv("CONNECTIONS_SECRET"), providers...)
+}
+
+func NewManager(dir, secret string, providers ...*Provider) (*Manager, error) {
+	store, err := keys.NewStore(dir, secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		client: http.DefaultClient,
+
+		providers: providers,
+		store:     store,
+	}, nil
+}
+
+func (m *Manager) Providers() []*Provider {
+	return m.providers
+}
+
+func (m *Manager) Provider(id string) (*Provider, bool) {
+	for _, p := range m.providers {
+		if p.ID == id {
+			return p, true
+		}
+	}
+
+	return nil, false
+}
+
+// Has reports whether provider is configured.
+func (m *Manager) Has(provider string) bool {
+	if m == nil {
+		return false
+	}
+
+	_, ok := m.Provider(provider)
+	return ok
+}
+
+// Get returns the stored token of user for provider.
+func (m *Manager) Get(user, provider string) (*Token, error) {
+	data, _, err := m.store.Get(storeKey(user, provider))
+
+	if errors.Is(err, keys.ErrNotFound) {
+		return nil, ErrNotConnected
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (m *Manager) Set(user, provider string, token *Token) error {
+	data, _ := json.Marshal(token)
+	return m.store.Set(storeKey(user, provider), string(data))
+}
+
+func (m *Manager) Delete(user, provider string) error {
+	return m.store.Delete(storeKey(user, provider))
+}
+
+// AccessToken returns a valid access token of the authenticated user in ctx
+// for provider, refreshing it if it is about to expire. Guests have none.
+func (m *Manager) AccessToken(ctx context.Context, provider string) (string, error) {
+	if m == nil {
+		return "", ErrNotConnected
+	}
+
+	user := auth.Authenticated(ctx)
+
+	if user == nil {
+		return "", ErrNotConnected
+	}
+
+	p, ok := m.Provider(provider)
+
+	if !ok {
+		return "", ErrNotConnected
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, err := m.Get(user.ID, provider)
+
+	if err != nil {
+		return "", err
+	}
+
+	if token.Expiry.IsZero() || time.Until(token.Expiry) > time.Minute {
+		return token.AccessToken, nil
+	}
+
+	if token.RefreshToken == "" {
+		return "", errors.New(p.Name + " connection expired, please reconnect")
+	}
+
+	refreshed, err := m.request(ctx, p, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
+
+	if err != nil {
+		return "", errors.New(p.Name + " connection expired, please reconnect: " + err.Error())
+	}
+
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+
+	refreshed.Account = token.Account
+	refreshed.Connected = token.Connected
+
+	if err := m.Set(user.ID, provider, refreshed); err != nil {
+		return "", err
+	}
+
+	return refreshed.AccessToken, nil
+}
+
+// Exchange redeems an authorization code.
+func (m *Manager) Exchange(ctx context.Context, p *Provider, code, redirectURI, verifier string) (*Token, error) {
+	token, err := m.request(ctx, p, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	token.Connected = time.Now().UTC()
+
+	return token, nil
+}
+
+func (m *Manager) request(ctx context.Context, p *Provider, data url.Values) (*Token, error) {
+	data.Set("client_id", p.ClientID)
+
+	if p.ClientSecret != "" {
+		data.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(data.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("token request failed (" + resp.Status + "): " + strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		IDToken      string `json:"id_token"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	if result.AccessToken == "" {
+		return nil, errors.New("token request returned no access_token")
+	}
+
+	token := &Token{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		Account:      accountFromIDToken(result.IDToken),
+	}
+
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second).UTC()
+	}
+
+	return token, nil
+}
+
+// accountFromIDToken reads the email of an ID token, if any. It only labels
+// the connection, and the token came straight from the token endpoint, so
+// the signature isn't checked.
+func accountFromIDToken(token string) string {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return ""
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+
+	json.Unmarshal(data, &claims)
+
+	return withDefault(claims.Email, claims.PreferredUsername)
+}
+
+// storeKey binds the token to user and provider.
+func storeKey(user, provider string) string {
+	return provider + "\x00" + user
+}
+
+func withDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,126 @@
+// Package cors lets other web apps call the API proxy from the browser.
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// maxAge is how long browsers may cache a preflight response, in seconds.
+const maxAge = "600"
+
+type Policy struct {
+	origins     []string
+	credentials bool
+}
+
+// FromEnv returns the policy of CORS_ALLOWED_ORIGINS, with
+// CORS_ALLOW_CREDENTIALS=true allowing credentials, or nil when no origin is
+// set.
+func FromEnv() (*Policy, error) {
+	origins := env.List("CORS_ALLOWED_ORIGINS")
+
+	if len(origins) == 0 {
+		return nil, nil
+	}
+
+	return New(origins, env.Bool("CORS_ALLOW_CREDENTIALS"))
+}
+
+// New allows the given origins ("https://app.example.com"); a "*" as first
+// label matches any subdomain ("https://*.example.com") and a lone "*"
+// matches every origin. With credentials, browsers send cookies along, so
+// every origin must be listed explicitly.
+func New(origins []string, credentials bool) (*Policy, error) {
+	p := &Policy{
+		credentials: credentials,
+	}
+
+	for _, o := range origins {
+		o = strings.TrimRight(strings.ToLower(o), "/")
+
+		if o == "*" && credentials {
+			return nil, errors.New("cors: credentials can't be allowed for every origin")
+		}
+
+		if o != "*" && !strings.Contains(o, "://") {
+			return nil, errors.New("cors: invalid origin " + o)
+		}
+
+		p.origins = append(p.origins, o)
+	}
+
+	return p, nil
+}
+
+// Allows reports whether origin may call the API.
+func (p *Policy) Allows(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, o := range p.origins {
+		if o == "*" || o == origin {
+			return true
+		}
+
+		scheme, host, ok := strings.Cut(o, "://*.")
+
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wrap adds the CORS headers to responses below prefix for allowed origins
+// and answers their preflight requests, which browsers send without
+// credentials, before authentication.
+func (p *Policy) Wrap(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/") + "/"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		if origin == "" || !strings.HasPrefix(r.URL.Path, prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		if !p.Allows(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+
+		if p.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+
+			h.Set("Access-Control-Max-Age", maxAge)
+
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.Set("Access-Control-Expose-Headers", "Retry-After, Content-Disposition")
+
+		next.ServeHTTP(w, r)
+	})
+}
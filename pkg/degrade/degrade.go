@@ -0,0 +1,303 @@
+// Package degrade watches the services behind optional features, as
+// degradation.yaml lists them, and turns features off while their service
+// fails: the UI is told to hide them and their endpoints answer 503, so a
+// partial outage doesn't surface as scattered errors.
+package degrade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/rbac"
+)
+
+// Models lists the ids of the models the platform serves.
+type Models func(ctx context.Context) ([]string, error)
+
+type Monitor struct {
+	store  *config.Store
+	models Models
+
+	client *http.Client
+
+	mu   sync.RWMutex
+	down map[string]string
+}
+
+func New(store *config.Store, models Models) *Monitor {
+	return &Monitor{
+		store:  store,
+		models: models,
+
+		client: &http.Client{Timeout: 10 * time.Second},
+
+		down: map[string]string{},
+	}
+}
+
+// FromEnv returns the monitor with DEGRADATION_ENABLED=true, checking every
+// DEGRADATION_INTERVAL (default 30s) until ctx is done, or nil otherwise.
+func FromEnv(ctx context.Context, store *config.Store, models Models) *Monitor {
+	if !env.Bool("DEGRADATION_ENABLED") {
+		return nil
+	}
+
+	m := New(store, models)
+	m.Watch(ctx, env.Duration("DEGRADATION_INTERVAL", 30*time.Second))
+
+	return m
+}
+
+// Watch checks the features every interval until ctx is done.
+func (m *Monitor) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		for ctx.Err() == nil {
+			m.check(ctx)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+func (m *Monitor) check(ctx context.Context) {
+	cfg := m.store.Config()
+
+	down := map[string]string{}
+
+	var listed []string
+	var listErr error
+	var loaded bool
+
+	for _, d := range cfg.Degradation {
+		var err error
+
+		if d.URL != "" {
+			err = m.probe(ctx, d.URL)
+		} else {
+			models := cfg.FeatureModels(d.Feature)
+
+			if len(models) == 0 {
+				continue
+			}
+
+			if !loaded {
+				listed, listErr = m.models(ctx)
+				loaded = true
+			}
+
+			// Without the platform, every feature fails alike; that is
+			// the platform's outage, not the feature's.
+			if listErr != nil {
+				continue
+			}
+
+			for _, id := range models {
+				if !slices.Contains(listed, id) {
+					err = fmt.Errorf("model %q is not available", id)
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			down[d.Feature] = err.Error()
+		}
+	}
+
+	m.set(down)
+}
+
+func (m *Monitor) set(down map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for feature, reason := range down {
+		if _, ok := m.down[feature]; !ok {
+			slog.Warn("degrade: turned off", "feature", feature, "reason", reason)
+		}
+	}
+
+	for feature := range m.down {
+		if _, ok := down[feature]; !ok {
+			slog.Info("degrade: restored", "feature", feature)
+		}
+	}
+
+	m.down = down
+}
+
+func (m *Monitor) probe(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return errors.New("answered " + resp.Status)
+	}
+
+	return nil
+}
+
+// Down returns the features turned off and why, by feature.
+func (m *Monitor) Down() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := map[string]string{}
+
+	for feature, reason := range m.down {
+		result[feature] = reason
+	}
+
+	return result
+}
+
+func (m *Monitor) isDown(feature string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.down[feature]
+	return ok
+}
+
+// Check fails while a feature is turned off.
+func (m *Monitor) Check(context.Context) error {
+	down := m.Down()
+
+	if len(down) == 0 {
+		return nil
+	}
+
+	var features []string
+
+	for feature := range down {
+		features = append(features, feature)
+	}
+
+	slices.Sort(features)
+
+	return errors.New("turned off: " + strings.Join(features, ", "))
+}
+
+// Apply turns the features that are down off in cfg and lists them with
+// the message of their degradation, for the client.
+func (m *Monitor) Apply(cfg *config.Config) *config.Config {
+	if m == nil {
+		return cfg
+	}
+
+	down := m.Down()
+
+	if len(down) == 0 {
+		return cfg
+	}
+
+	c := *cfg
+	c.Degraded = nil
+
+	for _, d := range cfg.Degradation {
+		if _, ok := down[d.Feature]; !ok || !c.HasFeature(d.Feature) {
+			continue
+		}
+
+		message := d.Message
+
+		if message == "" {
+			message = d.Feature + " is temporarily unavailable"
+		}
+
+		c.WithoutFeature(d.Feature)
+		c.Degraded = append(c.Degraded, config.Outage{Feature: d.Feature, Message: message})
+	}
+
+	return &c
+}
+
+// Wrap answers the requests below prefix for endpoints of features that are
+// down with 503.
+func (m *Monitor) Wrap(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+			if feature := rbac.FeatureOf(path); feature != "" && m.isDown(feature) {
+				w.Header().Set("Retry-After", "30")
+				http.Error(w, feature+" is temporarily unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PlatformModels lists the models of the platform at url from its
+// /v1/models.
+func PlatformModels(url string, token func() string, transport http.RoundTripper) Models {
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	return func(ctx context.Context) ([]string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(url, "/")+"/v1/models", nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if t := token(); t != "" {
+			req.Header.Set("Authorization", "Bearer "+t)
+		}
+
+		resp, err := client.Do(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.New("platform answered " + resp.Status)
+		}
+
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return nil, err
+		}
+
+		var ids []string
+
+		for _, m := range list.Data {
+			ids = append(ids, m.ID)
+		}
+
+		return ids, nil
+	}
+}
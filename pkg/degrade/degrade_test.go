@@ -0,0 +1,159 @@
+package degrade
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+)
+
+// monitor watches tts through its model, translator through probe and
+// renderer, which isn't configured.
+func monitor(t *testing.T, probe string, models Models) *Monitor {
+	t.Helper()
+
+	store := config.NewStore(&config.Config{
+		TTS:        &config.TTS{Model: "tts-1"},
+		Translator: &config.Translator{Model: "gpt"},
+
+		Degradation: []config.Degradation{
+			{Feature: "tts", Message: "Read aloud is down"},
+			{Feature: "translator", URL: probe},
+			{Feature: "renderer"},
+		},
+	})
+
+	return New(store, models)
+}
+
+func listing(ids ...string) Models {
+	return func(context.Context) ([]string, error) {
+		return ids, nil
+	}
+}
+
+func TestCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer healthy.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	for _, tc := range []struct {
+		name   string
+		probe  string
+		models Models
+		down   []string
+	}{
+		{"all up", healthy.URL, listing("tts-1", "gpt"), nil},
+		{"model gone", healthy.URL, listing("gpt"), []string{"tts"}},
+		{"probe fails", failing.URL, listing("tts-1"), []string{"translator"}},
+		{"probe unreachable", "http://127.0.0.1:1", listing("tts-1"), []string{"translator"}},
+
+		// The platform's outage isn't the features'.
+		{"platform down", healthy.URL, func(context.Context) ([]string, error) { return nil, errors.New("refused") }, nil},
+	} {
+		m := monitor(t, tc.probe, tc.models)
+		m.check(context.Background())
+
+		down := m.Down()
+
+		if len(down) != len(tc.down) {
+			t.Errorf("%s: down = %v, want %v", tc.name, down, tc.down)
+			continue
+		}
+
+		for _, feature := range tc.down {
+			if _, ok := down[feature]; !ok {
+				t.Errorf("%s: %s isn't down: %v", tc.name, feature, down)
+			}
+		}
+
+		if err := m.Check(context.Background()); (err != nil) != (len(tc.down) > 0) {
+			t.Errorf("%s: check = %v", tc.name, err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	m := monitor(t, "", listing())
+	m.set(map[string]string{"tts": "model gone", "translator": "answered 502", "renderer": "answered 502"})
+
+	cfg := m.store.Config()
+	applied := m.Apply(cfg)
+
+	if applied.TTS != nil || applied.Translator != nil {
+		t.Errorf("features still on: %+v", applied)
+	}
+
+	// Features that aren't configured aren't announced as degraded.
+	want := []config.Outage{
+		{Feature: "tts", Message: "Read aloud is down"},
+		{Feature: "translator", Message: "translator is temporarily unavailable"},
+	}
+
+	if len(applied.Degraded) != len(want) || applied.Degraded[0] != want[0] || applied.Degraded[1] != want[1] {
+		t.Errorf("degraded = %+v, want %+v", applied.Degraded, want)
+	}
+
+	if cfg.TTS == nil || cfg.Degraded != nil {
+		t.Error("the stored config was changed")
+	}
+
+	var none *Monitor
+
+	if none.Apply(cfg) != cfg {
+		t.Error("a nil monitor changed the config")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	m := monitor(t, "", listing())
+	m.set(map[string]string{"tts": "model gone"})
+
+	handler := m.Wrap("/api/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for path, want := range map[string]int{
+		"/api/v1/audio/speech":     http.StatusServiceUnavailable,
+		"/api/voices/alloy":        http.StatusServiceUnavailable,
+		"/api/v1/translate":        http.StatusOK,
+		"/api/v1/chat/completions": http.StatusOK,
+		"/v1/audio/speech":         http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestPlatformModels(t *testing.T) {
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"data": [{"id": "gpt"}, {"id": "tts-1"}]}`))
+	}))
+	defer platform.Close()
+
+	ids, err := PlatformModels(platform.URL+"/", func() string { return "secret" }, nil)(context.Background())
+
+	if err != nil || len(ids) != 2 || ids[0] != "gpt" || ids[1] != "tts-1" {
+		t.Errorf("models = %v, %v", ids, err)
+	}
+
+	if _, err := PlatformModels(platform.URL, func() string { return "" }, nil)(context.Background()); err == nil {
+		t.Error("an error answer was accepted")
+	}
+}
@@ -0,0 +1,244 @@
+// Package discovery fills the model catalog from the models the platform
+// lists at /v1/models, so models.yaml needn't be maintained by hand.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// retryDelay bounds the wait after a failed listing.
+const retryDelay = 30 * time.Second
+
+type Discovery struct {
+	client *http.Client
+
+	url   *url.URL
+	token func() string
+
+	// Include and Exclude are glob patterns (path.Match) of model ids; all
+	// models are included when Include is empty.
+	Include []string
+	Exclude []string
+
+	// Overrides is a file in the format of models.yaml whose entries give
+	// discovered models their name and description.
+	Overrides string
+}
+
+// New discovers the models of the API at u, authenticated with token and
+// requested through transport.
+func New(u *url.URL, token func() string, transport http.RoundTripper) *Discovery {
+	return &Discovery{
+		client: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+
+		url:   u,
+		token: token,
+	}
+}
+
+// FromEnv discovers the models with MODELS_DISCOVERY=true: those matching
+// MODELS_DISCOVERY_INCLUDE and not MODELS_DISCOVERY_EXCLUDE, named by
+// MODELS_DISCOVERY_OVERRIDES (default models.overrides.yaml), every
+// MODELS_DISCOVERY_INTERVAL (default 5m) until ctx is done. It returns nil
+// otherwise.
+func FromEnv(ctx context.Context, store *config.Store, u *url.URL, token func() string, transport http.RoundTripper) *Discovery {
+	if !env.Bool("MODELS_DISCOVERY") {
+		return nil
+	}
+
+	d := New(u, token, transport)
+
+	d.Include = env.List("MODELS_DISCOVERY_INCLUDE")
+	d.Exclude = env.List("MODELS_DISCOVERY_EXCLUDE")
+
+	d.Overrides = env.String("MODELS_DISCOVERY_OVERRIDES", "models.overrides.yaml")
+
+	d.Watch(ctx, store, env.Duration("MODELS_DISCOVERY_INTERVAL", 5*time.Minute))
+
+	return d
+}
+
+// Models returns the ids of the models the platform lists.
+func (d *Discovery) Models(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url.JoinPath("v1", "models").String(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token := d.token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("listing models failed (" + resp.Status + ")")
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+
+	for _, m := range list.Data {
+		if m.ID != "" && d.matches(m.ID) {
+			ids = append(ids, m.ID)
+		}
+	}
+
+	slices.Sort(ids)
+
+	return slices.Compact(ids), nil
+}
+
+func (d *Discovery) matches(id string) bool {
+	match := func(patterns []string) bool {
+		return slices.ContainsFunc(patterns, func(p string) bool {
+			ok, _ := path.Match(p, id)
+			return ok
+		})
+	}
+
+	if len(d.Include) > 0 && !match(d.Include) {
+		return false
+	}
+
+	return !match(d.Exclude)
+}
+
+// Watch lists the models every interval until ctx is done and merges them
+// into store. Configured models win over discovered ones with the same id;
+// when the platform is unreachable the last list is kept and it is asked
+// again within retryDelay.
+func (d *Discovery) Watch(ctx context.Context, store *config.Store, interval time.Duration) {
+	go func() {
+		for ctx.Err() == nil {
+			wait := interval
+
+			if err := d.sync(ctx, store); err != nil && ctx.Err() == nil {
+				slog.Warn("discovery", "error", err)
+				wait = min(wait, retryDelay)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+func (d *Discovery) sync(ctx context.Context, store *config.Store) error {
+	ids, err := d.Models(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	overrides, err := d.overrides()
+
+	if err != nil {
+		// Discovered models are still served, only without their names.
+		slog.Warn("discovery: overrides", "error", err)
+	}
+
+	discovered := make([]config.Model, 0, len(ids))
+
+	for _, id := range ids {
+		m := config.Model{ID: id, Discovered: true}
+
+		if o, ok := overrides[id]; ok {
+			m.Name = o.Name
+			m.Description = o.Description
+		}
+
+		discovered = append(discovered, m)
+	}
+
+	current := store.Config()
+
+	if reflect.DeepEqual(mergeModels(current.Models, discovered), current.Models) {
+		return nil
+	}
+
+	store.Update("discovery: "+d.url.String(), func(c *config.Config) {
+		c.Models = mergeModels(c.Models, discovered)
+	})
+
+	return nil
+}
+
+// overrides reads the overrides file, if any, keyed by model id.
+func (d *Discovery) overrides() (map[string]config.Model, error) {
+	if d.Overrides == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(d.Overrides)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var models []config.Model
+
+	if err := config.Decode(data, &models); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]config.Model, len(models))
+
+	for _, m := range models {
+		result[m.ID] = m
+	}
+
+	return result, nil
+}
+
+// mergeModels replaces the discovered entries of local with discovered.
+func mergeModels(local, discovered []config.Model) []config.Model {
+	result := slices.DeleteFunc(slices.Clone(local), func(m config.Model) bool { return m.Discovered })
+
+	for _, m := range discovered {
+		if slices.ContainsFunc(result, func(l config.Model) bool { return l.ID == m.ID }) {
+			continue
+		}
+
+		result = append(result, m)
+	}
+
+	return result
+}
@@ -0,0 +1,98 @@
+// Package env reads settings from environment variables. Unset or invalid
+// values fall back to the defaults, except for sizes, whose typos would go
+// unnoticed otherwise.
+package env
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns the value of key, or fallback when it is empty.
+func String(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+// Bool reports whether key is "true".
+func Bool(key string) bool {
+	return os.Getenv(key) == "true"
+}
+
+// List splits the comma separated value of key.
+func List(key string) []string {
+	var result []string
+
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+// Int returns the integer value of key, or fallback when it is unset or no
+// integer.
+func Int(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+
+	return fallback
+}
+
+// Duration returns the positive duration value of key, or fallback.
+func Duration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+
+	return fallback
+}
+
+// Size returns the size value of key (see ParseSize), or fallback when it
+// is unset.
+func Size(key string, fallback int64) (int64, error) {
+	s := os.Getenv(key)
+
+	if s == "" {
+		return fallback, nil
+	}
+
+	n, err := ParseSize(s)
+
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return n, nil
+}
+
+// ParseSize parses a size in bytes with an optional KB, MB or GB suffix
+// (powers of 1024).
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+
+	for suffix, u := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if v, ok := strings.CutSuffix(value, suffix); ok {
+			value, unit = strings.TrimSpace(v), u
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n * unit, nil
+}
@@ -0,0 +1,56 @@
+package env
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"0":      0,
+		"512":    512,
+		"64KB":   64 << 10,
+		"32 mb":  32 << 20,
+		" 1GB ":  1 << 30,
+		"100 MB": 100 << 20,
+	} {
+		if n, err := ParseSize(s); err != nil || n != want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", s, n, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "-1", "1TB", "MB", "1.5MB"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) succeeded", s)
+		}
+	}
+}
+
+func TestFallbacks(t *testing.T) {
+	t.Setenv("TEST_LIST", " a, ,b,")
+	t.Setenv("TEST_DURATION", "-1s")
+	t.Setenv("TEST_INT", "ten")
+	t.Setenv("TEST_SIZE", "lots")
+
+	if got := List("TEST_LIST"); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("List = %q", got)
+	}
+
+	if got := Duration("TEST_DURATION", time.Minute); got != time.Minute {
+		t.Errorf("Duration = %v, want the fallback", got)
+	}
+
+	if got := Int("TEST_INT", 10); got != 10 {
+		t.Errorf("Int = %d, want the fallback", got)
+	}
+
+	// A size that was meant to be set but can't be read is an error.
+	if _, err := Size("TEST_SIZE", 1); err == nil {
+		t.Error("an invalid size was accepted")
+	}
+
+	if n, err := Size("TEST_UNSET", 1); err != nil || n != 1 {
+		t.Errorf("Size = %d, %v, want the fallback", n, err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package federation takes over the model and tool catalogs of a peer
+// instance (e.g. a central gateway), so satellite deployments can offer them
+// without configuring them again. Requests for federated entries are proxied
+// to the peer.
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Peer is another wingman-chat instance.
+type Peer struct {
+	client *http.Client
+
+	// URL is the peer's API base, e.g. https://chat.example.com/api.
+	URL *url.URL
+
+	// Token authenticates this instance at the peer (an API key or JWT).
+	Token string
+}
+
+func NewPeer(rawURL, token string) (*Peer, error) {
+	u, err := url.Parse(strings.TrimRight(rawURL, "/"))
+
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("federation: invalid peer url " + rawURL)
+	}
+
+	return &Peer{
+		client: http.DefaultClient,
+
+		URL:   u,
+		Token: token,
+	}, nil
+}
+
+// FromEnv returns the peer at FEDERATION_URL, authenticated with
+// FEDERATION_TOKEN, and follows its catalog every FEDERATION_INTERVAL
+// (default 1m) until ctx is done, or returns nil when it is not set.
+func FromEnv(ctx context.Context, store *config.Store) (*Peer, error) {
+	u := os.Getenv("FEDERATION_URL")
+
+	if u == "" {
+		return nil, nil
+	}
+
+	p, err := NewPeer(u, os.Getenv("FEDERATION_TOKEN"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.Watch(ctx, store, env.Duration("FEDERATION_INTERVAL", time.Minute))
+
+	return p, nil
+}
+
+// Catalog is what a peer offers at <prefix>/catalog.
+type Catalog struct {
+	Models []config.Model `json:"models"`
+	Tools  []config.Tool  `json:"tools"`
+}
+
+func (p *Peer) Catalog(ctx context.Context) (*Catalog, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL.String()+"/catalog", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("fetching catalog failed (" + resp.Status + ")")
+	}
+
+	var catalog Catalog
+
+	if err := json.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		return nil, err
+	}
+
+	return &catalog, nil
+}
+
+// Watch fetches the peer's catalog every interval until ctx is done and
+// merges it into store. Local models and tools win over federated ones with
+// the same id; when the peer is unreachable the last catalog is kept.
+func (p *Peer) Watch(ctx context.Context, store *config.Store, interval time.Duration) {
+	go func() {
+		for ctx.Err() == nil {
+			if err := p.sync(ctx, store); err != nil && ctx.Err() == nil {
+				slog.Warn("federation", "peer", p.URL.String(), "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+func (p *Peer) sync(ctx context.Context, store *config.Store) error {
+	catalog, err := p.Catalog(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	current := store.Config()
+
+	models := mergeModels(current.Models, catalog.Models)
+	tools := mergeTools(current.Tools, catalog.Tools)
+
+	if reflect.DeepEqual(models, current.Models) && reflect.DeepEqual(tools, current.Tools) {
+		return nil
+	}
+
+	store.Update("federation: "+p.URL.String(), func(c *config.Config) {
+		c.Models = mergeModels(c.Models, catalog.Models)
+		c.Tools = mergeTools(c.Tools, catalog.Tools)
+	})
+
+	return nil
+}
+
+// mergeModels replaces the federated entries of local with remote.
+func mergeModels(local, remote []config.Model) []config.Model {
+	result := slices.DeleteFunc(slices.Clone(local), func(m config.Model) bool { return m.Federated })
+
+	for _, m := range remote {
+		if slices.ContainsFunc(result, func(l config.Model) bool { return l.ID == m.ID }) {
+			continue
+		}
+
+		m.Federated = true
+		result = append(result, m)
+	}
+
+	return result
+}
+
+func mergeTools(local, remote []config.Tool) []config.Tool {
+	result := slices.DeleteFunc(slices.Clone(local), func(t config.Tool) bool { return t.Federated })
+
+	for _, t := range remote {
+		if slices.ContainsFunc(result, func(l config.Tool) bool { return l.ID == t.ID }) {
+			continue
+		}
+
+		t.Federated = true
+		result = append(result, t)
+	}
+
+	return result
+}
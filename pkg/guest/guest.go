@@ -0,0 +1,366 @@
+// Package guest lets signed-in users mint time-limited links that grant
+// people without an account a single model, and optionally a tool, with a
+// tight quota of requests. Guests keep no history.
+package guest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// tokenPrefix marks the secrets of guest links.
+const tokenPrefix = "wg_"
+
+const cookieName = "wingman_guest"
+
+var (
+	ErrNotFound = errors.New("guest link not found")
+	ErrQuota    = errors.New("guest link quota used up")
+)
+
+// Link describes a guest link. Only a hash of the secret is kept.
+type Link struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+
+	// Owner is the id of the user who minted the link.
+	Owner string `json:"owner"`
+
+	Model string `json:"model"`
+	Tool  string `json:"tool,omitempty"`
+
+	// Requests is the number of model requests the link allows in all.
+	Requests int `json:"requests"`
+	Used     int `json:"used"`
+
+	Hash string `json:"hash,omitempty"`
+
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+func (l *Link) Expired() bool {
+	return time.Now().After(l.Expires)
+}
+
+// Store keeps links in a JSON file (or only in memory when path is empty).
+type Store struct {
+	path string
+
+	// MaxTTL and MaxRequests bound the links users mint.
+	MaxTTL      time.Duration
+	MaxRequests int
+
+	mu    sync.Mutex
+	links []*Link
+}
+
+// FromEnv returns the store in GUEST_LINKS_PATH (default guests.json)
+// with GUEST_LINKS=true, or nil otherwise. GUEST_LINKS_MAX_TTL and
+// GUEST_LINKS_MAX_REQUESTS lower or raise the bounds of the links.
+func FromEnv() (*Store, error) {
+	if !env.Bool("GUEST_LINKS") {
+		return nil, nil
+	}
+
+	s, err := NewStore(env.String("GUEST_LINKS_PATH", "guests.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.MaxTTL = env.Duration("GUEST_LINKS_MAX_TTL", s.MaxTTL)
+
+	if n := env.Int("GUEST_LINKS_MAX_REQUESTS", 0); n > 0 {
+		s.MaxRequests = n
+	}
+
+	return s, nil
+}
+
+// NewStore allows links of up to a week and 100 requests.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+
+		MaxTTL:      7 * 24 * time.Hour,
+		MaxRequests: 100,
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.links); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Create mints a link and returns its secret, which is not retrievable
+// later. Expired links are dropped on the way.
+func (s *Store) Create(l Link) (string, *Link, error) {
+	secret := tokenPrefix + randomString(32)
+
+	l.ID = randomString(8)
+	l.Hash = hashSecret(secret)
+	l.Used = 0
+	l.Created = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links := s.links
+
+	s.links = slices.DeleteFunc(slices.Clone(links), func(l *Link) bool { return l.Expired() })
+	s.links = append(s.links, &l)
+
+	if err := s.save(); err != nil {
+		s.links = links
+		return "", nil, err
+	}
+
+	return secret, public(&l), nil
+}
+
+// List returns the unexpired links of owner, of everyone when owner is
+// empty, without their hashes.
+func (s *Store) List(owner string) []*Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []*Link{}
+
+	for _, l := range s.links {
+		if (owner == "" || l.Owner == owner) && !l.Expired() {
+			result = append(result, public(l))
+		}
+	}
+
+	return result
+}
+
+// Revoke removes the link id of owner, of anyone when owner is empty.
+func (s *Store) Revoke(owner, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.links, func(l *Link) bool { return l.ID == id && (owner == "" || l.Owner == owner) })
+
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	links := s.links
+	s.links = slices.Delete(slices.Clone(links), i, i+1)
+
+	if err := s.save(); err != nil {
+		s.links = links
+		return err
+	}
+
+	return nil
+}
+
+// Lookup returns the unexpired link for secret.
+func (s *Store) Lookup(secret string) *Link {
+	hash := hashSecret(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range s.links {
+		if subtle.ConstantTimeCompare([]byte(l.Hash), []byte(hash)) == 1 && !l.Expired() {
+			return public(l)
+		}
+	}
+
+	return nil
+}
+
+// Use counts a request against the quota of link id.
+func (s *Store) Use(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.links, func(l *Link) bool { return l.ID == id })
+
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	l := s.links[i]
+
+	if l.Used >= l.Requests {
+		return ErrQuota
+	}
+
+	l.Used++
+
+	if err := s.save(); err != nil {
+		l.Used--
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.links, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+type contextKey int
+
+const linkKey contextKey = iota
+
+func WithLink(ctx context.Context, l *Link) context.Context {
+	return context.WithValue(ctx, linkKey, l)
+}
+
+// FromContext returns the guest link the request was made with, or nil.
+func FromContext(ctx context.Context) *Link {
+	l, _ := ctx.Value(linkKey).(*Link)
+	return l
+}
+
+// Identify redeems links at GET /guest/{secret}, setting a cookie and
+// redirecting to the app, and identifies requests with that cookie as the
+// user "guest:<id>". Below prefix, guests only reach the proxy (/v1/), where
+// each POST counts against the quota of their link.
+func (s *Store) Identify(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret, ok := strings.CutPrefix(r.URL.Path, "/guest/"); ok && r.Method == http.MethodGet {
+			s.redeem(w, r, secret)
+			return
+		}
+
+		c, err := r.Cookie(cookieName)
+
+		if err != nil || !strings.HasPrefix(c.Value, tokenPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		l := s.Lookup(c.Value)
+
+		if l == nil {
+			http.SetCookie(w, &http.Cookie{Name: cookieName, Path: "/", MaxAge: -1})
+			http.Error(w, "the guest link has expired", http.StatusUnauthorized)
+			return
+		}
+
+		if path, ok := strings.CutPrefix(r.URL.Path, prefix+"/"); ok {
+			if !strings.HasPrefix(path, "v1/") {
+				http.Error(w, "not available to guests", http.StatusForbidden)
+				return
+			}
+
+			if r.Method == http.MethodPost {
+				if err := s.Use(l.ID); errors.Is(err, ErrQuota) {
+					http.Error(w, "the quota of the guest link is used up", http.StatusTooManyRequests)
+					return
+				} else if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		// Guests act with the link only.
+		r.Header.Del("Authorization")
+
+		ctx := WithLink(r.Context(), l)
+		ctx = auth.WithUser(ctx, &auth.User{ID: "guest:" + l.ID, Name: l.Name}, auth.SourceGuest)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Store) redeem(w http.ResponseWriter, r *http.Request, secret string) {
+	l := s.Lookup(secret)
+
+	if l == nil {
+		http.Error(w, "the guest link is invalid or has expired", http.StatusNotFound)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:  cookieName,
+		Value: secret,
+		Path:  "/",
+
+		Expires: l.Expires,
+
+		Secure:   r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func public(l *Link) *Link {
+	c := *l
+	c.Hash = ""
+
+	return &c
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,244 @@
+package injection
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maxScreened bounds the responses that are screened; larger ones are
+// passed on as they are.
+const maxScreened = 8 << 20
+
+// screenedPaths are the endpoints (below the prefix) whose responses end up
+// in prompts: scraping, search, research and MCP tools.
+var screenedPaths = []string{
+	"/v1/extract",
+	"/v1/search",
+	"/v1/research",
+	"/v1/mcp",
+}
+
+// Wrap screens the responses of the endpoints below prefix that fetch
+// content for the models.
+func (s *Screener) Wrap(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, prefix)
+
+		if !ok || !slices.ContainsFunc(screenedPaths, func(p string) bool { return path == p || strings.HasPrefix(path, p+"/") }) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Compressed responses couldn't be read.
+		r.Header.Del("Accept-Encoding")
+
+		sw := &screenWriter{ResponseWriter: w, screener: s, request: r}
+		defer sw.finish()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// screenJSON screens the strings in data, returning it unchanged when
+// nothing is removed.
+func (s *Screener) screenJSON(r *http.Request, data []byte) []byte {
+	var v any
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+
+	var changed bool
+
+	v = walk(v, func(text string) string {
+		text, found := s.Screen(r, text)
+		changed = changed || found
+
+		return text
+	})
+
+	if !changed {
+		return data
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return data
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+func walk(v any, f func(string) string) any {
+	switch v := v.(type) {
+	case string:
+		return f(v)
+	case []any:
+		for i := range v {
+			v[i] = walk(v[i], f)
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = walk(v[k], f)
+		}
+	}
+
+	return v
+}
+
+const (
+	modePass = iota + 1
+	modeBuffer
+	modeEvents
+)
+
+// screenWriter holds JSON and text responses back until they are complete,
+// and screens event streams line by line.
+type screenWriter struct {
+	http.ResponseWriter
+
+	screener *Screener
+	request  *http.Request
+
+	mode   int
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *screenWriter) WriteHeader(status int) {
+	if w.mode != 0 {
+		return
+	}
+
+	w.status = status
+
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+
+	switch {
+	case status < 200 || status >= 300 || w.Header().Get("Content-Encoding") != "":
+		w.mode = modePass
+	case mediaType == "text/event-stream":
+		w.mode = modeEvents
+	case mediaType == "application/json" || strings.HasPrefix(mediaType, "text/"):
+		w.mode = modeBuffer
+	default:
+		w.mode = modePass
+	}
+
+	if w.mode == modeBuffer {
+		return
+	}
+
+	if w.mode == modeEvents {
+		w.Header().Del("Content-Length")
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *screenWriter) Write(p []byte) (int, error) {
+	if w.mode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	switch w.mode {
+	case modeBuffer:
+		w.buf.Write(p)
+
+		if w.buf.Len() > maxScreened {
+			slog.Warn("injection: response too large to screen", "path", w.request.URL.Path)
+
+			w.mode = modePass
+			w.ResponseWriter.WriteHeader(w.status)
+
+			_, err := w.ResponseWriter.Write(w.buf.Bytes())
+			w.buf.Reset()
+
+			return len(p), err
+		}
+
+		return len(p), nil
+
+	case modeEvents:
+		w.buf.Write(p)
+
+		for {
+			line, err := w.buf.ReadBytes('\n')
+
+			if err != nil {
+				// Keep the partial line for the next write.
+				rest := slices.Clone(line)
+				w.buf.Reset()
+				w.buf.Write(rest)
+
+				return len(p), nil
+			}
+
+			if _, err := w.ResponseWriter.Write(w.screenEvent(line)); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+// screenEvent screens the data line of an event stream.
+func (w *screenWriter) screenEvent(line []byte) []byte {
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+
+	if !ok {
+		return line
+	}
+
+	data = bytes.TrimSpace(data)
+
+	screened := w.screener.screenJSON(w.request, data)
+
+	if bytes.Equal(screened, data) {
+		return line
+	}
+
+	return append(append([]byte("data: "), screened...), '\n')
+}
+
+func (w *screenWriter) Flush() {
+	if w.mode == modeBuffer {
+		return
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *screenWriter) finish() {
+	switch w.mode {
+	case modeEvents:
+		w.ResponseWriter.Write(w.screenEvent(w.buf.Bytes()))
+
+	case modeBuffer:
+		data := w.buf.Bytes()
+
+		if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediaType == "application/json" {
+			data = w.screener.screenJSON(w.request, data)
+		} else if text, changed := w.screener.Screen(w.request, string(data)); changed {
+			data = []byte(text)
+		}
+
+		w.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(data)
+	}
+}
@@ -0,0 +1,193 @@
+// Package injection screens content fetched for the models, scraped pages,
+// search results and tool results, for instructions planted to hijack them
+// (indirect prompt injection). Suspicious lines are reported as security
+// events and, unless the policy says to only warn, replaced with a notice
+// before the content reaches the client, which passes it to the model.
+package injection
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/moderation"
+	"github.com/adrianliechti/wingman-chat/pkg/security"
+)
+
+// Notice replaces suspicious instructions.
+const Notice = "[removed: suspected prompt injection]"
+
+// defaultPatterns match common injection phrasings; they are matched case
+// insensitively within a line.
+var defaultPatterns = []string{
+	`(ignore|disregard|forget|override)\s+(all\s+|any\s+|the\s+|your\s+)*(previous|prior|above|earlier|preceding|original|system)\s+(instructions|prompts?|messages|rules|guidelines|directions)`,
+	`(new|updated|real|actual)\s+(system\s+)?instructions\s*:`,
+	`(reveal|print|repeat|output|show)\s+(me\s+)?(your|the)\s+(system\s+prompt|hidden\s+prompt|initial\s+instructions)`,
+	`do\s+not\s+(tell|inform|alert|show)\s+the\s+user`,
+	`\b(developer|god|jailbreak|dan)\s+mode\b`,
+	`<\|?(im_start|im_end|endoftext|system)\|?>`,
+	`\[/?(system|inst)\]`,
+	`</?(system|instructions?)>`,
+}
+
+// maxClassified bounds the paragraphs sent to the classifier at once.
+const maxClassified = 100
+
+// Category is the security event category of pattern matches; the
+// classifier's categories are reported as they are.
+const Category = "prompt_injection"
+
+// defaultAction applies without a policy for injections.
+var defaultAction = security.Action{Block: true}
+
+type Screener struct {
+	patterns   []*regexp.Regexp
+	classifier *moderation.Moderator
+
+	monitor *security.Monitor
+}
+
+// FromEnv returns the screener with INJECTION_SCREENING=true, or nil
+// otherwise. It adds the patterns in INJECTION_PATTERNS, a file of one
+// regular expression a line, and classifies with INJECTION_CLASSIFIER_MODEL
+// at INJECTION_CLASSIFIER_URL, authenticated with
+// INJECTION_CLASSIFIER_TOKEN, when set.
+func FromEnv(monitor *security.Monitor) (*Screener, error) {
+	if !env.Bool("INJECTION_SCREENING") {
+		return nil, nil
+	}
+
+	var patterns []string
+
+	if path := os.Getenv("INJECTION_PATTERNS"); path != "" {
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+
+	var classifier *moderation.Moderator
+
+	if u := os.Getenv("INJECTION_CLASSIFIER_URL"); u != "" {
+		token := os.Getenv("INJECTION_CLASSIFIER_TOKEN")
+
+		c, err := moderation.New(u, func() string { return token }, os.Getenv("INJECTION_CLASSIFIER_MODEL"), moderation.Log, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		classifier = c
+	}
+
+	return New(patterns, classifier, monitor)
+}
+
+// New screens with the default patterns and the given ones, and, when set,
+// a classifier (an OpenAI compatible moderations endpoint flagging
+// injections, e.g. a prompt guard model) for each paragraph.
+func New(patterns []string, classifier *moderation.Moderator, monitor *security.Monitor) (*Screener, error) {
+	s := &Screener{
+		classifier: classifier,
+		monitor:    monitor,
+	}
+
+	for _, p := range append(defaultPatterns, patterns...) {
+		re, err := regexp.Compile("(?i)" + p)
+
+		if err != nil {
+			return nil, fmt.Errorf("injection: pattern %q: %w", p, err)
+		}
+
+		s.patterns = append(s.patterns, re)
+	}
+
+	return s, nil
+}
+
+// Screen returns the text fetched for r with suspicious lines replaced by
+// the notice, and whether any were.
+func (s *Screener) Screen(r *http.Request, text string) (string, bool) {
+	var changed bool
+
+	lines := strings.Split(text, "\n")
+
+	for i, line := range lines {
+		for _, re := range s.patterns {
+			if !re.MatchString(line) {
+				continue
+			}
+
+			if s.monitor.Report(r, security.Injection, []string{Category}, line, defaultAction).Block {
+				lines[i] = Notice
+				changed = true
+			}
+
+			break
+		}
+	}
+
+	text = strings.Join(lines, "\n")
+
+	if s.classifier != nil {
+		var found bool
+		text, found = s.classify(r, text)
+
+		changed = changed || found
+	}
+
+	return text, changed
+}
+
+// classify replaces the paragraphs the classifier flags.
+func (s *Screener) classify(r *http.Request, text string) (string, bool) {
+	paragraphs := strings.Split(text, "\n\n")
+
+	var changed bool
+
+	for start := 0; start < len(paragraphs); start += maxClassified {
+		batch := paragraphs[start:min(start+maxClassified, len(paragraphs))]
+
+		var inputs []moderation.Input
+		var index []int
+
+		for i, p := range batch {
+			if strings.TrimSpace(p) != "" && p != Notice {
+				inputs = append(inputs, moderation.Input{Text: p})
+				index = append(index, start+i)
+			}
+		}
+
+		results, err := s.classifier.Classify(r.Context(), inputs)
+
+		if err != nil {
+			// The patterns still apply.
+			slog.Error("injection: classifier", "error", err)
+			continue
+		}
+
+		for i, categories := range results {
+			if len(categories) == 0 {
+				continue
+			}
+
+			if s.monitor.Report(r, security.Injection, categories, paragraphs[index[i]], defaultAction).Block {
+				paragraphs[index[i]] = Notice
+				changed = true
+			}
+		}
+	}
+
+	return strings.Join(paragraphs, "\n\n"), changed
+}
@@ -0,0 +1,304 @@
+// Package integrity detects broken or tampered frontend deployments: a
+// manifest of the checksums of the built assets, written at build time, is
+// verified at startup, and the scripts and styles index.html loads can carry
+// Subresource Integrity hashes.
+package integrity
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestName is the file of the manifest, at the root of the assets.
+const ManifestName = "integrity.json"
+
+// Manifest maps the paths of the assets to their SRI hashes
+// (sha384-<base64>).
+type Manifest map[string]string
+
+// Generate hashes all files of dist but the manifest.
+func Generate(dist fs.FS) (Manifest, error) {
+	m := Manifest{}
+
+	err := fs.WalkDir(dist, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || p == ManifestName {
+			return err
+		}
+
+		hash, err := hashFile(dist, p)
+
+		if err != nil {
+			return err
+		}
+
+		m[p] = hash
+
+		return nil
+	})
+
+	return m, err
+}
+
+func hashFile(dist fs.FS, p string) (string, error) {
+	f, err := dist.Open(p)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha512.New384()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return "sha384-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// Check verifies dist against its manifest, if it has one, and that the
+// assets index.html references exist. It returns the manifest, or one
+// generated from the files as they are, for SRI hashes.
+func Check(dist fs.FS) (Manifest, []error) {
+	var errs []error
+
+	m, err := readManifest(dist)
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		m = nil
+	case err != nil:
+		errs = append(errs, err)
+	}
+
+	if m != nil {
+		paths := make([]string, 0, len(m))
+
+		for p := range m {
+			paths = append(paths, p)
+		}
+
+		slices.Sort(paths)
+
+		for _, p := range paths {
+			hash, err := hashFile(dist, p)
+
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				errs = append(errs, fmt.Errorf("%s: missing", p))
+			case err != nil:
+				errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			case hash != m[p]:
+				errs = append(errs, fmt.Errorf("%s: checksum mismatch", p))
+			}
+		}
+	}
+
+	if index, err := fs.ReadFile(dist, "index.html"); err == nil {
+		for _, p := range references(index) {
+			if _, err := fs.Stat(dist, p); err != nil {
+				errs = append(errs, fmt.Errorf("index.html: referenced %s is missing", p))
+			}
+		}
+	}
+
+	if m == nil {
+		generated, err := Generate(dist)
+
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+
+		m = generated
+	}
+
+	return m, errs
+}
+
+func readManifest(dist fs.FS) (Manifest, error) {
+	data, err := fs.ReadFile(dist, ManifestName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestName, err)
+	}
+
+	return m, nil
+}
+
+var (
+	tagPattern  = regexp.MustCompile(`(?i)<(script|link)\b[^>]*>`)
+	attrPattern = regexp.MustCompile(`(?i)\s(src|href|rel|integrity)\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+// asset is a script or style tag of index.html loading a local file.
+type asset struct {
+	start, end int
+
+	path      string
+	integrity bool
+}
+
+// assets finds the tags of index.html that SRI applies to: scripts and
+// stylesheets or preloads.
+func assets(index []byte) []asset {
+	var result []asset
+
+	for _, loc := range tagPattern.FindAllSubmatchIndex(index, -1) {
+		tag := index[loc[0]:loc[1]]
+		name := strings.ToLower(string(index[loc[2]:loc[3]]))
+
+		a := asset{start: loc[0], end: loc[1]}
+
+		var ref, rel string
+
+		for _, attr := range attrPattern.FindAllSubmatch(tag, -1) {
+			value := string(attr[2][1 : len(attr[2])-1])
+
+			switch strings.ToLower(string(attr[1])) {
+			case "src", "href":
+				ref = value
+			case "rel":
+				rel = strings.ToLower(value)
+			case "integrity":
+				a.integrity = true
+			}
+		}
+
+		if name == "link" && rel != "stylesheet" && rel != "modulepreload" && rel != "preload" {
+			continue
+		}
+
+		// Other origins are not part of the deployment.
+		if ref == "" || strings.HasPrefix(ref, "//") || strings.Contains(ref, ":") {
+			continue
+		}
+
+		ref, _, _ = strings.Cut(ref, "?")
+		ref, _, _ = strings.Cut(ref, "#")
+
+		a.path = strings.TrimPrefix(path.Clean("/"+ref), "/")
+
+		result = append(result, a)
+	}
+
+	return result
+}
+
+func references(index []byte) []string {
+	var paths []string
+
+	for _, a := range assets(index) {
+		paths = append(paths, a.path)
+	}
+
+	return paths
+}
+
+// AddSRI adds integrity attributes with the hashes of m to the tags of
+// index that load local scripts and styles and have none.
+func AddSRI(index []byte, m Manifest) []byte {
+	var out bytes.Buffer
+
+	last := 0
+
+	for _, a := range assets(index) {
+		hash, ok := m[a.path]
+
+		if !ok || a.integrity {
+			continue
+		}
+
+		tag := index[a.start:a.end]
+
+		// Insert before the end of the tag, self-closing or not.
+		cut := len(tag) - 1
+
+		if bytes.HasSuffix(tag, []byte("/>")) {
+			cut--
+		}
+
+		for cut > 0 && tag[cut-1] == ' ' {
+			cut--
+		}
+
+		out.Write(index[last : a.start+cut])
+		out.WriteString(` integrity="` + hash + `"`)
+
+		last = a.start + cut
+	}
+
+	out.Write(index[last:])
+
+	return out.Bytes()
+}
+
+// WithSRI serves dist with the SRI hashes of m added to index.html.
+func WithSRI(dist fs.FS, m Manifest) (fs.FS, error) {
+	index, err := fs.ReadFile(dist, "index.html")
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := fs.Stat(dist, "index.html")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sriFS{FS: dist, index: AddSRI(index, m), modTime: info.ModTime()}, nil
+}
+
+type sriFS struct {
+	fs.FS
+
+	index   []byte
+	modTime time.Time
+}
+
+func (f *sriFS) Open(name string) (fs.File, error) {
+	if name != "index.html" {
+		return f.FS.Open(name)
+	}
+
+	return &memFile{Reader: bytes.NewReader(f.index), info: memInfo{size: int64(len(f.index)), modTime: f.modTime}}, nil
+}
+
+// memFile is a file served from memory; it seeks for http.FileServer.
+type memFile struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return "index.html" }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return 0o444 }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return false }
+func (i memInfo) Sys() any           { return nil }
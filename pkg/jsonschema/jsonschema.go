@@ -0,0 +1,437 @@
+// Package jsonschema validates JSON values against the subset of JSON Schema
+// used to describe structured model output: types, enums and constants,
+// object properties, array items, string, number and size bounds, patterns,
+// the anyOf, oneOf, allOf and not combinators, and local $refs.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type Schema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// Compile checks schema, e.g. as decoded from YAML or JSON, and prepares it
+// for validation.
+func Compile(schema any) (*Schema, error) {
+	// A round trip through JSON turns numbers into float64 and maps into
+	// map[string]any, whatever decoder the schema came from.
+	data, err := json.Marshal(schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var root any
+
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	s := &Schema{
+		root:     root,
+		patterns: map[string]*regexp.Regexp{},
+	}
+
+	if err := s.compile(root, ""); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+var types = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+func (s *Schema) compile(node any, path string) error {
+	if _, ok := node.(bool); ok {
+		return nil
+	}
+
+	m, ok := node.(map[string]any)
+
+	if !ok {
+		return fmt.Errorf("%s: schema must be an object or a boolean", pointer(path))
+	}
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		if !slices.Contains(types, t) {
+			return fmt.Errorf("%s: unknown type %q", pointer(path), t)
+		}
+	case []any:
+		for _, v := range t {
+			if name, _ := v.(string); !slices.Contains(types, name) {
+				return fmt.Errorf("%s: unknown type %v", pointer(path), v)
+			}
+		}
+	default:
+		return fmt.Errorf("%s: type must be a string or an array", pointer(path))
+	}
+
+	if p, ok := m["pattern"]; ok {
+		expr, _ := p.(string)
+		re, err := regexp.Compile(expr)
+
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %w", pointer(path), expr, err)
+		}
+
+		s.patterns[expr] = re
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		if _, err := s.resolve(ref); err != nil {
+			return fmt.Errorf("%s: %w", pointer(path), err)
+		}
+	}
+
+	for _, key := range []string{"properties", "$defs", "definitions"} {
+		children, _ := m[key].(map[string]any)
+
+		for name, child := range children {
+			if err := s.compile(child, path+"/"+key+"/"+name); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range []string{"items", "additionalProperties", "not"} {
+		if child, ok := m[key]; ok {
+			if err := s.compile(child, path+"/"+key); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range []string{"anyOf", "oneOf", "allOf"} {
+		children, _ := m[key].([]any)
+
+		for i, child := range children {
+			if err := s.compile(child, fmt.Sprintf("%s/%s/%d", path, key, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolve looks up a reference within the schema, e.g. #/$defs/address.
+func (s *Schema) resolve(ref string) (any, error) {
+	rest, ok := strings.CutPrefix(ref, "#")
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+
+	node := s.root
+
+	for _, part := range strings.Split(strings.TrimPrefix(rest, "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+
+		m, _ := node.(map[string]any)
+
+		if node, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unresolved $ref %q", ref)
+		}
+	}
+
+	return node, nil
+}
+
+// Validate returns the violations of v, a value as decoded by
+// encoding/json, each prefixed with the JSON pointer to the offending value.
+func (s *Schema) Validate(v any) []error {
+	var errs []error
+	s.validate(s.root, v, "", &errs, 0)
+
+	return errs
+}
+
+// ValidateJSON validates a JSON document.
+func (s *Schema) ValidateJSON(data []byte) []error {
+	var v any
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return []error{fmt.Errorf("invalid JSON: %w", err)}
+	}
+
+	return s.Validate(v)
+}
+
+// maxDepth stops recursive $refs.
+const maxDepth = 64
+
+func (s *Schema) validate(node, v any, path string, errs *[]error, depth int) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, fmt.Errorf("%s: %s", pointer(path), fmt.Sprintf(format, args...)))
+	}
+
+	if depth > maxDepth {
+		fail("schema nested too deeply")
+		return
+	}
+
+	if b, ok := node.(bool); ok {
+		if !b {
+			fail("no value allowed")
+		}
+
+		return
+	}
+
+	m, _ := node.(map[string]any)
+
+	if ref, ok := m["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+
+		s.validate(target, v, path, errs, depth+1)
+	}
+
+	if t, ok := m["type"]; ok && !matchesType(t, v) {
+		fail("expected %s, got %s", typeNames(t), typeOf(v))
+		return
+	}
+
+	if enum, ok := m["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return equal(e, v) }) {
+		fail("must be one of %s", compact(enum))
+	}
+
+	if c, ok := m["const"]; ok && !equal(c, v) {
+		fail("must be %s", compact(c))
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := len([]rune(v))
+
+		if min, ok := number(m["minLength"]); ok && float64(n) < min {
+			fail("must be at least %v characters long", min)
+		}
+
+		if max, ok := number(m["maxLength"]); ok && float64(n) > max {
+			fail("must be at most %v characters long", max)
+		}
+
+		if expr, ok := m["pattern"].(string); ok && !s.patterns[expr].MatchString(v) {
+			fail("must match %q", expr)
+		}
+
+	case float64:
+		if min, ok := number(m["minimum"]); ok && v < min {
+			fail("must be at least %v", min)
+		}
+
+		if max, ok := number(m["maximum"]); ok && v > max {
+			fail("must be at most %v", max)
+		}
+
+		if min, ok := number(m["exclusiveMinimum"]); ok && v <= min {
+			fail("must be greater than %v", min)
+		}
+
+		if max, ok := number(m["exclusiveMaximum"]); ok && v >= max {
+			fail("must be less than %v", max)
+		}
+
+	case []any:
+		if min, ok := number(m["minItems"]); ok && float64(len(v)) < min {
+			fail("must have at least %v items", min)
+		}
+
+		if max, ok := number(m["maxItems"]); ok && float64(len(v)) > max {
+			fail("must have at most %v items", max)
+		}
+
+		if items, ok := m["items"]; ok {
+			for i, item := range v {
+				s.validate(items, item, fmt.Sprintf("%s/%d", path, i), errs, depth+1)
+			}
+		}
+
+	case map[string]any:
+		for _, name := range stringList(m["required"]) {
+			if _, ok := v[name]; !ok {
+				fail("missing property %q", name)
+			}
+		}
+
+		properties, _ := m["properties"].(map[string]any)
+		additional, restricted := m["additionalProperties"]
+
+		names := make([]string, 0, len(v))
+
+		for name := range v {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+
+		for _, name := range names {
+			child := path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+
+			if p, ok := properties[name]; ok {
+				s.validate(p, v[name], child, errs, depth+1)
+			} else if restricted {
+				if b, ok := additional.(bool); ok && !b {
+					fail("unexpected property %q", name)
+				} else {
+					s.validate(additional, v[name], child, errs, depth+1)
+				}
+			}
+		}
+	}
+
+	if all, ok := m["allOf"].([]any); ok {
+		for _, sub := range all {
+			s.validate(sub, v, path, errs, depth+1)
+		}
+	}
+
+	if anyOf, ok := m["anyOf"].([]any); ok {
+		if s.count(anyOf, v, path, depth) == 0 {
+			fail("must match at least one schema of anyOf")
+		}
+	}
+
+	if oneOf, ok := m["oneOf"].([]any); ok {
+		if n := s.count(oneOf, v, path, depth); n != 1 {
+			fail("must match exactly one schema of oneOf, matches %d", n)
+		}
+	}
+
+	if not, ok := m["not"]; ok {
+		var sub []error
+
+		if s.validate(not, v, path, &sub, depth+1); len(sub) == 0 {
+			fail("must not match the schema of not")
+		}
+	}
+}
+
+// count returns how many of the schemas v matches.
+func (s *Schema) count(schemas []any, v any, path string, depth int) int {
+	n := 0
+
+	for _, sub := range schemas {
+		var errs []error
+
+		if s.validate(sub, v, path, &errs, depth+1); len(errs) == 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+func matchesType(t, v any) bool {
+	switch t := t.(type) {
+	case string:
+		return isType(t, v)
+	case []any:
+		return slices.ContainsFunc(t, func(name any) bool {
+			s, _ := name.(string)
+			return isType(s, v)
+		})
+	}
+
+	return true
+}
+
+func isType(name string, v any) bool {
+	switch name {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	default:
+		return typeOf(v) == name
+	}
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+func typeNames(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+
+		return strings.Join(names, " or ")
+	}
+
+	return fmt.Sprint(t)
+}
+
+func number(v any) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func stringList(v any) []string {
+	list, _ := v.([]any)
+	result := make([]string, 0, len(list))
+
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+func equal(a, b any) bool {
+	x, err1 := json.Marshal(a)
+	y, err2 := json.Marshal(b)
+
+	return err1 == nil && err2 == nil && string(x) == string(y)
+}
+
+func compact(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	return path
+}
@@ -0,0 +1,164 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// person is a schema of the kind models are asked to answer in.
+const person = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 10},
+		"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+		"email": {"type": ["string", "null"], "pattern": "^[^@]+@[^@]+$"},
+		"role": {"enum": ["admin", "user"]},
+		"kind": {"const": "person"},
+		"tags": {"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 2},
+		"address": {"$ref": "#/$defs/address"},
+		"a/b": {"type": "boolean"}
+	},
+	"$defs": {
+		"address": {
+			"type": "object",
+			"required": ["city"],
+			"properties": {"city": {"type": "string"}},
+			"additionalProperties": {"type": "string"}
+		}
+	}
+}`
+
+func compile(t *testing.T, schema string) *Schema {
+	t.Helper()
+
+	var v any
+
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Compile(v)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// check validates doc, expecting violations containing want, in order.
+func check(t *testing.T, s *Schema, doc string, want ...string) {
+	t.Helper()
+
+	errs := s.ValidateJSON([]byte(doc))
+
+	if len(errs) != len(want) {
+		t.Errorf("%s: errors = %v, want %q", doc, errs, want)
+		return
+	}
+
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("%s: error = %q, want %q", doc, err, want[i])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := compile(t, person)
+
+	for _, tc := range []struct {
+		doc  string
+		want []string
+	}{
+		{`{"name": "alice", "age": 30}`, nil},
+		{`{"name": "alice", "age": 30, "email": null, "role": "admin", "kind": "person", "tags": ["a"], "address": {"city": "Bern", "zip": "3000"}, "a/b": true}`, nil},
+		{`[]`, []string{"/: expected object, got array"}},
+		{`{"name": "alice"}`, []string{`/: missing property "age"`}},
+		{`{"name": "", "age": 30.5}`, []string{"/age: expected integer", "/name: must be at least 1 characters"}},
+		{`{"name": "bartholomew!", "age": 150}`, []string{"/age: must be less than 150", "/name: must be at most 10 characters"}},
+		{`{"name": "alice", "age": -1}`, []string{"/age: must be at least 0"}},
+		{`{"name": "alice", "age": 30, "email": "alice"}`, []string{`/email: must match`}},
+		{`{"name": "alice", "age": 30, "role": "root"}`, []string{`/role: must be one of ["admin","user"]`}},
+		{`{"name": "alice", "age": 30, "kind": "robot"}`, []string{`/kind: must be "person"`}},
+		{`{"name": "alice", "age": 30, "tags": []}`, []string{"/tags: must have at least 1 items"}},
+		{`{"name": "alice", "age": 30, "tags": ["a", 1, "c"]}`, []string{"/tags: must have at most 2 items", "/tags/1: expected string, got number"}},
+		{`{"name": "alice", "age": 30, "address": {"zip": 3000}}`, []string{`/address: missing property "city"`, "/address/zip: expected string"}},
+		{`{"name": "alice", "age": 30, "a/b": 1}`, []string{"/a~1b: expected boolean"}},
+		{`{"name": "alice", "age": 30, "nickname": "al"}`, []string{`/: unexpected property "nickname"`}},
+		{`{"name": "alice",`, []string{"invalid JSON"}},
+	} {
+		check(t, s, tc.doc, tc.want...)
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	for _, tc := range []struct {
+		schema string
+		doc    string
+		want   []string
+	}{
+		{`{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `1`, nil},
+		{`{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `true`, []string{"must match at least one schema of anyOf"}},
+		{`{"oneOf": [{"type": "integer"}, {"type": "number"}]}`, `1.5`, nil},
+		{`{"oneOf": [{"type": "integer"}, {"type": "number"}]}`, `1`, []string{"must match exactly one schema of oneOf, matches 2"}},
+		{`{"allOf": [{"minimum": 1}, {"maximum": 2}]}`, `3`, []string{"must be at most 2"}},
+		{`{"not": {"type": "null"}}`, `null`, []string{"must not match the schema of not"}},
+		{`{"properties": {"x": false}}`, `{"x": 1}`, []string{"/x: no value allowed"}},
+		{`true`, `{"anything": [1, 2]}`, nil},
+	} {
+		check(t, compile(t, tc.schema), tc.doc, tc.want...)
+	}
+}
+
+func TestRecursiveRef(t *testing.T) {
+	tree := compile(t, `{
+		"type": "object",
+		"properties": {"children": {"type": "array", "items": {"$ref": "#"}}},
+		"required": ["children"]
+	}`)
+
+	check(t, tree, `{"children": [{"children": []}, {"children": [{"children": []}]}]}`)
+	check(t, tree, `{"children": [{"children": [{}]}]}`, `/children/0/children/0: missing property "children"`)
+
+	// A schema referring to itself without consuming the value ends.
+	loop := compile(t, `{"$ref": "#"}`)
+
+	if errs := loop.Validate("x"); len(errs) == 0 || !strings.Contains(errs[0].Error(), "nested too deeply") {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, schema := range []any{
+		"string",
+		map[string]any{"type": "text"},
+		map[string]any{"type": []any{"string", 1}},
+		map[string]any{"type": 1},
+		map[string]any{"pattern": "("},
+		map[string]any{"$ref": "#/$defs/missing"},
+		map[string]any{"$ref": "https://example.com/schema.json"},
+		map[string]any{"properties": map[string]any{"x": map[string]any{"type": "text"}}},
+		map[string]any{"anyOf": []any{1}},
+	} {
+		if _, err := Compile(schema); err == nil {
+			t.Errorf("schema %v was accepted", schema)
+		}
+	}
+}
+
+func TestCompileYAMLNumbers(t *testing.T) {
+	// Schemas decoded from YAML carry ints; they are compared as numbers.
+	s, err := Compile(map[string]any{"type": "integer", "maximum": 10, "enum": []any{1, 2, 20}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check(t, s, `2`)
+	check(t, s, `20`, "must be at most 10")
+	check(t, s, `3`, "must be one of [1,2,20]")
+}
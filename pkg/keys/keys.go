@@ -0,0 +1,146 @@
+// Package keys keeps the upstream API keys users bring themselves, encrypted
+// at rest.
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// Store saves one key per user as a file in dir, sealed with AES-256-GCM.
+// File names are hashes of the user id, which is also bound to the ciphertext
+// so files can't be swapped between users.
+type Store struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+// FromEnv returns the store in KEYS_PATH (default keys) sealed with
+// KEYS_SECRET, or nil when KEYS_SECRET is not set.
+func FromEnv() (*Store, error) {
+	secret := os.Getenv("KEYS_SECRET")
+
+	if secret == "" {
+		return nil, nil
+	}
+
+	return NewStore(env.String("KEYS_PATH", "keys"), secret)
+}
+
+// NewStore derives the encryption key from secret.
+func NewStore(dir, secret string) (*Store, error) {
+	if secret == "" {
+		return nil, errors.New("keys: secret is required")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	key := sha256.Sum256([]byte(secret))
+
+	block, err := aes.NewCipher(key[:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		dir:  dir,
+		aead: aead,
+	}, nil
+}
+
+// Get returns the key of user and when it was stored.
+func (s *Store) Get(user string) (string, time.Time, error) {
+	path := s.path(user)
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return "", time.Time{}, ErrNotFound
+	}
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	size := s.aead.NonceSize()
+
+	if len(data) < size {
+		return "", time.Time{}, errors.New("keys: corrupt key file")
+	}
+
+	plain, err := s.aead.Open(nil, data[:size], data[size:], []byte(user))
+
+	if err != nil {
+		return "", time.Time{}, errors.New("keys: cannot decrypt key")
+	}
+
+	var updated time.Time
+
+	if info, err := os.Stat(path); err == nil {
+		updated = info.ModTime()
+	}
+
+	return string(plain), updated, nil
+}
+
+func (s *Store) Set(user, key string) error {
+	nonce := make([]byte, s.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data := s.aead.Seal(nonce, nonce, []byte(key), []byte(user))
+
+	tmp := s.path(user) + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path(user))
+}
+
+func (s *Store) Delete(user string) error {
+	err := os.Remove(s.path(user))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *Store) path(user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".key")
+}
+
+// Hint returns a recognizable but harmless form of key, e.g. "sk-…abcd".
+func Hint(key string) string {
+	if len(key) <= 8 {
+		return "…"
+	}
+
+	return key[:3] + "…" + key[len(key)-4:]
+}
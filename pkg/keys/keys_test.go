@@ -0,0 +1,86 @@
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	s, err := NewStore(t.TempDir(), "secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.Get("alice"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get before Set: err = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Set("alice", "sk-alice-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	key, updated, err := s.Get("alice")
+
+	if err != nil || key != "sk-alice-key" || updated.IsZero() {
+		t.Fatalf("Get = %q, %v, %v", key, updated, err)
+	}
+
+	data, _ := os.ReadFile(s.path("alice"))
+
+	if bytes.Contains(data, []byte("sk-alice-key")) {
+		t.Error("the key is stored in plain text")
+	}
+
+	if err := s.Delete("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.Get("alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSwappedFile(t *testing.T) {
+	s, _ := NewStore(t.TempDir(), "secret")
+
+	s.Set("alice", "sk-alice-key")
+	s.Set("mallory", "sk-mallory-key")
+
+	// Mallory's file in place of Alice's must not decrypt as hers.
+	data, _ := os.ReadFile(s.path("mallory"))
+	os.WriteFile(s.path("alice"), data, 0600)
+
+	if key, _, err := s.Get("alice"); err == nil {
+		t.Errorf("swapped file decrypted to %q", key)
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	dir := t.TempDir()
+
+	s, _ := NewStore(dir, "secret")
+	s.Set("alice", "sk-alice-key")
+
+	other, _ := NewStore(dir, "other")
+
+	if _, _, err := other.Get("alice"); err == nil {
+		t.Error("the key decrypted under another secret")
+	}
+
+	if _, err := NewStore(dir, ""); err == nil {
+		t.Error("an empty secret was accepted")
+	}
+}
+
+func TestHint(t *testing.T) {
+	if got := Hint("sk-1234567890abcd"); got != "sk-…abcd" {
+		t.Errorf("Hint = %q", got)
+	}
+
+	if got := Hint("short"); got != "…" {
+		t.Errorf("Hint of a short key = %q", got)
+	}
+}
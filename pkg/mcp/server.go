@@ -0,0 +1,194 @@
+// Package mcp implements a minimal Model Context Protocol server (streamable
+// HTTP transport, stateless, JSON responses) for tools built into the server.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+const protocolVersion = "2025-03-26"
+
+// Tool is a function the model can call.
+type Tool struct {
+	Name        string
+	Description string
+
+	// InputSchema is the JSON Schema of the arguments.
+	InputSchema map[string]any
+
+	Call func(ctx context.Context, args map[string]any) (string, error)
+}
+
+// ConfirmedKey is the _meta entry of a tools/call a client sets to true once
+// the user confirmed the call.
+const ConfirmedKey = "wingman/confirmed"
+
+type Server struct {
+	name    string
+	version string
+
+	tools []Tool
+
+	// Confirm, when set, reports whether calls of a tool need the user's
+	// confirmation; unconfirmed calls of those fail with
+	// codeConfirmationRequired.
+	Confirm func(ctx context.Context, tool string) bool
+}
+
+// codeConfirmationRequired tells the client to ask the user and call again
+// with ConfirmedKey set.
+const codeConfirmationRequired = -32001
+
+func NewServer(name, version string, tools ...Tool) *Server {
+	return &Server{
+		name:    name,
+		version: version,
+
+		tools: tools,
+	}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		// There are no sessions to terminate.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		// No server-initiated stream is offered.
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req request
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeResponse(w, response{Error: &rpcError{-32700, "parse error"}})
+		return
+	}
+
+	// Notifications and responses carry no id and get no answer.
+	if len(req.ID) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	result, err := s.handle(r.Context(), req)
+
+	resp := response{
+		ID:     req.ID,
+		Result: result,
+		Error:  err,
+	}
+
+	writeResponse(w, resp)
+}
+
+func (s *Server) handle(ctx context.Context, req request) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": protocolVersion,
+			"capabilities": map[string]any{
+				"tools": map[string]any{},
+			},
+			"serverInfo": map[string]any{
+				"name":    s.name,
+				"version": s.version,
+			},
+		}, nil
+
+	case "ping":
+		return map[string]any{}, nil
+
+	case "tools/list":
+		tools := []map[string]any{}
+
+		for _, t := range s.tools {
+			tools = append(tools, map[string]any{
+				"name":        t.Name,
+				"description": t.Description,
+				"inputSchema": t.InputSchema,
+			})
+		}
+
+		return map[string]any{"tools": tools}, nil
+
+	case "tools/call":
+		var params struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+			Meta      map[string]any `json:"_meta"`
+		}
+
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{-32602, "invalid params"}
+		}
+
+		for _, t := range s.tools {
+			if t.Name != params.Name {
+				continue
+			}
+
+			if confirmed, _ := params.Meta[ConfirmedKey].(bool); !confirmed && s.Confirm != nil && s.Confirm(ctx, t.Name) {
+				return nil, &rpcError{codeConfirmationRequired, "confirmation required: " + t.Name}
+			}
+
+			text, err := t.Call(ctx, params.Arguments)
+
+			// Tool failures are results the model should see, not protocol errors.
+			if err != nil {
+				return toolResult(err.Error(), true), nil
+			}
+
+			return toolResult(text, false), nil
+		}
+
+		return nil, &rpcError{-32602, "unknown tool: " + params.Name}
+	}
+
+	return nil, &rpcError{-32601, "method not found: " + req.Method}
+}
+
+func toolResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{
+			{"type": "text", "text": text},
+		},
+		"isError": isError,
+	}
+}
+
+func writeResponse(w http.ResponseWriter, resp response) {
+	resp.JSONRPC = "2.0"
+
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,224 @@
+// Package metrics keeps counters and histograms and writes them in the
+// Prometheus text format, optionally only the series of one label value, so
+// each tenant of an instance can be scraped on its own.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds in seconds of request durations.
+var DefaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+type family struct {
+	name string
+	help string
+	kind string
+
+	labels  []string
+	buckets []float64
+
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+
+	value float64
+
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func New() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(name, help, kind string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := &family{
+		name: name,
+		help: help,
+		kind: kind,
+
+		labels:  labels,
+		buckets: buckets,
+
+		series: map[string]*series{},
+	}
+
+	r.families = append(r.families, f)
+
+	return f
+}
+
+// Counter is a value that only goes up, per combination of label values.
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r, r.add(name, help, "counter", labels, nil)}
+}
+
+// Add adds v to the series of the label values, given in the order of the
+// labels.
+func (c *Counter) Add(v float64, values ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+
+	c.f.get(values).value += v
+}
+
+// Histogram counts observations into buckets, per combination of label
+// values.
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r, r.add(name, help, "histogram", labels, buckets)}
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+
+	s := h.f.get(values)
+
+	for i, b := range h.f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+
+	s.sum += v
+	s.count++
+}
+
+func (f *family) get(values []string) *series {
+	key := strings.Join(values, "\x00")
+
+	s, ok := f.series[key]
+
+	if !ok {
+		s = &series{
+			values: slices.Clone(values),
+			counts: make([]uint64, len(f.buckets)),
+		}
+
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// Write writes all series in the Prometheus text format; when label is set,
+// only those whose label has value, of the families that have the label.
+func (r *Registry) Write(w io.Writer, label, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+
+	for _, f := range r.families {
+		index := slices.Index(f.labels, label)
+
+		if label != "" && index < 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+
+		slices.Sort(keys)
+
+		for _, k := range keys {
+			s := f.series[k]
+
+			if label != "" && s.values[index] != value {
+				continue
+			}
+
+			labels := formatLabels(f.labels, s.values)
+
+			if f.kind == "counter" {
+				fmt.Fprintf(&b, "%s%s %s\n", f.name, wrap(labels), formatValue(s.value))
+				continue
+			}
+
+			for i, bound := range f.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, wrap(join(labels, `le="`+formatValue(bound)+`"`)), s.counts[i])
+			}
+
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, wrap(join(labels, `le="+Inf"`)), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", f.name, wrap(labels), formatValue(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", f.name, wrap(labels), s.count)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+
+	for i, name := range names {
+		pairs[i] = name + `="` + escape(values[i]) + `"`
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func join(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+
+	return labels + "," + label
+}
+
+func wrap(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
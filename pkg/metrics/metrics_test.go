@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func testRegistry() *Registry {
+	r := New()
+
+	requests := r.Counter("wingman_requests_total", "Requests by tenant and model.", "tenant", "model")
+	requests.Add(1, "acme", "gpt")
+	requests.Add(2, "acme", "gpt")
+	requests.Add(1, "globex", `say "hi"\`+"\n")
+
+	duration := r.Histogram("wingman_request_duration_seconds", "Request durations.", []float64{0.5, 1}, "tenant")
+	duration.Observe(0.25, "acme")
+	duration.Observe(0.75, "acme")
+	duration.Observe(3, "acme")
+
+	up := r.Counter("wingman_restarts_total", "Restarts.")
+	up.Add(1)
+
+	return r
+}
+
+func TestWrite(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		label, value string
+		want         string
+	}{
+		{"all", "", "", `# HELP wingman_requests_total Requests by tenant and model.
+# TYPE wingman_requests_total counter
+wingman_requests_total{tenant="acme",model="gpt"} 3
+wingman_requests_total{tenant="globex",model="say \"hi\"\\\n"} 1
+# HELP wingman_request_duration_seconds Request durations.
+# TYPE wingman_request_duration_seconds histogram
+wingman_request_duration_seconds_bucket{tenant="acme",le="0.5"} 1
+wingman_request_duration_seconds_bucket{tenant="acme",le="1"} 2
+wingman_request_duration_seconds_bucket{tenant="acme",le="+Inf"} 3
+wingman_request_duration_seconds_sum{tenant="acme"} 4
+wingman_request_duration_seconds_count{tenant="acme"} 3
+# HELP wingman_restarts_total Restarts.
+# TYPE wingman_restarts_total counter
+wingman_restarts_total 1
+`},
+		// A tenant's scrape has its series only, of the families by tenant.
+		{"one tenant", "tenant", "globex", `# HELP wingman_requests_total Requests by tenant and model.
+# TYPE wingman_requests_total counter
+wingman_requests_total{tenant="globex",model="say \"hi\"\\\n"} 1
+# HELP wingman_request_duration_seconds Request durations.
+# TYPE wingman_request_duration_seconds histogram
+`},
+	} {
+		var b strings.Builder
+
+		if err := testRegistry().Write(&b, tc.label, tc.value); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := b.String(); got != tc.want {
+			t.Errorf("%s:\n%s\nwant\n%s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	for v, want := range map[float64]string{0.1: "0.1", 2.5: "2.5", 120: "120", 1e21: "1e+21"} {
+		if got := formatValue(v); got != want {
+			t.Errorf("formatValue(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package migrate upgrades the data of the file-based stores (user keys,
+// connections, API keys) between releases. Migrations are compiled in and
+// versioned; the applied ones are recorded in a state file, so every upgrade
+// is auditable.
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Migration upgrades the stores by one version.
+type Migration struct {
+	Version int
+	Name    string
+
+	// Run performs the migration, or with dryRun only reports what it would
+	// do. It returns the changes, one per line, and must be safe to repeat
+	// after a failure.
+	Run func(dryRun bool) ([]string, error)
+}
+
+// Record is an applied migration.
+type Record struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+
+	Applied time.Time `json:"applied"`
+	Changes []string  `json:"changes,omitempty"`
+}
+
+type Migrator struct {
+	state      string
+	migrations []Migration
+}
+
+// FromEnv returns the migrations of the stores in KEYS_PATH,
+// CONNECTIONS_PATH and APIKEYS_PATH, recorded in MIGRATIONS_STATE (default
+// migrations.json next to them), or nil when none is set.
+func FromEnv() *Migrator {
+	stores := Stores{
+		Keys:        os.Getenv("KEYS_PATH"),
+		Connections: os.Getenv("CONNECTIONS_PATH"),
+		APIKeys:     os.Getenv("APIKEYS_PATH"),
+	}
+
+	if stores.Empty() {
+		return nil
+	}
+
+	return New(env.String("MIGRATIONS_STATE", filepath.Join(stores.Dir(), "migrations.json")), All(stores))
+}
+
+// New returns a migrator recording its state in the file state.
+func New(state string, migrations []Migration) *Migrator {
+	migrations = slices.Clone(migrations)
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+
+	return &Migrator{
+		state:      state,
+		migrations: migrations,
+	}
+}
+
+// Applied returns the recorded migrations, oldest first.
+func (m *Migrator) Applied() ([]Record, error) {
+	data, err := os.ReadFile(m.state)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("migrate: %s: %w", m.state, err)
+	}
+
+	return records, nil
+}
+
+// Pending returns the migrations not applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	records, err := m.Applied()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var current int
+
+	for _, r := range records {
+		current = max(current, r.Version)
+	}
+
+	if latest := m.latest(); current > latest {
+		return nil, fmt.Errorf("migrate: stores are at version %d, newer than this release (%d)", current, latest)
+	}
+
+	var result []Migration
+
+	for _, mig := range m.migrations {
+		if mig.Version > current {
+			result = append(result, mig)
+		}
+	}
+
+	return result, nil
+}
+
+// Up applies the pending migrations in order and records each, writing what
+// it does to log. With dryRun nothing is changed.
+func (m *Migrator) Up(dryRun bool, log io.Writer) error {
+	pending, err := m.Pending()
+
+	if err != nil {
+		return err
+	}
+
+	records, err := m.Applied()
+
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range pending {
+		fmt.Fprintf(log, "migration %d %s\n", mig.Version, mig.Name)
+
+		changes, err := mig.Run(dryRun)
+
+		for _, c := range changes {
+			fmt.Fprintf(log, "  %s\n", c)
+		}
+
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", mig.Version, mig.Name, err)
+		}
+
+		if dryRun {
+			continue
+		}
+
+		records = append(records, Record{
+			Version: mig.Version,
+			Name:    mig.Name,
+
+			Applied: time.Now().UTC(),
+			Changes: changes,
+		})
+
+		if err := m.save(records); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check reports pending migrations as error.
+func (m *Migrator) Check() error {
+	pending, err := m.Pending()
+
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		names := make([]string, 0, len(pending))
+
+		for _, mig := range pending {
+			names = append(names, fmt.Sprintf("%d %s", mig.Version, mig.Name))
+		}
+
+		return errors.New("pending migrations: " + strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+func (m *Migrator) latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) save(records []Record) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(m.state); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	tmp := m.state + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, m.state)
+}
@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUp(t *testing.T) {
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keys")
+
+	os.MkdirAll(keys, 0o755)
+	os.WriteFile(filepath.Join(keys, "alice.tmp"), nil, 0o600)
+	os.WriteFile(filepath.Join(keys, "alice"), nil, 0o600)
+
+	state := filepath.Join(dir, "migrations.json")
+	m := New(state, All(Stores{Keys: keys}))
+
+	if err := m.Up(true, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(state); !errors.Is(err, os.ErrNotExist) {
+		t.Error("a dry run wrote the state file")
+	}
+
+	if _, err := os.Stat(filepath.Join(keys, "alice.tmp")); err != nil {
+		t.Error("a dry run removed a file")
+	}
+
+	if err := m.Up(false, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(keys, "alice.tmp")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("the interrupted write is still there")
+	}
+
+	if _, err := os.Stat(filepath.Join(keys, "alice")); err != nil {
+		t.Error("a key was removed")
+	}
+
+	if err := m.Check(); err != nil {
+		t.Error(err)
+	}
+
+	applied, _ := m.Applied()
+
+	if len(applied) != 2 || applied[1].Changes[0] != "remove "+filepath.Join(keys, "alice.tmp") {
+		t.Errorf("applied = %+v", applied)
+	}
+}
+
+func TestUpToDateDoesNotWrite(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "migrations.json")
+
+	if err := New(state, nil).Up(false, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(state); !errors.Is(err, os.ErrNotExist) {
+		t.Error("the state file was written without anything to record")
+	}
+}
+
+func TestNewerStores(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "migrations.json")
+	os.WriteFile(state, []byte(`[{"version": 99, "name": "future"}]`), 0o600)
+
+	if err := New(state, All(Stores{})).Check(); err == nil {
+		t.Error("stores of a newer release were accepted")
+	}
+}
+
+func TestStoresDir(t *testing.T) {
+	for stores, want := range map[Stores]string{
+		{}:                                 ".",
+		{Keys: "/data/keys/"}:              "/data",
+		{APIKeys: "/var/lib/apikeys.json"}: "/var/lib",
+		{Keys: "keys"}:                     ".",
+	} {
+		if got := stores.Dir(); got != want {
+			t.Errorf("%+v: Dir = %q, want %q", stores, got, want)
+		}
+	}
+}
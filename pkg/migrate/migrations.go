@@ -0,0 +1,97 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Stores locates the data to migrate; empty paths are skipped.
+type Stores struct {
+	// Keys and Connections are directories of keys.Store files.
+	Keys        string
+	Connections string
+
+	// APIKeys is the file of the API keys.
+	APIKeys string
+}
+
+// Empty reports whether no store is configured, so there is nothing to
+// migrate.
+func (s Stores) Empty() bool {
+	return s.Keys == "" && s.Connections == "" && s.APIKeys == ""
+}
+
+// Dir returns the directory holding the stores, where the state file
+// belongs by default.
+func (s Stores) Dir() string {
+	for _, path := range []string{s.Keys, s.Connections, s.APIKeys} {
+		if path != "" {
+			return filepath.Dir(filepath.Clean(path))
+		}
+	}
+
+	return "."
+}
+
+// All returns the migrations of this release. Versions only ever grow; a
+// released migration is never changed.
+func All(s Stores) []Migration {
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "baseline",
+
+			// Records the layout the stores had before migrations existed.
+			Run: func(dryRun bool) ([]string, error) {
+				return nil, nil
+			},
+		},
+		{
+			Version: 2,
+			Name:    "remove interrupted writes",
+
+			// Stores write to a temporary file and rename it; a crash in
+			// between leaves the temporary file behind.
+			Run: func(dryRun bool) ([]string, error) {
+				var patterns []string
+
+				for _, dir := range []string{s.Keys, s.Connections} {
+					if dir != "" {
+						patterns = append(patterns, filepath.Join(dir, "*.tmp"))
+					}
+				}
+
+				if s.APIKeys != "" {
+					patterns = append(patterns, s.APIKeys+".tmp")
+				}
+
+				return removeFiles(patterns, dryRun)
+			},
+		},
+	}
+}
+
+func removeFiles(patterns []string, dryRun bool) ([]string, error) {
+	var changes []string
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+
+		if err != nil {
+			return changes, err
+		}
+
+		for _, path := range matches {
+			if !dryRun {
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return changes, err
+				}
+			}
+
+			changes = append(changes, "remove "+path)
+		}
+	}
+
+	return changes, nil
+}
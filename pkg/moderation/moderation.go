@@ -0,0 +1,209 @@
+// Package moderation classifies prompts with an OpenAI compatible
+// moderations endpoint: the platform's, OpenAI's, or a local classifier.
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// timeout bounds a moderation request.
+const timeout = 30 * time.Second
+
+type Policy string
+
+const (
+	// Block rejects flagged requests; requests that can't be checked are
+	// rejected as well.
+	Block Policy = "block"
+
+	// Log passes flagged requests on and logs them.
+	Log Policy = "log"
+)
+
+// Input is a text or an image (a URL or data URL) to classify.
+type Input struct {
+	Text     string
+	ImageURL string
+}
+
+type Moderator struct {
+	client *http.Client
+
+	url   string
+	token func() string
+	model string
+
+	policy Policy
+}
+
+// FromEnv returns the moderator posting to MODERATION_URL with
+// MODERATION_TOKEN, or else to the platform's moderations at url with token
+// through transport, asking for MODERATION_MODEL and acting on
+// MODERATION_POLICY (default block). It returns nil when neither
+// MODERATION_URL nor MODERATION_MODEL is set.
+func FromEnv(url string, token func() string, transport http.RoundTripper) (*Moderator, error) {
+	u, model := os.Getenv("MODERATION_URL"), os.Getenv("MODERATION_MODEL")
+
+	if u == "" && model == "" {
+		return nil, nil
+	}
+
+	policy := Policy(env.String("MODERATION_POLICY", string(Block)))
+
+	if u != "" {
+		t := os.Getenv("MODERATION_TOKEN")
+		return New(u, func() string { return t }, model, policy, nil)
+	}
+
+	return New(url+"/v1/moderations", token, model, policy, transport)
+}
+
+// New posts to url (e.g. https://api.openai.com/v1/moderations) with the
+// bearer token returns, if any. The model may be empty for endpoints with
+// a default.
+func New(url string, token func() string, model string, policy Policy, transport http.RoundTripper) (*Moderator, error) {
+	if policy != Block && policy != Log {
+		return nil, fmt.Errorf("moderation: unknown policy %q (want block or log)", policy)
+	}
+
+	return &Moderator{
+		client: &http.Client{Timeout: timeout, Transport: transport},
+
+		url:   url,
+		token: token,
+		model: model,
+
+		policy: policy,
+	}, nil
+}
+
+func (m *Moderator) Policy() Policy {
+	return m.policy
+}
+
+// Check returns the categories any of inputs is flagged for, or none.
+func (m *Moderator) Check(ctx context.Context, inputs []Input) ([]string, error) {
+	results, err := m.Classify(ctx, inputs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []string
+
+	for _, r := range results {
+		for _, name := range r {
+			if !slices.Contains(categories, name) {
+				categories = append(categories, name)
+			}
+		}
+	}
+
+	slices.Sort(categories)
+
+	return categories, nil
+}
+
+// Classify returns the categories each of inputs is flagged for.
+func (m *Moderator) Classify(ctx context.Context, inputs []Input) ([][]string, error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+
+	var input []map[string]any
+
+	for _, in := range inputs {
+		if in.ImageURL != "" {
+			input = append(input, map[string]any{"type": "image_url", "image_url": map[string]any{"url": in.ImageURL}})
+		} else {
+			input = append(input, map[string]any{"type": "text", "text": in.Text})
+		}
+	}
+
+	request := map[string]any{"input": input}
+
+	if m.model != "" {
+		request["model"] = m.model
+	}
+
+	body, err := json.Marshal(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if m.token != nil {
+		if token := m.token(); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	resp, err := m.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("moderation error (%d): %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Results) != len(inputs) {
+		return nil, fmt.Errorf("moderation: got %d results for %d inputs", len(result.Results), len(inputs))
+	}
+
+	results := make([][]string, len(inputs))
+
+	for i, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+
+		for name, flagged := range r.Categories {
+			if flagged {
+				results[i] = append(results[i], name)
+			}
+		}
+
+		if len(results[i]) == 0 {
+			results[i] = []string{"flagged"}
+		}
+
+		slices.Sort(results[i])
+	}
+
+	return results, nil
+}
@@ -0,0 +1,166 @@
+// Package netacl admits requests by client address, for deployments exposed
+// on the internet that should only serve e.g. corporate networks.
+package netacl
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+)
+
+// Enforce answers requests from networks the configuration (network.yaml)
+// doesn't admit with 403. Requests below prefix follow the API rules when
+// they are set. Changes to the configuration apply immediately.
+func Enforce(prefix string, store *config.Store, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/")
+
+	var mu sync.Mutex
+	var source *config.Network
+	var current *acl
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := store.Config().Network
+
+		if n == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mu.Lock()
+
+		if n != source {
+			source, current = n, compile(n)
+		}
+
+		a := current
+
+		mu.Unlock()
+
+		rules := a.site
+
+		if a.api != nil && (r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/")) {
+			rules = *a.api
+		}
+
+		if addr, ok := a.client(r); !rules.admits(addr, ok) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type acl struct {
+	site rules
+	api  *rules
+
+	proxies []netip.Prefix
+}
+
+type rules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// compile parses the configuration. Invalid entries are reported by
+// config.Validate and skipped here.
+func compile(n *config.Network) *acl {
+	a := &acl{
+		site:    rules{allow: parse(n.Allow), deny: parse(n.Deny)},
+		proxies: parse(n.TrustedProxies),
+	}
+
+	if n.API != nil {
+		a.api = &rules{allow: parse(n.API.Allow), deny: parse(n.API.Deny)}
+	}
+
+	return a
+}
+
+// admits reports whether rules let addr in. Clients of unknown address are
+// only admitted when no allow list is set.
+func (r rules) admits(addr netip.Addr, ok bool) bool {
+	if !ok {
+		return len(r.allow) == 0
+	}
+
+	if contains(r.deny, addr) {
+		return false
+	}
+
+	return len(r.allow) == 0 || contains(r.allow, addr)
+}
+
+// client returns the address of the client: the peer, or for connections
+// from trusted proxies (and over a Unix socket) the last untrusted address in
+// X-Forwarded-For.
+func (a *acl) client(r *http.Request) (netip.Addr, bool) {
+	var addr netip.Addr
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err == nil {
+		addr, err = netip.ParseAddr(host)
+	}
+
+	local := r.RemoteAddr == "" || r.RemoteAddr == "@"
+
+	if err == nil {
+		addr = addr.Unmap()
+	}
+
+	if !local && (err != nil || !contains(a.proxies, addr)) {
+		return addr, err == nil
+	}
+
+	var hops []string
+
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	ok := !local
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+
+		if err != nil {
+			return netip.Addr{}, false
+		}
+
+		addr, ok = hop.Unmap(), true
+
+		if !contains(a.proxies, addr) {
+			break
+		}
+	}
+
+	return addr, ok
+}
+
+func parse(values []string) []netip.Prefix {
+	var result []netip.Prefix
+
+	for _, s := range values {
+		if prefix, err := config.ParsePrefix(s); err == nil {
+			result = append(result, prefix)
+		}
+	}
+
+	return result
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,352 @@
+// Package openapi builds the OpenAPI document of the server's own API. The
+// handlers describe their endpoints next to where they attach them, with the
+// Go types they read and write; schemas are derived from the JSON tags of
+// those types, so the document follows the code.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Security schemes of operations.
+const (
+	// Public endpoints need no credentials.
+	Public = ""
+
+	// User endpoints take the signed-in user's session, an OIDC token or an
+	// API key as bearer token.
+	User = "user"
+
+	// Admin endpoints take an admin token as bearer token.
+	Admin = "admin"
+)
+
+// Operation describes an endpoint.
+type Operation struct {
+	Summary string
+	Tag     string
+
+	// Security is one of Public, User or Admin.
+	Security string
+
+	// Query names the query parameters; path parameters are taken from the
+	// path.
+	Query []string
+
+	// Request and Response are the bodies: a reflect.Type whose JSON form is
+	// sent, a schema as map[string]any, or nil for none.
+	Request  any
+	Response any
+
+	// RequestType and ResponseType are the media types of the bodies,
+	// application/json when empty.
+	RequestType  string
+	ResponseType string
+
+	// Status is the status of success, 200 when 0.
+	Status int
+}
+
+type Spec struct {
+	title   string
+	version string
+
+	paths   map[string]map[string]any
+	schemas map[string]any
+	types   map[string]reflect.Type
+}
+
+func New(title, version string) *Spec {
+	return &Spec{
+		title:   title,
+		version: version,
+
+		paths:   map[string]map[string]any{},
+		schemas: map[string]any{},
+		types:   map[string]reflect.Type{},
+	}
+}
+
+var pathParam = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// Add describes the endpoint method path, in the pattern syntax of
+// http.ServeMux.
+func (s *Spec) Add(method, pattern string, op Operation) {
+	p := pathParam.ReplaceAllString(pattern, "{$1}")
+
+	if s.paths[p] == nil {
+		s.paths[p] = map[string]any{}
+	}
+
+	o := map[string]any{
+		"summary":     op.Summary,
+		"operationId": operationID(method, p),
+	}
+
+	if op.Tag != "" {
+		o["tags"] = []string{op.Tag}
+	}
+
+	switch op.Security {
+	case Public:
+		o["security"] = []any{}
+	default:
+		o["security"] = []any{map[string]any{op.Security: []string{}}}
+	}
+
+	var params []any
+
+	for _, m := range pathParam.FindAllStringSubmatch(pattern, -1) {
+		params = append(params, map[string]any{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+
+	for _, name := range op.Query {
+		params = append(params, map[string]any{
+			"name":   name,
+			"in":     "query",
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+
+	if op.Request != nil {
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{mediaType(op.RequestType): map[string]any{"schema": s.schema(op.Request)}},
+		}
+	}
+
+	status := op.Status
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	response := map[string]any{"description": http.StatusText(status)}
+
+	if op.Response != nil {
+		response["content"] = map[string]any{mediaType(op.ResponseType): map[string]any{"schema": s.schema(op.Response)}}
+	}
+
+	o["responses"] = map[string]any{strconv.Itoa(status): response}
+
+	s.paths[p][strings.ToLower(method)] = o
+}
+
+// Document returns the OpenAPI 3.1 document.
+func (s *Spec) Document() map[string]any {
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   s.title,
+			"version": s.version,
+		},
+		"paths": s.paths,
+		"components": map[string]any{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]any{
+				User: map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "OIDC access token or API key (wk_...); browsers use the session cookie",
+				},
+				Admin: map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Admin token of a role",
+				},
+			},
+		},
+	}
+}
+
+// ServeHTTP serves the document as JSON.
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Document())
+}
+
+func (s *Spec) schema(v any) map[string]any {
+	switch v := v.(type) {
+	case reflect.Type:
+		return s.typeSchema(v)
+	case map[string]any:
+		return v
+	}
+
+	return map[string]any{}
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// typeSchema derives the schema of the JSON form of t; named structs become
+// components.
+func (s *Spec) typeSchema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+
+		return map[string]any{
+			"type":  "array",
+			"items": s.typeSchema(t.Elem()),
+		}
+
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": s.typeSchema(t.Elem()),
+		}
+
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+
+		name := s.component(t)
+
+		if _, ok := s.schemas[name]; !ok {
+			s.schemas[name] = map[string]any{} // placeholder guards against recursive types
+			s.schemas[name] = s.structSchema(t)
+		}
+
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+
+	// Interfaces accept anything.
+	return map[string]any{}
+}
+
+// component names the schema of t, qualified by its package when another
+// type has the name.
+func (s *Spec) component(t reflect.Type) string {
+	name := t.Name()
+
+	if other, ok := s.types[name]; ok && other != t {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+
+	s.types[name] = t
+
+	return name
+}
+
+func (s *Spec) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+
+	var required []string
+
+	var add func(t reflect.Type)
+
+	add = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+
+			tag := f.Tag.Get("json")
+			name, options, _ := strings.Cut(tag, ",")
+
+			if name == "-" {
+				continue
+			}
+
+			// Embedded structs lend their fields, as in encoding/json.
+			if f.Anonymous && name == "" {
+				ft := f.Type
+
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+
+				if ft.Kind() == reflect.Struct {
+					add(ft)
+					continue
+				}
+			}
+
+			if !f.IsExported() {
+				continue
+			}
+
+			if name == "" {
+				name = f.Name
+			}
+
+			properties[name] = s.typeSchema(f.Type)
+
+			if !strings.Contains(options, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+
+	add(t)
+
+	result := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+
+	if len(required) > 0 {
+		slices.Sort(required)
+		result["required"] = required
+	}
+
+	return result
+}
+
+func mediaType(t string) string {
+	if t == "" {
+		return "application/json"
+	}
+
+	return t
+}
+
+// operationID derives an id like getAdminConfigHistory from method and path.
+func operationID(method, p string) string {
+	id := strings.ToLower(method)
+
+	for _, part := range strings.FieldsFunc(p, func(r rune) bool { return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return id
+}
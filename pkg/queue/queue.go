@@ -0,0 +1,252 @@
+// Package queue limits the requests in flight toward the upstream, overall
+// and per caller. Requests beyond the limits wait, interactive ones ahead of
+// background ones, and background requests may only hold part of the slots,
+// so a burst of them can't starve live chats.
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// ErrFull is returned by Acquire when too many requests are waiting already.
+var ErrFull = errors.New("too many requests waiting")
+
+// Priority orders waiting requests.
+type Priority int
+
+const (
+	// Background requests, like research or title generation, nobody
+	// watches while they run.
+	Background Priority = iota
+
+	// Interactive requests are answers users wait for.
+	Interactive
+)
+
+type Queue struct {
+	// Limit is the number of requests in flight overall; none when 0.
+	Limit int
+
+	// CallerLimit is the number of requests in flight per caller; none
+	// when 0.
+	CallerLimit int
+
+	// BackgroundLimit is the number of background requests in flight; none
+	// but Limit when 0.
+	BackgroundLimit int
+
+	// MaxWaiting is the number of requests waiting; none when 0.
+	MaxWaiting int
+
+	mu sync.Mutex
+
+	active     int
+	background int
+	callers    map[string]int
+
+	// waiting is ordered by priority, then by arrival.
+	waiting []*waiter
+}
+
+type waiter struct {
+	caller   string
+	priority Priority
+
+	ready chan struct{}
+}
+
+// FromEnv returns the queue of QUEUE_LIMIT and QUEUE_CALLER_LIMIT requests
+// in flight, overall and per caller, QUEUE_BACKGROUND_LIMIT (default half
+// of QUEUE_LIMIT) and QUEUE_MAX_WAITING, or nil when neither limit is set.
+func FromEnv() *Queue {
+	limit, callerLimit := env.Int("QUEUE_LIMIT", 0), env.Int("QUEUE_CALLER_LIMIT", 0)
+
+	if limit <= 0 && callerLimit <= 0 {
+		return nil
+	}
+
+	q := New(max(limit, 0), max(callerLimit, 0))
+
+	if n := env.Int("QUEUE_BACKGROUND_LIMIT", -1); n >= 0 {
+		q.BackgroundLimit = n
+	} else {
+		q.BackgroundLimit = (q.Limit + 1) / 2
+	}
+
+	if n := env.Int("QUEUE_MAX_WAITING", 0); n > 0 {
+		q.MaxWaiting = n
+	}
+
+	return q
+}
+
+func New(limit, callerLimit int) *Queue {
+	return &Queue{
+		Limit:       limit,
+		CallerLimit: callerLimit,
+
+		callers: map[string]int{},
+	}
+}
+
+// Acquire waits for a slot of caller until ctx is done; release must be
+// called once the request is complete.
+func (q *Queue) Acquire(ctx context.Context, caller string, p Priority) (release func(), err error) {
+	q.mu.Lock()
+
+	if q.free(caller, p) && !q.waitingAhead(p) {
+		q.take(caller, p)
+		q.mu.Unlock()
+
+		return q.releaser(caller, p), nil
+	}
+
+	if q.MaxWaiting > 0 && len(q.waiting) >= q.MaxWaiting {
+		q.mu.Unlock()
+		return nil, ErrFull
+	}
+
+	w := &waiter{
+		caller:   caller,
+		priority: p,
+
+		ready: make(chan struct{}),
+	}
+
+	i := len(q.waiting)
+
+	for i > 0 && q.waiting[i-1].priority < p {
+		i--
+	}
+
+	q.waiting = append(q.waiting[:i], append([]*waiter{w}, q.waiting[i:]...)...)
+
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return q.releaser(caller, p), nil
+
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		select {
+		case <-w.ready:
+			// Granted meanwhile: pass the slot on.
+			q.release(caller, p)
+
+		default:
+			q.remove(w)
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// Stats reports the requests in flight and waiting.
+func (q *Queue) Stats() (active, waiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.active, len(q.waiting)
+}
+
+// waitingAhead reports whether a request of priority p that could start has
+// to wait its turn; a waiter only held back by its caller's limit isn't one
+// to wait for.
+func (q *Queue) waitingAhead(p Priority) bool {
+	for _, w := range q.waiting {
+		if w.priority >= p && q.free(w.caller, w.priority) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (q *Queue) free(caller string, p Priority) bool {
+	if q.Limit > 0 && q.active >= q.Limit {
+		return false
+	}
+
+	if q.CallerLimit > 0 && q.callers[caller] >= q.CallerLimit {
+		return false
+	}
+
+	if p == Background && q.BackgroundLimit > 0 && q.background >= q.BackgroundLimit {
+		return false
+	}
+
+	return true
+}
+
+func (q *Queue) take(caller string, p Priority) {
+	q.active++
+	q.callers[caller]++
+
+	if p == Background {
+		q.background++
+	}
+}
+
+func (q *Queue) releaser(caller string, p Priority) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+
+			q.release(caller, p)
+		})
+	}
+}
+
+func (q *Queue) release(caller string, p Priority) {
+	q.active--
+
+	if p == Background {
+		q.background--
+	}
+
+	if q.callers[caller]--; q.callers[caller] <= 0 {
+		delete(q.callers, caller)
+	}
+
+	q.dispatch()
+}
+
+// dispatch starts the waiters in order that fit the limits now.
+func (q *Queue) dispatch() {
+	for i := 0; i < len(q.waiting); {
+		if q.Limit > 0 && q.active >= q.Limit {
+			return
+		}
+
+		w := q.waiting[i]
+
+		if !q.free(w.caller, w.priority) {
+			i++
+			continue
+		}
+
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		q.take(w.caller, w.priority)
+
+		close(w.ready)
+	}
+}
+
+func (q *Queue) remove(w *waiter) {
+	for i, v := range q.waiting {
+		if v == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func acquire(t *testing.T, q *Queue, caller string, p Priority) func() {
+	t.Helper()
+
+	release, err := q.Acquire(context.Background(), caller, p)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return release
+}
+
+// wait acquires a slot in the background, sending the caller on started
+// once it got one.
+func wait(q *Queue, caller string, p Priority, started chan<- string) {
+	go func() {
+		release, err := q.Acquire(context.Background(), caller, p)
+
+		if err != nil {
+			started <- "error: " + err.Error()
+			return
+		}
+
+		started <- caller
+		release()
+	}()
+}
+
+// waiting blocks until n requests wait.
+func waiting(t *testing.T, q *Queue, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, w := q.Stats(); w == n {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests never waited", n)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		queue  *Queue
+		caller string
+		p      Priority
+		waits  bool
+	}{
+		{"overall limit", &Queue{Limit: 1}, "bob", Interactive, true},
+		{"caller limit", &Queue{CallerLimit: 1}, "alice", Interactive, true},
+		{"other caller", &Queue{CallerLimit: 1}, "bob", Interactive, false},
+		{"background limit", &Queue{BackgroundLimit: 1}, "bob", Background, true},
+		{"interactive past the background limit", &Queue{BackgroundLimit: 1}, "bob", Interactive, false},
+	} {
+		q := tc.queue
+		q.callers = map[string]int{}
+
+		// alice holds a background slot.
+		release := acquire(t, q, "alice", Background)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		second, err := q.Acquire(ctx, tc.caller, tc.p)
+		cancel()
+
+		if waits := errors.Is(err, context.DeadlineExceeded); waits != tc.waits {
+			t.Errorf("%s: waited = %v, want %v", tc.name, waits, tc.waits)
+		}
+
+		if second != nil {
+			second()
+		}
+
+		release()
+
+		if active, waiting := q.Stats(); active != 0 || waiting != 0 {
+			t.Errorf("%s: %d active, %d waiting after all were released", tc.name, active, waiting)
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	q := New(1, 0)
+	release := acquire(t, q, "holder", Interactive)
+
+	started := make(chan string, 3)
+
+	wait(q, "research", Background, started)
+	waiting(t, q, 1)
+
+	wait(q, "title", Background, started)
+	waiting(t, q, 2)
+
+	// Live chats go ahead of the background requests that waited before.
+	wait(q, "chat", Interactive, started)
+	waiting(t, q, 3)
+
+	release()
+
+	for _, want := range []string{"chat", "research", "title"} {
+		if got := <-started; got != want {
+			t.Errorf("started %s, want %s", got, want)
+		}
+	}
+}
+
+func TestMaxWaiting(t *testing.T) {
+	q := New(1, 0)
+	q.MaxWaiting = 1
+
+	release := acquire(t, q, "holder", Interactive)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go q.Acquire(ctx, "first", Interactive)
+	waiting(t, q, 1)
+
+	if _, err := q.Acquire(context.Background(), "second", Interactive); !errors.Is(err, ErrFull) {
+		t.Errorf("err = %v, want ErrFull", err)
+	}
+
+	// Those giving up leave the queue.
+	cancel()
+	waiting(t, q, 0)
+}
+
+func TestCallerLimitDoesntBlockOthers(t *testing.T) {
+	q := New(2, 1)
+
+	release := acquire(t, q, "alice", Interactive)
+	defer release()
+
+	started := make(chan string, 1)
+
+	wait(q, "alice", Interactive, started)
+	waiting(t, q, 1)
+
+	// alice's waiting request holds no one else up.
+	bob := acquire(t, q, "bob", Interactive)
+	bob()
+}
+
+func TestFromEnv(t *testing.T) {
+	if q := FromEnv(); q != nil {
+		t.Error("queue enabled by default")
+	}
+
+	t.Setenv("QUEUE_LIMIT", "5")
+	t.Setenv("QUEUE_MAX_WAITING", "100")
+
+	if q := FromEnv(); q == nil || q.Limit != 5 || q.CallerLimit != 0 || q.BackgroundLimit != 3 || q.MaxWaiting != 100 {
+		t.Errorf("queue = %+v", q)
+	}
+
+	t.Setenv("QUEUE_BACKGROUND_LIMIT", "0")
+
+	if q := FromEnv(); q == nil || q.BackgroundLimit != 0 {
+		t.Errorf("queue = %+v, want no background limit", q)
+	}
+}
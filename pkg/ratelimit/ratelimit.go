@@ -0,0 +1,100 @@
+// Package ratelimit implements token buckets refilling continuously at a
+// per-minute rate, keyed by caller.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that refilled completely are dropped.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	level   float64
+	limit   int
+	updated time.Time
+}
+
+// refill tops the bucket up for the time passed since its last update.
+func (b *bucket) refill(now time.Time, limit int) {
+	if b.limit != limit {
+		// The limit changed with the configuration; keep the spent part.
+		b.level += float64(limit - b.limit)
+		b.limit = limit
+	}
+
+	b.level = min(float64(limit), b.level+now.Sub(b.updated).Minutes()*float64(limit))
+	b.updated = now
+}
+
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets: map[string]*bucket{},
+		swept:   time.Now(),
+	}
+}
+
+// Take removes n units from the bucket of key holding limit units a minute.
+// When fewer are left, nothing is removed and the time until there are
+// enough is returned.
+func (l *Limiter) Take(key string, limit, n int) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, limit, time.Now())
+
+	if missing := float64(min(n, limit)) - b.level; missing > 0 {
+		return time.Duration(missing / float64(limit) * float64(time.Minute))
+	}
+
+	b.level -= float64(n)
+
+	return 0
+}
+
+// Charge removes n units from the bucket of key, leaving it in debt if
+// needed; used for costs only known afterwards.
+func (l *Limiter) Charge(key string, limit, n int) {
+	if limit <= 0 || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bucket(key, limit, time.Now()).level -= float64(n)
+}
+
+func (l *Limiter) bucket(key string, limit int, now time.Time) *bucket {
+	if now.Sub(l.swept) >= sweepInterval {
+		l.swept = now
+
+		for k, b := range l.buckets {
+			if b.refill(now, b.limit); b.level >= float64(b.limit) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b := l.buckets[key]
+
+	if b == nil {
+		b = &bucket{level: float64(limit), limit: limit, updated: now}
+		l.buckets[key] = b
+	}
+
+	b.refill(now, limit)
+
+	return b
+}
@@ -0,0 +1,187 @@
+// Package rbac decides which models, tools and features a user may use,
+// based on the roles in roles.yaml.
+package rbac
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/guest"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// featurePaths are the API endpoints (below the prefix) behind each feature.
+var featurePaths = map[string][]string{
+	"internet":   {"/v1/search", "/v1/research"},
+	"renderer":   {"/v1/render", "/v1/images/generations", "/v1/images/edits"},
+	"translator": {"/v1/translate"},
+	"voice":      {"/v1/realtime"},
+	"tts":        {"/v1/audio/speech", "/voices"},
+	"stt":        {"/v1/audio/transcriptions"},
+	"guests":     {"/guests"},
+}
+
+// Permissions are what the roles of a user grant. A nil *Permissions (no
+// roles configured) allows everything.
+type Permissions struct {
+	models   []string
+	tools    []string
+	features []string
+}
+
+// Resolve collects the grants of all roles that apply to user.
+func Resolve(roles []config.Role, user *auth.User) *Permissions {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	p := &Permissions{}
+
+	for _, r := range roles {
+		if !applies(r, user) {
+			continue
+		}
+
+		p.models = append(p.models, all(r.Models)...)
+		p.tools = append(p.tools, all(r.Tools)...)
+		p.features = append(p.features, all(r.Features)...)
+	}
+
+	return p
+}
+
+func applies(r config.Role, user *auth.User) bool {
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+
+	if user == nil {
+		return false
+	}
+
+	return slices.Contains(r.Users, user.ID) || (user.Email != "" && slices.Contains(r.Users, user.Email)) || user.InGroup(r.Groups...)
+}
+
+// all treats an empty grant as a grant of everything.
+func all(values []string) []string {
+	if len(values) == 0 {
+		return []string{"*"}
+	}
+
+	return values
+}
+
+func (p *Permissions) AllowsModel(id string) bool {
+	return p == nil || slices.Contains(p.models, "*") || slices.Contains(p.models, id)
+}
+
+func (p *Permissions) AllowsTool(id string) bool {
+	return p == nil || slices.Contains(p.tools, "*") || slices.Contains(p.tools, id)
+}
+
+func (p *Permissions) AllowsFeature(name string) bool {
+	return p == nil || slices.Contains(p.features, "*") || slices.Contains(p.features, name)
+}
+
+// ForRequest resolves the permissions of the caller against the roles of
+// the request's tenant. Guests are granted the model and tool of their link
+// only. Users and groups are only taken from an authenticated identity;
+// everyone else gets the roles that apply to all.
+func ForRequest(r *http.Request, store *config.Store) *Permissions {
+	if l := guest.FromContext(r.Context()); l != nil {
+		p := &Permissions{
+			models: []string{l.Model},
+		}
+
+		if l.Tool != "" {
+			p.tools = []string{l.Tool}
+		}
+
+		return p
+	}
+
+	return Resolve(tenant.Config(r.Context(), store).Roles, auth.Authenticated(r.Context()))
+}
+
+// Filter returns cfg without the models, tools and features p doesn't grant,
+// for serving /config.json.
+func Filter(cfg *config.Config, p *Permissions) *config.Config {
+	if p == nil {
+		return cfg
+	}
+
+	c := *cfg
+
+	c.Models = nil
+
+	for _, m := range cfg.Models {
+		if p.AllowsModel(m.ID) {
+			c.Models = append(c.Models, m)
+		}
+	}
+
+	c.Tools = nil
+
+	for _, t := range cfg.Tools {
+		if p.AllowsTool(t.ID) {
+			c.Tools = append(c.Tools, t)
+		}
+	}
+
+	for _, feature := range config.Features {
+		if !p.AllowsFeature(feature) {
+			c.WithoutFeature(feature)
+		}
+	}
+
+	return &c
+}
+
+// Enforce rejects requests below prefix for tools (<prefix>/v1/mcp/<id>) and
+// feature endpoints the caller isn't granted. Models are checked on the
+// request body by the API proxy.
+func Enforce(prefix string, store *config.Store, next http.Handler) http.Handler {
+	prefix = strings.TrimRight(prefix, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, prefix)
+
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p := ForRequest(r, store)
+
+		if id, ok := strings.CutPrefix(path, "/v1/mcp/"); ok {
+			id, _, _ = strings.Cut(id, "/")
+
+			if !p.AllowsTool(id) {
+				http.Error(w, "tool not allowed", http.StatusForbidden)
+				return
+			}
+		}
+
+		if feature := FeatureOf(path); feature != "" && !p.AllowsFeature(feature) {
+			http.Error(w, "feature not allowed", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FeatureOf returns the feature behind the API endpoint path (below the
+// prefix), or "".
+func FeatureOf(path string) string {
+	for feature, paths := range featurePaths {
+		if slices.ContainsFunc(paths, func(s string) bool { return path == s || strings.HasPrefix(path, s+"/") }) {
+			return feature
+		}
+	}
+
+	return ""
+}
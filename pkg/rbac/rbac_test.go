@@ -0,0 +1,83 @@
+package rbac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+)
+
+func testStore() *config.Store {
+	return config.NewStore(&config.Config{
+		Roles: []config.Role{
+			{Name: "everyone", Models: []string{"small"}, Features: []string{"tts"}},
+			{Name: "admins", Groups: []string{"admins"}},
+			{Name: "alice", Users: []string{"alice"}, Models: []string{"large"}},
+		},
+	})
+}
+
+func TestForRequestAuthenticated(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(auth.WithUser(r.Context(), &auth.User{ID: "bob", Groups: []string{"admins"}}, auth.SourceLogin))
+
+	p := ForRequest(r, testStore())
+
+	if !p.AllowsModel("large") || !p.AllowsFeature("guests") {
+		t.Error("the admins role was not granted to a signed-in member")
+	}
+}
+
+func TestForRequestUnauthenticated(t *testing.T) {
+	// A guest, or any identity that wasn't authenticated, must not be
+	// matched against users and groups.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(auth.WithUser(r.Context(), &auth.User{ID: "alice", Groups: []string{"admins"}}, auth.SourceGuest))
+
+	p := ForRequest(r, testStore())
+
+	if p.AllowsModel("large") || p.AllowsFeature("guests") {
+		t.Error("roles of users and groups were granted without authentication")
+	}
+
+	if !p.AllowsModel("small") || !p.AllowsFeature("tts") {
+		t.Error("the roles for everyone were not granted")
+	}
+}
+
+func TestForRequestAnonymous(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	// What a client would send to claim a group; only a trusted proxy's
+	// headers become a user, and none ran here.
+	r.Header.Set("X-Forwarded-User", "alice")
+	r.Header.Set("X-Forwarded-Groups", "admins")
+
+	p := ForRequest(r, testStore())
+
+	if p.AllowsModel("large") {
+		t.Error("identity headers granted roles")
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Enforce("/api", testStore(), next)
+
+	for path, want := range map[string]int{
+		"/api/v1/audio/speech":     http.StatusOK,
+		"/api/v1/search":           http.StatusForbidden,
+		"/api/guests":              http.StatusForbidden,
+		"/api/v1/mcp/issues/x":     http.StatusOK,
+		"/api/v1/chat/completions": http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
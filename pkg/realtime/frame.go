@@ -0,0 +1,152 @@
+package realtime
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// WebSocket opcodes (RFC 6455, section 5.2).
+const (
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+// Close codes (RFC 6455, section 7.4.1).
+const (
+	closeGoingAway = 1001
+	closeTooBig    = 1009
+	closeError     = 1011
+)
+
+var errFrameTooBig = errors.New("frame too big")
+
+// frame is a frame as read, passed on unchanged: payloads of clients stay
+// masked.
+type frame struct {
+	opcode byte
+
+	header  []byte
+	payload []byte
+	mask    []byte
+}
+
+// readFrame reads the next frame, refusing payloads larger than max.
+func readFrame(r *bufio.Reader, max int64) (*frame, error) {
+	header := make([]byte, 2, 14)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	f := &frame{opcode: header[0] & 0xf}
+
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+
+		header = append(header, ext...)
+		length = int64(binary.BigEndian.Uint16(ext))
+
+	case 127:
+		ext := make([]byte, 8)
+
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+
+		header = append(header, ext...)
+		length = int64(binary.BigEndian.Uint64(ext) & (1<<63 - 1))
+	}
+
+	if length > max {
+		return nil, errFrameTooBig
+	}
+
+	if masked {
+		f.mask = make([]byte, 4)
+
+		if _, err := io.ReadFull(r, f.mask); err != nil {
+			return nil, err
+		}
+
+		header = append(header, f.mask...)
+	}
+
+	f.header = header
+	f.payload = make([]byte, length)
+
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// data returns the unmasked payload.
+func (f *frame) data() []byte {
+	if f.mask == nil {
+		return f.payload
+	}
+
+	data := make([]byte, len(f.payload))
+
+	for i, b := range f.payload {
+		data[i] = b ^ f.mask[i%4]
+	}
+
+	return data
+}
+
+// encodeFrame builds a final frame; frames sent to the upstream, as a client,
+// must be masked.
+func encodeFrame(opcode byte, payload []byte, masked bool) []byte {
+	buf := []byte{0x80 | opcode}
+
+	var bit byte
+
+	if masked {
+		bit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, bit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, bit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, bit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if !masked {
+		return append(buf, payload...)
+	}
+
+	mask := make([]byte, 4)
+	rand.Read(mask)
+
+	buf = append(buf, mask...)
+
+	for i, b := range payload {
+		buf = append(buf, b^mask[i%4])
+	}
+
+	return buf
+}
+
+// closePayload encodes a close code and reason.
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
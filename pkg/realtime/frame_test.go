@@ -0,0 +1,90 @@
+package realtime
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReadFrame(t *testing.T) {
+	// The examples of RFC 6455, section 5.7.
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		opcode byte
+		want   []byte
+	}{
+		{"unmasked text", []byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}, 0x1, []byte("Hello")},
+		{"masked text", []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, 0x1, []byte("Hello")},
+		{"ping", []byte{0x89, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}, opPing, []byte("Hello")},
+		{"256 bytes", append([]byte{0x82, 0x7e, 0x01, 0x00}, make([]byte, 256)...), 0x2, make([]byte, 256)},
+		{"64 KiB", append([]byte{0x82, 0x7f, 0, 0, 0, 0, 0, 0x01, 0x00, 0x00}, make([]byte, 65536)...), 0x2, make([]byte, 65536)},
+	} {
+		f, err := readFrame(bufio.NewReader(bytes.NewReader(tc.data)), maxFrame)
+
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		if f.opcode != tc.opcode || !bytes.Equal(f.data(), tc.want) {
+			t.Errorf("%s: opcode %x, data %q", tc.name, f.opcode, f.data())
+		}
+
+		// Frames are passed on as they were read.
+		if !bytes.Equal(append(f.header, f.payload...), tc.data) {
+			t.Errorf("%s: frame changed", tc.name)
+		}
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"too big", []byte{0x82, 0x7e, 0x01, 0x00}, errFrameTooBig},
+		{"too big, 64 bit length", []byte{0x82, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, errFrameTooBig},
+		{"truncated header", []byte{0x81}, nil},
+		{"truncated mask", []byte{0x81, 0x85, 0x37}, nil},
+		{"truncated payload", []byte{0x81, 0x05, 0x48}, nil},
+	} {
+		_, err := readFrame(bufio.NewReader(bytes.NewReader(tc.data)), 255)
+
+		if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 65535, 65536} {
+		payload := bytes.Repeat([]byte("x"), n)
+
+		for _, masked := range []bool{false, true} {
+			data := encodeFrame(0x1, payload, masked)
+
+			f, err := readFrame(bufio.NewReader(bytes.NewReader(data)), maxFrame)
+
+			if err != nil {
+				t.Fatalf("%d bytes, masked %v: %v", n, masked, err)
+			}
+
+			if f.opcode != 0x1 || data[0]&0x80 == 0 || (f.mask != nil) != masked || !bytes.Equal(f.data(), payload) {
+				t.Errorf("%d bytes, masked %v: didn't round-trip", n, masked)
+			}
+
+			if masked && n > 0 && bytes.Equal(f.payload, payload) {
+				t.Errorf("%d bytes: payload sent unmasked", n)
+			}
+		}
+	}
+}
+
+func TestClosePayload(t *testing.T) {
+	if got := closePayload(closeGoingAway, "bye"); !bytes.Equal(got, []byte{0x03, 0xe9, 'b', 'y', 'e'}) {
+		t.Errorf("close payload = %x", got)
+	}
+}
@@ -0,0 +1,431 @@
+// Package realtime proxies WebSocket sessions, such as those of the realtime
+// API, frame by frame: it keeps both connections alive with pings, ends
+// sessions after a maximum duration, limits the sessions per caller and
+// passes a close on to the other side.
+package realtime
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// maxFrame bounds the payload of a single frame.
+const maxFrame = 16 << 20
+
+// closeGrace is how long the other side has to answer a close.
+const closeGrace = 5 * time.Second
+
+// writeTimeout bounds writing a frame to the client.
+const writeTimeout = 10 * time.Second
+
+// keepalive is the payload of the pings sent by the proxy; the pongs
+// answering them are not passed on.
+var keepalive = []byte("wingman-keepalive")
+
+// ErrSessionLimit rejects sessions beyond the limit of a caller.
+var ErrSessionLimit = errors.New("too many realtime sessions")
+
+type Config struct {
+	// HandshakeTimeout bounds opening the session upstream.
+	HandshakeTimeout time.Duration
+
+	// PingInterval is how often both sides are pinged; a side that sends
+	// nothing for two intervals is taken as gone.
+	PingInterval time.Duration
+
+	// MaxDuration ends sessions after that long; 0 is unlimited.
+	MaxDuration time.Duration
+
+	// MaxSessions limits the concurrent sessions per caller; 0 is
+	// unlimited.
+	MaxSessions int
+}
+
+// DefaultConfig gives the upstream 10s to accept a session and pings every
+// 30s.
+func DefaultConfig() Config {
+	return Config{
+		HandshakeTimeout: 10 * time.Second,
+		PingInterval:     30 * time.Second,
+	}
+}
+
+// ConfigFromEnv reads REALTIME_HANDSHAKE_TIMEOUT, REALTIME_PING_INTERVAL
+// (0 disables pings), REALTIME_MAX_DURATION and REALTIME_MAX_SESSIONS over
+// the defaults.
+func ConfigFromEnv() Config {
+	c := DefaultConfig()
+
+	c.HandshakeTimeout = env.Duration("REALTIME_HANDSHAKE_TIMEOUT", c.HandshakeTimeout)
+
+	if d, err := time.ParseDuration(os.Getenv("REALTIME_PING_INTERVAL")); err == nil && d >= 0 {
+		c.PingInterval = d
+	}
+
+	c.MaxDuration = env.Duration("REALTIME_MAX_DURATION", c.MaxDuration)
+	c.MaxSessions = env.Int("REALTIME_MAX_SESSIONS", 0)
+
+	return c
+}
+
+type Proxy struct {
+	config Config
+
+	mu       sync.Mutex
+	sessions map[string]int
+}
+
+func New(config Config) *Proxy {
+	return &Proxy{
+		config: config,
+
+		sessions: map[string]int{},
+	}
+}
+
+// IsUpgrade tells whether r opens a WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return r.Method == http.MethodGet && hasToken(r.Header, "Connection", "upgrade") && hasToken(r.Header, "Upgrade", "websocket")
+}
+
+func hasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Serve proxies the WebSocket session r opens for caller. open sends the
+// handshake upstream; answers other than 101 are passed to the client as
+// they are. Errors before the session is established are returned for the
+// caller to report; ErrSessionLimit when caller has too many sessions.
+func (p *Proxy) Serve(w http.ResponseWriter, r *http.Request, caller string, open func(ctx context.Context) (*http.Response, error)) error {
+	if !p.acquire(caller) {
+		return ErrSessionLimit
+	}
+
+	defer p.release(caller)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	handshake := time.AfterFunc(p.config.HandshakeTimeout, cancel)
+
+	resp, err := open(ctx)
+
+	if !handshake.Stop() && err == nil {
+		resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+
+	if err != nil {
+		return fmt.Errorf("realtime handshake: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+
+		return nil
+	}
+
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+
+	if !ok {
+		resp.Body.Close()
+		return errors.New("realtime handshake: upstream connection is not writable")
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+
+	if err != nil {
+		backend.Close()
+		return fmt.Errorf("realtime handshake: %w", err)
+	}
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	resp.Header.Write(brw)
+	brw.WriteString("\r\n")
+
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		backend.Close()
+
+		return nil
+	}
+
+	closing := make(chan struct{}, 2)
+
+	client := &side{name: "client", conn: conn, r: brw.Reader, deadline: conn, closing: closing}
+	upstream := &side{name: "upstream", conn: backend, r: bufio.NewReader(backend), masked: true, closing: closing}
+
+	p.bridge(client, upstream, closing)
+
+	return nil
+}
+
+func (p *Proxy) acquire(caller string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config.MaxSessions > 0 && p.sessions[caller] >= p.config.MaxSessions {
+		return false
+	}
+
+	p.sessions[caller]++
+
+	return true
+}
+
+func (p *Proxy) release(caller string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sessions[caller]--; p.sessions[caller] <= 0 {
+		delete(p.sessions, caller)
+	}
+}
+
+// side is one connection of a session.
+type side struct {
+	name string
+
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+
+	// masked is set for the upstream, to which the proxy is the client.
+	masked bool
+
+	// deadline bounds writes to connections that support it.
+	deadline net.Conn
+
+	mu sync.Mutex
+
+	// closed is set once a close was sent to this side, which is then
+	// signaled on closing.
+	closed  bool
+	closing chan struct{}
+
+	// seen is when this side last sent a frame.
+	seen atomic.Int64
+}
+
+func (s *side) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deadline != nil {
+		s.deadline.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+
+	_, err := s.conn.Write(data)
+
+	return err
+}
+
+// forward passes a frame read from the other side on, noting a close.
+func (s *side) forward(f *frame) error {
+	if f.opcode == opClose {
+		s.markClosed()
+	}
+
+	return s.write(append(f.header, f.payload...))
+}
+
+func (s *side) send(opcode byte, payload []byte) error {
+	return s.write(encodeFrame(opcode, payload, s.masked))
+}
+
+// close sends a close unless one was sent already.
+func (s *side) close(code int, reason string) {
+	if s.markClosed() {
+		s.send(opClose, closePayload(code, reason))
+	}
+}
+
+// markClosed notes a close sent to this side, reporting whether it is the
+// first.
+func (s *side) markClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.closed = true
+
+	select {
+	case s.closing <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+func (s *side) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// bridge passes frames between client and upstream until the session ends.
+func (p *Proxy) bridge(client, upstream *side, closing <-chan struct{}) {
+	now := time.Now().UnixNano()
+
+	client.seen.Store(now)
+	upstream.seen.Store(now)
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		pump(client, upstream)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		pump(upstream, client)
+		done <- struct{}{}
+	}()
+
+	interval := p.config.PingInterval
+
+	var ping <-chan time.Time
+
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		ping = ticker.C
+	}
+
+	var expired <-chan time.Time
+
+	if p.config.MaxDuration > 0 {
+		timer := time.NewTimer(p.config.MaxDuration)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	var grace <-chan time.Time
+
+	shutdown := func() {
+		client.conn.Close()
+		upstream.conn.Close()
+	}
+
+	for running := 2; running > 0; {
+		select {
+		case <-done:
+			// One direction ended; the other follows once the
+			// connections are closed.
+			running--
+			shutdown()
+
+		case <-ping:
+			for _, s := range []*side{client, upstream} {
+				if time.Since(time.Unix(0, s.seen.Load())) > 2*interval {
+					slog.Warn("realtime: stopped responding", "side", s.name)
+
+					client.close(closeGoingAway, "connection timed out")
+					upstream.close(closeGoingAway, "connection timed out")
+					shutdown()
+
+					break
+				}
+
+				s.send(opPing, keepalive)
+			}
+
+		case <-expired:
+			client.close(closeGoingAway, "session time limit reached")
+			upstream.close(closeGoingAway, "session time limit reached")
+
+		case <-closing:
+			// A side was told to close and gets time to answer.
+			if grace == nil {
+				grace = time.After(closeGrace)
+			}
+
+		case <-grace:
+			shutdown()
+		}
+	}
+}
+
+// pump passes the frames of from on to to. When from goes away without a
+// close, to is told so.
+func pump(from, to *side) {
+	for {
+		f, err := readFrame(from.r, maxFrame)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, errFrameTooBig):
+				from.close(closeTooBig, "frame too big")
+				to.close(closeError, "frame too big")
+			case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+				to.close(closeGoingAway, from.name+" went away")
+			default:
+				to.close(closeError, from.name+" connection failed")
+			}
+
+			return
+		}
+
+		from.seen.Store(time.Now().UnixNano())
+
+		switch f.opcode {
+		case opPong:
+			if string(f.data()) == string(keepalive) {
+				continue
+			}
+
+		case opClose:
+			// A close answering one the proxy sent isn't passed on.
+			if !to.isClosed() {
+				if err := to.forward(f); err != nil {
+					return
+				}
+			}
+
+			// The answer to a close is the last frame.
+			if from.isClosed() {
+				return
+			}
+
+			continue
+		}
+
+		if err := to.forward(f); err != nil {
+			from.close(closeGoingAway, to.name+" went away")
+			return
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package realtime
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsUpgrade(t *testing.T) {
+	for _, tc := range []struct {
+		method     string
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{http.MethodGet, "Upgrade", "websocket", true},
+		{http.MethodGet, "keep-alive, upgrade", "WebSocket", true},
+		{http.MethodPost, "Upgrade", "websocket", false},
+		{http.MethodGet, "keep-alive", "websocket", false},
+		{http.MethodGet, "Upgrade", "h2c", false},
+	} {
+		r := httptest.NewRequest(tc.method, "/v1/realtime", nil)
+		r.Header.Set("Connection", tc.connection)
+		r.Header.Set("Upgrade", tc.upgrade)
+
+		if got := IsUpgrade(r); got != tc.want {
+			t.Errorf("%s with %q, %q: upgrade = %v", tc.method, tc.connection, tc.upgrade, got)
+		}
+	}
+}
+
+// echo is an upstream answering text frames with their text in upper case
+// and a close with a close.
+func echo(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		defer conn.Close()
+
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+
+		for {
+			f, err := readFrame(brw.Reader, maxFrame)
+
+			if err != nil {
+				return
+			}
+
+			if f.mask == nil {
+				t.Error("the proxy sent an unmasked frame upstream")
+			}
+
+			switch f.opcode {
+			case 0x1:
+				conn.Write(encodeFrame(0x1, []byte(strings.ToUpper(string(f.data()))), false))
+			case opClose:
+				conn.Write(encodeFrame(opClose, f.data(), false))
+				return
+			}
+		}
+	}))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// proxy serves the realtime sessions of alice through p to upstream.
+func proxy(t *testing.T, p *Proxy, upstream string) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := p.Serve(w, r, "alice", func(ctx context.Context) (*http.Response, error) {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream, nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+
+			return http.DefaultTransport.RoundTrip(req)
+		})
+
+		if errors.Is(err, ErrSessionLimit) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		}
+	}))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// dial opens a session on the proxy, returning the status of the handshake.
+func dial(t *testing.T, url string) (net.Conn, *bufio.Reader, int) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /v1/realtime HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, br, resp.StatusCode
+}
+
+func TestSession(t *testing.T) {
+	p := New(DefaultConfig())
+	s := proxy(t, p, echo(t).URL)
+
+	conn, br, status := dial(t, s.URL)
+
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", status)
+	}
+
+	conn.Write(encodeFrame(0x1, []byte("hello"), true))
+
+	if f, err := readFrame(br, maxFrame); err != nil || f.opcode != 0x1 || string(f.data()) != "HELLO" {
+		t.Fatalf("answer = %v, %v", f, err)
+	}
+
+	// The close of the client reaches the upstream, and its answer the
+	// client.
+	conn.Write(encodeFrame(opClose, closePayload(1000, "done"), true))
+
+	f, err := readFrame(br, maxFrame)
+
+	if err != nil || f.opcode != opClose || string(f.data()[2:]) != "done" {
+		t.Fatalf("close = %v, %v", f, err)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	p := New(Config{HandshakeTimeout: 5 * time.Second, MaxSessions: 1})
+	s := proxy(t, p, echo(t).URL)
+
+	if _, _, status := dial(t, s.URL); status != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", status)
+	}
+
+	if _, _, status := dial(t, s.URL); status != http.StatusTooManyRequests {
+		t.Errorf("second session: status = %d, want 429", status)
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	p := New(Config{HandshakeTimeout: 5 * time.Second, MaxDuration: 50 * time.Millisecond})
+	s := proxy(t, p, echo(t).URL)
+
+	_, br, _ := dial(t, s.URL)
+
+	// Both sides are told the session ended; the client hears it first
+	// hand or as the upstream's answer.
+	f, err := readFrame(br, maxFrame)
+
+	if err != nil || f.opcode != opClose || !strings.Contains(string(f.data()), "session time limit reached") {
+		t.Fatalf("close = %v, %v", f, err)
+	}
+}
+
+func TestUpstreamRefuses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	s := proxy(t, New(DefaultConfig()), upstream.URL)
+
+	// Answers other than 101 are passed on as they are.
+	if _, _, status := dial(t, s.URL); status != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", status)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("REALTIME_PING_INTERVAL", "0")
+	t.Setenv("REALTIME_MAX_DURATION", "1h")
+	t.Setenv("REALTIME_MAX_SESSIONS", "3")
+
+	c := ConfigFromEnv()
+
+	if c.HandshakeTimeout != 10*time.Second || c.PingInterval != 0 || c.MaxDuration != time.Hour || c.MaxSessions != 3 {
+		t.Errorf("config = %+v", c)
+	}
+}
@@ -0,0 +1,56 @@
+package redact
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry records the redactions of a request.
+type Entry struct {
+	Time time.Time `json:"time"`
+
+	Tenant string `json:"tenant,omitempty"`
+	User   string `json:"user,omitempty"`
+	Device string `json:"device,omitempty"`
+
+	Path  string `json:"path"`
+	Model string `json:"model,omitempty"`
+
+	Redactions []Redaction `json:"redactions"`
+}
+
+// Audit appends entries to a file, one JSON object per line.
+type Audit struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func OpenAudit(path string) (*Audit, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Audit{file: f}, nil
+}
+
+func (a *Audit) Write(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(e)
+
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err = a.file.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,249 @@
+// Package redact masks personal data, e.g. email addresses and credit card
+// numbers, in prompts before they leave for the model provider, and keeps an
+// audit trail of what was masked.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Rule masks the matches of a pattern the check accepts with [NAME].
+type Rule struct {
+	Name string
+
+	pattern *regexp.Regexp
+	check   func(string) bool
+}
+
+// builtin are the rules that can be enabled by name.
+var builtin = map[string]Rule{
+	"email":       {Name: "email", pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	"credit_card": {Name: "credit_card", pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), check: luhn},
+	"iban":        {Name: "iban", pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}\b`), check: iban},
+	"ipv4":        {Name: "ipv4", pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+}
+
+// DefaultRules are enabled unless configured otherwise.
+var DefaultRules = []string{"email", "credit_card"}
+
+// Builtin returns the built-in rules of the given names.
+func Builtin(names ...string) ([]Rule, error) {
+	var rules []Rule
+
+	for _, name := range names {
+		r, ok := builtin[name]
+
+		if !ok {
+			return nil, fmt.Errorf("redact: unknown rule %q (want %s)", name, strings.Join(ruleNames(), ", "))
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// Pattern returns a rule masking the matches of a regular expression.
+func Pattern(name, expr string) (Rule, error) {
+	re, err := regexp.Compile(expr)
+
+	if err != nil {
+		return Rule{}, fmt.Errorf("redact: rule %q: %w", name, err)
+	}
+
+	return Rule{Name: name, pattern: re}, nil
+}
+
+// Redaction is a masked value; the value itself is only kept as the
+// beginning of its SHA-256, to match known values in audits.
+type Redaction struct {
+	Rule        string `json:"rule"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type Redactor struct {
+	rules []Rule
+	audit *Audit
+}
+
+// FromEnv returns the redactor with REDACTION=true, or nil otherwise. It
+// masks with the built-in rules of REDACT_RULES (default email and
+// credit_card) and those of REDACT_PATTERNS, a file of NAME=regex lines,
+// and audits to REDACT_AUDIT_PATH.
+func FromEnv() (*Redactor, error) {
+	if !env.Bool("REDACTION") {
+		return nil, nil
+	}
+
+	names := DefaultRules
+
+	if list := env.List("REDACT_RULES"); len(list) > 0 {
+		names = list
+	}
+
+	rules, err := Builtin(names...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if path := os.Getenv("REDACT_PATTERNS"); path != "" {
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			name, expr, ok := strings.Cut(line, "=")
+
+			if !ok {
+				return nil, fmt.Errorf("%s: %q is not NAME=regex", path, line)
+			}
+
+			rule, err := Pattern(strings.TrimSpace(name), strings.TrimSpace(expr))
+
+			if err != nil {
+				return nil, err
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	var audit *Audit
+
+	if path := os.Getenv("REDACT_AUDIT_PATH"); path != "" {
+		audit, err = OpenAudit(path)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return New(rules, audit), nil
+}
+
+// New masks with rules, and appends what was masked to audit when set.
+func New(rules []Rule, audit *Audit) *Redactor {
+	return &Redactor{
+		rules: rules,
+		audit: audit,
+	}
+}
+
+// Redact masks the matches of the rules in text.
+func (r *Redactor) Redact(text string) (string, []Redaction) {
+	var redactions []Redaction
+
+	for _, rule := range r.rules {
+		mask := "[" + strings.ToUpper(rule.Name) + "]"
+
+		text = rule.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if rule.check != nil && !rule.check(match) {
+				return match
+			}
+
+			redactions = append(redactions, Redaction{Rule: rule.Name, Fingerprint: fingerprint(match)})
+
+			return mask
+		})
+	}
+
+	return text, redactions
+}
+
+func (r *Redactor) Audit() *Audit {
+	return r.audit
+}
+
+func fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:6])
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+
+		return -1
+	}, s)
+}
+
+// luhn validates card numbers, which keeps other long numbers unmasked.
+func luhn(s string) bool {
+	d := digits(s)
+
+	if len(d) < 13 || len(d) > 19 {
+		return false
+	}
+
+	sum := 0
+
+	for i := range d {
+		n := int(d[len(d)-1-i] - '0')
+
+		if i%2 == 1 {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+
+		sum += n
+	}
+
+	return sum%10 == 0
+}
+
+// iban validates the check digits of an IBAN (ISO 13616, mod 97).
+func iban(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+
+	var b strings.Builder
+
+	for _, c := range s[4:] + s[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			fmt.Fprintf(&b, "%d", c-'A'+10)
+		default:
+			return false
+		}
+	}
+
+	n, ok := new(big.Int).SetString(b.String(), 10)
+
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func ruleNames() []string {
+	var names []string
+
+	for name := range builtin {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
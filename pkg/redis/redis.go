@@ -0,0 +1,244 @@
+// Package redis is a minimal Redis client speaking RESP2 over a single
+// connection, enough for the shared state of several instances.
+package redis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeout bounds every command including the connection setup.
+const timeout = 5 * time.Second
+
+// Error is an error reply of the server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+type Client struct {
+	addr string
+	host string
+	tls  bool
+
+	username string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// New returns a client of the server at rawURL
+// (redis://[user:password@]host[:port][/db], or rediss:// for TLS). It
+// connects on first use and reconnects after errors.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, errors.New("redis: unsupported url scheme " + u.Scheme)
+	}
+
+	c := &Client{
+		addr: u.Host,
+		host: u.Hostname(),
+		tls:  u.Scheme == "rediss",
+	}
+
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, errors.New("redis: invalid database " + db)
+		}
+	}
+
+	return c, nil
+}
+
+// Do sends a command and returns its reply: a string, an int64, nil, or a
+// []any of those.
+func (c *Client) Do(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.do(args...)
+
+	var rerr Error
+
+	if err != nil && !errors.As(err, &rerr) {
+		// The connection is in an unknown state.
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return reply, err
+}
+
+func (c *Client) connect() error {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	var err error
+
+	if c.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, &tls.Config{ServerName: c.host})
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+
+	var setup [][]string
+
+	if c.password != "" {
+		if c.username != "" {
+			setup = append(setup, []string{"AUTH", c.username, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+
+	for _, args := range setup {
+		if _, err := c.do(args...); err != nil {
+			conn.Close()
+			c.conn = nil
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) do(args ...string) (any, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+func (c *Client) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+
+	case '-':
+		return nil, Error(line[1:])
+
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		data := make([]byte, n+2)
+
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+
+		return string(data[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]any, n)
+
+		for i := range items {
+			item, err := c.read()
+
+			var rerr Error
+
+			if err != nil && !errors.As(err, &rerr) {
+				return nil, err
+			}
+
+			if err != nil {
+				item = rerr
+			}
+
+			items[i] = item
+		}
+
+		return items, nil
+	}
+
+	return nil, errors.New("redis: invalid reply " + line)
+}
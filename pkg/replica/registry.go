@@ -0,0 +1,91 @@
+package replica
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxReplicas bounds the nodes a hub keeps track of.
+const maxReplicas = 1000
+
+// forget is how long a hub keeps nodes that stopped reporting.
+const forget = 7 * 24 * time.Hour
+
+// Registry is the hub's view of the nodes reporting to it.
+type Registry struct {
+	mu    sync.Mutex
+	nodes map[string]*Entry
+}
+
+// Entry is the last report of a node.
+type Entry struct {
+	Report
+
+	// Identity is who authenticated the report, Address where it came from.
+	Identity string `json:"identity,omitempty"`
+	Address  string `json:"address,omitempty"`
+
+	Seen time.Time `json:"seen"`
+
+	// Stale is set when the node missed three reports.
+	Stale bool `json:"stale"`
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		nodes: map[string]*Entry{},
+	}
+}
+
+// Record stores a report. Reports of new nodes are dropped when the registry
+// is full.
+func (r *Registry) Record(report Report, identity, address string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for id, e := range r.nodes {
+		if now.Sub(e.Seen) > forget {
+			delete(r.nodes, id)
+		}
+	}
+
+	if _, ok := r.nodes[report.ID]; !ok && len(r.nodes) >= maxReplicas {
+		return false
+	}
+
+	r.nodes[report.ID] = &Entry{
+		Report: report,
+
+		Identity: identity,
+		Address:  address,
+
+		Seen: now,
+	}
+
+	return true
+}
+
+// List returns the nodes ordered by id.
+func (r *Registry) List() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]Entry, 0, len(r.nodes))
+
+	for _, e := range r.nodes {
+		entry := *e
+		entry.Stale = entry.Interval > 0 && time.Since(entry.Seen) > 3*time.Duration(entry.Interval)*time.Second
+
+		result = append(result, entry)
+	}
+
+	slices.SortFunc(result, func(a, b Entry) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return result
+}
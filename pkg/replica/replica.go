@@ -0,0 +1,373 @@
+// Package replica runs an instance as a lightweight node of a hub instance
+// (e.g. in a branch office): it serves the frontend with the hub's
+// configuration, cached on disk, proxies the API to the hub and reports its
+// health there.
+package replica
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// maxConfigSize bounds the configuration fetched from the hub.
+const maxConfigSize = 4 << 20
+
+// Node is this instance running as a replica of a hub.
+type Node struct {
+	client *http.Client
+
+	// URL is the hub's API base, e.g. https://chat.example.com/api.
+	URL *url.URL
+
+	// Token authenticates this node at the hub (an API key or JWT).
+	Token string
+
+	// ID names this node in the hub's replica list.
+	ID string
+
+	// Version is reported to the hub.
+	Version string
+
+	cache    string
+	started  time.Time
+	interval time.Duration
+
+	requests atomic.Uint64
+	errors   atomic.Uint64
+	latency  atomic.Int64
+
+	mu      sync.Mutex
+	config  []byte
+	synced  time.Time
+	lastErr string
+}
+
+// NewNode returns a node of the hub at rawURL. The hub's configuration is
+// cached in the file cache, so the node can start while the hub is down.
+func NewNode(rawURL, token, id, cache string) (*Node, error) {
+	u, err := url.Parse(strings.TrimRight(rawURL, "/"))
+
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("replica: invalid hub url " + rawURL)
+	}
+
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+
+	return &Node{
+		client: http.DefaultClient,
+
+		URL:   u,
+		Token: token,
+
+		ID: id,
+
+		cache:   cache,
+		started: time.Now().UTC(),
+	}, nil
+}
+
+// FromEnv returns the node of the hub at HUB_URL, authenticated with
+// HUB_TOKEN and named REPLICA_ID (default the hostname), which caches the
+// configuration in REPLICA_CACHE (default replica.json) and syncs every
+// REPLICA_INTERVAL (default 1m) until ctx is done. It returns nil when
+// HUB_URL is not set.
+func FromEnv(ctx context.Context, store *config.Store, version string) (*Node, error) {
+	u := os.Getenv("HUB_URL")
+
+	if u == "" {
+		return nil, nil
+	}
+
+	n, err := NewNode(u, os.Getenv("HUB_TOKEN"), os.Getenv("REPLICA_ID"), env.String("REPLICA_CACHE", "replica.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	n.Version = version
+	n.Watch(ctx, store, env.Duration("REPLICA_INTERVAL", time.Minute))
+
+	return n, nil
+}
+
+// Watch applies the cached configuration, then fetches the hub's
+// configuration and reports to the hub every interval until ctx is done.
+// When the hub is unreachable the last configuration is kept.
+func (n *Node) Watch(ctx context.Context, store *config.Store, interval time.Duration) {
+	n.interval = interval
+
+	if data, err := os.ReadFile(n.cache); err == nil {
+		if err := n.apply(store, data); err != nil {
+			slog.Error("replica: cache", "path", n.cache, "error", err)
+		}
+	}
+
+	go func() {
+		for ctx.Err() == nil {
+			err := n.sync(ctx, store)
+
+			if err == nil {
+				err = n.report(ctx)
+			}
+
+			n.mu.Lock()
+			n.lastErr = ""
+
+			if err != nil && ctx.Err() == nil {
+				n.lastErr = err.Error()
+				slog.Warn("replica: hub", "hub", n.URL.String(), "error", err)
+			}
+
+			n.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+func (n *Node) sync(ctx context.Context, store *config.Store) error {
+	resp, err := n.do(ctx, http.MethodGet, "/replica/config", nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("fetching config failed (" + resp.Status + ")")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize))
+
+	if err != nil {
+		return err
+	}
+
+	if err := n.apply(store, data); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.synced = time.Now().UTC()
+	n.mu.Unlock()
+
+	if err := writeFile(n.cache, data); err != nil {
+		slog.Error("replica: cache", "path", n.cache, "error", err)
+	}
+
+	return nil
+}
+
+// apply publishes the hub's configuration. The hub only sends what it serves
+// as /config.json, so the node's own server-side settings (flags, roles,
+// network rules) are kept.
+func (n *Node) apply(store *config.Store, data []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if bytes.Equal(data, n.config) {
+		return nil
+	}
+
+	var hub config.Config
+
+	if err := json.Unmarshal(data, &hub); err != nil {
+		return err
+	}
+
+	store.Update("replica: "+n.URL.String(), func(c *config.Config) {
+		flags, roles, network := c.Flags, c.Roles, c.Network
+
+		*c = hub
+
+		c.Flags = flags
+		c.Roles = roles
+		c.Network = network
+	})
+
+	n.config = data
+
+	return nil
+}
+
+// Loaded reports whether a configuration of the hub is in use, fetched or
+// cached.
+func (n *Node) Loaded(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.config == nil {
+		return errors.New("no configuration from the hub yet")
+	}
+
+	return nil
+}
+
+// Report is what a node sends to the hub and serves at /replica/status.
+type Report struct {
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"`
+
+	Started  time.Time `json:"started"`
+	Interval int       `json:"interval"`
+
+	// Requests and Errors (5xx responses) count since Started; Latency is
+	// their mean duration in milliseconds.
+	Requests uint64  `json:"requests"`
+	Errors   uint64  `json:"errors"`
+	Latency  float64 `json:"latency"`
+
+	// Synced is when the configuration was last fetched from the hub, Error
+	// the last problem talking to it.
+	Synced time.Time `json:"synced,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func (n *Node) Report() Report {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	r := Report{
+		ID:      n.ID,
+		Version: n.Version,
+
+		Started:  n.started,
+		Interval: int(n.interval.Seconds()),
+
+		Requests: n.requests.Load(),
+		Errors:   n.errors.Load(),
+
+		Synced: n.synced,
+		Error:  n.lastErr,
+	}
+
+	if r.Requests > 0 {
+		r.Latency = float64(n.latency.Load()) / float64(time.Millisecond) / float64(r.Requests)
+	}
+
+	return r
+}
+
+func (n *Node) report(ctx context.Context) error {
+	data, _ := json.Marshal(n.Report())
+
+	resp, err := n.do(ctx, http.MethodPost, "/replica/heartbeat", bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("reporting failed (" + resp.Status + ")")
+	}
+
+	return nil
+}
+
+func (n *Node) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, n.URL.String()+path, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return n.client.Do(req)
+}
+
+// Track counts the requests next serves for the node's report.
+func (n *Node) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		n.requests.Add(1)
+		n.latency.Add(int64(time.Since(start)))
+
+		if rw.status >= 500 {
+			n.errors.Add(1)
+		}
+	})
+}
+
+// HandleStatus serves the node's report locally.
+func (n *Node) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n.Report())
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writeFile replaces path atomically, so a crash doesn't leave a truncated
+// cache behind.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".replica-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/sigv4"
+)
+
+var _ Provider = (*AWS)(nil)
+
+// AWS reads secrets of AWS Secrets Manager by name or ARN.
+type AWS struct {
+	client *http.Client
+
+	region   string
+	endpoint string
+
+	credentials sigv4.Credentials
+}
+
+// NewAWS reads AWS_REGION (or AWS_DEFAULT_REGION), the credentials in
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, and
+// AWS_ENDPOINT_URL_SECRETS_MANAGER to use another endpoint.
+func NewAWS(client *http.Client) *AWS {
+	region := os.Getenv("AWS_REGION")
+
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	return &AWS{
+		client: client,
+
+		region:   region,
+		endpoint: strings.TrimRight(os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"), "/"),
+
+		credentials: sigv4.FromEnv(),
+	}
+}
+
+func (a *AWS) Secret(ctx context.Context, ref string) (string, error) {
+	if a.credentials.AccessKey == "" {
+		return "", errors.New("AWS_ACCESS_KEY_ID is not set")
+	}
+
+	region := a.region
+
+	// ARNs name their region: arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	if parts := strings.Split(ref, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+
+	if region == "" {
+		return "", errors.New("AWS_REGION is not set")
+	}
+
+	endpoint := a.endpoint
+
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": ref})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", strings.NewReader(string(body)))
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	sigv4.Sign(req, body, a.credentials, region, "secretsmanager", time.Now())
+
+	var result struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary string  `json:"SecretBinary"`
+	}
+
+	if err := send(a.client, req, &result); err != nil {
+		return "", err
+	}
+
+	if result.SecretString != nil {
+		return *result.SecretString, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(result.SecretBinary)
+	return string(data), err
+}
@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Provider = (*Azure)(nil)
+
+// Azure reads secrets of Azure Key Vault, referred to as <vault>/<name> or
+// <vault>/<name>/<version>; vault is a name or, for other clouds, a host.
+type Azure struct {
+	client *http.Client
+
+	authority string
+	tenant    string
+	clientID  string
+	secret    string
+	tokenFile string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewAzure authenticates with AZURE_TENANT_ID, AZURE_CLIENT_ID and
+// AZURE_CLIENT_SECRET or AZURE_FEDERATED_TOKEN_FILE (workload identity),
+// and with the managed identity (AZURE_CLIENT_ID picks a user-assigned one)
+// otherwise.
+func NewAzure(client *http.Client) *Azure {
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+
+	if authority == "" {
+		authority = "https://login.microsoftonline.com"
+	}
+
+	return &Azure{
+		client: client,
+
+		authority: strings.TrimRight(authority, "/"),
+		tenant:    os.Getenv("AZURE_TENANT_ID"),
+		clientID:  os.Getenv("AZURE_CLIENT_ID"),
+		secret:    os.Getenv("AZURE_CLIENT_SECRET"),
+		tokenFile: os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+	}
+}
+
+func (a *Azure) Secret(ctx context.Context, ref string) (string, error) {
+	vault, name, ok := strings.Cut(strings.Trim(ref, "/"), "/")
+
+	if !ok || vault == "" || name == "" {
+		return "", fmt.Errorf("want <vault>/<name>, got %q", ref)
+	}
+
+	if !strings.Contains(vault, ".") {
+		vault += ".vault.azure.net"
+	}
+
+	token, err := a.login(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+vault+"/secrets/"+name+"?api-version=7.4", nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var result struct {
+		Value string `json:"value"`
+	}
+
+	if err := send(a.client, req, &result); err != nil {
+		return "", err
+	}
+
+	return result.Value, nil
+}
+
+// login returns an access token for Key Vault, getting a new one when it is
+// about to expire.
+func (a *Azure) login(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expires) {
+		return a.token, nil
+	}
+
+	req, err := a.tokenRequest(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+
+		// The managed identity endpoint returns a string.
+		ExpiresIn any `json:"expires_in"`
+	}
+
+	if err := send(a.client, req, &result); err != nil {
+		return "", fmt.Errorf("login: %w", err)
+	}
+
+	expires, _ := strconv.Atoi(fmt.Sprint(result.ExpiresIn))
+
+	a.token = result.AccessToken
+	a.expires = time.Now().Add(time.Duration(expires)*time.Second - 5*time.Minute)
+
+	return a.token, nil
+}
+
+func (a *Azure) tokenRequest(ctx context.Context) (*http.Request, error) {
+	const resource = "https://vault.azure.net"
+
+	if a.tenant == "" || (a.secret == "" && a.tokenFile == "") {
+		query := url.Values{
+			"api-version": {"2018-02-01"},
+			"resource":    {resource},
+		}
+
+		if a.clientID != "" {
+			query.Set("client_id", a.clientID)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Metadata", "true")
+
+		return req, nil
+	}
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {a.clientID},
+		"scope":      {resource + "/.default"},
+	}
+
+	if a.secret != "" {
+		form.Set("client_secret", a.secret)
+	} else {
+		// The federated token is renewed in place, so it is read each time.
+		assertion, err := os.ReadFile(a.tokenFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.authority+"/"+a.tenant+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return req, nil
+}
@@ -0,0 +1,221 @@
+// Package secrets reads secrets from HashiCorp Vault, AWS Secrets Manager and
+// Azure Key Vault, so they need not live in plain environment variables.
+// Environment variables hold references instead, which are resolved on
+// startup:
+//
+//	vault://<path>#<field>         e.g. vault://secret/data/wingman#token
+//	awssm://<name or arn>[#<key>]  e.g. awssm://prod/wingman#token
+//	azurekv://<vault>/<name>       e.g. azurekv://wingman-kv/platform-token
+//
+// The #key picks a field of a secret holding JSON.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// timeout bounds a request to a secrets manager.
+const timeout = 30 * time.Second
+
+// Provider reads the secret at ref, the part of a reference after the scheme
+// and before the #.
+type Provider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+type Resolver struct {
+	providers map[string]Provider
+}
+
+// FromEnv configures the providers from their usual environment variables:
+// VAULT_ADDR and friends, AWS_REGION and the AWS credentials, and
+// AZURE_TENANT_ID and friends or a managed identity.
+func FromEnv() *Resolver {
+	client := &http.Client{Timeout: timeout}
+
+	return &Resolver{
+		providers: map[string]Provider{
+			"vault":   NewVault(client),
+			"awssm":   NewAWS(client),
+			"azurekv": NewAzure(client),
+		},
+	}
+}
+
+// IsRef reports whether value is a reference to a secret.
+func IsRef(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	return ok && slices.Contains([]string{"vault", "awssm", "azurekv"}, scheme)
+}
+
+// Get reads the secret value refers to.
+func (r *Resolver) Get(ctx context.Context, value string) (string, error) {
+	scheme, rest, ok := strings.Cut(value, "://")
+
+	p := r.providers[scheme]
+
+	if !ok || p == nil {
+		return "", fmt.Errorf("secrets: unknown reference %q", value)
+	}
+
+	ref, key, _ := strings.Cut(rest, "#")
+
+	secret, err := p.Secret(ctx, ref)
+
+	if err != nil {
+		return "", fmt.Errorf("secrets: %s://%s: %w", scheme, ref, err)
+	}
+
+	if key == "" {
+		return secret, nil
+	}
+
+	var fields map[string]any
+
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secrets: %s://%s: not a JSON object", scheme, ref)
+	}
+
+	return field(fields, key)
+}
+
+func field(fields map[string]any, key string) (string, error) {
+	v, ok := fields[key]
+
+	if !ok {
+		return "", fmt.Errorf("secrets: no field %q", key)
+	}
+
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// Resolve replaces the environment variables holding a reference with the
+// secret. Variables named in files are expected to name a file, e.g. a
+// certificate; their secret is written to a file in dir instead, which is
+// written again every interval until ctx is done, so renewed secrets are
+// picked up by whatever reads the file.
+func (r *Resolver) Resolve(ctx context.Context, files []string, dir string, interval time.Duration) error {
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+
+		if !IsRef(value) {
+			continue
+		}
+
+		secret, err := r.Get(ctx, value)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if !slices.Contains(files, name) {
+			os.Setenv(name, secret)
+			continue
+		}
+
+		if dir == "" {
+			d, err := os.MkdirTemp("", "wingman-secrets")
+
+			if err != nil {
+				return err
+			}
+
+			dir = d
+		}
+
+		path := filepath.Join(dir, strings.ToLower(name))
+
+		if err := writeFile(path, secret); err != nil {
+			return err
+		}
+
+		os.Setenv(name, path)
+
+		go r.renew(ctx, name, value, path, secret, interval)
+	}
+
+	return nil
+}
+
+func (r *Resolver) renew(ctx context.Context, name, value, path, secret string, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		s, err := r.Get(ctx, value)
+
+		if err != nil {
+			// Keep the file we have.
+			slog.Error("secrets: renew", "name", name, "error", err)
+			continue
+		}
+
+		if s == secret {
+			continue
+		}
+
+		if err := writeFile(path, s); err != nil {
+			slog.Error("secrets: renew", "name", name, "error", err)
+			continue
+		}
+
+		secret = s
+	}
+}
+
+// writeFile replaces path atomically, so readers never see a partial secret.
+func writeFile(path, secret string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, []byte(secret), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+type statusError int
+
+func (s statusError) Error() string {
+	return fmt.Sprintf("status %d", int(s))
+}
+
+// send does req and decodes the JSON response into result.
+func send(client *http.Client, req *http.Request, result any) error {
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("%w: %s", statusError(resp.StatusCode), strings.TrimSpace(string(data)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
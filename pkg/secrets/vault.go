@@ -0,0 +1,173 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Provider = (*Vault)(nil)
+
+// Vault reads secrets of the KV engines (version 1 and 2) of HashiCorp Vault.
+// Vault secrets hold several fields, so references name one: the path of
+// a KV version 2 secret includes its data/ segment, as with the HTTP API.
+type Vault struct {
+	client *http.Client
+
+	addr      string
+	namespace string
+
+	// role logs in with Kubernetes auth at mount, presenting the service
+	// account token in jwtFile, when there is no static token.
+	role    string
+	mount   string
+	jwtFile string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewVault reads VAULT_ADDR, VAULT_NAMESPACE and VAULT_TOKEN, or VAULT_ROLE,
+// VAULT_AUTH_PATH (default kubernetes) and VAULT_JWT_FILE (default the pod's
+// service account token) to log in with Kubernetes auth.
+func NewVault(client *http.Client) *Vault {
+	jwtFile := os.Getenv("VAULT_JWT_FILE")
+
+	if jwtFile == "" {
+		jwtFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	}
+
+	mount := os.Getenv("VAULT_AUTH_PATH")
+
+	if mount == "" {
+		mount = "kubernetes"
+	}
+
+	return &Vault{
+		client: client,
+
+		addr:      strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+
+		role:    os.Getenv("VAULT_ROLE"),
+		mount:   strings.Trim(mount, "/"),
+		jwtFile: jwtFile,
+
+		token: os.Getenv("VAULT_TOKEN"),
+	}
+}
+
+func (v *Vault) Secret(ctx context.Context, ref string) (string, error) {
+	if v.addr == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+
+	var result struct {
+		Data map[string]any `json:"data"`
+	}
+
+	err := v.do(ctx, http.MethodGet, "/v1/"+strings.Trim(ref, "/"), nil, &result)
+
+	var status statusError
+
+	if errors.As(err, &status) && status == http.StatusForbidden && v.role != "" {
+		// The login may have been revoked; log in again once.
+		v.mu.Lock()
+		v.expires = time.Time{}
+		v.mu.Unlock()
+
+		err = v.do(ctx, http.MethodGet, "/v1/"+strings.Trim(ref, "/"), nil, &result)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	fields := result.Data
+
+	// KV version 2 nests the fields next to their metadata.
+	if data, ok := fields["data"].(map[string]any); ok {
+		if _, ok := fields["metadata"]; ok {
+			fields = data
+		}
+	}
+
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+// login returns the token to use, logging in again when it is about to
+// expire.
+func (v *Vault) login(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.role == "" || (v.token != "" && time.Now().Before(v.expires)) {
+		return v.token, nil
+	}
+
+	jwt, err := os.ReadFile(v.jwtFile)
+
+	if err != nil {
+		return "", err
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"role": v.role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+
+	var result struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+
+	if err := v.request(ctx, http.MethodPost, "/v1/auth/"+v.mount+"/login", "", body, &result); err != nil {
+		return "", fmt.Errorf("login: %w", err)
+	}
+
+	v.token = result.Auth.ClientToken
+
+	// Log in again well before the lease ends.
+	v.expires = time.Now().Add(time.Duration(result.Auth.LeaseDuration) * time.Second * 3 / 4)
+
+	return v.token, nil
+}
+
+func (v *Vault) do(ctx context.Context, method, path string, body []byte, result any) error {
+	token, err := v.login(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return v.request(ctx, method, path, token, body, result)
+}
+
+func (v *Vault) request(ctx context.Context, method, path, token string, body []byte, result any) error {
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+path, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	return send(v.client, req, result)
+}
@@ -0,0 +1,302 @@
+// Package security collects the security events of the proxy — flagged
+// moderation categories, prompt injections, tripped canaries — decides what
+// to do about them per category, and keeps hourly statistics of the trends.
+package security
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/alert"
+	"github.com/adrianliechti/wingman-chat/pkg/anomaly"
+)
+
+// Sources of events; they also name the default policy of their categories.
+const (
+	Moderation = "moderation"
+	Injection  = "injection"
+	Canary     = "canary"
+)
+
+// keep is how long hourly statistics are kept.
+const keep = 7 * 24 * time.Hour
+
+// Action is what is done about an event; it is logged in any case, and only
+// logged (warn) when neither is set.
+type Action struct {
+	Block  bool
+	Notify bool
+}
+
+func (a Action) String() string {
+	var parts []string
+
+	if a.Block {
+		parts = append(parts, "block")
+	}
+
+	if a.Notify {
+		parts = append(parts, "notify")
+	}
+
+	if len(parts) == 0 {
+		return "warn"
+	}
+
+	return strings.Join(parts, "+")
+}
+
+// ParseAction reads warn, block, notify or a combination like block+notify.
+func ParseAction(s string) (Action, error) {
+	var a Action
+
+	for _, part := range strings.Split(s, "+") {
+		switch strings.TrimSpace(part) {
+		case "warn":
+		case "block":
+			a.Block = true
+		case "notify":
+			a.Notify = true
+		default:
+			return a, fmt.Errorf("security: unknown action %q (want warn, block or notify)", part)
+		}
+	}
+
+	return a, nil
+}
+
+// ParsePolicies reads comma-separated category=action pairs, e.g.
+// "violence=block,self-harm=warn+notify,injection=warn". A source name sets
+// the action of all its categories without one of their own.
+func ParsePolicies(s string) (map[string]Action, error) {
+	policies := map[string]Action{}
+
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		category, action, ok := strings.Cut(entry, "=")
+
+		if !ok {
+			return nil, fmt.Errorf("security: policy %q is not category=action", entry)
+		}
+
+		a, err := ParseAction(action)
+
+		if err != nil {
+			return nil, err
+		}
+
+		policies[strings.TrimSpace(category)] = a
+	}
+
+	return policies, nil
+}
+
+type counts struct {
+	Events  int `json:"events"`
+	Blocked int `json:"blocked"`
+}
+
+type Monitor struct {
+	policies map[string]Action
+	notifier *alert.Notifier
+
+	mu    sync.Mutex
+	hours map[time.Time]map[string]*counts
+}
+
+// FromEnv returns the monitor acting on the SECURITY_POLICIES (see
+// ParsePolicies).
+func FromEnv(notifier *alert.Notifier) (*Monitor, error) {
+	policies, err := ParsePolicies(os.Getenv("SECURITY_POLICIES"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return New(policies, notifier), nil
+}
+
+func New(policies map[string]Action, notifier *alert.Notifier) *Monitor {
+	return &Monitor{
+		policies: policies,
+		notifier: notifier,
+
+		hours: map[time.Time]map[string]*counts{},
+	}
+}
+
+// Report records an event of source for the request r, flagged for
+// categories, and returns what to do about it: the combined policy of the
+// categories, falling back to that of the source and then to fallback.
+func (m *Monitor) Report(r *http.Request, source string, categories []string, detail string, fallback Action) Action {
+	if m == nil {
+		return fallback
+	}
+
+	var action Action
+
+	for _, c := range categories {
+		a, ok := m.policies[c]
+
+		if !ok {
+			a, ok = m.policies[source]
+		}
+
+		if !ok {
+			a = fallback
+		}
+
+		action.Block = action.Block || a.Block
+		action.Notify = action.Notify || a.Notify
+	}
+
+	caller := anomaly.Caller(r)
+
+	if len(detail) > 200 {
+		detail = detail[:200] + "..."
+	}
+
+	if detail != "" {
+		slog.Warn("security", "source", source, "caller", caller, "action", action, "path", r.URL.Path, "categories", strings.Join(categories, ", "), "detail", detail)
+	} else {
+		slog.Warn("security", "source", source, "caller", caller, "action", action, "path", r.URL.Path, "categories", strings.Join(categories, ", "))
+	}
+
+	m.record(categories, action.Block)
+
+	if action.Notify {
+		m.notifier.Notify(alert.Alert{
+			Type:    source,
+			Subject: caller,
+			Message: fmt.Sprintf("%s event (%s) on %s by %s", source, strings.Join(categories, ", "), r.URL.Path, caller),
+
+			Details: map[string]any{
+				"categories": categories,
+				"path":       r.URL.Path,
+				"blocked":    action.Block,
+				"detail":     detail,
+			},
+		})
+	}
+
+	return action
+}
+
+func (m *Monitor) record(categories []string, blocked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	hour := now.Truncate(time.Hour)
+
+	for h := range m.hours {
+		if now.Sub(h) > keep {
+			delete(m.hours, h)
+		}
+	}
+
+	if m.hours[hour] == nil {
+		m.hours[hour] = map[string]*counts{}
+	}
+
+	for _, c := range categories {
+		n := m.hours[hour][c]
+
+		if n == nil {
+			n = &counts{}
+			m.hours[hour][c] = n
+		}
+
+		n.Events++
+
+		if blocked {
+			n.Blocked++
+		}
+	}
+}
+
+type Hour struct {
+	Hour       time.Time         `json:"hour"`
+	Categories map[string]counts `json:"categories"`
+}
+
+type Category struct {
+	Category string `json:"category"`
+	counts
+}
+
+type Stats struct {
+	Since time.Time `json:"since"`
+
+	// Categories are the totals, most frequent first; Hours the counts of
+	// the hours with events, oldest first.
+	Categories []Category `json:"categories"`
+	Hours      []Hour     `json:"hours"`
+}
+
+// Stats returns the statistics of the events of the past period (at most
+// a week).
+func (m *Monitor) Stats(period time.Duration) *Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := time.Now().Add(-min(period, keep)).Truncate(time.Hour)
+
+	stats := &Stats{
+		Since: since,
+
+		Categories: []Category{},
+		Hours:      []Hour{},
+	}
+
+	totals := map[string]*Category{}
+
+	for h, categories := range m.hours {
+		if h.Before(since) {
+			continue
+		}
+
+		hour := Hour{Hour: h, Categories: map[string]counts{}}
+
+		for c, n := range categories {
+			hour.Categories[c] = *n
+
+			t := totals[c]
+
+			if t == nil {
+				t = &Category{Category: c}
+				totals[c] = t
+			}
+
+			t.Events += n.Events
+			t.Blocked += n.Blocked
+		}
+
+		stats.Hours = append(stats.Hours, hour)
+	}
+
+	for _, t := range totals {
+		stats.Categories = append(stats.Categories, *t)
+	}
+
+	slices.SortFunc(stats.Hours, func(a, b Hour) int { return a.Hour.Compare(b.Hour) })
+
+	slices.SortFunc(stats.Categories, func(a, b Category) int {
+		if a.Events != b.Events {
+			return b.Events - a.Events
+		}
+
+		return strings.Compare(a.Category, b.Category)
+	})
+
+	return stats
+}
@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/apikeys"
+)
+
+type createKeyRequest struct {
+	Name string `json:"name"`
+
+	Models    []string `json:"models"`
+	RateLimit int      `json:"rateLimit"`
+
+	// Expires is an RFC 3339 time; TTL (e.g. "720h") is an alternative.
+	Expires *time.Time `json:"expires"`
+	TTL     string     `json:"ttl"`
+}
+
+type createKeyResponse struct {
+	*apikeys.Key
+
+	// Secret is shown only once.
+	Secret string `json:"key"`
+}
+
+func (h *Handler) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.apikeys.List())
+}
+
+func (h *Handler) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	if req.RateLimit < 0 {
+		writeError(w, http.StatusBadRequest, "rateLimit must not be negative")
+		return
+	}
+
+	expires := req.Expires
+
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+
+		if err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+
+		t := time.Now().Add(ttl).UTC()
+		expires = &t
+	}
+
+	secret, key, err := h.apikeys.Create(apikeys.Key{
+		Name: req.Name,
+
+		Models:    req.Models,
+		RateLimit: req.RateLimit,
+
+		Expires: expires,
+	})
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createKeyResponse{key, secret})
+}
+
+func (h *Handler) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.apikeys.Revoke(r.PathValue("id")); err != nil {
+		status := http.StatusInternalServerError
+
+		if errors.Is(err, apikeys.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+
+		writeError(w, status, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,30 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/adrianliechti/wingman-chat/pkg/guest"
+)
+
+// handleListGuests lists the unexpired guest links of everyone, or of the
+// user given as ?owner=.
+func (h *Handler) handleListGuests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.guests.List(r.URL.Query().Get("owner")))
+}
+
+// handleRevokeGuest revokes a guest link, whoever minted it.
+func (h *Handler) handleRevokeGuest(w http.ResponseWriter, r *http.Request) {
+	if err := h.guests.Revoke("", r.PathValue("id")); err != nil {
+		status := http.StatusInternalServerError
+
+		if errors.Is(err, guest.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+
+		writeError(w, status, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,331 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/apikeys"
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/buffers"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/guest"
+	"github.com/adrianliechti/wingman-chat/pkg/metrics"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+	"github.com/adrianliechti/wingman-chat/pkg/replica"
+	"github.com/adrianliechti/wingman-chat/pkg/security"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxConfigSize bounds proposed configuration payloads.
+const maxConfigSize = 4 << 20
+
+type Handler struct {
+	tokens Tokens
+	store  *config.Store
+
+	tenants *tenant.Registry
+
+	apikeys  *apikeys.Store
+	guests   *guest.Store
+	replicas *replica.Registry
+	sessions *auth.Sessions
+	security *security.Monitor
+	metrics  *metrics.Registry
+}
+
+func New(tokens Tokens, store *config.Store, tenants *tenant.Registry, keys *apikeys.Store, guests *guest.Store, replicas *replica.Registry, sessions *auth.Sessions, monitor *security.Monitor, registry *metrics.Registry) *Handler {
+	return &Handler{
+		tokens: tokens,
+		store:  store,
+
+		tenants: tenants,
+
+		apikeys:  keys,
+		guests:   guests,
+		replicas: replicas,
+		sessions: sessions,
+		security: monitor,
+		metrics:  registry,
+	}
+}
+
+func (h *Handler) Attach(mux *http.ServeMux) {
+	mux.Handle("GET /admin/me", h.authorize(h.handleMe, RoleConfig, RoleUsage, RoleModerator, RoleTenant))
+
+	mux.Handle("GET /admin/config", h.authorize(h.handleConfig, RoleConfig))
+	mux.Handle("POST /admin/config", h.authorize(h.handleApply, RoleConfig))
+	mux.Handle("POST /admin/config/preview", h.authorize(h.handlePreview, RoleConfig))
+
+	mux.Handle("GET /admin/config/history", h.authorize(h.handleHistory, RoleConfig))
+	mux.Handle("GET /admin/config/history/{version}", h.authorize(h.handleVersion, RoleConfig))
+	mux.Handle("POST /admin/config/history/{version}/rollback", h.authorize(h.handleRollback, RoleConfig))
+
+	if h.tenants != nil {
+		mux.Handle("GET /admin/tenants", h.authorize(h.handleTenants, RoleConfig, RoleTenant))
+		mux.Handle("GET /admin/tenants/{id}/config", h.authorize(h.handleTenantConfig, RoleConfig, RoleTenant))
+		mux.Handle("POST /admin/tenants/{id}/config", h.authorize(h.handleTenantApply, RoleConfig, RoleTenant))
+		mux.Handle("POST /admin/tenants/{id}/config/preview", h.authorize(h.handleTenantPreview, RoleConfig, RoleTenant))
+	}
+
+	if h.apikeys != nil {
+		mux.Handle("GET /admin/apikeys", h.authorize(h.handleListKeys, RoleUsage, RoleModerator))
+		mux.Handle("POST /admin/apikeys", h.authorize(h.handleCreateKey))
+		mux.Handle("DELETE /admin/apikeys/{id}", h.authorize(h.handleRevokeKey, RoleModerator))
+	}
+
+	if h.guests != nil {
+		mux.Handle("GET /admin/guests", h.authorize(h.handleListGuests, RoleUsage, RoleModerator))
+		mux.Handle("DELETE /admin/guests/{id}", h.authorize(h.handleRevokeGuest, RoleModerator))
+	}
+
+	if h.replicas != nil {
+		mux.Handle("GET /admin/replicas", h.authorize(h.handleReplicas, RoleUsage))
+	}
+
+	if h.sessions != nil && h.sessions.Tracked() {
+		mux.Handle("GET /admin/sessions", h.authorize(h.handleListSessions, RoleModerator))
+		mux.Handle("DELETE /admin/sessions", h.authorize(h.handleRevokeUserSessions, RoleModerator))
+		mux.Handle("DELETE /admin/sessions/{id}", h.authorize(h.handleRevokeSession, RoleModerator))
+	}
+
+	if h.security != nil {
+		mux.Handle("GET /admin/security", h.authorize(h.handleSecurity, RoleUsage, RoleModerator))
+	}
+
+	if h.metrics != nil {
+		mux.Handle("GET /admin/metrics", h.authorize(h.handleMetrics, RoleUsage, RoleTenant))
+	}
+
+	mux.Handle("GET /admin/memory", h.authorize(h.handleMemory, RoleUsage))
+}
+
+func (h *Handler) Describe(spec *openapi.Spec) {
+	yamlConfig := map[string]any{"type": "string", "description": "YAML shaped like /config.schema.json"}
+
+	add := func(method, path, summary string, op openapi.Operation) {
+		op.Summary = summary
+		op.Tag = "admin"
+		op.Security = openapi.Admin
+
+		spec.Add(method, path, op)
+	}
+
+	add("GET", "/admin/me", "The role of the token", openapi.Operation{Response: reflect.TypeFor[caller]()})
+
+	add("GET", "/admin/config", "The effective configuration, including server-side settings", openapi.Operation{Response: yamlConfig, ResponseType: "application/yaml"})
+	add("POST", "/admin/config", "Overlay and apply a configuration document when it is valid", openapi.Operation{Request: yamlConfig, RequestType: "application/yaml", Response: reflect.TypeFor[previewResponse]()})
+	add("POST", "/admin/config/preview", "Validate a configuration document and list its changes", openapi.Operation{Request: yamlConfig, RequestType: "application/yaml", Response: reflect.TypeFor[previewResponse]()})
+
+	add("GET", "/admin/config/history", "The last effective configurations", openapi.Operation{Response: reflect.TypeFor[[]config.Version]()})
+	add("GET", "/admin/config/history/{version}", "An earlier effective configuration", openapi.Operation{Response: yamlConfig, ResponseType: "application/yaml"})
+	add("POST", "/admin/config/history/{version}/rollback", "Restore an earlier configuration", openapi.Operation{Response: reflect.TypeFor[config.Version]()})
+
+	if h.tenants != nil {
+		add("GET", "/admin/tenants", "The tenants the token may manage", openapi.Operation{Response: reflect.TypeFor[[]string]()})
+		add("GET", "/admin/tenants/{id}/config", "The effective configuration of a tenant", openapi.Operation{Response: yamlConfig, ResponseType: "application/yaml"})
+		add("POST", "/admin/tenants/{id}/config", "Overlay and apply a configuration document on a tenant", openapi.Operation{Request: yamlConfig, RequestType: "application/yaml", Response: reflect.TypeFor[previewResponse]()})
+		add("POST", "/admin/tenants/{id}/config/preview", "Validate a configuration document for a tenant", openapi.Operation{Request: yamlConfig, RequestType: "application/yaml", Response: reflect.TypeFor[previewResponse]()})
+	}
+
+	if h.apikeys != nil {
+		add("GET", "/admin/apikeys", "List the API keys", openapi.Operation{Response: reflect.TypeFor[[]*apikeys.Key]()})
+		add("POST", "/admin/apikeys", "Mint an API key; the secret is only returned here", openapi.Operation{Request: reflect.TypeFor[createKeyRequest](), Response: reflect.TypeFor[createKeyResponse](), Status: http.StatusCreated})
+		add("DELETE", "/admin/apikeys/{id}", "Revoke an API key", openapi.Operation{Status: http.StatusNoContent})
+	}
+
+	if h.guests != nil {
+		add("GET", "/admin/guests", "List the guest links (of ?owner=)", openapi.Operation{Query: []string{"owner"}, Response: reflect.TypeFor[[]*guest.Link]()})
+		add("DELETE", "/admin/guests/{id}", "Revoke a guest link", openapi.Operation{Status: http.StatusNoContent})
+	}
+
+	if h.replicas != nil {
+		add("GET", "/admin/replicas", "The replicas reporting to this instance", openapi.Operation{Response: reflect.TypeFor[[]replica.Entry]()})
+	}
+
+	if h.sessions != nil && h.sessions.Tracked() {
+		add("GET", "/admin/sessions", "List the active sessions (of ?user=)", openapi.Operation{Query: []string{"user"}, Response: reflect.TypeFor[[]auth.Session]()})
+		add("DELETE", "/admin/sessions", "Revoke the sessions of ?user=", openapi.Operation{Query: []string{"user"}, Response: reflect.TypeFor[map[string]int]()})
+		add("DELETE", "/admin/sessions/{id}", "Revoke a session", openapi.Operation{Status: http.StatusNoContent})
+	}
+
+	if h.security != nil {
+		add("GET", "/admin/security", "Security events per category and hour of ?period=", openapi.Operation{Query: []string{"period"}, Response: reflect.TypeFor[security.Stats]()})
+	}
+
+	if h.metrics != nil {
+		add("GET", "/admin/metrics", "Usage metrics in the Prometheus text format, of ?tenant= or the tenant of the token", openapi.Operation{Query: []string{"tenant"}, Response: map[string]any{"type": "string"}, ResponseType: "text/plain"})
+	}
+
+	add("GET", "/admin/memory", "Memory of the process and of the bodies it holds", openapi.Operation{Response: reflect.TypeFor[buffers.Stats]()})
+}
+
+// handleConfig returns the effective configuration including server-side
+// settings, as YAML.
+func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeYAML(w, h.store.Config())
+}
+
+// handleReplicas lists the nodes reporting to this instance.
+func (h *Handler) handleReplicas(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.replicas.List())
+}
+
+// handleMemory reports the memory of the process and of the bodies it holds.
+func (h *Handler) handleMemory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buffers.ReadStats())
+}
+
+// handleMetrics writes the usage metrics for Prometheus: those of the
+// tenant of a tenant admin, of ?tenant= or of all tenants.
+func (h *Handler) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	id := strings.ToLower(r.URL.Query().Get("tenant"))
+
+	if c := callerFromContext(r.Context()); c.Role == RoleTenant {
+		id = c.Tenant
+	}
+
+	label := ""
+
+	if id != "" {
+		label = "tenant"
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.metrics.Write(w, label, id)
+}
+
+// handleApply overlays a proposed YAML document like handlePreview and
+// publishes the result when it is valid.
+func (h *Handler) handleApply(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.store)
+}
+
+func (h *Handler) apply(w http.ResponseWriter, r *http.Request, store *config.Store) {
+	proposed, result, ok := h.propose(w, r, store)
+
+	if !ok {
+		return
+	}
+
+	if !result.Valid {
+		writeJSON(w, http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	store.Update("admin", func(c *config.Config) {
+		*c = *proposed
+	})
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) handleHistory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.store.History())
+}
+
+func (h *Handler) handleVersion(w http.ResponseWriter, r *http.Request) {
+	version, _ := strconv.Atoi(r.PathValue("version"))
+
+	for _, v := range h.store.History() {
+		if v.Version == version {
+			writeYAML(w, v.Config)
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "version not found")
+}
+
+func (h *Handler) handleRollback(w http.ResponseWriter, r *http.Request) {
+	version, _ := strconv.Atoi(r.PathValue("version"))
+
+	if err := h.store.Rollback(version); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	history := h.store.History()
+	writeJSON(w, http.StatusOK, history[len(history)-1])
+}
+
+type previewResponse struct {
+	Valid   bool            `json:"valid"`
+	Errors  []string        `json:"errors"`
+	Changes []config.Change `json:"changes"`
+}
+
+// handlePreview overlays a proposed YAML document (same shape as
+// /config.schema.json; sections that are left out stay as they are) on the
+// effective configuration and reports validation errors and the resulting diff
+// without applying anything.
+func (h *Handler) handlePreview(w http.ResponseWriter, r *http.Request) {
+	if _, result, ok := h.propose(w, r, h.store); ok {
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// propose builds the configuration proposed by the request body on top of
+// that of store. It writes an error response and returns false when the
+// request can't be processed.
+func (h *Handler) propose(w http.ResponseWriter, r *http.Request, store *config.Store) (*config.Config, *previewResponse, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return nil, nil, false
+	}
+
+	current := store.Config()
+
+	proposed, err := config.Clone(current)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, nil, false
+	}
+
+	result := &previewResponse{
+		Errors: []string{},
+	}
+
+	if err := config.Decode(data, proposed); err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	for _, err := range config.Validate(proposed) {
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	changes, err := config.Diff(current, proposed)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, nil, false
+	}
+
+	result.Valid = len(result.Errors) == 0
+	result.Changes = changes
+
+	return proposed, result, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeYAML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/yaml")
+	yaml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
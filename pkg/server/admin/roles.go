@@ -0,0 +1,134 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Role is a tier of access to the admin API.
+type Role string
+
+const (
+	// RoleAdmin may use everything.
+	RoleAdmin Role = "admin"
+
+	// RoleConfig reads and changes the configuration of the deployment and
+	// its tenants.
+	RoleConfig Role = "config"
+
+	// RoleUsage views the API keys, replicas, memory, metrics and security
+	// statistics without changing anything.
+	RoleUsage Role = "usage"
+
+	// RoleModerator views security statistics and revokes sessions and API
+	// keys.
+	RoleModerator Role = "moderator"
+
+	// RoleTenant reads and changes the configuration of one tenant and reads
+	// its metrics; its token is the adminToken of the tenant's tenant.yaml.
+	RoleTenant Role = "tenant"
+)
+
+// Tokens are the tokens of each role; a token may only belong to one.
+type Tokens map[Role][]string
+
+// TokensFromEnv reads the comma separated tokens of ADMIN_TOKEN,
+// ADMIN_CONFIG_TOKEN, ADMIN_USAGE_TOKEN and ADMIN_MODERATOR_TOKEN.
+func TokensFromEnv() Tokens {
+	t := Tokens{}
+
+	for role, key := range map[Role]string{
+		RoleAdmin:     "ADMIN_TOKEN",
+		RoleConfig:    "ADMIN_CONFIG_TOKEN",
+		RoleUsage:     "ADMIN_USAGE_TOKEN",
+		RoleModerator: "ADMIN_MODERATOR_TOKEN",
+	} {
+		if tokens := env.List(key); len(tokens) > 0 {
+			t[role] = tokens
+		}
+	}
+
+	return t
+}
+
+// caller is the holder of an admin token.
+type caller struct {
+	Role Role `json:"role"`
+
+	// Tenant is the tenant of a tenant admin.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+type callerKey struct{}
+
+func callerFromContext(ctx context.Context) caller {
+	c, _ := ctx.Value(callerKey{}).(caller)
+	return c
+}
+
+// identify finds the role of the bearer token of r.
+func (h *Handler) identify(r *http.Request) (caller, bool) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if token == "" {
+		return caller{}, false
+	}
+
+	match := func(candidate string) bool {
+		return candidate != "" && subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1
+	}
+
+	// Ordered, so a token listed for two roles gets the wider one.
+	for _, role := range []Role{RoleAdmin, RoleConfig, RoleModerator, RoleUsage} {
+		if slices.ContainsFunc(h.tokens[role], match) {
+			return caller{Role: role}, true
+		}
+	}
+
+	if h.tenants != nil {
+		for _, t := range h.tenants.Tenants() {
+			if match(t.AdminToken) {
+				return caller{Role: RoleTenant, Tenant: t.ID}, true
+			}
+		}
+	}
+
+	return caller{}, false
+}
+
+// authorize only lets requests through that carry the token of the admin
+// role or of one of roles. Tenant admins only reach the routes of their
+// tenant, those with an {id} of it.
+func (h *Handler) authorize(next http.HandlerFunc, roles ...Role) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := h.identify(r)
+
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		if c.Role != RoleAdmin && !slices.Contains(roles, c.Role) {
+			writeError(w, http.StatusForbidden, "the "+string(c.Role)+" role may not use this endpoint")
+			return
+		}
+
+		if c.Role == RoleTenant && r.PathValue("id") != "" && !strings.EqualFold(r.PathValue("id"), c.Tenant) {
+			writeError(w, http.StatusForbidden, "the tenant role may only manage its own tenant")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, c)))
+	})
+}
+
+// handleMe reports the role of the caller, e.g. for an admin UI to show
+// what it may use.
+func (h *Handler) handleMe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, callerFromContext(r.Context()))
+}
@@ -0,0 +1,25 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+)
+
+// handleSecurity reports the security events per category and hour of the
+// period given as ?period= (default 24h, at most a week).
+func (h *Handler) handleSecurity(w http.ResponseWriter, r *http.Request) {
+	period := 24 * time.Hour
+
+	if s := r.URL.Query().Get("period"); s != "" {
+		d, err := time.ParseDuration(s)
+
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid period")
+			return
+		}
+
+		period = d
+	}
+
+	writeJSON(w, http.StatusOK, h.security.Stats(period))
+}
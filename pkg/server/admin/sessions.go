@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+)
+
+// handleListSessions lists the active sessions, optionally only those of
+// the user given as ?user=.
+func (h *Handler) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.userSessions(r.URL.Query().Get("user"))
+
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (h *Handler) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if err := h.sessions.Revoke(r.PathValue("id")); err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRevokeUserSessions signs the user given as ?user= out everywhere.
+func (h *Handler) handleRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+
+	if user == "" {
+		writeError(w, http.StatusBadRequest, "user is required")
+		return
+	}
+
+	sessions, err := h.userSessions(user)
+
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	for _, s := range sessions {
+		if err := h.sessions.Revoke(s.ID); err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"revoked": len(sessions)})
+}
+
+func (h *Handler) userSessions(user string) ([]*auth.Session, error) {
+	sessions, err := h.sessions.List()
+
+	if err != nil || user == "" {
+		return sessions, err
+	}
+
+	return slices.DeleteFunc(sessions, func(s *auth.Session) bool {
+		return s.User == nil || s.User.ID != user
+	}), nil
+}
@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// handleTenants lists the ids of the tenants the caller may manage.
+func (h *Handler) handleTenants(w http.ResponseWriter, r *http.Request) {
+	c := callerFromContext(r.Context())
+
+	ids := []string{}
+
+	for _, t := range h.tenants.Tenants() {
+		if c.Role == RoleTenant && t.ID != c.Tenant {
+			continue
+		}
+
+		ids = append(ids, t.ID)
+	}
+
+	slices.Sort(ids)
+
+	writeJSON(w, http.StatusOK, ids)
+}
+
+// tenantStore returns the store of the tenant {id}, answering 404 when there
+// is none.
+func (h *Handler) tenantStore(w http.ResponseWriter, r *http.Request) (*config.Store, bool) {
+	id := strings.ToLower(r.PathValue("id"))
+
+	tenants := h.tenants.Tenants()
+
+	i := slices.IndexFunc(tenants, func(t *tenant.Tenant) bool { return t.ID == id })
+
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "tenant not found")
+		return nil, false
+	}
+
+	return tenants[i].Store, true
+}
+
+func (h *Handler) handleTenantConfig(w http.ResponseWriter, r *http.Request) {
+	if store, ok := h.tenantStore(w, r); ok {
+		writeYAML(w, store.Config())
+	}
+}
+
+func (h *Handler) handleTenantPreview(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.tenantStore(w, r)
+
+	if !ok {
+		return
+	}
+
+	if _, result, ok := h.propose(w, r, store); ok {
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func (h *Handler) handleTenantApply(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.tenantStore(w, r)
+
+	if !ok {
+		return
+	}
+
+	h.apply(w, r, store)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adrianliechti/wingman-chat/pkg/apikeys"
+	"github.com/adrianliechti/wingman-chat/pkg/rbac"
+)
+
+// checkAPIKey enforces the model allowlist of the API key a request was
+// made with.
+func (h *Handler) checkAPIKey(r *http.Request, body map[string]any) error {
+	k := apikeys.FromContext(r.Context())
+
+	if k == nil {
+		return nil
+	}
+
+	model, _ := body["model"].(string)
+
+	if !k.AllowsModel(model) {
+		return &Error{Status: http.StatusForbidden, Code: "model_not_allowed", Message: fmt.Sprintf("api key may not use model %q", model)}
+	}
+
+	return nil
+}
+
+// checkRoles rejects models the caller's roles don't grant.
+func (h *Handler) checkRoles(r *http.Request, body map[string]any) error {
+	model, _ := body["model"].(string)
+
+	if model != "" && !rbac.ForRequest(r, h.store).AllowsModel(model) {
+		return &Error{Status: http.StatusForbidden, Code: "model_not_allowed", Message: fmt.Sprintf("model %q is not allowed", model)}
+	}
+
+	return nil
+}
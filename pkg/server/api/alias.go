@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// maxAliasHops bounds alias chains; validation rejects loops, but tenant
+// configs are merged after it.
+const maxAliasHops = 8
+
+// applyAliases redirects requests for an aliased model, e.g. one retired
+// upstream that old chats still name or one past its deprecation date, to
+// its target before the other transforms see the model.
+func (h *Handler) applyAliases(r *http.Request, body map[string]any) error {
+	aliases := tenant.Config(r.Context(), h.store).ApplyDeprecations(time.Now()).Aliases
+
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	id, _ := body["model"].(string)
+
+	if id == "" {
+		return nil
+	}
+
+	targets := make(map[string]string, len(aliases))
+
+	for _, a := range aliases {
+		targets[a.ID] = a.Target
+	}
+
+	for range maxAliasHops {
+		target, ok := targets[id]
+
+		if !ok {
+			break
+		}
+
+		id = target
+	}
+
+	body["model"] = id
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/adrianliechti/wingman-chat/pkg/anomaly"
+)
+
+// observeUsage reports model requests and the tokens they use to the
+// anomaly detector.
+func (h *Handler) observeUsage(r *http.Request, body map[string]any) error {
+	if h.anomalies == nil {
+		return nil
+	}
+
+	caller := anomaly.Caller(r)
+
+	h.anomalies.Request(caller)
+
+	onUsage(r, body, func(tokens int) {
+		h.anomalies.Tokens(caller, tokens)
+	})
+
+	return nil
+}
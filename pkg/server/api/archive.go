@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/adrianliechti/wingman-chat/pkg/archive"
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// maxArchivedResponse bounds the part of a response that is archived.
+const maxArchivedResponse = 1 << 20
+
+// recordRequest prepares the archive record of a model request, holding the
+// body as sent upstream; it runs after the other transforms.
+func (h *Handler) recordRequest(r *http.Request, body map[string]any) error {
+	if h.archive == nil || !h.permitsStorage(r, config.ArchiveStorage) {
+		return nil
+	}
+
+	data, err := json.Marshal(body)
+
+	if err != nil {
+		return err
+	}
+
+	rec := &archive.Record{
+		Method: r.Method,
+		Path:   r.URL.Path,
+
+		Request: data,
+	}
+
+	rec.Model, _ = body["model"].(string)
+
+	if u := routeFromContext(r.Context()).upstream; u != nil {
+		rec.Upstream = u.ID
+	}
+
+	rec.Region = h.region(r, tenant.Config(r.Context(), h.store))
+
+	if t := tenant.FromContext(r.Context()); t != nil {
+		rec.Tenant = t.ID
+	}
+
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		rec.User = user.ID
+	}
+
+	rec.Device = auth.DeviceFromContext(r.Context())
+	rec.OwnKey = h.ownKey(r) != ""
+
+	routeFromContext(r.Context()).record = rec
+
+	return nil
+}
+
+// archiveResponse completes the route's record with the response once its
+// body has been read.
+func (h *Handler) archiveResponse(resp *http.Response) {
+	rec := routeFromContext(resp.Request.Context()).record
+
+	if rec == nil {
+		return
+	}
+
+	rec.Status = resp.StatusCode
+
+	resp.Body = &archiveReader{
+		ReadCloser: resp.Body,
+
+		text: isText(resp.Header.Get("Content-Type")),
+
+		done: func(data []byte, truncated bool) {
+			rec.Response = string(data)
+			rec.Truncated = truncated
+
+			if err := h.archive.Record(rec); err != nil {
+				slog.Error("archive", "path", rec.Path, "error", err)
+			}
+		},
+	}
+}
+
+func isText(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json"
+}
+
+// archiveReader passes the body through, keeping a copy of text bodies.
+type archiveReader struct {
+	io.ReadCloser
+
+	text      bool
+	data      []byte
+	truncated bool
+
+	once sync.Once
+	done func(data []byte, truncated bool)
+}
+
+func (a *archiveReader) Read(p []byte) (int, error) {
+	n, err := a.ReadCloser.Read(p)
+
+	if a.text && n > 0 {
+		if room := maxArchivedResponse - len(a.data); room < n {
+			a.data = append(a.data, p[:max(room, 0)]...)
+			a.truncated = true
+		} else {
+			a.data = append(a.data, p[:n]...)
+		}
+	}
+
+	if err == io.EOF {
+		a.finish()
+	}
+
+	return n, err
+}
+
+func (a *archiveReader) Close() error {
+	a.finish()
+	return a.ReadCloser.Close()
+}
+
+func (a *archiveReader) finish() {
+	a.once.Do(func() {
+		a.done(a.data, a.truncated)
+	})
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/buffers"
+)
+
+// transform inspects or rewrites the decoded JSON body of a model request
+// before it is proxied. Returning an *Error rejects the request with its
+// status; other errors are reported as 400.
+type transform func(r *http.Request, body map[string]any) error
+
+// Error is a request rejection reported to the client in the OpenAI error
+// format.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+
+	// RetryAfter is sent as Retry-After header when set.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// modelPaths are the endpoints (below the prefix) whose JSON body names a model.
+var modelPaths = map[string]bool{
+	"/v1/chat/completions":   true,
+	"/v1/completions":        true,
+	"/v1/responses":          true,
+	"/v1/embeddings":         true,
+	"/v1/audio/speech":       true,
+	"/v1/images/generations": true,
+}
+
+// formPaths are the endpoints (below the prefix) that take a multipart form
+// naming a model in its fields.
+var formPaths = map[string]bool{
+	"/v1/audio/transcriptions": true,
+	"/v1/audio/translations":   true,
+	"/v1/images/edits":         true,
+}
+
+// withTransforms decodes the bodies of model requests, JSON or the fields of
+// a multipart form, runs the transforms and passes the re-encoded body on to
+// next. Model requests with other or malformed bodies are rejected, so none
+// gets past the transforms. Bodies are read up to the limit of their
+// endpoint, whether or not withBodyLimit ran before.
+func withTransforms(next http.Handler, limit BodyLimit, transforms ...transform) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(transforms) == 0 || r.Method != http.MethodPost || (!modelPaths[r.URL.Path] && !formPaths[r.URL.Path]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if n := limit.limit(r.URL.Path); n > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+
+		body, encode, err := decodeBody(r)
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if err := applyTransforms(r, body, transforms); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if err := encode(r, body); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeBody reads the body of a model request as a map, and returns how to
+// make the map the body of the request once transformed.
+func decodeBody(r *http.Request) (map[string]any, func(*http.Request, map[string]any) error, error) {
+	contentType := r.Header.Get("Content-Type")
+
+	if formPaths[r.URL.Path] {
+		mediaType, params, _ := mime.ParseMediaType(contentType)
+
+		if mediaType != "multipart/form-data" || params["boundary"] == "" {
+			return nil, nil, &Error{Status: http.StatusUnsupportedMediaType, Code: "unsupported_media_type", Message: "expected a multipart/form-data body"}
+		}
+
+		f, err := readForm(r, params["boundary"])
+
+		var tooBig *http.MaxBytesError
+
+		if errors.As(err, &tooBig) {
+			return nil, nil, err
+		}
+
+		if err != nil {
+			return nil, nil, &Error{Status: http.StatusBadRequest, Code: "invalid_request_body", Message: "malformed multipart body: " + err.Error()}
+		}
+
+		return f.fields, f.setBody, nil
+	}
+
+	if !isJSON(contentType) {
+		return nil, nil, &Error{Status: http.StatusUnsupportedMediaType, Code: "unsupported_media_type", Message: "expected an application/json body"}
+	}
+
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var body map[string]any
+
+	if err := json.Unmarshal(data, &body); err != nil || body == nil {
+		return nil, nil, &Error{Status: http.StatusBadRequest, Code: "invalid_request_body", Message: "the body is not a JSON object"}
+	}
+
+	return body, func(r *http.Request, body map[string]any) error {
+		data, err := json.Marshal(body)
+
+		if err != nil {
+			return err
+		}
+
+		r.Header.Set("Content-Type", contentType)
+		setBody(r, data)
+
+		return nil
+	}, nil
+}
+
+// applyTransforms runs the transforms on body in order, stopping at the
+// first that rejects the request.
+func applyTransforms(r *http.Request, body map[string]any, transforms []transform) error {
+	for _, t := range transforms {
+		if err := t(r, body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setBody(r *http.Request, data []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	r.ContentLength = int64(len(data))
+	r.Header.Set("Content-Length", strconv.Itoa(len(data)))
+}
+
+// setSpooled makes the spooled body s that of r.
+func setSpooled(r *http.Request, s *buffers.Spooled) {
+	r.Body = io.NopCloser(s.Reader())
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(s.Reader()), nil
+	}
+
+	r.ContentLength = s.Size()
+	r.Header.Set("Content-Length", strconv.FormatInt(s.Size(), 10))
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json"
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*Error)
+
+	var tooBig *http.MaxBytesError
+
+	switch {
+	case ok:
+	case errors.As(err, &tooBig):
+		e = tooLarge(tooBig.Limit)
+	default:
+		e = &Error{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(errorBody(e))
+}
+
+// errorResponse answers r with e in place of the upstream.
+func errorResponse(r *http.Request, e *Error) *http.Response {
+	data := errorBody(e)
+
+	header := http.Header{"Content-Type": {"application/json"}}
+
+	if e.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode: e.Status,
+
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+
+		Header: header,
+		Body:   io.NopCloser(bytes.NewReader(data)),
+
+		ContentLength: int64(len(data)),
+		Request:       r,
+	}
+}
+
+func errorBody(e *Error) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"message": e.Message,
+			"type":    http.StatusText(e.Status),
+			"code":    e.Code,
+		},
+	})
+
+	return append(data, '\n')
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adrianliechti/wingman-chat/pkg/buffers"
+)
+
+// denyModel rejects requests for the model "secret" and renames "alias" to
+// "target", like the role check and the aliases do.
+func denyModel(r *http.Request, body map[string]any) error {
+	switch body["model"] {
+	case "secret":
+		return &Error{Status: http.StatusForbidden, Code: "model_not_allowed", Message: "denied"}
+	case "alias":
+		body["model"] = "target"
+	}
+
+	return nil
+}
+
+func serveTransforms(r *http.Request) (*httptest.ResponseRecorder, *http.Request) {
+	var forwarded *http.Request
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})
+
+	rec := httptest.NewRecorder()
+	withTransforms(next, BodyLimit{Default: 1 << 20}, denyModel).ServeHTTP(rec, r)
+
+	return rec, forwarded
+}
+
+func TestTransformsRejectOtherBodies(t *testing.T) {
+	for name, tc := range map[string]struct {
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		"text/plain":       {"/v1/chat/completions", "text/plain", `{"model":"secret"}`, http.StatusUnsupportedMediaType},
+		"no content type":  {"/v1/chat/completions", "", `{"model":"secret"}`, http.StatusUnsupportedMediaType},
+		"malformed":        {"/v1/chat/completions", "application/json", `{"model":"secret"`, http.StatusBadRequest},
+		"not an object":    {"/v1/embeddings", "application/json", `["secret"]`, http.StatusBadRequest},
+		"json for a form":  {"/v1/audio/transcriptions", "application/json", `{"model":"secret"}`, http.StatusUnsupportedMediaType},
+		"broken multipart": {"/v1/images/edits", "multipart/form-data; boundary=x", "garbage", http.StatusBadRequest},
+		"denied json":      {"/v1/chat/completions", "application/json; charset=utf-8", `{"model":"secret"}`, http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+
+			rec, forwarded := serveTransforms(r)
+
+			if rec.Code != tc.status {
+				t.Errorf("status = %d, want %d", rec.Code, tc.status)
+			}
+
+			if forwarded != nil {
+				t.Error("the request was passed on")
+			}
+		})
+	}
+}
+
+func TestTransformsBodyLimit(t *testing.T) {
+	// Without withBodyLimit in front, the transforms still read no more
+	// than the limit.
+	body := `{"model":"alias","input":"` + strings.Repeat("x", 2<<20) + `"}`
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	rec, forwarded := serveTransforms(r)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+
+	if forwarded != nil {
+		t.Error("the request was passed on")
+	}
+}
+
+func TestTransformsOtherPaths(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/files", strings.NewReader("data"))
+	r.Header.Set("Content-Type", "text/plain")
+
+	if _, forwarded := serveTransforms(r); forwarded == nil {
+		t.Error("a request without a model was not passed on")
+	}
+}
+
+func multipartBody(t *testing.T, fields [][2]string) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	fw, err := mw.CreateFormFile("file", "audio.mp3")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fw.Write([]byte("\x00\x01audio"))
+
+	for _, f := range fields {
+		mw.WriteField(f[0], f[1])
+	}
+
+	mw.Close()
+
+	return &buf, mw.FormDataContentType()
+}
+
+func TestTransformsForm(t *testing.T) {
+	body, contentType := multipartBody(t, [][2]string{{"model", "secret"}})
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", body)
+	r.Header.Set("Content-Type", contentType)
+
+	if rec, _ := serveTransforms(r); rec.Code != http.StatusForbidden {
+		t.Fatalf("denied model: status = %d, want 403", rec.Code)
+	}
+
+	body, contentType = multipartBody(t, [][2]string{
+		{"model", "alias"},
+		{"timestamp_granularities[]", "word"},
+		{"timestamp_granularities[]", "segment"},
+	})
+
+	r = httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", body)
+	r.Header.Set("Content-Type", contentType)
+
+	_, forwarded := serveTransforms(r)
+
+	if forwarded == nil {
+		t.Fatal("the request was not passed on")
+	}
+
+	if err := forwarded.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := forwarded.MultipartForm.Value["model"]; len(got) != 1 || got[0] != "target" {
+		t.Errorf("model = %v, want [target]", got)
+	}
+
+	if got := forwarded.MultipartForm.Value["timestamp_granularities[]"]; len(got) != 2 {
+		t.Errorf("timestamp_granularities[] = %v, want both", got)
+	}
+
+	files := forwarded.MultipartForm.File["file"]
+
+	if len(files) != 1 || files[0].Filename != "audio.mp3" {
+		t.Fatalf("file = %v, want audio.mp3", files)
+	}
+
+	f, _ := files[0].Open()
+	data, _ := io.ReadAll(f)
+
+	if string(data) != "\x00\x01audio" {
+		t.Errorf("file content = %q, want it unchanged", data)
+	}
+}
+
+func TestTransformsFormUnchanged(t *testing.T) {
+	body, contentType := multipartBody(t, [][2]string{{"model", "whisper"}, {"language", "de"}})
+	sent := body.String()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", body)
+	r.Header.Set("Content-Type", contentType)
+
+	_, forwarded := serveTransforms(r)
+
+	if forwarded == nil {
+		t.Fatal("the request was not passed on")
+	}
+
+	// A form the transforms left alone is passed on as it came.
+	data, _ := io.ReadAll(forwarded.Body)
+
+	if string(data) != sent || forwarded.Header.Get("Content-Type") != contentType {
+		t.Error("the unchanged form was re-encoded")
+	}
+
+	if forwarded.ContentLength != int64(len(sent)) {
+		t.Errorf("content length = %d, want %d", forwarded.ContentLength, len(sent))
+	}
+}
+
+func TestTransformsFormSpoolsFiles(t *testing.T) {
+	audio := bytes.Repeat([]byte("\x00\x01audio"), 3*formMemory/7)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	mw.WriteField("model", "alias")
+
+	fw, _ := mw.CreateFormFile("file", "audio.mp3")
+	fw.Write(audio)
+
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var spooled int64
+	var form *multipart.Form
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spooled = buffers.ReadStats().SpooledDisk
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return
+		}
+
+		form = r.MultipartForm
+	})
+
+	withTransforms(next, BodyLimit{Default: 8 << 20}, denyModel).ServeHTTP(httptest.NewRecorder(), r)
+
+	// Both the upload and the re-encoded form are held beyond formMemory on
+	// disk, not in memory.
+	if spooled < int64(2*len(audio)-2*formMemory) {
+		t.Errorf("spooled to disk = %d bytes, want the files", spooled)
+	}
+
+	if form == nil {
+		t.Fatal("the request was not passed on")
+	}
+
+	if got := form.Value["model"]; len(got) != 1 || got[0] != "target" {
+		t.Errorf("model = %v, want [target]", got)
+	}
+
+	f, _ := form.File["file"][0].Open()
+	data, _ := io.ReadAll(f)
+
+	if !bytes.Equal(data, audio) {
+		t.Error("the file was changed")
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/cache"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Cache keeps the answers of calls that are the same for the same request:
+// the model listing, speech and embeddings.
+type Cache struct {
+	Store cache.Store
+
+	// TTL is how long answers are kept; model listings at most a minute,
+	// so new models show up.
+	TTL time.Duration
+}
+
+// CacheFromEnv reads CACHE_STORE ("memory" or a Redis URL; no caching when
+// unset), CACHE_SIZE, the bytes kept in memory (default 64MB), and
+// CACHE_TTL (default 1h).
+func CacheFromEnv() (Cache, error) {
+	c := Cache{
+		TTL: time.Hour,
+	}
+
+	backend := os.Getenv("CACHE_STORE")
+
+	if backend == "" {
+		return c, nil
+	}
+
+	size, err := env.Size("CACHE_SIZE", 64<<20)
+
+	if err != nil {
+		return c, err
+	}
+
+	if s := os.Getenv("CACHE_TTL"); s != "" {
+		d, err := time.ParseDuration(s)
+
+		if err != nil || d <= 0 {
+			return c, fmt.Errorf("CACHE_TTL: invalid duration %q", s)
+		}
+
+		c.TTL = d
+	}
+
+	store, err := cache.NewStore(backend, size)
+
+	if err != nil {
+		return c, err
+	}
+
+	c.Store = store
+
+	return c, nil
+}
+
+// maxCacheEntry bounds the answers that are cached.
+const maxCacheEntry = 8 << 20
+
+// cachePaths are the endpoints whose answers are cached, by method.
+var cachePaths = map[string]string{
+	"/v1/models":       http.MethodGet,
+	"/v1/audio/speech": http.MethodPost,
+	"/v1/embeddings":   http.MethodPost,
+}
+
+func cacheable(r *http.Request) (string, bool) {
+	for path, method := range cachePaths {
+		if r.Method == method && strings.HasSuffix(r.URL.Path, path) {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// cacheKey hashes what the answer depends on: the target, the credential,
+// the accepted encodings and the body.
+func cacheKey(r *http.Request) (string, error) {
+	h := sha256.New()
+
+	for _, v := range []string{r.Method, r.URL.String(), r.Header.Get("Authorization"), r.Header.Get("Accept-Encoding")} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+
+		if err != nil {
+			return "", err
+		}
+
+		defer body.Close()
+
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sendCached answers cacheable calls from the cache, and caches the
+// successful answers of the upstream that aren't streamed.
+func (h *Handler) sendCached(r *http.Request) (*http.Response, error) {
+	path, ok := cacheable(r)
+
+	if h.cache.Store == nil || !ok || (r.Body != nil && r.Body != http.NoBody && r.GetBody == nil) || !h.permitsStorage(r, config.CacheStorage) {
+		return h.sendRetrying(r)
+	}
+
+	key, err := cacheKey(r)
+
+	if err != nil {
+		return h.sendRetrying(r)
+	}
+
+	entry, err := h.cache.Store.Get(key)
+
+	if err != nil {
+		slog.Error("cache", "error", err)
+	}
+
+	if entry != nil {
+		routeFromContext(r.Context()).cached = true
+		return cachedResponse(r, entry), nil
+	}
+
+	resp, err := h.sendRetrying(r)
+
+	if err != nil || resp.StatusCode != http.StatusOK || resp.ContentLength > maxCacheEntry || isEventStream(resp.Header.Get("Content-Type")) {
+		return resp, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCacheEntry+1))
+
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	if len(data) > maxCacheEntry {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return resp, nil
+	}
+
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	header.Del("Date")
+
+	ttl := h.cache.TTL
+
+	if path == "/v1/models" {
+		ttl = min(ttl, time.Minute)
+	}
+
+	if err := h.cache.Store.Put(key, &cache.Entry{Status: resp.StatusCode, Header: header, Body: data}, ttl); err != nil {
+		slog.Error("cache", "error", err)
+	}
+
+	resp.Header.Set("X-Cache", "MISS")
+
+	return resp, nil
+}
+
+func cachedResponse(r *http.Request, e *cache.Entry) *http.Response {
+	header := e.Header.Clone()
+
+	if header == nil {
+		header = http.Header{}
+	}
+
+	header.Set("X-Cache", "HIT")
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode: e.Status,
+
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+
+		Header: header,
+		Body:   io.NopCloser(bytes.NewReader(e.Body)),
+
+		ContentLength: int64(len(e.Body)),
+		Request:       r,
+	}
+}
+
+func isEventStream(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/event-stream"
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
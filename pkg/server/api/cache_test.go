@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	key := func(token, body string) string {
+		// Like the requests to the upstream, which can be read again.
+		r, _ := http.NewRequest(http.MethodPost, "http://platform/v1/embeddings", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		k, err := cacheKey(r)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return k
+	}
+
+	if key("alice", `{"input":"a"}`) != key("alice", `{"input":"a"}`) {
+		t.Error("the same request has different keys")
+	}
+
+	// Callers with their own keys must not be answered from each other's
+	// calls.
+	if key("alice", `{"input":"a"}`) == key("bob", `{"input":"a"}`) {
+		t.Error("requests with other credentials share a key")
+	}
+
+	if key("alice", `{"input":"a"}`) == key("alice", `{"input":"b"}`) {
+		t.Error("requests with other bodies share a key")
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	for _, tc := range []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodGet, "/api/v1/models", true},
+		{http.MethodPost, "/api/v1/embeddings", true},
+		{http.MethodPost, "/api/v1/chat/completions", false},
+		{http.MethodPost, "/api/v1/models", false},
+	} {
+		if _, got := cacheable(httptest.NewRequest(tc.method, tc.path, nil)); got != tc.want {
+			t.Errorf("%s %s: cacheable = %v, want %v", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
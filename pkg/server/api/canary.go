@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+)
+
+// checkCanaries rejects model requests whose conversation holds a tool call
+// carrying a canary: the model was told to pass on planted context, and the
+// call may already have been made by a tool outside this server.
+func (h *Handler) checkCanaries(r *http.Request, body map[string]any) error {
+	if h.canaries == nil {
+		return nil
+	}
+
+	for _, args := range toolCallArguments(body) {
+		if c := h.canaries.Find(args); c != "" && h.canaries.Trip(r, c, "a tool call") {
+			return &Error{Status: http.StatusForbidden, Code: "canary_triggered", Message: "request blocked: a tool call carries confidential context"}
+		}
+	}
+
+	return nil
+}
+
+// toolCallArguments returns the arguments of the tool calls in chat
+// completion messages and responses input items.
+func toolCallArguments(body map[string]any) []string {
+	var result []string
+
+	messages, _ := body["messages"].([]any)
+
+	for _, m := range messages {
+		m, _ := m.(map[string]any)
+		calls, _ := m["tool_calls"].([]any)
+
+		for _, c := range calls {
+			c, _ := c.(map[string]any)
+			f, _ := c["function"].(map[string]any)
+
+			if args, ok := f["arguments"].(string); ok {
+				result = append(result, args)
+			}
+		}
+	}
+
+	items, _ := body["input"].([]any)
+
+	for _, item := range items {
+		item, _ := item.(map[string]any)
+
+		if args, ok := item["arguments"].(string); ok && item["type"] == "function_call" {
+			result = append(result, args)
+		}
+	}
+
+	return result
+}
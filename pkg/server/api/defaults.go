@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// applyDefaults fills in the sampling parameters configured for the requested
+// model in models.yaml, leaving values set by the client untouched.
+func (h *Handler) applyDefaults(r *http.Request, body map[string]any) error {
+	if r.URL.Path != "/v1/chat/completions" && r.URL.Path != "/v1/responses" {
+		return nil
+	}
+
+	id, _ := body["model"].(string)
+
+	var model *config.Model
+
+	for _, m := range tenant.Config(r.Context(), h.store).Models {
+		if m.ID == id {
+			model = &m
+			break
+		}
+	}
+
+	if model == nil {
+		return nil
+	}
+
+	setDefault(body, "temperature", model.Temperature)
+	setDefault(body, "top_p", model.TopP)
+
+	switch r.URL.Path {
+	case "/v1/chat/completions":
+		if _, ok := body["max_completion_tokens"]; !ok {
+			setDefault(body, "max_tokens", model.MaxTokens)
+		}
+
+		if model.ReasoningEffort != "" {
+			setDefault(body, "reasoning_effort", &model.ReasoningEffort)
+		}
+
+	case "/v1/responses":
+		setDefault(body, "max_output_tokens", model.MaxTokens)
+
+		if model.ReasoningEffort != "" {
+			reasoning, _ := body["reasoning"].(map[string]any)
+
+			if reasoning == nil {
+				reasoning = map[string]any{}
+				body["reasoning"] = reasoning
+			}
+
+			setDefault(reasoning, "effort", &model.ReasoningEffort)
+		}
+	}
+
+	return nil
+}
+
+func setDefault[T any](body map[string]any, key string, value *T) {
+	if value == nil {
+		return
+	}
+
+	if v, ok := body[key]; ok && v != nil {
+		return
+	}
+
+	body[key] = *value
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/apikeys"
+	"github.com/adrianliechti/wingman-chat/pkg/archive"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/federation"
+	"github.com/adrianliechti/wingman-chat/pkg/rbac"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+type routeKey struct{}
+
+// route is the upstream picked for a request; transforms may change it.
+type route struct {
+	peer bool
+
+	// upstream serves the requested model instead of the platform.
+	upstream *upstream
+
+	// path replaces that of the request upstream when set.
+	path string
+
+	// key is the caller's own upstream key, once looked up.
+	key       string
+	keyLoaded bool
+
+	// cached is set when the answer came from the cache.
+	cached bool
+
+	// model is the requested model, noted for the metrics.
+	model string
+
+	// hooks transform the answer before it is returned.
+	hooks *responseHooks
+
+	// usage receives the tokens the response reports as used.
+	usage func(tokens int)
+
+	// record is completed with the response and archived.
+	record *archive.Record
+
+	// schema validates the answer before it is returned.
+	schema *schemaCheck
+}
+
+// withRouting applies routes.yaml, sends calls to federated tools to the
+// peer and realtime sessions to the upstream of their model, and lets
+// routeUpstream and routeFederated do the same for model requests.
+func (h *Handler) withRouting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := &route{}
+
+		h.applyRoutes(r, rt)
+
+		if r.URL.Path == "/v1/realtime" {
+			if u := h.upstreamFor(r, r.URL.Query().Get("model")); u != nil {
+				rt.upstream = u
+			}
+		}
+
+		if id, ok := strings.CutPrefix(r.URL.Path, "/v1/mcp/"); ok && h.peer != nil {
+			rt.peer = slices.ContainsFunc(tenant.Config(r.Context(), h.store).Tools, func(t config.Tool) bool {
+				return t.ID == id && t.Federated
+			})
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, rt)))
+	})
+}
+
+func routeFromContext(ctx context.Context) *route {
+	if rt, ok := ctx.Value(routeKey{}).(*route); ok {
+		return rt
+	}
+
+	return &route{}
+}
+
+// routeFederated proxies requests for federated models to the peer.
+func (h *Handler) routeFederated(r *http.Request, body map[string]any) error {
+	if h.peer == nil {
+		return nil
+	}
+
+	model, _ := body["model"].(string)
+
+	if slices.ContainsFunc(tenant.Config(r.Context(), h.store).Models, func(m config.Model) bool { return m.ID == model && m.Federated }) {
+		routeFromContext(r.Context()).peer = true
+	}
+
+	return nil
+}
+
+// federatedTools are the ids of the peer's tools reached through this server.
+func (h *Handler) federatedTools(r *http.Request) []string {
+	var ids []string
+
+	for _, t := range tenant.Config(r.Context(), h.store).Tools {
+		if t.Federated && t.URL == "" {
+			ids = append(ids, t.ID)
+		}
+	}
+
+	return ids
+}
+
+// handleCatalog offers the models and tools the caller may use to other
+// instances federating with this one.
+func (h *Handler) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	cfg := rbac.Filter(tenant.Config(r.Context(), h.store), rbac.ForRequest(r, h.store))
+
+	catalog := federation.Catalog{
+		Models: []config.Model{},
+		Tools:  []config.Tool{},
+	}
+
+	k := apikeys.FromContext(r.Context())
+
+	for _, m := range cfg.Models {
+		if k == nil || k.AllowsModel(m.ID) {
+			catalog.Models = append(catalog.Models, m)
+		}
+	}
+
+	catalog.Tools = append(catalog.Tools, cfg.Tools...)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog)
+}
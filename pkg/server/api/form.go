@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/buffers"
+)
+
+// formMemory is how much of a multipart body is held in memory; the rest,
+// the files mostly, is spooled to disk. Text fields must fit in it.
+const formMemory = 1 << 20
+
+// form is a multipart body whose text fields the transforms see as a map;
+// files pass through as they came, streamed from the spooled body.
+type form struct {
+	spool    *buffers.Spooled
+	boundary string
+
+	fields map[string]any
+
+	// values are the fields as they came.
+	values map[string]string
+}
+
+// readForm spools the body of r and reads its text fields. The spool is
+// released when the request is done.
+func readForm(r *http.Request, boundary string) (*form, error) {
+	spool, err := buffers.Spool(r.Body, formMemory)
+	r.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	context.AfterFunc(r.Context(), func() { spool.Close() })
+
+	f := &form{
+		spool:    spool,
+		boundary: boundary,
+
+		fields: map[string]any{},
+		values: map[string]string{},
+	}
+
+	mr := multipart.NewReader(spool.Reader(), boundary)
+	parts := 0
+
+	for ; ; parts++ {
+		p, err := mr.NextRawPart()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Files are skipped by the next NextRawPart without being read.
+		if p.FileName() != "" {
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(p, formMemory+1))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(value) > formMemory {
+			return nil, fmt.Errorf("field %q is too large", p.FormName())
+		}
+
+		// Of repeated fields, like timestamp_granularities[], the
+		// transforms see the first value.
+		if _, ok := f.values[p.FormName()]; !ok {
+			f.fields[p.FormName()] = string(value)
+			f.values[p.FormName()] = string(value)
+		}
+	}
+
+	if parts == 0 {
+		return nil, errors.New("no parts")
+	}
+
+	return f, nil
+}
+
+// changed reports whether the transforms changed, removed or added fields.
+func (f *form) changed(body map[string]any) bool {
+	if len(body) != len(f.values) {
+		return true
+	}
+
+	for name, value := range f.values {
+		v, ok := body[name]
+
+		if !ok || formValue(v) != value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setBody makes the form with the text fields of body the body of r: the
+// spooled body itself when no field changed, a re-encoded copy, spooled as
+// well, otherwise.
+func (f *form) setBody(r *http.Request, body map[string]any) error {
+	if !f.changed(body) {
+		setSpooled(r, f.spool)
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(f.encode(mw, body))
+	}()
+
+	spool, err := buffers.Spool(pr, formMemory)
+	pr.Close()
+
+	if err != nil {
+		return err
+	}
+
+	context.AfterFunc(r.Context(), func() { spool.Close() })
+
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	setSpooled(r, spool)
+
+	return nil
+}
+
+// encode writes the form with the text fields of body to mw: changed ones in
+// place, removed ones left out and new ones at the end. Repeated fields a
+// transform changed are written once, with the new value. Files are copied
+// from the spool as they came.
+func (f *form) encode(mw *multipart.Writer, body map[string]any) error {
+	mr := multipart.NewReader(f.spool.Reader(), f.boundary)
+
+	written := map[string]bool{}
+
+	for {
+		p, err := mr.NextRawPart()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var src io.Reader = p
+		name := p.FormName()
+
+		if p.FileName() == "" {
+			v, ok := body[name]
+
+			if !ok {
+				continue
+			}
+
+			if value := formValue(v); value != f.values[name] {
+				if written[name] {
+					continue
+				}
+
+				src = strings.NewReader(value)
+			}
+
+			written[name] = true
+		}
+
+		w, err := mw.CreatePart(p.Header)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := buffers.Copy(w, src); err != nil {
+			return err
+		}
+	}
+
+	var added []string
+
+	for name := range body {
+		if !written[name] {
+			added = append(added, name)
+		}
+	}
+
+	slices.Sort(added)
+
+	for _, name := range added {
+		if err := mw.WriteField(name, formValue(body[name])); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+// formValue renders a field set by a transform; values other than strings
+// as JSON.
+func formValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(data)
+}
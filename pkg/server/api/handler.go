@@ -1,33 +1,469 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/anomaly"
+	"github.com/adrianliechti/wingman-chat/pkg/archive"
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/breaker"
+	"github.com/adrianliechti/wingman-chat/pkg/buffers"
+	"github.com/adrianliechti/wingman-chat/pkg/canary"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/federation"
+	"github.com/adrianliechti/wingman-chat/pkg/keys"
+	"github.com/adrianliechti/wingman-chat/pkg/metrics"
+	"github.com/adrianliechti/wingman-chat/pkg/moderation"
+	"github.com/adrianliechti/wingman-chat/pkg/queue"
+	"github.com/adrianliechti/wingman-chat/pkg/ratelimit"
+	"github.com/adrianliechti/wingman-chat/pkg/realtime"
+	"github.com/adrianliechti/wingman-chat/pkg/redact"
+	"github.com/adrianliechti/wingman-chat/pkg/security"
+	"github.com/adrianliechti/wingman-chat/pkg/statuspage"
+	"github.com/adrianliechti/wingman-chat/pkg/tokens"
 )
 
 type Handler struct {
+	store *config.Store
+
 	prefix string
-	token  string
+	tokens *tokens.Tokens
 	url    *url.URL
+
+	transport http.RoundTripper
+
+	keys      *keys.Store
+	keyHeader string
+
+	geoHeader string
+
+	platformRegion string
+
+	tools []string
+
+	peer *federation.Peer
+
+	limits  RateLimit
+	limiter *ratelimit.Limiter
+
+	archive   *archive.Archive
+	anomalies *anomaly.Detector
+	canaries  *canary.Tripwire
+	moderator *moderation.Moderator
+	security  *security.Monitor
+	redactor  *redact.Redactor
+
+	jsonRetries int
+
+	realtime *realtime.Proxy
+
+	smoothing time.Duration
+	keepAlive time.Duration
+
+	retry Retry
+
+	breaker *breaker.Breaker
+
+	bodyLimit BodyLimit
+
+	headers HeaderPolicy
+
+	status *statuspage.Monitor
+
+	cache Cache
+
+	queue *queue.Queue
+
+	metrics *usageMetrics
+}
+
+type Options struct {
+	Prefix string
+
+	URL    *url.URL
+	Tokens *tokens.Tokens
+
+	// Transport connects to the platform (e.g. with a client certificate);
+	// http.DefaultTransport when nil.
+	Transport http.RoundTripper
+
+	// Keys enables per-user upstream keys managed at <prefix>/keys.
+	Keys *keys.Store
+
+	// KeyHeader names a request header carrying the caller's own upstream
+	// key, which then replaces the shared token.
+	KeyHeader string
+
+	// GeoIPHeader names a request header carrying the ISO country code of
+	// the client (e.g. CF-IPCountry), set by a trusted reverse proxy.
+	GeoIPHeader string
+
+	// PlatformRegion is the region of residency.yaml the platform serves
+	// in.
+	PlatformRegion string
+
+	// Tools are the ids of MCP servers built into this server.
+	Tools []string
+
+	// Peer receives the requests for federated models and tools.
+	Peer *federation.Peer
+
+	// RateLimit are the default limits per caller and model.
+	RateLimit RateLimit
+
+	// Archive records the model requests and their responses when set.
+	Archive *archive.Archive
+
+	// Anomalies is told about the requests and tokens of each caller when
+	// set.
+	Anomalies *anomaly.Detector
+
+	// Canaries blocks requests with tool calls carrying a canary when set.
+	Canaries *canary.Tripwire
+
+	// Moderator checks the input of chat requests when set.
+	Moderator *moderation.Moderator
+
+	// Security receives the security events of the proxy and decides what
+	// to do about them; without it, their defaults apply.
+	Security *security.Monitor
+
+	// Redactor masks personal data in prompts when set.
+	Redactor *redact.Redactor
+
+	// JSONRetries enables the guaranteed-JSON mode: answers to chat
+	// completions asking for JSON output are validated and the model is
+	// asked to repair invalid ones up to that many times.
+	JSONRetries int
+
+	// Realtime proxies the WebSocket sessions of <prefix>/v1/realtime when
+	// set; they are passed through as they are otherwise.
+	Realtime *realtime.Proxy
+
+	// StreamSmoothing coalesces the chunks of streamed answers into one
+	// write per interval when set.
+	StreamSmoothing time.Duration
+
+	// StreamKeepAlive sends an SSE comment (": ping") on streamed answers
+	// whenever the upstream sent nothing for that long, when set.
+	StreamKeepAlive time.Duration
+
+	// Retry repeats model listings, embeddings, speech and other idempotent
+	// calls that fail transiently.
+	Retry Retry
+
+	// Breaker turns requests to the platform away with 503 while it keeps
+	// failing when set.
+	Breaker *breaker.Breaker
+
+	// BodyLimit caps the size of request bodies.
+	BodyLimit BodyLimit
+
+	// Cache answers the model listing, speech and embeddings of identical
+	// requests without the upstream when its store is set.
+	Cache Cache
+
+	// Queue limits the requests in flight toward the upstream when set.
+	Queue *queue.Queue
+
+	// Metrics receives the usage of the proxy by tenant and model when set.
+	Metrics *metrics.Registry
+
+	// Headers selects the headers passed to the upstream and back; all but
+	// hop-by-hop ones are when empty.
+	Headers HeaderPolicy
+
+	// Status tells users about incidents of the providers when the platform
+	// fails while one is reported.
+	Status *statuspage.Monitor
 }
 
-func New(prefix, token string, url *url.URL) *Handler {
+func New(store *config.Store, opts Options) *Handler {
 	return &Handler{
-		prefix: prefix,
-		token:  token,
-		url:    url,
+		store: store,
+
+		prefix: opts.Prefix,
+		tokens: opts.Tokens,
+		url:    opts.URL,
+
+		transport: opts.Transport,
+
+		keys:      opts.Keys,
+		keyHeader: opts.KeyHeader,
+
+		geoHeader: opts.GeoIPHeader,
+
+		platformRegion: opts.PlatformRegion,
+
+		tools: opts.Tools,
+
+		peer: opts.Peer,
+
+		limits:  opts.RateLimit,
+		limiter: ratelimit.New(),
+
+		archive:   opts.Archive,
+		anomalies: opts.Anomalies,
+		canaries:  opts.Canaries,
+		moderator: opts.Moderator,
+		security:  opts.Security,
+		redactor:  opts.Redactor,
+
+		jsonRetries: opts.JSONRetries,
+
+		realtime: opts.Realtime,
+
+		smoothing: opts.StreamSmoothing,
+		keepAlive: opts.StreamKeepAlive,
+
+		retry: opts.Retry,
+
+		breaker: opts.Breaker,
+
+		bodyLimit: opts.BodyLimit,
+
+		cache: opts.Cache,
+		queue: opts.Queue,
+
+		metrics: newUsageMetrics(opts.Metrics),
+
+		headers: opts.Headers,
+
+		status: opts.Status,
 	}
 }
 
 func (h *Handler) Attach(mux *http.ServeMux) {
-	mux.Handle(h.prefix+"/", http.StripPrefix(h.prefix, &httputil.ReverseProxy{
-		Rewrite: func(r *httputil.ProxyRequest) {
-			r.SetURL(h.url)
+	proxy := &httputil.ReverseProxy{
+		Rewrite:   h.rewrite,
+		Transport: transportFunc(h.roundTrip),
+
+		// Pass streamed answers on as they arrive, whatever their type.
+		FlushInterval: -1,
 
-			if h.token != "" {
-				r.Out.Header.Set("Authorization", "Bearer "+h.token)
+		BufferPool: buffers.Pool,
+
+		ErrorHandler: handleProxyError,
+
+		ModifyResponse: func(resp *http.Response) error {
+			h.headers.Response.apply(resp.Header, essentialResponse)
+
+			// CORS is answered by this server, not the platform.
+			for name := range resp.Header {
+				if strings.HasPrefix(name, "Access-Control-") {
+					resp.Header.Del(name)
+				}
 			}
+
+			h.smoothStream(resp)
+
+			countUsage(resp)
+			h.archiveResponse(resp)
+
+			// Hooks see the answer after its usage is counted and it is
+			// archived as the model gave it.
+			applyResponseHooks(resp)
+
+			// Pings stay out of the usage and the archive.
+			h.keepStreamAlive(resp)
+
+			return h.listTools(resp)
 		},
-	}))
+	}
+
+	if h.keys != nil {
+		h.attachKeys(mux)
+	}
+
+	mux.HandleFunc("GET "+h.prefix+"/catalog", h.handleCatalog)
+
+	mux.Handle(h.prefix+"/", http.StripPrefix(h.prefix, h.withBodyLimit(h.withRouting(h.withRealtime(h.withMetrics(withTranscoding(withTransforms(h.withQueue(proxy), h.bodyLimit, h.transforms()...))))))))
+}
+
+// transforms are what model requests go through, in order.
+func (h *Handler) transforms() []transform {
+	return []transform{
+		h.applyAliases,
+		h.observeMetrics,
+		h.checkAPIKey,
+		h.checkRoles,
+		h.checkCanaries,
+		h.checkRateLimit,
+		h.observeUsage,
+		h.redactPrompt,
+		h.moderate,
+		h.applyDefaults,
+		h.applyHooks,
+		h.enforceSchema,
+		h.guaranteeJSON,
+		h.negotiateFormat,
+		h.routeUpstream,
+		h.routeFederated,
+		h.recordRequest,
+	}
+}
+
+// rewrite points the request to the platform, the peer for federated routes
+// or the upstream of the model, with the credentials and identity headers
+// for it.
+func (h *Handler) rewrite(r *httputil.ProxyRequest) {
+	rt := routeFromContext(r.In.Context())
+
+	h.headers.Request.apply(r.Out.Header, essentialRequest)
+
+	if rt.path != "" {
+		r.Out.URL.Path = rt.path
+		r.Out.URL.RawPath = ""
+	}
+
+	if h.peer != nil && rt.peer {
+		r.SetURL(h.peer.URL)
+
+		if h.peer.Token != "" {
+			r.Out.Header.Set("Authorization", "Bearer "+h.peer.Token)
+		}
+	} else if u := rt.upstream; u != nil {
+		r.SetURL(u.url)
+
+		// The platform credentials of the caller don't belong there.
+		r.Out.Header.Del("Authorization")
+
+		if u.Token != "" {
+			r.Out.Header.Set("Authorization", "Bearer "+u.Token)
+		}
+
+		for name, value := range u.Headers {
+			r.Out.Header.Set(name, value)
+		}
+	} else {
+		r.SetURL(h.url)
+
+		if token := h.upstreamToken(r.In); token != "" {
+			r.Out.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	if h.keyHeader != "" {
+		r.Out.Header.Del(h.keyHeader)
+	}
+
+	setUserHeaders(r.Out.Header, auth.UserFromContext(r.In.Context()))
+
+	r.Out.Header.Del("X-Device-Id")
+
+	if device := auth.DeviceFromContext(r.In.Context()); device != "" {
+		r.Out.Header.Set("X-Device-Id", device)
+	}
+}
+
+// roundTrip validates the answers of models with a schema on the way,
+// answers deterministic calls from the cache and retries idempotent calls,
+// once the residency policy permits where they go.
+func (h *Handler) roundTrip(r *http.Request) (*http.Response, error) {
+	if e := h.checkResidency(r); e != nil {
+		return errorResponse(r, e), nil
+	}
+
+	if check := routeFromContext(r.Context()).schema; check != nil {
+		return h.roundTripSchema(r, check)
+	}
+
+	return h.sendCached(r)
+}
+
+// send uses the platform transport for everything but requests routed to a
+// federated peer or another upstream.
+func (h *Handler) send(r *http.Request) (*http.Response, error) {
+	rt := routeFromContext(r.Context())
+
+	if u := rt.upstream; u != nil {
+		return u.transport().RoundTrip(r)
+	}
+
+	if rt.peer {
+		return http.DefaultTransport.RoundTrip(r)
+	}
+
+	resp, err := h.sendPlatform(r)
+
+	return h.explainOutage(r, resp, err)
+}
+
+// sendPlatform sends r to the platform, unless the breaker is open: then the
+// client learns right away that the platform is down.
+func (h *Handler) sendPlatform(r *http.Request) (*http.Response, error) {
+	transport := h.transport
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	if h.breaker == nil {
+		return transport.RoundTrip(r)
+	}
+
+	done, err := h.breaker.Allow()
+
+	if err != nil {
+		return errorResponse(r, &Error{
+			Status:  http.StatusServiceUnavailable,
+			Code:    "platform_unavailable",
+			Message: "the AI platform is unavailable, please try again later",
+
+			RetryAfter: max(h.breaker.RetryIn(), time.Second),
+		}), nil
+	}
+
+	resp, err := transport.RoundTrip(r)
+
+	switch {
+	case r.Context().Err() != nil:
+		// The client gave up; that says nothing about the platform.
+		done(nil)
+	case err != nil:
+		done(err)
+	case resp.StatusCode >= 500:
+		done(errors.New("platform answered " + resp.Status))
+	default:
+		done(nil)
+	}
+
+	return resp, err
+}
+
+type transportFunc func(*http.Request) (*http.Response, error)
+
+func (f transportFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// setUserHeaders tells the upstream who is calling. Headers sent by the
+// client are dropped so identities can't be forged.
+func setUserHeaders(h http.Header, user *auth.User) {
+	for _, name := range []string{"X-User-Id", "X-User-Email", "X-User-Name", "X-User-Groups"} {
+		h.Del(name)
+	}
+
+	if user == nil {
+		return
+	}
+
+	h.Set("X-User-Id", user.ID)
+
+	if user.Email != "" {
+		h.Set("X-User-Email", user.Email)
+	}
+
+	if user.Name != "" {
+		h.Set("X-User-Name", user.Name)
+	}
+
+	if len(user.Groups) > 0 {
+		h.Set("X-User-Groups", strings.Join(user.Groups, ","))
+	}
 }
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// HeaderFilter selects the headers passed through the proxy in one
+// direction. Patterns match names case-insensitively and may end in * to
+// match a prefix (X-*).
+type HeaderFilter struct {
+	// Allow lists the headers that are passed; all are when empty.
+	Allow []string
+
+	// Deny lists headers that are dropped even if allowed.
+	Deny []string
+}
+
+// HeaderPolicy decides which headers of clients reach the upstream and
+// which headers of the upstream reach clients. Headers the proxy sets
+// itself (credentials, identity) and those the bodies need to be read are
+// not subject to it.
+type HeaderPolicy struct {
+	Request  HeaderFilter
+	Response HeaderFilter
+}
+
+// HeaderPolicyFromEnv reads REQUEST_HEADERS_ALLOW, REQUEST_HEADERS_DENY,
+// RESPONSE_HEADERS_ALLOW and RESPONSE_HEADERS_DENY, comma separated.
+func HeaderPolicyFromEnv() HeaderPolicy {
+	return HeaderPolicy{
+		Request: HeaderFilter{
+			Allow: env.List("REQUEST_HEADERS_ALLOW"),
+			Deny:  env.List("REQUEST_HEADERS_DENY"),
+		},
+
+		Response: HeaderFilter{
+			Allow: env.List("RESPONSE_HEADERS_ALLOW"),
+			Deny:  env.List("RESPONSE_HEADERS_DENY"),
+		},
+	}
+}
+
+// essentialRequest and essentialResponse pass whatever the policy says.
+var (
+	essentialRequest  = []string{"Content-Type", "Content-Encoding", "Accept", "Accept-Encoding"}
+	essentialResponse = []string{"Content-Type", "Content-Encoding", "Content-Length", "Retry-After"}
+)
+
+func (f HeaderFilter) empty() bool {
+	return len(f.Allow) == 0 && len(f.Deny) == 0
+}
+
+func (f HeaderFilter) apply(header http.Header, essential []string) {
+	if f.empty() {
+		return
+	}
+
+	for name := range header {
+		if slices.ContainsFunc(essential, func(e string) bool { return strings.EqualFold(e, name) }) {
+			continue
+		}
+
+		if (len(f.Allow) > 0 && !matchHeader(f.Allow, name)) || matchHeader(f.Deny, name) {
+			header.Del(name)
+		}
+	}
+}
+
+func matchHeader(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(p string) bool {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			return len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix)
+		}
+
+		return strings.EqualFold(p, name)
+	})
+}
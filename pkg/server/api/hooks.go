@@ -0,0 +1,324 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// maxHookBody bounds the answers response hooks transform; larger ones pass
+// untouched.
+const maxHookBody = 8 << 20
+
+var hookClient = &http.Client{Timeout: 10 * time.Second}
+
+// responseHooks are the hooks of hooks.yaml that apply to the answer of a
+// request, chosen when it was made.
+type responseHooks struct {
+	path  string
+	model string
+
+	hooks []config.Hook
+}
+
+// applyHooks runs the request hooks of hooks.yaml that apply to the path
+// and model of the request and notes those for its answer.
+func (h *Handler) applyHooks(r *http.Request, body map[string]any) error {
+	hooks := tenant.Config(r.Context(), h.store).Hooks
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	model, _ := body["model"].(string)
+
+	for _, hook := range hooks {
+		if !hook.Applies(config.HookRequest, r.URL.Path, model) {
+			continue
+		}
+
+		if e := runHook(r, hook, config.HookRequest, r.URL.Path, model, body); e != nil {
+			return e
+		}
+	}
+
+	rh := &responseHooks{path: r.URL.Path, model: model}
+
+	for _, hook := range hooks {
+		if hook.Applies(config.HookResponse, r.URL.Path, model) {
+			rh.hooks = append(rh.hooks, hook)
+		}
+	}
+
+	if len(rh.hooks) > 0 {
+		routeFromContext(r.Context()).hooks = rh
+	}
+
+	return nil
+}
+
+// applyResponseHooks runs the response hooks noted for the request on its
+// answer, if it was successful, JSON and not streamed.
+func applyResponseHooks(resp *http.Response) {
+	rh := routeFromContext(resp.Request.Context()).hooks
+
+	if rh == nil || resp.StatusCode < 200 || resp.StatusCode > 299 || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxHookBody+1))
+
+	if err != nil {
+		resp.Body.Close()
+		*resp = *errorResponse(resp.Request, &Error{Status: http.StatusBadGateway, Message: err.Error()})
+		return
+	}
+
+	if len(data) > maxHookBody {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return
+	}
+
+	resp.Body.Close()
+
+	var body map[string]any
+
+	if err := json.Unmarshal(data, &body); err != nil || body == nil {
+		setResponseBody(resp, data)
+		return
+	}
+
+	for _, hook := range rh.hooks {
+		if e := runHook(resp.Request, hook, config.HookResponse, rh.path, rh.model, body); e != nil {
+			*resp = *errorResponse(resp.Request, e)
+			return
+		}
+	}
+
+	data, _ = json.Marshal(body)
+	setResponseBody(resp, data)
+}
+
+// runHook applies hook to body in place.
+func runHook(r *http.Request, hook config.Hook, stage, path, model string, body map[string]any) *Error {
+	if hook.System != "" {
+		prependSystem(path, body, hook.System)
+	}
+
+	for key, value := range hook.Set {
+		setPath(body, strings.Split(key, "."), cloneValue(value))
+	}
+
+	for _, key := range hook.Remove {
+		removePath(body, strings.Split(key, "."))
+	}
+
+	if hook.URL == "" {
+		return nil
+	}
+
+	result, e := callHook(r.Context(), hook, map[string]any{
+		"hook":  hook.Name,
+		"stage": stage,
+
+		"path":  path,
+		"model": model,
+
+		"user":   userID(r),
+		"tenant": tenantID(r),
+
+		"body": body,
+	})
+
+	if e != nil {
+		return e
+	}
+
+	if result != nil {
+		clear(body)
+
+		for key, value := range result {
+			body[key] = value
+		}
+	}
+
+	return nil
+}
+
+// callHook posts the request to the hook service. It answers 200 with
+// {"body": ...} to replace the body, 204 to keep it, or 4xx to reject it.
+func callHook(ctx context.Context, hook config.Hook, request map[string]any) (map[string]any, *Error) {
+	data, _ := json.Marshal(request)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(data))
+
+	if err != nil {
+		return nil, hookFailed(hook, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := hookClient.Do(req)
+
+	if err != nil {
+		return nil, hookFailed(hook, err)
+	}
+
+	defer resp.Body.Close()
+
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxHookBody))
+
+	if err != nil {
+		return nil, hookFailed(hook, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return nil, nil
+
+	case resp.StatusCode == http.StatusOK:
+		var result struct {
+			Body map[string]any `json:"body"`
+		}
+
+		if err := json.Unmarshal(data, &result); err != nil || result.Body == nil {
+			return nil, hookFailed(hook, fmt.Errorf("unexpected answer"))
+		}
+
+		return result.Body, nil
+
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return nil, &Error{Status: resp.StatusCode, Code: "hook_rejected", Message: hookMessage(hook, data)}
+	}
+
+	return nil, hookFailed(hook, fmt.Errorf("unexpected status %d", resp.StatusCode))
+}
+
+func hookFailed(hook config.Hook, err error) *Error {
+	slog.Error("hooks", "hook", hookName(hook), "error", err)
+	return &Error{Status: http.StatusBadGateway, Code: "hook_failed", Message: "the hook " + hookName(hook) + " failed"}
+}
+
+// hookMessage is the reason a hook service gave for a rejection, as
+// {"message": ...} or plain text.
+func hookMessage(hook config.Hook, data []byte) string {
+	var result struct {
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(data, &result) == nil && result.Message != "" {
+		return result.Message
+	}
+
+	if text := strings.TrimSpace(string(data)); text != "" && len(text) <= 1024 && !strings.HasPrefix(text, "{") {
+		return text
+	}
+
+	return "rejected by the hook " + hookName(hook)
+}
+
+func hookName(hook config.Hook) string {
+	if hook.Name != "" {
+		return hook.Name
+	}
+
+	return hook.URL
+}
+
+// prependSystem adds text as the first system message of chat completions
+// and ahead of the instructions of responses.
+func prependSystem(path string, body map[string]any, text string) {
+	switch path {
+	case "/v1/chat/completions":
+		messages, _ := body["messages"].([]any)
+		body["messages"] = append([]any{map[string]any{"role": "system", "content": text}}, messages...)
+
+	case "/v1/responses":
+		if instructions, _ := body["instructions"].(string); instructions != "" {
+			text += "\n\n" + instructions
+		}
+
+		body["instructions"] = text
+	}
+}
+
+// setPath sets the field at path, creating the objects on the way.
+func setPath(body map[string]any, path []string, value any) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := body[key].(map[string]any)
+
+		if !ok {
+			next = map[string]any{}
+			body[key] = next
+		}
+
+		body = next
+	}
+
+	body[path[len(path)-1]] = value
+}
+
+// cloneValue copies a value of the configuration, so later transforms can't
+// change it through the body.
+func cloneValue(v any) any {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return v
+	}
+
+	var c any
+	json.Unmarshal(data, &c)
+
+	return c
+}
+
+// removePath drops the field at path, in each element of the arrays on the
+// way.
+func removePath(v any, path []string) {
+	switch v := v.(type) {
+	case map[string]any:
+		if len(path) == 1 {
+			delete(v, path[0])
+			return
+		}
+
+		removePath(v[path[0]], path[1:])
+
+	case []any:
+		for _, e := range v {
+			removePath(e, path)
+		}
+	}
+}
+
+func userID(r *http.Request) string {
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		return user.ID
+	}
+
+	return ""
+}
+
+func tenantID(r *http.Request) string {
+	if t := tenant.FromContext(r.Context()); t != nil {
+		return t.ID
+	}
+
+	return ""
+}
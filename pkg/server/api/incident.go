@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// explainOutage replaces failed answers of the platform with what the status
+// pages of the providers report, when they report problems, so users learn
+// about the provider incident rather than see a generic error.
+func (h *Handler) explainOutage(r *http.Request, resp *http.Response, err error) (*http.Response, error) {
+	if h.status == nil || r.Context().Err() != nil || (err == nil && resp.StatusCode < 500) {
+		return resp, err
+	}
+
+	incidents := h.status.Incidents()
+
+	if len(incidents) == 0 {
+		return resp, err
+	}
+
+	var reports []string
+
+	for _, i := range incidents {
+		reports = append(reports, i.String())
+	}
+
+	e := &Error{
+		Status:  http.StatusBadGateway,
+		Code:    "provider_incident",
+		Message: "the AI provider reports an incident, please try again later: " + strings.Join(reports, ", "),
+	}
+
+	if resp == nil {
+		return errorResponse(r, e), nil
+	}
+
+	resp.Body.Close()
+
+	e.Status = resp.StatusCode
+	result := errorResponse(r, e)
+
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		result.Header.Set("Retry-After", v)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// ping is the SSE comment sent to keep idle streams open; clients ignore it.
+var ping = []byte(": ping\n\n")
+
+// keepStreamAlive sends a comment on streamed answers whenever the upstream
+// sent nothing for the keep-alive interval, so proxies on the way don't
+// close the connection as idle.
+func (h *Handler) keepStreamAlive(resp *http.Response) {
+	if h.keepAlive <= 0 {
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		return
+	}
+
+	resp.Body = newPinger(resp.Body, h.keepAlive)
+}
+
+// pinger reads the body in the background and hands out a ping when it has
+// been idle for an interval. Pings only go between events, never into one
+// the upstream is still sending.
+type pinger struct {
+	body     io.ReadCloser
+	interval time.Duration
+
+	chunks chan []byte
+	done   chan struct{}
+
+	// err ends the body once chunks is closed.
+	err error
+
+	pending []byte
+
+	// tail are the last bytes handed out, to tell where events end.
+	tail []byte
+}
+
+func newPinger(body io.ReadCloser, interval time.Duration) *pinger {
+	p := &pinger{
+		body:     body,
+		interval: interval,
+
+		chunks: make(chan []byte, 64),
+		done:   make(chan struct{}),
+	}
+
+	go p.read()
+
+	return p
+}
+
+func (p *pinger) read() {
+	defer close(p.chunks)
+
+	for {
+		buf := make([]byte, 32<<10)
+		n, err := p.body.Read(buf)
+
+		if n > 0 {
+			select {
+			case p.chunks <- buf[:n]:
+			case <-p.done:
+				return
+			}
+		}
+
+		if err != nil {
+			p.err = err
+			return
+		}
+	}
+}
+
+func (p *pinger) Read(b []byte) (int, error) {
+	if len(p.pending) == 0 {
+		timer := time.NewTimer(p.interval)
+		defer timer.Stop()
+
+	wait:
+		for {
+			select {
+			case chunk, ok := <-p.chunks:
+				if !ok {
+					return 0, p.err
+				}
+
+				p.pending = chunk
+				break wait
+
+			case <-timer.C:
+				if p.betweenEvents() {
+					p.pending = ping
+					break wait
+				}
+
+				timer.Reset(p.interval)
+			}
+		}
+	}
+
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+
+	p.tail = append(p.tail, b[:n]...)
+
+	if len(p.tail) > 4 {
+		p.tail = append(p.tail[:0], p.tail[len(p.tail)-4:]...)
+	}
+
+	return n, nil
+}
+
+// betweenEvents reports whether the stream is at its start or the last
+// event has ended with a blank line.
+func (p *pinger) betweenEvents() bool {
+	if len(p.tail) == 0 {
+		return true
+	}
+
+	return bytes.HasSuffix(p.tail, []byte("\n\n")) || bytes.HasSuffix(p.tail, []byte("\r\r")) || bytes.HasSuffix(p.tail, []byte("\n\r\n"))
+}
+
+func (p *pinger) Close() error {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+
+	return p.body.Close()
+}
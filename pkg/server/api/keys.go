@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/keys"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// upstreamToken picks the credential for the platform: a key sent by the
+// client, then the key the user stored, then the tenant's, then the shared
+// token.
+func (h *Handler) upstreamToken(r *http.Request) string {
+	if key := h.ownKey(r); key != "" {
+		return key
+	}
+
+	if t := tenant.FromContext(r.Context()); t != nil && t.Token != "" {
+		return t.Token
+	}
+
+	return h.tokens.Token()
+}
+
+// ownKey returns the caller's own upstream key, sent along with the request
+// or stored, if any.
+func (h *Handler) ownKey(r *http.Request) string {
+	rt := routeFromContext(r.Context())
+
+	if !rt.keyLoaded {
+		rt.key = h.lookupKey(r)
+		rt.keyLoaded = true
+	}
+
+	return rt.key
+}
+
+func (h *Handler) lookupKey(r *http.Request) string {
+	if h.keyHeader != "" {
+		if key := strings.TrimPrefix(r.Header.Get(h.keyHeader), "Bearer "); key != "" {
+			return key
+		}
+	}
+
+	// Only a user who proved who they are may spend the key stored for them.
+	if h.keys != nil {
+		if user := auth.Authenticated(r.Context()); user != nil {
+			key, _, err := h.keys.Get(user.ID)
+
+			if err == nil {
+				return key
+			}
+
+			if !errors.Is(err, keys.ErrNotFound) {
+				slog.Error("keys", "user", user.ID, "error", err)
+			}
+		}
+	}
+
+	return ""
+}
+
+// validateKey asks the platform to list its models with key, so keys it
+// rejects aren't stored. Other failures leave the key to be tried later.
+func (h *Handler) validateKey(r *http.Request, key string) error {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, h.url.JoinPath("v1", "models").String(), nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	transport := h.transport
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+
+	if err != nil {
+		slog.Error("keys: validation", "error", err)
+		return nil
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errors.New("the key was rejected by the platform (" + resp.Status + ")")
+	}
+
+	return nil
+}
+
+type keyInfo struct {
+	Configured bool       `json:"configured"`
+	Hint       string     `json:"hint,omitempty"`
+	Updated    *time.Time `json:"updated,omitempty"`
+}
+
+// attachKeys lets authenticated users manage their own upstream key. The key
+// is never returned, only a hint to recognize it.
+func (h *Handler) attachKeys(mux *http.ServeMux) {
+	path := h.prefix + "/keys"
+
+	mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+		user := requireUser(w, r)
+
+		if user == nil {
+			return
+		}
+
+		info := keyInfo{}
+
+		key, updated, err := h.keys.Get(user.ID)
+
+		if err != nil && !errors.Is(err, keys.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err == nil {
+			info = keyInfo{Configured: true, Hint: keys.Hint(key), Updated: &updated}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	})
+
+	mux.HandleFunc("PUT "+path, func(w http.ResponseWriter, r *http.Request) {
+		user := requireUser(w, r)
+
+		if user == nil {
+			return
+		}
+
+		var body struct {
+			Key string `json:"key"`
+		}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil || strings.TrimSpace(body.Key) == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+
+		key := strings.TrimSpace(body.Key)
+
+		if err := h.validateKey(r, key); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := h.keys.Set(user.ID, key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("DELETE "+path, func(w http.ResponseWriter, r *http.Request) {
+		user := requireUser(w, r)
+
+		if user == nil {
+			return
+		}
+
+		if err := h.keys.Delete(user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// requireUser answers 401 unless the caller is authenticated; guests and
+// anonymous callers have no key of their own.
+func requireUser(w http.ResponseWriter, r *http.Request) *auth.User {
+	user := auth.Authenticated(r.Context())
+
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+
+	return user
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/keys"
+)
+
+func keysHandler(t *testing.T) *Handler {
+	store, err := keys.NewStore(t.TempDir(), "secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Set("alice", "sk-alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Handler{prefix: "/api", keys: store}
+}
+
+func asUser(r *http.Request, id string, source auth.Source) *http.Request {
+	return r.WithContext(auth.WithUser(r.Context(), &auth.User{ID: id}, source))
+}
+
+func TestStoredKeyNeedsAuthentication(t *testing.T) {
+	h := keysHandler(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	if key := h.lookupKey(asUser(r, "alice", auth.SourceGuest)); key != "" {
+		t.Errorf("a guest named alice spent her key %q", key)
+	}
+
+	if key := h.lookupKey(r); key != "" {
+		t.Errorf("an anonymous caller spent the key %q", key)
+	}
+
+	if key := h.lookupKey(asUser(r, "alice", auth.SourceLogin)); key != "sk-alice" {
+		t.Errorf("key = %q, want sk-alice", key)
+	}
+}
+
+func TestKeysEndpointNeedsAuthentication(t *testing.T) {
+	h := keysHandler(t)
+
+	mux := http.NewServeMux()
+	h.attachKeys(mux)
+
+	for _, tc := range []struct {
+		source auth.Source
+		status int
+	}{
+		{0, http.StatusUnauthorized},
+		{auth.SourceGuest, http.StatusUnauthorized},
+		{auth.SourceProxy, http.StatusNoContent},
+	} {
+		r := httptest.NewRequest(http.MethodDelete, "/api/keys", nil)
+
+		if tc.source != 0 {
+			r = asUser(r, "alice", tc.source)
+		}
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, r)
+
+		if rec.Code != tc.status {
+			t.Errorf("source %d: status = %d, want %d", tc.source, rec.Code, tc.status)
+		}
+	}
+
+	if _, _, err := h.keys.Get("alice"); err != keys.ErrNotFound {
+		t.Errorf("the key of alice is still stored: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// BodyLimit caps the size of the request bodies the proxy accepts, so a
+// single huge upload can't exhaust the memory of the server.
+type BodyLimit struct {
+	// Default is the limit in bytes of endpoints without one of their own;
+	// none when 0.
+	Default int64
+
+	// Routes are limits of endpoints by path below the prefix, e.g. a larger
+	// one for /v1/audio/transcriptions.
+	Routes map[string]int64
+}
+
+// BodyLimitFromEnv reads MAX_REQUEST_BODY (default 32MB; 0 disables the
+// limit) and the per-endpoint MAX_REQUEST_BODY_ROUTES (path=size, comma
+// separated) over the defaults, which leave transcriptions room for longer
+// recordings.
+func BodyLimitFromEnv() (BodyLimit, error) {
+	l := BodyLimit{
+		Routes: map[string]int64{
+			"/v1/audio/transcriptions": 64 << 20,
+			"/v1/audio/translations":   64 << 20,
+		},
+	}
+
+	n, err := env.Size("MAX_REQUEST_BODY", 32<<20)
+
+	if err != nil {
+		return l, err
+	}
+
+	l.Default = n
+
+	for _, route := range env.List("MAX_REQUEST_BODY_ROUTES") {
+		path, size, _ := strings.Cut(route, "=")
+		path = strings.TrimSpace(path)
+
+		n, err := env.ParseSize(size)
+
+		if err != nil || !strings.HasPrefix(path, "/") {
+			return l, fmt.Errorf("MAX_REQUEST_BODY_ROUTES: invalid entry %q", route)
+		}
+
+		l.Routes[path] = n
+	}
+
+	return l, nil
+}
+
+func (l BodyLimit) limit(path string) int64 {
+	if n, ok := l.Routes[path]; ok {
+		return n
+	}
+
+	return l.Default
+}
+
+// withBodyLimit rejects requests whose body exceeds the limit of their
+// endpoint with 413: right away when they announce their length, as soon as
+// the limit is read past otherwise.
+func (h *Handler) withBodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := h.bodyLimit.limit(r.URL.Path)
+
+		if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > limit {
+			writeError(w, tooLarge(limit))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tooLarge(limit int64) *Error {
+	return &Error{
+		Status:  http.StatusRequestEntityTooLarge,
+		Code:    "request_too_large",
+		Message: fmt.Sprintf("request body exceeds the limit of %d bytes", limit),
+	}
+}
+
+// handleProxyError answers requests the proxy failed on: with 413 when the
+// body was cut off by its limit while being sent, with 502 as the
+// ReverseProxy does otherwise.
+func handleProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooBig *http.MaxBytesError
+
+	if errors.As(err, &tooBig) {
+		writeError(w, tooLarge(tooBig.Limit))
+		return
+	}
+
+	if r.Context().Err() == nil {
+		slog.Error("api: proxy", "error", err)
+	}
+
+	w.WriteHeader(http.StatusBadGateway)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/adrianliechti/wingman-chat/pkg/rbac"
+)
+
+// listTools adds the MCP servers built into this server (and those of a
+// federated peer) to the upstream's /v1/mcp listing, which the UI uses to
+// decide which tools are available, and drops those the caller's roles don't
+// grant.
+func (h *Handler) listTools(resp *http.Response) error {
+	if resp.Request.Method != http.MethodGet || resp.Request.URL.Path != "/v1/mcp" {
+		return nil
+	}
+
+	p := rbac.ForRequest(resp.Request, h.store)
+
+	tools := append(slices.Clone(h.tools), h.federatedTools(resp.Request)...)
+
+	if len(tools) == 0 && p == nil {
+		return nil
+	}
+
+	var list struct {
+		Object string           `json:"object"`
+		Data   []map[string]any `json:"data"`
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, &list); err != nil {
+			// Unknown shape; pass it on untouched.
+			setResponseBody(resp, data)
+			return nil
+		}
+	} else {
+		// The platform offers no MCP servers of its own.
+		resp.Body.Close()
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+	}
+
+	if list.Object == "" {
+		list.Object = "list"
+	}
+
+	for _, id := range tools {
+		if !slices.ContainsFunc(list.Data, func(m map[string]any) bool { return m["id"] == id }) {
+			list.Data = append(list.Data, map[string]any{"id": id, "object": "mcp"})
+		}
+	}
+
+	list.Data = slices.DeleteFunc(list.Data, func(m map[string]any) bool {
+		id, _ := m["id"].(string)
+		return !p.AllowsTool(id)
+	})
+
+	data, _ := json.Marshal(list)
+
+	resp.Header.Set("Content-Type", "application/json")
+	setResponseBody(resp, data)
+
+	return nil
+}
+
+func setResponseBody(resp *http.Response, data []byte) {
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	resp.Header.Del("Content-Encoding")
+}
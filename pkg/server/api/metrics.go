@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/metrics"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// usageMetrics count the requests, tokens and durations of the proxy by
+// tenant and model.
+type usageMetrics struct {
+	requests *metrics.Counter
+	tokens   *metrics.Counter
+	cached   *metrics.Counter
+
+	duration *metrics.Histogram
+}
+
+func newUsageMetrics(r *metrics.Registry) *usageMetrics {
+	if r == nil {
+		return nil
+	}
+
+	return &usageMetrics{
+		requests: r.Counter("wingman_requests_total", "Requests to the proxy by tenant, model and status.", "tenant", "model", "status"),
+		tokens:   r.Counter("wingman_tokens_total", "Tokens the answers reported as used, by tenant and model.", "tenant", "model"),
+		cached:   r.Counter("wingman_cache_hits_total", "Requests answered from the cache, by tenant and model.", "tenant", "model"),
+
+		duration: r.Histogram("wingman_request_duration_seconds", "Duration of requests to the proxy until their answer was complete, by tenant and model.", metrics.DefaultBuckets, "tenant", "model"),
+	}
+}
+
+// metricLabels are the tenant and model of r. Models that aren't configured
+// count as "other", so clients can't grow the series at will.
+func (h *Handler) metricLabels(r *http.Request) (string, string) {
+	id := "default"
+
+	if t := tenant.FromContext(r.Context()); t != nil {
+		id = t.ID
+	}
+
+	model := routeFromContext(r.Context()).model
+
+	if model != "" && !slices.ContainsFunc(tenant.Config(r.Context(), h.store).Models, func(m config.Model) bool { return m.ID == model }) {
+		model = "other"
+	}
+
+	return id, model
+}
+
+// observeMetrics notes the model of the request and counts the tokens of
+// its answer.
+func (h *Handler) observeMetrics(r *http.Request, body map[string]any) error {
+	if h.metrics == nil {
+		return nil
+	}
+
+	rt := routeFromContext(r.Context())
+	rt.model, _ = body["model"].(string)
+
+	onUsage(r, body, func(tokens int) {
+		tenant, model := h.metricLabels(r)
+		h.metrics.tokens.Add(float64(tokens), tenant, model)
+	})
+
+	return nil
+}
+
+// withMetrics counts the requests of the proxy and how long they take.
+func (h *Handler) withMetrics(next http.Handler) http.Handler {
+	if h.metrics == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		tenant, model := h.metricLabels(r)
+
+		h.metrics.requests.Add(1, tenant, model, strconv.Itoa(sw.status))
+		h.metrics.duration.Observe(time.Since(started).Seconds(), tenant, model)
+
+		if routeFromContext(r.Context()).cached {
+			h.metrics.cached.Add(1, tenant, model)
+		}
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
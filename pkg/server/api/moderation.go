@@ -0,0 +1,106 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/moderation"
+	"github.com/adrianliechti/wingman-chat/pkg/security"
+)
+
+// moderate classifies the new user input of chat requests, the messages
+// after the last answer, before they reach the model. Flagged categories
+// are reported as security events, whose policy decides whether to reject
+// the request; the moderation policy applies to categories without one.
+func (h *Handler) moderate(r *http.Request, body map[string]any) error {
+	if h.moderator == nil || (r.URL.Path != "/v1/chat/completions" && r.URL.Path != "/v1/responses") {
+		return nil
+	}
+
+	categories, err := h.moderator.Check(r.Context(), moderationInputs(body))
+
+	if err != nil {
+		slog.Error("moderation", "error", err)
+
+		if h.moderator.Policy() == moderation.Block {
+			return &Error{Status: http.StatusServiceUnavailable, Code: "moderation_unavailable", Message: "content moderation is unavailable"}
+		}
+
+		return nil
+	}
+
+	if len(categories) == 0 {
+		return nil
+	}
+
+	fallback := security.Action{Block: h.moderator.Policy() == moderation.Block}
+
+	if h.security.Report(r, security.Moderation, categories, "", fallback).Block {
+		return &Error{Status: http.StatusBadRequest, Code: "content_flagged", Message: "the input was flagged by content moderation: " + strings.Join(categories, ", ")}
+	}
+
+	return nil
+}
+
+// moderationInputs returns the text and images of the user messages after
+// the last assistant message, of chat completions and responses alike.
+func moderationInputs(body map[string]any) []moderation.Input {
+	var messages []any
+
+	switch v := body["input"].(type) {
+	case string:
+		return []moderation.Input{{Text: v}}
+	case []any:
+		messages = v
+	default:
+		messages, _ = body["messages"].([]any)
+	}
+
+	start := len(messages)
+
+	for start > 0 {
+		if m, _ := messages[start-1].(map[string]any); m["role"] == "assistant" {
+			break
+		}
+
+		start--
+	}
+
+	var inputs []moderation.Input
+
+	for _, m := range messages[start:] {
+		m, _ := m.(map[string]any)
+
+		if m["role"] != "user" {
+			continue
+		}
+
+		switch content := m["content"].(type) {
+		case string:
+			inputs = append(inputs, moderation.Input{Text: content})
+
+		case []any:
+			for _, p := range content {
+				p, _ := p.(map[string]any)
+
+				switch p["type"] {
+				case "text", "input_text":
+					text, _ := p["text"].(string)
+					inputs = append(inputs, moderation.Input{Text: text})
+
+				case "image_url":
+					img, _ := p["image_url"].(map[string]any)
+					url, _ := img["url"].(string)
+					inputs = append(inputs, moderation.Input{ImageURL: url})
+
+				case "input_image":
+					url, _ := p["image_url"].(string)
+					inputs = append(inputs, moderation.Input{ImageURL: url})
+				}
+			}
+		}
+	}
+
+	return inputs
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/adrianliechti/wingman-chat/pkg/federation"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+)
+
+// proxied are the platform endpoints the frontend uses. Their bodies are the
+// platform's (OpenAI compatible) and are passed on, so they are only
+// described as objects.
+var proxied = []struct {
+	method, path, summary string
+}{
+	{"GET", "/v1/models", "List the models of the platform"},
+	{"POST", "/v1/chat/completions", "Create a chat completion, streamed with \"stream\": true"},
+	{"POST", "/v1/responses", "Create a response"},
+	{"POST", "/v1/embeddings", "Create embeddings"},
+	{"POST", "/v1/images/generations", "Generate images"},
+	{"POST", "/v1/audio/speech", "Synthesize speech"},
+	{"POST", "/v1/audio/transcriptions", "Transcribe audio"},
+	{"POST", "/v1/mcp", "Call the MCP server of a tool"},
+}
+
+func (h *Handler) Describe(spec *openapi.Spec) {
+	object := map[string]any{"type": "object"}
+
+	spec.Add("GET", h.prefix+"/catalog", openapi.Operation{
+		Summary: "The models and tools available to the caller", Tag: "api", Security: openapi.User,
+		Response: reflect.TypeFor[federation.Catalog](),
+	})
+
+	if h.keys != nil {
+		spec.Add("GET", h.prefix+"/keys", openapi.Operation{
+			Summary: "Whether the user has an upstream key of their own", Tag: "api", Security: openapi.User,
+			Response: reflect.TypeFor[keyInfo](),
+		})
+
+		spec.Add("PUT", h.prefix+"/keys", openapi.Operation{
+			Summary: "Set the upstream key of the user", Tag: "api", Security: openapi.User,
+			Request: reflect.TypeFor[struct {
+				Key string `json:"key"`
+			}](),
+			Status: http.StatusNoContent,
+		})
+
+		spec.Add("DELETE", h.prefix+"/keys", openapi.Operation{
+			Summary: "Remove the upstream key of the user", Tag: "api", Security: openapi.User,
+			Status: http.StatusNoContent,
+		})
+	}
+
+	for _, p := range proxied {
+		op := openapi.Operation{
+			Summary: p.summary, Tag: "platform", Security: openapi.User,
+			Response: object,
+		}
+
+		if p.method == "POST" {
+			op.Request = object
+		}
+
+		spec.Add(p.method, h.prefix+p.path, op)
+	}
+}
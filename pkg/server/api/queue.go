@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/queue"
+)
+
+// backgroundPaths are endpoints whose requests run in the background.
+var backgroundPaths = []string{
+	"/v1/research",
+}
+
+// priority tells background requests, marked by the client with
+// X-Priority: background or made to a background endpoint, from the
+// interactive rest.
+func priority(r *http.Request) queue.Priority {
+	if strings.EqualFold(r.Header.Get("X-Priority"), "background") {
+		return queue.Background
+	}
+
+	for _, p := range backgroundPaths {
+		if strings.HasSuffix(r.URL.Path, p) {
+			return queue.Background
+		}
+	}
+
+	return queue.Interactive
+}
+
+// withQueue holds a slot of the queue while a request is sent and its
+// answer streamed, waiting for one as long as the client does.
+func (h *Handler) withQueue(next http.Handler) http.Handler {
+	if h.queue == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := h.queue.Acquire(r.Context(), caller(r), priority(r))
+
+		if errors.Is(err, queue.ErrFull) {
+			writeError(w, &Error{Status: http.StatusTooManyRequests, Code: "server_busy", Message: "too many requests are waiting for the upstream", RetryAfter: time.Second})
+			return
+		}
+
+		if err != nil {
+			// The client is gone.
+			return
+		}
+
+		defer release()
+
+		r.Header.Del("X-Priority")
+
+		next.ServeHTTP(w, r)
+	})
+}
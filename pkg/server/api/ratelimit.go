@@ -0,0 +1,203 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// RateLimit limits each caller per model and minute; 0 is unlimited.
+type RateLimit struct {
+	Requests int
+	Tokens   int
+}
+
+// RateLimitFromEnv reads RATE_LIMIT_REQUESTS and RATE_LIMIT_TOKENS.
+func RateLimitFromEnv() RateLimit {
+	return RateLimit{
+		Requests: env.Int("RATE_LIMIT_REQUESTS", 0),
+		Tokens:   env.Int("RATE_LIMIT_TOKENS", 0),
+	}
+}
+
+// checkRateLimit counts a model request against the token buckets of its
+// caller, keyed by authenticated user (API keys have users of their own), or
+// device or client address for everyone else. Tokens are charged once the response
+// reports its usage, so a request is let through while any are left.
+func (h *Handler) checkRateLimit(r *http.Request, body map[string]any) error {
+	model, _ := body["model"].(string)
+
+	limits := h.limits
+
+	for _, m := range tenant.Config(r.Context(), h.store).Models {
+		if m.ID != model {
+			continue
+		}
+
+		if m.RequestsPerMinute != 0 {
+			limits.Requests = m.RequestsPerMinute
+		}
+
+		if m.TokensPerMinute != 0 {
+			limits.Tokens = m.TokensPerMinute
+		}
+
+		break
+	}
+
+	if limits.Requests <= 0 && limits.Tokens <= 0 {
+		return nil
+	}
+
+	key := caller(r) + "\x00" + model
+
+	// Calls on the caller's own key are billed to them, so they don't
+	// spend the budget of the shared one.
+	if h.ownKey(r) != "" {
+		key = "own\x00" + key
+	}
+
+	if wait := h.limiter.Take("tokens\x00"+key, limits.Tokens, 1); wait > 0 {
+		return rateLimited(fmt.Sprintf("token limit of %d per minute exceeded for model %q", limits.Tokens, model), wait)
+	}
+
+	if wait := h.limiter.Take("requests\x00"+key, limits.Requests, 1); wait > 0 {
+		return rateLimited(fmt.Sprintf("rate limit of %d requests per minute exceeded for model %q", limits.Requests, model), wait)
+	}
+
+	if limits.Tokens > 0 {
+		onUsage(r, body, func(tokens int) {
+			h.limiter.Charge("tokens\x00"+key, limits.Tokens, tokens-1)
+		})
+	}
+
+	return nil
+}
+
+// onUsage calls f with the tokens the response to r reports as used, after
+// earlier callbacks.
+func onUsage(r *http.Request, body map[string]any, f func(tokens int)) {
+	rt := routeFromContext(r.Context())
+
+	if prev := rt.usage; prev != nil {
+		rt.usage = func(tokens int) {
+			prev(tokens)
+			f(tokens)
+		}
+	} else {
+		rt.usage = f
+	}
+
+	// Streamed chat completions only report usage when asked to.
+	if stream, _ := body["stream"].(bool); stream && r.URL.Path == "/v1/chat/completions" {
+		if _, ok := body["stream_options"]; !ok {
+			body["stream_options"] = map[string]any{"include_usage": true}
+		}
+	}
+}
+
+func rateLimited(message string, wait time.Duration) error {
+	return &Error{Status: http.StatusTooManyRequests, Code: "rate_limit_exceeded", Message: message, RetryAfter: wait}
+}
+
+// caller is who limits count against: the authenticated user, else the
+// device, else the client address. Identities that weren't authenticated
+// could be changed at will to escape the limits.
+func caller(r *http.Request) string {
+	var id string
+
+	if t := tenant.FromContext(r.Context()); t != nil {
+		id = t.ID + "/"
+	}
+
+	if user := auth.Authenticated(r.Context()); user != nil {
+		return id + "user:" + user.ID
+	}
+
+	if device := auth.DeviceFromContext(r.Context()); device != "" {
+		return id + "device:" + device
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return id + "addr:" + host
+}
+
+// countUsage reports the tokens used by a response to the route's usage
+// callback when the body has been read; answers from the cache use none.
+func countUsage(resp *http.Response) {
+	rt := routeFromContext(resp.Request.Context())
+
+	if rt.usage == nil || rt.cached {
+		return
+	}
+
+	resp.Body = &usageReader{ReadCloser: resp.Body, report: rt.usage}
+}
+
+// totalTokens matches the usage of both the chat completions and the
+// responses API, in plain and streamed bodies alike.
+var totalTokens = regexp.MustCompile(`"total_tokens"\s*:\s*(\d+)`)
+
+// usageReader passes the body through, remembering the last total_tokens
+// seen, which is reported once at the end.
+type usageReader struct {
+	io.ReadCloser
+
+	tail   []byte
+	tokens int
+
+	once   sync.Once
+	report func(int)
+}
+
+func (u *usageReader) Read(p []byte) (int, error) {
+	n, err := u.ReadCloser.Read(p)
+
+	if n > 0 {
+		u.tail = append(u.tail, p[:n]...)
+
+		for _, m := range totalTokens.FindAllSubmatch(u.tail, -1) {
+			if v, err := strconv.Atoi(string(m[1])); err == nil {
+				u.tokens = v
+			}
+		}
+
+		// Keep enough for a match split across reads.
+		if len(u.tail) > 64 {
+			u.tail = append(u.tail[:0], u.tail[len(u.tail)-64:]...)
+		}
+	}
+
+	if err == io.EOF {
+		u.finish()
+	}
+
+	return n, err
+}
+
+func (u *usageReader) Close() error {
+	u.finish()
+	return u.ReadCloser.Close()
+}
+
+func (u *usageReader) finish() {
+	u.once.Do(func() {
+		if u.tokens > 0 {
+			u.report(u.tokens)
+		}
+	})
+}
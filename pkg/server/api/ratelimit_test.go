@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/ratelimit"
+)
+
+func TestCaller(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+
+	if got := caller(r); got != "addr:203.0.113.7" {
+		t.Errorf("anonymous: caller = %q", got)
+	}
+
+	if got := caller(asUser(r, "mallory", auth.SourceGuest)); got != "addr:203.0.113.7" {
+		t.Errorf("unauthenticated user: caller = %q, want the address", got)
+	}
+
+	if got := caller(asUser(r, "alice", auth.SourceBearer)); got != "user:alice" {
+		t.Errorf("authenticated: caller = %q", got)
+	}
+}
+
+func TestRateLimitPerAddress(t *testing.T) {
+	h := &Handler{
+		store: config.NewStore(&config.Config{}),
+
+		limits:  RateLimit{Requests: 2},
+		limiter: ratelimit.New(),
+	}
+
+	allowed := 0
+
+	// Rotating identities that weren't authenticated must not reset the
+	// budget of the address.
+	for i := range 5 {
+		r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+		r = asUser(r, "user"+strings.Repeat("x", i), auth.SourceGuest)
+
+		if h.checkRateLimit(r, map[string]any{"model": "small"}) == nil {
+			allowed++
+		}
+	}
+
+	if allowed != 2 {
+		t.Errorf("%d requests allowed, want 2", allowed)
+	}
+}
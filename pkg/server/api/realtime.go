@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/adrianliechti/wingman-chat/pkg/realtime"
+)
+
+// withRealtime hands WebSocket sessions of the realtime API to the session
+// proxy; everything else goes to next. The model of the session, in the
+// query, goes through the transforms like the body of other requests.
+func (h *Handler) withRealtime(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.realtime == nil || r.URL.Path != "/v1/realtime" || !realtime.IsUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		body := map[string]any{}
+
+		if model := query.Get("model"); model != "" {
+			body["model"] = model
+		}
+
+		if err := applyTransforms(r, body, h.transforms()); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if model, _ := body["model"].(string); model != query.Get("model") {
+			query.Set("model", model)
+			r.URL.RawQuery = query.Encode()
+		}
+
+		err := h.realtime.Serve(w, r, caller(r), func(ctx context.Context) (*http.Response, error) {
+			out := r.Clone(ctx)
+			out.RequestURI = ""
+
+			// Hop-by-hop headers stay here, but for the upgrade itself.
+			for _, name := range []string{"Keep-Alive", "Proxy-Connection", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding"} {
+				out.Header.Del(name)
+			}
+
+			h.rewrite(&httputil.ProxyRequest{In: r, Out: out})
+
+			return h.roundTrip(out)
+		})
+
+		if errors.Is(err, realtime.ErrSessionLimit) {
+			writeError(w, &Error{Status: http.StatusTooManyRequests, Code: "session_limit_exceeded", Message: "too many concurrent realtime sessions"})
+			return
+		}
+
+		if err != nil {
+			slog.Error("api: realtime", "error", err)
+			writeError(w, &Error{Status: http.StatusBadGateway, Code: "upstream_unavailable", Message: "the realtime session could not be opened"})
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/redact"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// redactPrompt masks personal data in the text of model requests: message
+// contents, instructions, prompts and embedding inputs. Images and files
+// are passed on as they are.
+func (h *Handler) redactPrompt(r *http.Request, body map[string]any) error {
+	if h.redactor == nil {
+		return nil
+	}
+
+	var redactions []redact.Redaction
+
+	mask := func(s string) string {
+		s, found := h.redactor.Redact(s)
+		redactions = append(redactions, found...)
+
+		return s
+	}
+
+	for _, key := range []string{"messages", "input", "prompt", "instructions"} {
+		if v, ok := body[key]; ok {
+			body[key] = redactText(v, mask)
+		}
+	}
+
+	if len(redactions) == 0 {
+		return nil
+	}
+
+	if audit := h.redactor.Audit(); audit != nil {
+		e := redact.Entry{
+			Path:   r.URL.Path,
+			Device: auth.DeviceFromContext(r.Context()),
+
+			Redactions: redactions,
+		}
+
+		e.Model, _ = body["model"].(string)
+
+		if t := tenant.FromContext(r.Context()); t != nil {
+			e.Tenant = t.ID
+		}
+
+		if user := auth.UserFromContext(r.Context()); user != nil {
+			e.User = user.ID
+		}
+
+		if err := audit.Write(e); err != nil {
+			slog.Error("redact: audit", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// redactText applies mask to the text in v: strings, lists of them, and the
+// text and content of messages and their parts.
+func redactText(v any, mask func(string) string) any {
+	switch v := v.(type) {
+	case string:
+		return mask(v)
+
+	case []any:
+		for i := range v {
+			v[i] = redactText(v[i], mask)
+		}
+
+	case map[string]any:
+		for _, key := range []string{"content", "text", "output"} {
+			if _, ok := v[key]; ok {
+				v[key] = redactText(v[key], mask)
+			}
+		}
+
+		// Tool call arguments, of chat completions and responses.
+		if args, ok := v["arguments"].(string); ok {
+			v["arguments"] = mask(args)
+		}
+
+		if calls, ok := v["tool_calls"].([]any); ok {
+			for _, c := range calls {
+				c, _ := c.(map[string]any)
+
+				if f, ok := c["function"].(map[string]any); ok {
+					redactText(f, mask)
+				}
+			}
+		}
+	}
+
+	return v
+}
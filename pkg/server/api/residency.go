@@ -0,0 +1,44 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// checkResidency refuses to send r where it is routed unless the residency
+// policy permits the upstream and the region it serves in.
+func (h *Handler) checkResidency(r *http.Request) *Error {
+	res := tenant.Config(r.Context(), h.store).Residency
+
+	if res == nil {
+		return nil
+	}
+
+	rt := routeFromContext(r.Context())
+
+	id, region := config.PlatformUpstream, h.platformRegion
+
+	switch {
+	case rt.peer:
+		id, region = config.FederationUpstream, ""
+	case rt.upstream != nil:
+		id, region = rt.upstream.ID, rt.upstream.Location
+	}
+
+	if res.PermitsUpstream(id, region) {
+		return nil
+	}
+
+	slog.Warn("residency: upstream region not permitted", "method", r.Method, "path", r.URL.Path, "upstream", id, "region", region)
+
+	return &Error{Status: http.StatusForbidden, Code: "residency_violation", Message: "the data residency policy does not permit serving this request"}
+}
+
+// permitsStorage reports whether the residency policy lets the prompts and
+// answers of r be kept in store.
+func (h *Handler) permitsStorage(r *http.Request, store string) bool {
+	return tenant.Config(r.Context(), h.store).Residency.PermitsStorage(store)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Retry repeats idempotent upstream calls that fail transiently.
+type Retry struct {
+	// Attempts is the number of retries; none when 0.
+	Attempts int
+
+	// Backoff is the wait before the first retry, doubled for each further
+	// one and jittered.
+	Backoff time.Duration
+
+	// MaxLatency bounds the time spent on a call including its retries; a
+	// retry that would end past it is not made.
+	MaxLatency time.Duration
+}
+
+// RetryFromEnv reads RETRY_ATTEMPTS, RETRY_BACKOFF (default 250ms) and
+// RETRY_MAX_LATENCY (default 10s).
+func RetryFromEnv() Retry {
+	return Retry{
+		Attempts:   env.Int("RETRY_ATTEMPTS", 0),
+		Backoff:    env.Duration("RETRY_BACKOFF", 250*time.Millisecond),
+		MaxLatency: env.Duration("RETRY_MAX_LATENCY", 10*time.Second),
+	}
+}
+
+// retryPaths are the POST endpoints that are safe to repeat: their answers
+// are not streamed and repeating them has no effect beyond the cost.
+var retryPaths = []string{
+	"/v1/embeddings",
+	"/v1/audio/speech",
+}
+
+// retryable reports whether r may be sent again, and for which answers:
+// GET requests also after network errors and gateway failures, the safe POST
+// endpoints only when rate limited or unavailable. Chat completions are never
+// repeated.
+func retryable(r *http.Request) (bool, func(status int) bool) {
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false, nil
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return true, func(status int) bool {
+			return status == http.StatusTooManyRequests || status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+		}
+
+	case http.MethodPost:
+		for _, p := range retryPaths {
+			if strings.HasSuffix(r.URL.Path, p) {
+				return false, func(status int) bool {
+					return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// sendRetrying sends r as send does, repeating it as h.retry allows.
+func (h *Handler) sendRetrying(r *http.Request) (*http.Response, error) {
+	networkErrors, statuses := retryable(r)
+
+	if h.retry.Attempts <= 0 || statuses == nil {
+		return h.send(r)
+	}
+
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		out := r
+
+		if attempt > 0 && r.GetBody != nil {
+			body, err := r.GetBody()
+
+			if err != nil {
+				return nil, err
+			}
+
+			out = r.Clone(r.Context())
+			out.Body = body
+		}
+
+		resp, err := h.send(out)
+
+		if attempt >= h.retry.Attempts || r.Context().Err() != nil {
+			return resp, err
+		}
+
+		if err != nil && !networkErrors {
+			return resp, err
+		}
+
+		if err == nil && !statuses(resp.StatusCode) {
+			return resp, nil
+		}
+
+		// The platform is known to be down; waiting won't help.
+		if h.breaker != nil && h.breaker.RetryIn() > 0 {
+			return resp, err
+		}
+
+		wait := h.retry.backoff(attempt)
+
+		if resp != nil {
+			if d := retryAfter(resp.Header.Get("Retry-After")); d > 0 {
+				wait = d
+			}
+		}
+
+		if h.retry.MaxLatency > 0 && time.Since(start)+wait > h.retry.MaxLatency {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil, r.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff is the jittered wait before retry attempt+1: between half and all
+// of Backoff doubled attempt times.
+func (r Retry) backoff(attempt int) time.Duration {
+	base := r.Backoff
+
+	if base <= 0 {
+		base = 250 * time.Millisecond
+	}
+
+	d := base << min(attempt, 10)
+
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter parses a Retry-After header given in seconds.
+func retryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/jsonschema"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// maxValidatedResponse bounds the answers read for schema validation.
+const maxValidatedResponse = 8 << 20
+
+// maxRepairErrors bounds the violations listed in a repair prompt.
+const maxRepairErrors = 10
+
+// schemaCheck validates the answers to a chat completion request.
+type schemaCheck struct {
+	// schema is the schema answers must satisfy; without one, they must be
+	// JSON.
+	schema  *jsonschema.Schema
+	retries int
+
+	// body is the request as sent upstream, extended by repair prompts.
+	body map[string]any
+}
+
+// enforceSchema requests the schema configured for the model as response
+// format, unless the client asks for a format of its own, and has the answers
+// to chat completions that aren't streamed validated against it.
+func (h *Handler) enforceSchema(r *http.Request, body map[string]any) error {
+	if r.URL.Path != "/v1/chat/completions" && r.URL.Path != "/v1/responses" {
+		return nil
+	}
+
+	id, _ := body["model"].(string)
+
+	var model *config.Model
+
+	for _, m := range tenant.Config(r.Context(), h.store).Models {
+		if m.ID == id {
+			model = &m
+			break
+		}
+	}
+
+	if model == nil || model.Schema == nil {
+		return nil
+	}
+
+	schema, err := jsonschema.Compile(model.Schema)
+
+	if err != nil {
+		return &Error{Status: http.StatusInternalServerError, Code: "invalid_schema", Message: fmt.Sprintf("the schema of model %q is invalid: %v", id, err)}
+	}
+
+	switch r.URL.Path {
+	case "/v1/chat/completions":
+		if _, ok := body["response_format"]; !ok {
+			body["response_format"] = map[string]any{
+				"type": "json_schema",
+				"json_schema": map[string]any{
+					"name":   "response",
+					"schema": model.Schema,
+				},
+			}
+		}
+
+		if stream, _ := body["stream"].(bool); !stream {
+			routeFromContext(r.Context()).schema = &schemaCheck{
+				schema:  schema,
+				retries: model.SchemaRetries,
+
+				body: body,
+			}
+		}
+
+	case "/v1/responses":
+		text, _ := body["text"].(map[string]any)
+
+		if text == nil {
+			text = map[string]any{}
+			body["text"] = text
+		}
+
+		if _, ok := text["format"]; !ok {
+			text["format"] = map[string]any{
+				"type":   "json_schema",
+				"name":   "response",
+				"schema": model.Schema,
+			}
+		}
+	}
+
+	return nil
+}
+
+// guaranteeJSON has the answers to chat completions that ask for JSON output
+// without a model schema validated against the requested schema, or just as
+// JSON, repairing them up to jsonRetries times.
+func (h *Handler) guaranteeJSON(r *http.Request, body map[string]any) error {
+	if h.jsonRetries <= 0 || r.URL.Path != "/v1/chat/completions" {
+		return nil
+	}
+
+	rt := routeFromContext(r.Context())
+
+	if stream, _ := body["stream"].(bool); stream || rt.schema != nil {
+		return nil
+	}
+
+	format, _ := body["response_format"].(map[string]any)
+
+	check := &schemaCheck{
+		retries: h.jsonRetries,
+		body:    body,
+	}
+
+	switch format["type"] {
+	case "json_object":
+		// Any JSON will do.
+
+	case "json_schema":
+		spec, _ := format["json_schema"].(map[string]any)
+
+		if schema, ok := spec["schema"]; ok {
+			// A schema the upstream accepts but we can't read is left
+			// to the upstream; the answer must be JSON still.
+			check.schema, _ = jsonschema.Compile(schema)
+		}
+
+	default:
+		return nil
+	}
+
+	rt.schema = check
+
+	return nil
+}
+
+// roundTripSchema sends a chat completion request and validates the answer,
+// asking the model to repair an invalid one as often as the check allows.
+// Answers still invalid then are replaced by a 502 error.
+func (h *Handler) roundTripSchema(r *http.Request, check *schemaCheck) (*http.Response, error) {
+	// The answer is read here, so it must not be compressed.
+	r.Header.Del("Accept-Encoding")
+
+	for attempt := 0; ; attempt++ {
+		resp, err := h.send(r)
+
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return resp, err
+		}
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxValidatedResponse))
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		data, content, errs := check.validate(data)
+
+		if len(errs) == 0 {
+			resp.Body = io.NopCloser(bytes.NewReader(data))
+			resp.ContentLength = int64(len(data))
+			resp.Header.Del("Content-Length")
+
+			return resp, nil
+		}
+
+		model, _ := check.body["model"].(string)
+
+		if attempt >= check.retries {
+			slog.Warn("api: schema: answer invalid", "model", model, "attempts", attempt+1, "error", errs[0])
+
+			code := "schema_validation_failed"
+
+			if check.schema == nil {
+				code = "invalid_json"
+			}
+
+			return errorResponse(r, &Error{
+				Status:  http.StatusBadGateway,
+				Code:    code,
+				Message: fmt.Sprintf("the answer of model %q is not valid: %v", model, errs[0]),
+			}), nil
+		}
+
+		// Discarded answers count as well.
+		if rt := routeFromContext(r.Context()); rt.usage != nil {
+			if m := totalTokens.FindSubmatch(data); m != nil {
+				if tokens, err := strconv.Atoi(string(m[1])); err == nil {
+					rt.usage(tokens)
+				}
+			}
+		}
+
+		check.repair(content, errs)
+
+		body, err := json.Marshal(check.body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		r = r.Clone(r.Context())
+		setBody(r, body)
+	}
+}
+
+// validate checks the text answers of a completion; tool calls are not
+// checked. Answers wrapped in a Markdown code block are unwrapped if that
+// makes them valid, returned as changed completion. Otherwise the first
+// invalid answer is returned with its violations.
+func (c *schemaCheck) validate(data []byte) ([]byte, string, []error) {
+	var completion map[string]any
+
+	if err := json.Unmarshal(data, &completion); err != nil {
+		// Not a completion; pass it on as is.
+		return data, "", nil
+	}
+
+	choices, _ := completion["choices"].([]any)
+	changed := false
+
+	for _, choice := range choices {
+		choice, _ := choice.(map[string]any)
+		message, _ := choice["message"].(map[string]any)
+
+		content, ok := message["content"].(string)
+
+		if !ok {
+			continue
+		}
+
+		errs := c.check(content)
+
+		if len(errs) == 0 {
+			continue
+		}
+
+		if trimmed := unfence(content); trimmed != content && len(c.check(trimmed)) == 0 {
+			message["content"] = trimmed
+			changed = true
+
+			continue
+		}
+
+		return data, content, errs
+	}
+
+	if changed {
+		if repaired, err := json.Marshal(completion); err == nil {
+			return repaired, "", nil
+		}
+	}
+
+	return data, "", nil
+}
+
+// check validates an answer against the schema, or just as JSON without one.
+func (c *schemaCheck) check(content string) []error {
+	if c.schema != nil {
+		return c.schema.ValidateJSON([]byte(content))
+	}
+
+	var v any
+
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return []error{fmt.Errorf("invalid JSON: %w", err)}
+	}
+
+	return nil
+}
+
+// unfence returns the JSON within a Markdown code block, or the text
+// between the outermost braces or brackets around it.
+func unfence(content string) string {
+	s := strings.TrimSpace(content)
+
+	if rest, ok := strings.CutPrefix(s, "```"); ok {
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			rest = rest[i+1:]
+		}
+
+		if i := strings.LastIndex(rest, "```"); i >= 0 {
+			rest = rest[:i]
+		}
+
+		return strings.TrimSpace(rest)
+	}
+
+	start := strings.IndexAny(s, "{[")
+
+	if start < 0 {
+		return s
+	}
+
+	end := strings.LastIndexAny(s, "}]")
+
+	if end < start {
+		return s
+	}
+
+	return s[start : end+1]
+}
+
+// repair appends the invalid answer and a prompt listing its violations to
+// the conversation.
+func (c *schemaCheck) repair(content string, errs []error) {
+	var sb strings.Builder
+
+	if c.schema != nil {
+		sb.WriteString("Your answer does not match the required JSON schema:\n")
+	} else {
+		sb.WriteString("Your answer is not valid JSON:\n")
+	}
+
+	for i, err := range errs {
+		if i == maxRepairErrors {
+			fmt.Fprintf(&sb, "- and %d more\n", len(errs)-i)
+			break
+		}
+
+		fmt.Fprintf(&sb, "- %v\n", err)
+	}
+
+	sb.WriteString("\nAnswer again with the corrected JSON only.")
+
+	messages, _ := c.body["messages"].([]any)
+
+	c.body["messages"] = append(messages,
+		map[string]any{"role": "assistant", "content": content},
+		map[string]any{"role": "user", "content": sb.String()},
+	)
+}
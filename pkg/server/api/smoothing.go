@@ -0,0 +1,124 @@
+package api
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// smoothStream coalesces the chunks of streamed answers, so clients receive
+// at most one write per smoothing interval instead of a burst of tiny ones.
+func (h *Handler) smoothStream(resp *http.Response) {
+	if h.smoothing <= 0 {
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		return
+	}
+
+	resp.Body = newSmoother(resp.Body, h.smoothing)
+}
+
+// smoother reads the body in the background and hands out what arrived
+// within an interval at once. The first chunk after a pause is passed on
+// right away.
+type smoother struct {
+	body     io.ReadCloser
+	interval time.Duration
+
+	chunks chan []byte
+	done   chan struct{}
+
+	// err ends the body once chunks is closed.
+	err error
+
+	pending []byte
+	next    time.Time
+}
+
+func newSmoother(body io.ReadCloser, interval time.Duration) *smoother {
+	s := &smoother{
+		body:     body,
+		interval: interval,
+
+		chunks: make(chan []byte, 64),
+		done:   make(chan struct{}),
+	}
+
+	go s.read()
+
+	return s
+}
+
+func (s *smoother) read() {
+	defer close(s.chunks)
+
+	for {
+		buf := make([]byte, 32<<10)
+		n, err := s.body.Read(buf)
+
+		if n > 0 {
+			select {
+			case s.chunks <- buf[:n]:
+			case <-s.done:
+				return
+			}
+		}
+
+		if err != nil {
+			s.err = err
+			return
+		}
+	}
+}
+
+func (s *smoother) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		chunk, ok := <-s.chunks
+
+		if !ok {
+			return 0, s.err
+		}
+
+		s.pending = chunk
+
+		// Gather what else arrives until the next write is due.
+		timer := time.NewTimer(time.Until(s.next))
+
+	gather:
+		for {
+			select {
+			case chunk, ok := <-s.chunks:
+				if !ok {
+					break gather
+				}
+
+				s.pending = append(s.pending, chunk...)
+
+			case <-timer.C:
+				break gather
+			}
+		}
+
+		timer.Stop()
+
+		s.next = time.Now().Add(s.interval)
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+
+	return n, nil
+}
+
+func (s *smoother) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+
+	return s.body.Close()
+}
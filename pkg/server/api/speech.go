@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+const speechPath = "/v1/audio/speech"
+
+// speechFormats are the output formats a client may request, with the ffmpeg
+// arguments producing them and their content type.
+var speechFormats = map[string]struct {
+	args        []string
+	contentType string
+}{
+	"mp3":  {[]string{"-c:a", "libmp3lame", "-f", "mp3"}, "audio/mpeg"},
+	"opus": {[]string{"-c:a", "libopus", "-f", "ogg"}, "audio/ogg"},
+	"aac":  {[]string{"-c:a", "aac", "-f", "adts"}, "audio/aac"},
+	"flac": {[]string{"-c:a", "flac", "-f", "flac"}, "audio/flac"},
+	"wav":  {[]string{"-c:a", "pcm_s16le", "-f", "wav"}, "audio/wav"},
+	"pcm":  {[]string{"-c:a", "pcm_s16le", "-ar", "24000", "-ac", "1", "-f", "s16le"}, "audio/pcm"},
+}
+
+var bitratePattern = regexp.MustCompile(`^[0-9]+k?$`)
+
+type transcodingKey struct{}
+
+// transcoding is what negotiateFormat decided for a speech request: when
+// format is set, the upstream answers in source and ffmpeg converts it.
+type transcoding struct {
+	source  string
+	format  string
+	bitrate string
+}
+
+// withTranscoding converts speech responses when negotiateFormat asked for it.
+func withTranscoding(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != speechPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t := &transcoding{}
+
+		tw := &transcodeWriter{
+			ctx: r.Context(),
+
+			w:      w,
+			header: make(http.Header),
+
+			transcoding: t,
+		}
+
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), transcodingKey{}, t)))
+
+		// A client going away kills ffmpeg; that is no error worth a line.
+		if err := tw.close(); err != nil && r.Context().Err() == nil {
+			slog.Error("transcode", "format", t.format, "error", err)
+		}
+	})
+}
+
+// negotiateFormat serves response_format and the (non-standard) bitrate of
+// speech requests: formats the upstream produces natively (formats in
+// tts.yaml, all of them when unset) are passed through, everything else and
+// any bitrate is transcoded with ffmpeg.
+func (h *Handler) negotiateFormat(r *http.Request, body map[string]any) error {
+	t, _ := r.Context().Value(transcodingKey{}).(*transcoding)
+
+	if t == nil {
+		return nil
+	}
+
+	format, _ := body["response_format"].(string)
+
+	if format == "" {
+		format = "mp3"
+	}
+
+	bitrate := ""
+
+	switch v := body["bitrate"].(type) {
+	case string:
+		bitrate = v
+	case float64:
+		bitrate = strconv.Itoa(int(v)) + "k"
+	}
+
+	delete(body, "bitrate")
+
+	if bitrate != "" && !bitratePattern.MatchString(bitrate) {
+		return &Error{Status: http.StatusBadRequest, Code: "invalid_bitrate", Message: fmt.Sprintf("invalid bitrate %q", bitrate)}
+	}
+
+	var native []string
+
+	if tts := tenant.Config(r.Context(), h.store).TTS; tts != nil {
+		native = tts.Formats
+	}
+
+	isNative := len(native) == 0 || slices.Contains(native, format)
+
+	if bitrate == "" && isNative {
+		return nil
+	}
+
+	if _, ok := speechFormats[format]; !ok {
+		return &Error{Status: http.StatusBadRequest, Code: "unsupported_format", Message: fmt.Sprintf("unsupported audio format %q", format)}
+	}
+
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return &Error{Status: http.StatusBadRequest, Code: "unsupported_format", Message: fmt.Sprintf("audio format %q is not available: transcoding requires ffmpeg", format)}
+	}
+
+	source := "wav"
+
+	if len(native) > 0 && !slices.Contains(native, source) {
+		source = native[0]
+	}
+
+	body["response_format"] = source
+
+	t.source = source
+	t.format = format
+	t.bitrate = bitrate
+
+	return nil
+}
+
+// transcodeWriter pipes a successful upstream response through ffmpeg;
+// errors and untouched requests are passed on as they are.
+type transcodeWriter struct {
+	ctx context.Context
+
+	w      http.ResponseWriter
+	header http.Header
+
+	transcoding *transcoding
+
+	wroteHeader bool
+	passthrough bool
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func (tw *transcodeWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *transcodeWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+
+	tw.wroteHeader = true
+
+	if tw.transcoding.format == "" || status != http.StatusOK {
+		tw.passthrough = true
+
+		for k, v := range tw.header {
+			tw.w.Header()[k] = v
+		}
+
+		tw.w.WriteHeader(status)
+		return
+	}
+
+	t := tw.transcoding
+
+	args := []string{"-hide_banner", "-loglevel", "error"}
+
+	if t.source == "pcm" {
+		args = append(args, "-f", "s16le", "-ar", "24000", "-ac", "1")
+	}
+
+	args = append(args, "-i", "pipe:0")
+
+	if t.bitrate != "" {
+		args = append(args, "-b:a", t.bitrate)
+	}
+
+	args = append(args, speechFormats[t.format].args...)
+	args = append(args, "pipe:1")
+
+	// ffmpeg is killed when the client goes away.
+	tw.cmd = exec.CommandContext(tw.ctx, "ffmpeg", args...)
+	tw.cmd.Stdout = flushWriter{tw.w}
+
+	stdin, err := tw.cmd.StdinPipe()
+
+	if err == nil {
+		err = tw.cmd.Start()
+	}
+
+	// The status is only sent once ffmpeg runs; the audio that follows is
+	// dropped by Write.
+	if err != nil {
+		slog.Error("transcode", "format", t.format, "error", err)
+		tw.cmd = nil
+
+		writeError(tw.w, &Error{Status: http.StatusBadGateway, Code: "transcoding_failed", Message: fmt.Sprintf("transcoding to %q failed", t.format)})
+		return
+	}
+
+	tw.stdin = stdin
+
+	tw.w.Header().Set("Content-Type", speechFormats[t.format].contentType)
+	tw.w.WriteHeader(status)
+}
+
+func (tw *transcodeWriter) Write(p []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+
+	if tw.passthrough {
+		return tw.w.Write(p)
+	}
+
+	if tw.cmd == nil {
+		return 0, io.ErrClosedPipe
+	}
+
+	return tw.stdin.Write(p)
+}
+
+// Flush keeps passed-through audio streaming; ffmpeg output is written, and
+// flushed, by its own goroutine through flushWriter.
+func (tw *transcodeWriter) Flush() {
+	if f, ok := tw.w.(http.Flusher); ok && tw.passthrough {
+		f.Flush()
+	}
+}
+
+// flushWriter flushes every chunk ffmpeg writes, so transcoded audio streams
+// to the client instead of piling up in the response buffer.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return n, err
+}
+
+func (tw *transcodeWriter) close() error {
+	if tw.cmd == nil {
+		return nil
+	}
+
+	tw.stdin.Close()
+
+	return tw.cmd.Wait()
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTranscodeStartFailure(t *testing.T) {
+	// Without ffmpeg on the path, starting it fails.
+	t.Setenv("PATH", "")
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, speechPath, nil)
+
+	tw := &transcodeWriter{
+		ctx: r.Context(),
+
+		w:      rec,
+		header: make(http.Header),
+
+		transcoding: &transcoding{source: "wav", format: "mp3"},
+	}
+
+	tw.Header().Set("Content-Type", "audio/wav")
+
+	if _, err := tw.Write([]byte("RIFF")); err == nil {
+		t.Error("audio was accepted without ffmpeg")
+	}
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct == "audio/mpeg" || ct == "audio/wav" {
+		t.Errorf("content type = %q for an error", ct)
+	}
+
+	if err := tw.close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// flushRecorder counts the flushes of a response, which ffmpeg's goroutine
+// writes.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+
+	mu      sync.Mutex
+	flushes int
+}
+
+func (r *flushRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.ResponseRecorder.Write(p)
+}
+
+func (r *flushRecorder) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.flushes++
+}
+
+func (r *flushRecorder) flushed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.flushes
+}
+
+func TestTranscodeStreams(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script for ffmpeg")
+	}
+
+	// A stand-in for ffmpeg that passes the audio through as it comes.
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte("#!/bin/sh\nexec /bin/cat\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir)
+
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r := httptest.NewRequest(http.MethodPost, speechPath, nil)
+
+	tw := &transcodeWriter{
+		ctx: r.Context(),
+
+		w:      rec,
+		header: make(http.Header),
+
+		transcoding: &transcoding{source: "wav", format: "mp3"},
+	}
+
+	for i, chunk := range []string{"first", "second"} {
+		if _, err := tw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Each chunk reaches the client before the next one is sent.
+		for deadline := time.Now().Add(5 * time.Second); rec.flushed() <= i; {
+			if time.Now().After(deadline) {
+				t.Fatalf("chunk %d wasn't flushed", i)
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := tw.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := rec.flushed(); n < 2 {
+		t.Errorf("flushes = %d, want more than one", n)
+	}
+
+	if body := rec.Body.String(); body != "firstsecond" {
+		t.Errorf("body = %q", body)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "audio/mpeg" {
+		t.Errorf("content type = %q", ct)
+	}
+}
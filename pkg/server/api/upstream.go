@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/anthropic"
+	"github.com/adrianliechti/wingman-chat/pkg/azure"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/sigv4"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// upstream is an entry of upstreams.yaml a request is routed to.
+type upstream struct {
+	config.Upstream
+
+	url *url.URL
+}
+
+// transport adapts requests to the API of the upstream.
+func (u *upstream) transport() http.RoundTripper {
+	switch u.Type {
+	case "azure":
+		return azure.Transport(http.DefaultTransport, u.APIVersion)
+	case "anthropic":
+		return anthropic.Transport(http.DefaultTransport)
+	case "bedrock":
+		return anthropic.Bedrock(http.DefaultTransport, u.Region, sigv4.FromEnv())
+	}
+
+	return http.DefaultTransport
+}
+
+// routeUpstream sends requests for models served by an upstream other than
+// the platform there.
+func (h *Handler) routeUpstream(r *http.Request, body map[string]any) error {
+	model, _ := body["model"].(string)
+
+	if u := h.upstreamFor(r, model); u != nil {
+		routeFromContext(r.Context()).upstream = u
+	}
+
+	return nil
+}
+
+// upstreamFor returns the upstream serving model, or nil for the platform.
+func (h *Handler) upstreamFor(r *http.Request, model string) *upstream {
+	if model == "" {
+		return nil
+	}
+
+	cfg := tenant.Config(r.Context(), h.store)
+
+	for _, m := range cfg.Models {
+		if m.ID == model && m.Upstream != "" {
+			return h.regionalUpstream(r, cfg, m.Upstream)
+		}
+	}
+
+	return nil
+}
+
+// upstreamByID returns the entry id of upstreams.yaml, or nil.
+func upstreamByID(cfg *config.Config, id string) *upstream {
+	for _, u := range cfg.Upstreams {
+		if u.ID != id {
+			continue
+		}
+
+		target, err := url.Parse(u.URL)
+
+		if err != nil {
+			return nil
+		}
+
+		// Requests carry the /v1 already, as for the platform.
+		target.Path = strings.TrimSuffix(strings.TrimSuffix(target.Path, "/"), "/v1")
+
+		return &upstream{Upstream: u, url: target}
+	}
+
+	return nil
+}
+
+// regionalUpstream returns the entry id of upstreams.yaml, or the one it
+// names for the region of r.
+func (h *Handler) regionalUpstream(r *http.Request, cfg *config.Config, id string) *upstream {
+	u := upstreamByID(cfg, id)
+
+	if u == nil || len(u.Regions) == 0 {
+		return u
+	}
+
+	if regional, ok := u.Regions[h.region(r, cfg)]; ok {
+		if ru := upstreamByID(cfg, regional); ru != nil {
+			return ru
+		}
+	}
+
+	return u
+}
+
+// region returns the region the requests of r are served in: that of the
+// residency policy, else that of the user's country as told by the GeoIP
+// header; "" when neither decides.
+func (h *Handler) region(r *http.Request, cfg *config.Config) string {
+	res := cfg.Residency
+
+	if res == nil {
+		return ""
+	}
+
+	if res.Region != "" {
+		return res.Region
+	}
+
+	if h.geoHeader == "" {
+		return ""
+	}
+
+	return res.RegionOf(strings.TrimSpace(r.Header.Get(h.geoHeader)))
+}
+
+// applyRoutes sends r as the first entry of routes.yaml matching its path
+// says: to another upstream and/or path.
+func (h *Handler) applyRoutes(r *http.Request, rt *route) {
+	cfg := tenant.Config(r.Context(), h.store)
+
+	for _, entry := range cfg.Routes {
+		path, ok := entry.Match(r.URL.Path)
+
+		if !ok {
+			continue
+		}
+
+		if entry.Rewrite != "" {
+			rt.path = path
+		}
+
+		if entry.Upstream != "" {
+			rt.upstream = h.regionalUpstream(r, cfg, entry.Upstream)
+		}
+
+		return
+	}
+}
@@ -0,0 +1,276 @@
+// Package connections lets signed-in users link accounts (OAuth 2.0 with
+// PKCE) that built-in tools then use on their behalf.
+package connections
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/connections"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+)
+
+const stateCookie = "wingman_connect"
+
+type Handler struct {
+	prefix  string
+	manager *connections.Manager
+}
+
+func New(manager *connections.Manager) *Handler {
+	return &Handler{
+		manager: manager,
+	}
+}
+
+func (h *Handler) Attach(mux *http.ServeMux, prefix string) {
+	h.prefix = strings.TrimRight(prefix, "/") + "/connections"
+
+	mux.HandleFunc("GET "+h.prefix, h.handleList)
+	mux.HandleFunc("GET "+h.prefix+"/{provider}/connect", h.handleConnect)
+	mux.HandleFunc("GET "+h.prefix+"/{provider}/callback", h.handleCallback)
+	mux.HandleFunc("DELETE "+h.prefix+"/{provider}", h.handleDisconnect)
+}
+
+func (h *Handler) Describe(spec *openapi.Spec, prefix string) {
+	prefix = strings.TrimRight(prefix, "/") + "/connections"
+
+	spec.Add("GET", prefix, openapi.Operation{
+		Summary: "List the account providers and whether the user is connected", Tag: "connections", Security: openapi.User,
+		Response: reflect.TypeFor[struct {
+			Data []connection `json:"data"`
+		}](),
+	})
+
+	spec.Add("GET", prefix+"/{provider}/connect", openapi.Operation{
+		Summary: "Start connecting an account (redirects to the provider)", Tag: "connections", Security: openapi.User,
+		Query:  []string{"redirect"},
+		Status: http.StatusFound,
+	})
+
+	spec.Add("DELETE", prefix+"/{provider}", openapi.Operation{
+		Summary: "Disconnect an account", Tag: "connections", Security: openapi.User,
+		Status: http.StatusNoContent,
+	})
+}
+
+type connection struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	Connected bool   `json:"connected"`
+	Account   string `json:"account,omitempty"`
+
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// handleList returns the providers and whether the user is connected to them.
+// Tokens are never returned.
+func (h *Handler) handleList(w http.ResponseWriter, r *http.Request) {
+	user := requireUser(w, r)
+
+	if user == nil {
+		return
+	}
+
+	result := []connection{}
+
+	for _, p := range h.manager.Providers() {
+		c := connection{
+			ID:   p.ID,
+			Name: p.Name,
+		}
+
+		token, err := h.manager.Get(user.ID, p.ID)
+
+		if err != nil && !errors.Is(err, connections.ErrNotConnected) {
+			slog.Error("connections", "provider", p.ID, "user", user.ID, "error", err)
+		}
+
+		if err == nil {
+			c.Connected = true
+			c.Account = token.Account
+			c.Since = &token.Connected
+		}
+
+		result = append(result, c)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"data": result})
+}
+
+type connectState struct {
+	User     string `json:"user"`
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+}
+
+// handleConnect sends the browser to the provider's consent page.
+func (h *Handler) handleConnect(w http.ResponseWriter, r *http.Request) {
+	user := requireUser(w, r)
+
+	if user == nil {
+		return
+	}
+
+	p, ok := h.manager.Provider(r.PathValue("provider"))
+
+	if !ok {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	redirect := r.URL.Query().Get("redirect")
+
+	// Only local paths, so this can't be abused as an open redirect.
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+
+	state := connectState{
+		User:     user.ID,
+		Provider: p.ID,
+		State:    randomString(),
+		Verifier: randomString(),
+		Redirect: redirect,
+	}
+
+	data, _ := json.Marshal(state)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(data),
+		Path:     h.prefix + "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(auth.ExternalURL(r), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+
+	query := url.Values{}
+
+	for k, v := range p.Params {
+		query[k] = v
+	}
+
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", h.redirectURI(r, p))
+	query.Set("scope", p.Scopes)
+	query.Set("state", state.State)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	http.Redirect(w, r, p.AuthURL+"?"+query.Encode(), http.StatusFound)
+}
+
+func (h *Handler) handleCallback(w http.ResponseWriter, r *http.Request) {
+	user := requireUser(w, r)
+
+	if user == nil {
+		return
+	}
+
+	p, ok := h.manager.Provider(r.PathValue("provider"))
+
+	if !ok {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+
+	if e := query.Get("error"); e != "" {
+		http.Error(w, "connecting failed: "+e+" "+query.Get("error_description"), http.StatusBadRequest)
+		return
+	}
+
+	var state connectState
+
+	c, err := r.Cookie(stateCookie)
+
+	if err == nil {
+		data, _ := base64.RawURLEncoding.DecodeString(c.Value)
+		err = json.Unmarshal(data, &state)
+	}
+
+	if err != nil || state.State == "" || state.State != query.Get("state") || state.User != user.ID || state.Provider != p.ID {
+		http.Error(w, "connecting failed: invalid state", http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: h.prefix + "/", MaxAge: -1})
+
+	token, err := h.manager.Exchange(r.Context(), p, query.Get("code"), h.redirectURI(r, p), state.Verifier)
+
+	if err != nil {
+		http.Error(w, "connecting failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if err := h.manager.Set(user.ID, p.ID, token); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+func (h *Handler) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	user := requireUser(w, r)
+
+	if user == nil {
+		return
+	}
+
+	p, ok := h.manager.Provider(r.PathValue("provider"))
+
+	if !ok {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.manager.Delete(user.ID, p.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) redirectURI(r *http.Request, p *connections.Provider) string {
+	return auth.ExternalURL(r) + h.prefix + "/" + p.ID + "/callback"
+}
+
+func requireUser(w http.ResponseWriter, r *http.Request) *auth.User {
+	user := auth.Authenticated(r.Context())
+
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+
+	return user
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,370 @@
+// Package database offers a built-in tool for read-only SQL queries against
+// the PostgreSQL databases in databases.yaml.
+//
+// Statements are screened and run in read-only transactions that are rolled
+// back, but the screening is no SQL parser: the DSN must connect as a role
+// that is only granted reads, which is what actually keeps data safe.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/mcp"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// ID is the tool id to list in tools.yaml; the tool is served at
+// <prefix>/v1/mcp/sql.
+const ID = "sql"
+
+const (
+	defaultMaxRows = 200
+	defaultTimeout = 30 * time.Second
+)
+
+// readStatement matches the statements a query may start with.
+var readStatement = regexp.MustCompile(`(?is)^\s*(select|with|explain|show|values|table)\b`)
+
+type database struct {
+	config.Database
+
+	db      *sql.DB
+	maxRows int
+	timeout time.Duration
+}
+
+type Handler struct {
+	databases map[string]*database
+	order     []string
+
+	server *mcp.Server
+}
+
+// New serves the databases of cfgs; confirm reports whether calls of a tool
+// need the user's confirmation (see mcp.Server). It fails on a database
+// without id or DSN, or a DSN that doesn't parse.
+func New(cfgs []config.Database, confirm func(ctx context.Context, tool string) bool) (*Handler, error) {
+	h := &Handler{
+		databases: make(map[string]*database),
+	}
+
+	for _, cfg := range cfgs {
+		if cfg.ID == "" || cfg.DSN == "" {
+			return nil, fmt.Errorf("database %q: id and dsn are required", cfg.ID)
+		}
+
+		if _, ok := h.databases[cfg.ID]; ok {
+			return nil, fmt.Errorf("database %q: duplicate id", cfg.ID)
+		}
+
+		// The driver only parses the DSN on the first connection. Its error
+		// may quote the DSN, credentials included.
+		if _, err := pgx.ParseConfig(cfg.DSN); err != nil {
+			return nil, fmt.Errorf("database %q: invalid dsn", cfg.ID)
+		}
+
+		db, err := sql.Open("pgx", cfg.DSN)
+
+		if err != nil {
+			return nil, fmt.Errorf("database %q: %w", cfg.ID, err)
+		}
+
+		d := &database{
+			Database: cfg,
+
+			db:      db,
+			maxRows: cfg.MaxRows,
+			timeout: defaultTimeout,
+		}
+
+		if d.maxRows <= 0 {
+			d.maxRows = defaultMaxRows
+		}
+
+		if t, err := time.ParseDuration(cfg.Timeout); err == nil && t > 0 {
+			d.timeout = t
+		}
+
+		h.databases[cfg.ID] = d
+		h.order = append(h.order, cfg.ID)
+	}
+
+	databaseArg := map[string]any{"type": "string", "description": "Database id from list_databases"}
+
+	h.server = mcp.NewServer("wingman-sql", "1.0.0",
+		mcp.Tool{
+			Name:        "list_databases",
+			Description: "Lists the databases that can be queried.",
+			InputSchema: map[string]any{"type": "object", "properties": map[string]any{}},
+			Call:        h.listDatabases,
+		},
+		mcp.Tool{
+			Name:        "describe_database",
+			Description: "Lists the tables and columns of a database. Call this before writing queries.",
+			InputSchema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"database": databaseArg},
+				"required":   []string{"database"},
+			},
+			Call: h.describeDatabase,
+		},
+		mcp.Tool{
+			Name:        "query",
+			Description: "Runs a single read-only SQL statement (PostgreSQL dialect) and returns the rows as JSON. Results are truncated to a row limit; aggregate in SQL where possible.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"database": databaseArg,
+					"sql":      map[string]any{"type": "string"},
+				},
+				"required": []string{"database", "sql"},
+			},
+			Call: h.query,
+		},
+	)
+
+	h.server.Confirm = confirm
+
+	return h, nil
+}
+
+func (h *Handler) Attach(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimRight(prefix, "/")
+
+	mux.Handle(prefix+"/v1/mcp/"+ID, h.server)
+	mux.HandleFunc("GET "+prefix+"/databases/{id}/schema", h.handleSchema)
+}
+
+func (h *Handler) Describe(spec *openapi.Spec, prefix string) {
+	prefix = strings.TrimRight(prefix, "/")
+
+	spec.Add("GET", prefix+"/databases/{id}/schema", openapi.Operation{
+		Summary: "List the tables and columns of a database", Tag: "tools", Security: openapi.User,
+		Response: reflect.TypeFor[[]table](),
+	})
+}
+
+// handleSchema returns the tables and columns of a database.
+func (h *Handler) handleSchema(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.databases[r.PathValue("id")]
+
+	if !ok {
+		http.Error(w, "database not found", http.StatusNotFound)
+		return
+	}
+
+	tables, err := d.schema(r.Context())
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tables)
+}
+
+func (h *Handler) listDatabases(ctx context.Context, args map[string]any) (string, error) {
+	var result []map[string]string
+
+	for _, id := range h.order {
+		d := h.databases[id]
+
+		result = append(result, map[string]string{
+			"id":          d.ID,
+			"name":        d.Name,
+			"description": d.Description,
+		})
+	}
+
+	return toJSON(result)
+}
+
+func (h *Handler) describeDatabase(ctx context.Context, args map[string]any) (string, error) {
+	d, err := h.database(args)
+
+	if err != nil {
+		return "", err
+	}
+
+	tables, err := d.schema(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	return toJSON(tables)
+}
+
+func (h *Handler) query(ctx context.Context, args map[string]any) (string, error) {
+	d, err := h.database(args)
+
+	if err != nil {
+		return "", err
+	}
+
+	statement, _ := args["sql"].(string)
+	statement, err = singleStatement(statement)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !readStatement.MatchString(statement) {
+		return "", errors.New("only read statements (SELECT, WITH, EXPLAIN, SHOW, VALUES, TABLE) are allowed")
+	}
+
+	result, err := d.query(ctx, statement)
+
+	if err != nil {
+		return "", err
+	}
+
+	return toJSON(result)
+}
+
+func (h *Handler) database(args map[string]any) (*database, error) {
+	id, _ := args["database"].(string)
+
+	d, ok := h.databases[id]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown database %q", id)
+	}
+
+	return d, nil
+}
+
+type table struct {
+	Schema  string   `json:"schema"`
+	Name    string   `json:"name"`
+	Columns []column `json:"columns"`
+}
+
+type column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (d *database) schema(ctx context.Context) ([]*table, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT table_schema, table_name, column_name, data_type
+		FROM information_schema.columns
+		WHERE table_schema NOT IN ('pg_catalog', 'information_schema')
+		ORDER BY table_schema, table_name, ordinal_position`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tables []*table
+
+	for rows.Next() {
+		var schema, name string
+		var c column
+
+		if err := rows.Scan(&schema, &name, &c.Name, &c.Type); err != nil {
+			return nil, err
+		}
+
+		if n := len(tables); n == 0 || tables[n-1].Schema != schema || tables[n-1].Name != name {
+			tables = append(tables, &table{Schema: schema, Name: name})
+		}
+
+		t := tables[len(tables)-1]
+		t.Columns = append(t.Columns, c)
+	}
+
+	return tables, rows.Err()
+}
+
+type queryResult struct {
+	Columns   []string `json:"columns"`
+	Rows      [][]any  `json:"rows"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// query runs statement in a read-only transaction that is always rolled
+// back, so even functions with side effects can't change data.
+func (d *database) query(ctx context.Context, statement string) (*queryResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, statement)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &queryResult{
+		Columns: columns,
+		Rows:    [][]any{},
+	}
+
+	for rows.Next() {
+		if len(result.Rows) >= d.maxRows {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+
+		result.Rows = append(result.Rows, values)
+	}
+
+	return result, rows.Err()
+}
+
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
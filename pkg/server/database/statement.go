@@ -0,0 +1,162 @@
+package database
+
+import (
+	"errors"
+	"strings"
+)
+
+// singleStatement returns statement without trailing semicolons and
+// comments, or an error when it holds more than one statement. Semicolons in
+// string literals, quoted identifiers, dollar-quoted strings and comments
+// don't end a statement.
+func singleStatement(statement string) (string, error) {
+	s := statement
+	end := -1
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == '\'':
+			// E'…' strings escape with backslashes as well.
+			escapes := i > 0 && (s[i-1] == 'e' || s[i-1] == 'E') && (i < 2 || !isIdentifier(s[i-2]))
+
+			n, ok := skipQuoted(s[i:], '\'', escapes)
+
+			if !ok {
+				return "", errors.New("unterminated string literal")
+			}
+
+			i += n
+
+		case c == '"':
+			n, ok := skipQuoted(s[i:], '"', false)
+
+			if !ok {
+				return "", errors.New("unterminated quoted identifier")
+			}
+
+			i += n
+
+		case c == '$' && (i == 0 || !isIdentifier(s[i-1])):
+			tag, ok := dollarTag(s[i:])
+
+			if !ok {
+				i++
+				continue
+			}
+
+			n := strings.Index(s[i+len(tag):], tag)
+
+			if n < 0 {
+				return "", errors.New("unterminated dollar-quoted string")
+			}
+
+			i += 2*len(tag) + n
+
+		case strings.HasPrefix(s[i:], "--"):
+			n := strings.IndexByte(s[i:], '\n')
+
+			if n < 0 {
+				n = len(s) - i
+			}
+
+			i += n
+
+		case strings.HasPrefix(s[i:], "/*"):
+			n, ok := skipComment(s[i:])
+
+			if !ok {
+				return "", errors.New("unterminated comment")
+			}
+
+			i += n
+
+		case c == ';':
+			if end < 0 {
+				end = i
+			}
+
+			i++
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+
+		default:
+			if end >= 0 {
+				return "", errors.New("only a single statement is allowed")
+			}
+
+			i++
+		}
+	}
+
+	if end >= 0 {
+		s = s[:end]
+	}
+
+	return strings.TrimSpace(s), nil
+}
+
+// skipQuoted returns the length of the quoted text at the start of s, where
+// a doubled quote stands for itself.
+func skipQuoted(s string, quote byte, escapes bool) (int, bool) {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case escapes && s[i] == '\\':
+			i++
+		case s[i] == quote && i+1 < len(s) && s[i+1] == quote:
+			i++
+		case s[i] == quote:
+			return i + 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// skipComment returns the length of the block comment at the start of s;
+// block comments nest.
+func skipComment(s string) (int, bool) {
+	depth := 0
+
+	for i := 0; i+1 < len(s); i++ {
+		switch s[i : i+2] {
+		case "/*":
+			depth++
+			i++
+		case "*/":
+			depth--
+			i++
+
+			if depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// dollarTag returns the $tag$ opening the dollar-quoted string at the start
+// of s; $1 and the like are parameters.
+func dollarTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c == '$':
+			return s[:i+1], true
+		case c >= '0' && c <= '9' && i > 1:
+		case isIdentifier(c) && (c < '0' || c > '9'):
+		default:
+			return "", false
+		}
+	}
+
+	return "", false
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -3,18 +3,20 @@ package drive
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 
+	"github.com/adrianliechti/wingman-chat/pkg/buffers"
 	"github.com/adrianliechti/wingman-chat/pkg/config"
 	"github.com/adrianliechti/wingman-chat/pkg/drive"
 	"github.com/adrianliechti/wingman-chat/pkg/drive/local"
 	"github.com/adrianliechti/wingman-chat/pkg/drive/obo"
 	"github.com/adrianliechti/wingman-chat/pkg/drive/onedrive"
 	"github.com/adrianliechti/wingman-chat/pkg/drive/sharepoint"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
 )
 
 type driveInfo struct {
@@ -46,7 +48,7 @@ func New(cfgs []config.Drive) *Handler {
 		}
 
 		if err != nil {
-			fmt.Printf("drive %q: %v\n", cfg.ID, err)
+			slog.Error("drive", "id", cfg.ID, "error", err)
 			continue
 		}
 
@@ -60,7 +62,7 @@ func New(cfgs []config.Drive) *Handler {
 			exchanger, err := obo.New(cfg.Auth.Issuer, cfg.Auth.ClientID, cfg.Auth.ClientSecret, scope)
 
 			if err != nil {
-				fmt.Printf("drive %q: %v\n", cfg.ID, err)
+				slog.Error("drive", "id", cfg.ID, "error", err)
 				continue
 			}
 
@@ -86,6 +88,28 @@ func (h *Handler) Attach(mux *http.ServeMux, prefix string) {
 	mux.HandleFunc("GET "+prefix+"/v1/drives/{id}/content", h.handleContent)
 }
 
+func (h *Handler) Describe(spec *openapi.Spec, prefix string) {
+	prefix = strings.TrimRight(prefix, "/")
+
+	spec.Add("GET", prefix+"/v1/drives", openapi.Operation{
+		Summary: "List the drives", Tag: "drives", Security: openapi.User,
+		Response: reflect.TypeFor[[]driveInfo](),
+	})
+
+	spec.Add("GET", prefix+"/v1/drives/{id}/entries", openapi.Operation{
+		Summary: "List the entries of a folder of a drive (?id=, the root when empty)", Tag: "drives", Security: openapi.User,
+		Query:    []string{"id"},
+		Response: reflect.TypeFor[[]drive.Entry](),
+	})
+
+	spec.Add("GET", prefix+"/v1/drives/{id}/content", openapi.Operation{
+		Summary: "Download a file of a drive", Tag: "drives", Security: openapi.User,
+		Query:        []string{"id"},
+		Response:     map[string]any{"type": "string", "format": "binary"},
+		ResponseType: "application/octet-stream",
+	})
+}
+
 func (h *Handler) handleList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(h.info)
@@ -151,7 +175,7 @@ func (h *Handler) handleContent(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	}
 
-	io.Copy(w, reader)
+	buffers.Copy(w, reader)
 }
 
 func contextWithToken(r *http.Request) context.Context {
@@ -0,0 +1,79 @@
+package flags
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+)
+
+type Handler struct {
+	flags []config.Flag
+}
+
+func New(flags []config.Flag) *Handler {
+	return &Handler{
+		flags: flags,
+	}
+}
+
+func (h *Handler) Attach(mux *http.ServeMux) {
+	mux.HandleFunc("GET /flags.json", h.handleFlags)
+}
+
+func (h *Handler) Describe(spec *openapi.Spec) {
+	spec.Add("GET", "/flags.json", openapi.Operation{
+		Summary: "Evaluate the feature flags for the caller", Tag: "config",
+		Response: reflect.TypeFor[map[string]bool](),
+	})
+}
+
+func (h *Handler) handleFlags(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	result := make(map[string]bool, len(h.flags))
+
+	for _, f := range h.flags {
+		result[f.Name] = Evaluate(f, user)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Evaluate decides whether flag f is on for user. Listed users and groups are
+// always in; everyone else is bucketed by a stable hash of flag and user so a
+// given user keeps their answer while the percentage grows. Anonymous callers
+// only get percentage rollouts once they reach 100.
+func Evaluate(f config.Flag, user *auth.User) bool {
+	if user != nil && (slices.Contains(f.Users, user.ID) || user.InGroup(f.Groups...)) {
+		return true
+	}
+
+	if f.Percentage == nil {
+		return f.Enabled
+	}
+
+	if *f.Percentage >= 100 {
+		return true
+	}
+
+	if user == nil || *f.Percentage <= 0 {
+		return false
+	}
+
+	return bucket(f.Name, user.ID) < *f.Percentage
+}
+
+func bucket(name, id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + id))
+
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,212 @@
+// Package guests lets authenticated users mint, list and revoke guest links
+// to a single model of theirs.
+package guests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/apikeys"
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/guest"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+	"github.com/adrianliechti/wingman-chat/pkg/rbac"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+type Handler struct {
+	prefix string
+
+	store  *config.Store
+	guests *guest.Store
+}
+
+func New(store *config.Store, guests *guest.Store) *Handler {
+	return &Handler{
+		store:  store,
+		guests: guests,
+	}
+}
+
+func (h *Handler) Attach(mux *http.ServeMux, prefix string) {
+	h.prefix = strings.TrimRight(prefix, "/") + "/guests"
+
+	mux.HandleFunc("GET "+h.prefix, h.handleList)
+	mux.HandleFunc("POST "+h.prefix, h.handleCreate)
+	mux.HandleFunc("DELETE "+h.prefix+"/{id}", h.handleRevoke)
+}
+
+func (h *Handler) Describe(spec *openapi.Spec, prefix string) {
+	prefix = strings.TrimRight(prefix, "/") + "/guests"
+
+	spec.Add("GET", prefix, openapi.Operation{
+		Summary: "List the user's guest links", Tag: "guests", Security: openapi.User,
+		Response: reflect.TypeFor[struct {
+			Data []*guest.Link `json:"data"`
+		}](),
+	})
+
+	spec.Add("POST", prefix, openapi.Operation{
+		Summary: "Mint a guest link to a model; its URL is shown only once", Tag: "guests", Security: openapi.User,
+		Request:  reflect.TypeFor[createRequest](),
+		Response: reflect.TypeFor[createResponse](),
+		Status:   http.StatusCreated,
+	})
+
+	spec.Add("DELETE", prefix+"/{id}", openapi.Operation{
+		Summary: "Revoke a guest link", Tag: "guests", Security: openapi.User,
+		Status: http.StatusNoContent,
+	})
+}
+
+type createRequest struct {
+	Name string `json:"name"`
+
+	Model string `json:"model"`
+	Tool  string `json:"tool,omitempty"`
+
+	// Requests is the quota of the link; the most allowed when 0.
+	Requests int `json:"requests,omitempty"`
+
+	// TTL is how long the link is valid (e.g. "24h"), a day when empty.
+	TTL string `json:"ttl,omitempty"`
+}
+
+type createResponse struct {
+	*guest.Link
+
+	// URL carries the secret and is shown only once.
+	URL string `json:"url"`
+}
+
+func (h *Handler) handleList(w http.ResponseWriter, r *http.Request) {
+	user := requireUser(w, r)
+
+	if user == nil {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"data": h.guests.List(user.ID)})
+}
+
+func (h *Handler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	user := requireUser(w, r)
+
+	if user == nil {
+		return
+	}
+
+	var req createRequest
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := tenant.Config(r.Context(), h.store)
+	p := rbac.ForRequest(r, h.store)
+
+	if !slices.ContainsFunc(cfg.Models, func(m config.Model) bool { return m.ID == req.Model }) || !p.AllowsModel(req.Model) {
+		http.Error(w, fmt.Sprintf("model %q is not available", req.Model), http.StatusBadRequest)
+		return
+	}
+
+	if req.Tool != "" && (!slices.ContainsFunc(cfg.Tools, func(t config.Tool) bool { return t.ID == req.Tool }) || !p.AllowsTool(req.Tool)) {
+		http.Error(w, fmt.Sprintf("tool %q is not available", req.Tool), http.StatusBadRequest)
+		return
+	}
+
+	if req.Requests == 0 {
+		req.Requests = h.guests.MaxRequests
+	}
+
+	if req.Requests < 0 || req.Requests > h.guests.MaxRequests {
+		http.Error(w, fmt.Sprintf("requests must be between 1 and %d", h.guests.MaxRequests), http.StatusBadRequest)
+		return
+	}
+
+	ttl := 24 * time.Hour
+
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+
+		ttl = d
+	}
+
+	if ttl > h.guests.MaxTTL {
+		http.Error(w, fmt.Sprintf("ttl must not exceed %s", h.guests.MaxTTL), http.StatusBadRequest)
+		return
+	}
+
+	secret, link, err := h.guests.Create(guest.Link{
+		Name:  req.Name,
+		Owner: user.ID,
+
+		Model: req.Model,
+		Tool:  req.Tool,
+
+		Requests: req.Requests,
+
+		Expires: time.Now().Add(ttl).UTC(),
+	})
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(createResponse{link, auth.ExternalURL(r) + "/guest/" + secret})
+}
+
+func (h *Handler) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	user := requireUser(w, r)
+
+	if user == nil {
+		return
+	}
+
+	if err := h.guests.Revoke(user.ID, r.PathValue("id")); err != nil {
+		status := http.StatusInternalServerError
+
+		if errors.Is(err, guest.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireUser answers 401 unless an authenticated user is calling; guests
+// and API keys can't mint links. Users only see and revoke their own links;
+// the admin API manages everyone's.
+func requireUser(w http.ResponseWriter, r *http.Request) *auth.User {
+	user := auth.Authenticated(r.Context())
+
+	if user == nil || apikeys.FromContext(r.Context()) != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	return user
+}
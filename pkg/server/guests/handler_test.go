@@ -0,0 +1,103 @@
+package guests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/guest"
+)
+
+func testMux(t *testing.T) (*http.ServeMux, *guest.Store) {
+	links, err := guest.NewStore("")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := config.NewStore(&config.Config{
+		Models: []config.Model{{ID: "small"}},
+	})
+
+	mux := http.NewServeMux()
+	New(store, links).Attach(mux, "/api")
+
+	return mux, links
+}
+
+func call(mux *http.ServeMux, method, path, body, user string, source auth.Source) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+
+	if user != "" {
+		r = r.WithContext(auth.WithUser(r.Context(), &auth.User{ID: user}, source))
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, r)
+
+	return rec
+}
+
+func TestMintNeedsAuthentication(t *testing.T) {
+	mux, links := testMux(t)
+
+	for name, tc := range map[string]struct {
+		user   string
+		source auth.Source
+		status int
+	}{
+		"anonymous": {"", 0, http.StatusUnauthorized},
+		"guest":     {"guest:x", auth.SourceGuest, http.StatusUnauthorized},
+		"signed in": {"alice", auth.SourceLogin, http.StatusCreated},
+	} {
+		rec := call(mux, http.MethodPost, "/api/guests", `{"name":"demo","model":"small"}`, tc.user, tc.source)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, tc.status)
+		}
+	}
+
+	if n := len(links.List("")); n != 1 {
+		t.Errorf("%d links minted, want 1", n)
+	}
+}
+
+func TestListAndRevokeOwnLinksOnly(t *testing.T) {
+	mux, links := testMux(t)
+
+	rec := call(mux, http.MethodPost, "/api/guests", `{"name":"demo","model":"small"}`, "alice", auth.SourceLogin)
+
+	var link struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(rec.Body).Decode(&link); err != nil || link.ID == "" {
+		t.Fatalf("mint: status %d, %v", rec.Code, err)
+	}
+
+	rec = call(mux, http.MethodGet, "/api/guests", "", "bob", auth.SourceLogin)
+
+	if strings.Contains(rec.Body.String(), link.ID) {
+		t.Error("bob sees alice's link")
+	}
+
+	if rec := call(mux, http.MethodDelete, "/api/guests/"+link.ID, "", "bob", auth.SourceLogin); rec.Code != http.StatusNotFound {
+		t.Errorf("bob revoking alice's link: status = %d, want 404", rec.Code)
+	}
+
+	if rec := call(mux, http.MethodDelete, "/api/guests/"+link.ID, "", "", 0); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous revoke: status = %d, want 401", rec.Code)
+	}
+
+	if len(links.List("alice")) != 1 {
+		t.Fatal("alice's link is gone")
+	}
+
+	if rec := call(mux, http.MethodDelete, "/api/guests/"+link.ID, "", "alice", auth.SourceLogin); rec.Code != http.StatusNoContent {
+		t.Errorf("alice revoking her link: status = %d, want 204", rec.Code)
+	}
+}
@@ -0,0 +1,243 @@
+// Package health serves probes for orchestrators: liveness (the process
+// serves requests), startup (configuration and stores are loaded) and
+// readiness (started and the upstream is reachable), each with the results
+// of its checks as JSON, and the state of the upstream in detail.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+)
+
+// Check reports a problem as error.
+type Check func(ctx context.Context) error
+
+type Handler struct {
+	mu       sync.Mutex
+	startup  []named
+	ready    []named
+	upstream []named
+}
+
+type named struct {
+	name  string
+	check Check
+
+	// details are reported with the result of the check when set.
+	details func() any
+}
+
+func New() *Handler {
+	return &Handler{}
+}
+
+// Startup adds a check that must pass before the instance counts as started
+// (and ready).
+func (h *Handler) Startup(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.startup = append(h.startup, named{name: name, check: check})
+}
+
+// Ready adds a check that must pass for the instance to take traffic.
+func (h *Handler) Ready(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.ready = append(h.ready, named{name: name, check: check})
+}
+
+// Upstream adds a check of the upstream served at /healthz/upstream, with
+// details about its state (e.g. a circuit breaker), when not nil.
+func (h *Handler) Upstream(name string, check Check, details func() any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.upstream = append(h.upstream, named{name: name, check: check, details: details})
+}
+
+func (h *Handler) Attach(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz/live", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, nil)
+	})
+
+	mux.HandleFunc("GET /healthz/startup", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		checks := slices.Clone(h.startup)
+		h.mu.Unlock()
+
+		h.serve(w, r, checks)
+	})
+
+	mux.HandleFunc("GET /healthz/ready", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		checks := append(slices.Clone(h.startup), h.ready...)
+		h.mu.Unlock()
+
+		h.serve(w, r, checks)
+	})
+
+	mux.HandleFunc("GET /healthz/upstream", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		checks := slices.Clone(h.upstream)
+		h.mu.Unlock()
+
+		h.serve(w, r, checks)
+	})
+}
+
+func (h *Handler) Describe(spec *openapi.Spec) {
+	for path, summary := range map[string]string{
+		"/healthz/live":     "Liveness: the process serves requests",
+		"/healthz/startup":  "Startup: configuration and stores are loaded",
+		"/healthz/ready":    "Readiness: started, and the platform answers",
+		"/healthz/upstream": "The state of the platform, its replicas and the circuit breaker",
+	} {
+		spec.Add("GET", path, openapi.Operation{
+			Summary: summary, Tag: "health",
+			Response: reflect.TypeFor[report](),
+		})
+	}
+}
+
+// Wrap serves the probes ahead of next, so they bypass authentication and
+// network rules.
+func (h *Handler) Wrap(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	h.Attach(mux)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/healthz/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type result struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	Details any `json:"details,omitempty"`
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]result `json:"checks,omitempty"`
+}
+
+// serve runs the checks concurrently and answers 503 when any fails.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, checks []named) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	results := make([]result, len(checks))
+
+	var wg sync.WaitGroup
+
+	for i, c := range checks {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			results[i] = result{Status: "ok"}
+
+			if err := c.check(ctx); err != nil {
+				results[i] = result{Status: "fail", Error: err.Error()}
+			}
+
+			if c.details != nil {
+				results[i].Details = c.details()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	rep := report{
+		Status: "ok",
+		Checks: map[string]result{},
+	}
+
+	for i, c := range checks {
+		rep.Checks[c.name] = results[i]
+
+		if results[i].Status != "ok" {
+			rep.Status = "fail"
+		}
+	}
+
+	status := http.StatusOK
+
+	if rep.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rep)
+}
+
+// Upstream returns a check that the API at url answers (any status below
+// 500), caching the outcome for ttl so probes don't hammer it.
+func Upstream(url string, token func() string, transport http.RoundTripper, ttl time.Duration) Check {
+	client := &http.Client{Transport: transport}
+
+	var mu sync.Mutex
+	var checked time.Time
+	var last error
+
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if time.Since(checked) < ttl {
+			return last
+		}
+
+		last = probe(ctx, client, url, token())
+		checked = time.Now()
+
+		return last
+	}
+}
+
+func probe(ctx context.Context, client *http.Client, url, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return errors.New("upstream answered " + resp.Status)
+	}
+
+	return nil
+}
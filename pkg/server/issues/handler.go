@@ -0,0 +1,328 @@
+// Package issues offers built-in tools that file GitHub issues and Jira
+// tickets from a chat.
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/connections"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+	"github.com/adrianliechti/wingman-chat/pkg/mcp"
+)
+
+// ID is the tool id to list in tools.yaml; the tools are served at
+// <prefix>/v1/mcp/issues, where the UI looks for tools without a URL.
+const ID = "issues"
+
+type Config struct {
+	GitHubURL   string
+	GitHubToken string
+
+	// GitHubRepositories restricts the repositories ("owner/name") issues may
+	// be created in; empty allows all the token can access.
+	GitHubRepositories []string
+
+	JiraURL   string
+	JiraEmail string
+	JiraToken string
+
+	// JiraProjects restricts the project keys tickets may be created in.
+	JiraProjects []string
+
+	// Connections, when it has an Atlassian provider, lets users create Jira
+	// tickets as themselves (Jira Cloud only); JiraToken is then the fallback
+	// for users who haven't connected.
+	Connections *connections.Manager
+
+	// Confirm reports whether calls of a tool need the user's confirmation
+	// (see mcp.Server).
+	Confirm func(ctx context.Context, tool string) bool
+}
+
+// ConfigFromEnv reads GITHUB_API_URL, GITHUB_TOKEN, GITHUB_REPOSITORIES,
+// JIRA_URL, JIRA_EMAIL, JIRA_TOKEN and JIRA_PROJECTS.
+func ConfigFromEnv() Config {
+	return Config{
+		GitHubURL:          os.Getenv("GITHUB_API_URL"),
+		GitHubToken:        os.Getenv("GITHUB_TOKEN"),
+		GitHubRepositories: env.List("GITHUB_REPOSITORIES"),
+
+		JiraURL:      os.Getenv("JIRA_URL"),
+		JiraEmail:    os.Getenv("JIRA_EMAIL"),
+		JiraToken:    os.Getenv("JIRA_TOKEN"),
+		JiraProjects: env.List("JIRA_PROJECTS"),
+	}
+}
+
+func (c Config) jira() bool {
+	return c.JiraURL != "" && (c.JiraToken != "" || c.Connections.Has("atlassian"))
+}
+
+// Enabled reports whether any tracker is configured.
+func (c Config) Enabled() bool {
+	return c.GitHubToken != "" || c.jira()
+}
+
+type Handler struct {
+	client *http.Client
+
+	config Config
+	server *mcp.Server
+}
+
+func New(cfg Config) *Handler {
+	if cfg.GitHubURL == "" {
+		cfg.GitHubURL = "https://api.github.com"
+	}
+
+	h := &Handler{
+		client: http.DefaultClient,
+
+		config: cfg,
+	}
+
+	var tools []mcp.Tool
+
+	if cfg.GitHubToken != "" {
+		tools = append(tools, mcp.Tool{
+			Name:        "create_github_issue",
+			Description: "Creates an issue in a GitHub repository and returns its URL.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"repository": map[string]any{"type": "string", "description": "Repository as owner/name"},
+					"title":      map[string]any{"type": "string"},
+					"body":       map[string]any{"type": "string", "description": "Markdown description"},
+					"labels":     map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+				},
+				"required": []string{"repository", "title"},
+			},
+			Call: h.createGitHubIssue,
+		})
+	}
+
+	if cfg.jira() {
+		tools = append(tools, mcp.Tool{
+			Name:        "create_jira_issue",
+			Description: "Creates a Jira issue and returns its key and URL.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"project":     map[string]any{"type": "string", "description": "Project key, e.g. OPS"},
+					"summary":     map[string]any{"type": "string"},
+					"description": map[string]any{"type": "string"},
+					"type":        map[string]any{"type": "string", "description": "Issue type (default Task)"},
+				},
+				"required": []string{"project", "summary"},
+			},
+			Call: h.createJiraIssue,
+		})
+	}
+
+	h.server = mcp.NewServer("wingman-issues", "1.0.0", tools...)
+	h.server.Confirm = cfg.Confirm
+
+	return h
+}
+
+func (h *Handler) Attach(mux *http.ServeMux, prefix string) {
+	mux.Handle(strings.TrimRight(prefix, "/")+"/v1/mcp/"+ID, h.server)
+}
+
+func (h *Handler) createGitHubIssue(ctx context.Context, args map[string]any) (string, error) {
+	repository := stringArg(args, "repository")
+
+	if strings.Count(repository, "/") != 1 {
+		return "", errors.New("repository must be given as owner/name")
+	}
+
+	if len(h.config.GitHubRepositories) > 0 && !slices.Contains(h.config.GitHubRepositories, repository) {
+		return "", fmt.Errorf("creating issues in %s is not allowed", repository)
+	}
+
+	body := map[string]any{
+		"title": stringArg(args, "title"),
+		"body":  stringArg(args, "body"),
+	}
+
+	if labels, ok := args["labels"].([]any); ok {
+		body["labels"] = labels
+	}
+
+	var result struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+
+	url := strings.TrimRight(h.config.GitHubURL, "/") + "/repos/" + repository + "/issues"
+
+	if err := h.post(ctx, url, "Bearer "+h.config.GitHubToken, body, &result); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Created issue #%d: %s", result.Number, result.HTMLURL), nil
+}
+
+func (h *Handler) createJiraIssue(ctx context.Context, args map[string]any) (string, error) {
+	project := stringArg(args, "project")
+
+	if len(h.config.JiraProjects) > 0 && !slices.Contains(h.config.JiraProjects, project) {
+		return "", fmt.Errorf("creating issues in project %s is not allowed", project)
+	}
+
+	issueType := stringArg(args, "type")
+
+	if issueType == "" {
+		issueType = "Task"
+	}
+
+	body := map[string]any{
+		"fields": map[string]any{
+			"project":     map[string]any{"key": project},
+			"summary":     stringArg(args, "summary"),
+			"description": stringArg(args, "description"),
+			"issuetype":   map[string]any{"name": issueType},
+		},
+	}
+
+	var result struct {
+		Key string `json:"key"`
+	}
+
+	site := strings.TrimRight(h.config.JiraURL, "/")
+
+	base, authorization, err := h.jiraAccess(ctx, site)
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.post(ctx, base+"/rest/api/2/issue", authorization, body, &result); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Created %s: %s/browse/%s", result.Key, site, result.Key), nil
+}
+
+// jiraAccess returns the API base and credentials: the user's connected
+// Atlassian account if any, else the shared token.
+func (h *Handler) jiraAccess(ctx context.Context, site string) (string, string, error) {
+	token, err := h.config.Connections.AccessToken(ctx, "atlassian")
+
+	if err == nil {
+		id, err := h.atlassianCloudID(ctx, token, site)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		return "https://api.atlassian.com/ex/jira/" + id, "Bearer " + token, nil
+	}
+
+	if !errors.Is(err, connections.ErrNotConnected) {
+		return "", "", err
+	}
+
+	if h.config.JiraToken == "" {
+		return "", "", errors.New("connect your Atlassian account to create Jira issues")
+	}
+
+	// Jira Cloud authenticates with email and API token, Data Center with a
+	// personal access token.
+	if h.config.JiraEmail != "" {
+		return site, "Basic " + base64.StdEncoding.EncodeToString([]byte(h.config.JiraEmail+":"+h.config.JiraToken)), nil
+	}
+
+	return site, "Bearer " + h.config.JiraToken, nil
+}
+
+// atlassianCloudID looks up the id of site among the sites token grants
+// access to; OAuth calls go through api.atlassian.com with it.
+func (h *Handler) atlassianCloudID(ctx context.Context, token, site string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.atlassian.com/oauth/token/accessible-resources", nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("listing Atlassian sites failed (%s)", resp.Status)
+	}
+
+	var resources []struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&resources); err != nil {
+		return "", err
+	}
+
+	for _, r := range resources {
+		if strings.TrimRight(r.URL, "/") == site {
+			return r.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("your Atlassian account has no access to %s", site)
+}
+
+func (h *Handler) post(ctx context.Context, url, authorization string, body, result any) error {
+	data, _ := json.Marshal(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	data, err = io.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("request failed (%s): %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+func stringArg(args map[string]any, name string) string {
+	s, _ := args[name].(string)
+	return strings.TrimSpace(s)
+}
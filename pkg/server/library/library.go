@@ -11,11 +11,14 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -107,6 +110,20 @@ func (inv *inventory[T]) serve(w http.ResponseWriter, _ *http.Request) {
 	json.NewEncoder(w).Encode(out)
 }
 
+// describe adds the inventory at base and the files below it to spec.
+func describe(spec *openapi.Spec, base, tag string, entries reflect.Type) {
+	spec.Add("GET", base, openapi.Operation{
+		Summary: "List the " + tag, Tag: "library",
+		Response: entries,
+	})
+
+	spec.Add("GET", base+"/{path...}", openapi.Operation{
+		Summary: "A file of the " + tag + " library, by the path of its entry", Tag: "library",
+		Response:     map[string]any{"type": "string"},
+		ResponseType: "text/markdown",
+	})
+}
+
 // ── Skills ──────────────────────────────────────────────────────────────────
 
 type skillEntry struct {
@@ -134,6 +151,10 @@ func (h *Skills) Attach(mux *http.ServeMux) {
 	mux.HandleFunc("GET /skills/{path...}", h.handleContent)
 }
 
+func (h *Skills) Describe(spec *openapi.Spec) {
+	describe(spec, "/skills", "skills", reflect.TypeFor[[]skillEntry]())
+}
+
 func (h *Skills) handleContent(w http.ResponseWriter, r *http.Request) {
 	full, ok := safePath(h.root, r.PathValue("path"))
 	if !ok {
@@ -260,6 +281,10 @@ func (h *Notebooks) Attach(mux *http.ServeMux) {
 	mux.HandleFunc("GET /notebooks/{path...}", h.handleContent)
 }
 
+func (h *Notebooks) Describe(spec *openapi.Spec) {
+	describe(spec, "/notebooks", "notebooks", reflect.TypeFor[[]notebookEntry]())
+}
+
 func (h *Notebooks) handleContent(w http.ResponseWriter, r *http.Request) {
 	full, ok := safePath(h.root, r.PathValue("path"))
 	if !ok {
@@ -6,6 +6,8 @@ import (
 	"net/url"
 	"os"
 	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/buffers"
 )
 
 type Handler struct {
@@ -60,6 +62,8 @@ func newHandler(endpoint string) http.Handler {
 	target, _ := url.Parse(endpoint)
 
 	return &httputil.ReverseProxy{
+		BufferPool: buffers.Pool,
+
 		Rewrite: func(req *httputil.ProxyRequest) {
 			req.SetXForwarded()
 			req.Out.URL.Scheme = target.Scheme
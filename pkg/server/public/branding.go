@@ -0,0 +1,117 @@
+package public
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+)
+
+// brandingAssets maps the bundled asset paths to the branding setting that
+// replaces them; dark variants fall back to the light setting.
+var brandingAssets = map[string]func(*config.Branding) string{
+	"/logo_light.svg": func(b *config.Branding) string { return b.Logo },
+	"/logo_dark.svg":  func(b *config.Branding) string { return or(b.LogoDark, b.Logo) },
+
+	"/icon_light.svg": func(b *config.Branding) string { return b.Icon },
+	"/icon_light.png": func(b *config.Branding) string { return b.Icon },
+	"/icon_dark.svg":  func(b *config.Branding) string { return or(b.IconDark, b.Icon) },
+	"/icon_dark.png":  func(b *config.Branding) string { return or(b.IconDark, b.Icon) },
+	"/icon_app.png":   func(b *config.Branding) string { return b.Icon },
+}
+
+func (h *Handler) attachBranding(mux *http.ServeMux, fallback http.Handler) {
+	for p, asset := range brandingAssets {
+		mux.HandleFunc("GET "+p, func(w http.ResponseWriter, r *http.Request) {
+			b := h.config(r).Branding
+
+			if b == nil || asset(b) == "" {
+				fallback.ServeHTTP(w, r)
+				return
+			}
+
+			if value := asset(b); isURL(value) {
+				http.Redirect(w, r, value, http.StatusFound)
+			} else {
+				http.ServeFile(w, r, value)
+			}
+		})
+	}
+
+	mux.HandleFunc("GET /manifest.json", h.handleManifest)
+}
+
+// handleManifest generates the web app manifest from title and branding.
+func (h *Handler) handleManifest(w http.ResponseWriter, r *http.Request) {
+	cfg := localize(r, h.config(r))
+
+	manifest := map[string]any{
+		"name":       cfg.Title,
+		"short_name": cfg.Title,
+		"start_url":  "/",
+		"display":    "standalone",
+		"icons": []map[string]string{
+			{"src": "/icon_app.png", "sizes": "512x512", "type": "image/png", "purpose": "any"},
+		},
+	}
+
+	if b := cfg.Branding; b != nil {
+		if b.Color != "" {
+			manifest["theme_color"] = b.Color
+		}
+
+		if b.BackgroundColor != "" {
+			manifest["background_color"] = b.BackgroundColor
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Vary", "Accept-Language, Cookie")
+
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// Config returns cfg as served in /config.json: branding files are replaced
+// by the asset paths serving them, so server paths don't leak.
+func Config(cfg *config.Config) *config.Config {
+	if cfg.Branding == nil {
+		return cfg
+	}
+
+	b := *cfg.Branding
+
+	b.Logo = publicAsset(b.Logo, "/logo_light.svg")
+	b.LogoDark = publicAsset(b.LogoDark, "/logo_dark.svg")
+	b.Icon = publicAsset(b.Icon, "/icon_light.svg")
+	b.IconDark = publicAsset(b.IconDark, "/icon_dark.svg")
+
+	c := *cfg
+	c.Branding = &b
+
+	return &c
+}
+
+func publicAsset(value, path string) string {
+	if value == "" || isURL(value) {
+		return value
+	}
+
+	return path
+}
+
+// isURL tells URLs (with a scheme, e.g. https: or data:) from file paths.
+func isURL(value string) bool {
+	scheme, _, ok := strings.Cut(value, ":")
+	return ok && len(scheme) > 1 && !strings.ContainsAny(scheme, `/\`)
+}
+
+func or(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
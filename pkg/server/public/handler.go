@@ -5,30 +5,95 @@ import (
 	"io/fs"
 	"net/http"
 	"path"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/degrade"
+	"github.com/adrianliechti/wingman-chat/pkg/guest"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+	"github.com/adrianliechti/wingman-chat/pkg/rbac"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
 )
 
 type Handler struct {
-	config *config.Config
-	dist   fs.FS
+	store *config.Store
+	dist  fs.FS
+
+	degradation *degrade.Monitor
 }
 
-func New(cfg *config.Config, dist fs.FS) *Handler {
+func New(store *config.Store, dist fs.FS, degradation *degrade.Monitor) *Handler {
 	return &Handler{
-		config: cfg,
-		dist:   dist,
+		store: store,
+		dist:  dist,
+
+		degradation: degradation,
 	}
 }
 
 func (h *Handler) Attach(mux *http.ServeMux) {
 	mux.HandleFunc("GET /config.json", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(h.config)
+		w.Header().Set("Vary", "Accept-Language, Cookie")
+
+		json.NewEncoder(w).Encode(Config(localize(r, rbac.Filter(h.config(r), rbac.ForRequest(r, h.store)))))
+	})
+
+	mux.HandleFunc("GET /config.schema.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		json.NewEncoder(w).Encode(config.Schema())
+	})
+
+	spa := h.spaHandler()
+
+	h.attachBranding(mux, spa)
+
+	mux.Handle("/", spa)
+}
+
+func (h *Handler) Describe(spec *openapi.Spec) {
+	spec.Add("GET", "/config.json", openapi.Operation{
+		Summary: "The configuration of the UI for the caller and their tenant, translated per ?lang=", Tag: "config",
+		Query:    []string{"lang"},
+		Response: reflect.TypeFor[config.Config](),
 	})
 
-	mux.Handle("/", h.spaHandler())
+	spec.Add("GET", "/config.schema.json", openapi.Operation{
+		Summary: "The JSON Schema of the YAML configuration files", Tag: "config",
+		Response:     map[string]any{"type": "object"},
+		ResponseType: "application/schema+json",
+	})
+
+	spec.Add("GET", "/manifest.json", openapi.Operation{
+		Summary: "The web app manifest with the branding of the tenant", Tag: "config",
+		Response:     map[string]any{"type": "object"},
+		ResponseType: "application/manifest+json",
+	})
+}
+
+// config is the configuration of the request's tenant, with retired models
+// aliased to their replacements and the features whose services fail
+// turned off. Guests keep their chats in memory only.
+func (h *Handler) config(r *http.Request) *config.Config {
+	cfg := h.degradation.Apply(tenant.Config(r.Context(), h.store).ApplyDeprecations(time.Now()))
+
+	if guest.FromContext(r.Context()) == nil {
+		return cfg
+	}
+
+	c := *cfg
+	chat := config.Chat{}
+
+	if cfg.Chat != nil {
+		chat = *cfg.Chat
+	}
+
+	chat.Ephemeral = true
+	c.Chat = &chat
+
+	return &c
 }
 
 func (h *Handler) spaHandler() http.Handler {
@@ -0,0 +1,140 @@
+package public
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+)
+
+// langCookie stores a user's language preference; it wins over the
+// browser's Accept-Language, as does a ?lang= query.
+const langCookie = "lang"
+
+// localize returns cfg with the title and disclaimers in the language the
+// request prefers, where they are translated.
+func localize(r *http.Request, cfg *config.Config) *config.Config {
+	c := *cfg
+
+	if b := cfg.Branding; b != nil {
+		if title, ok := translate(r, b.Titles); ok {
+			c.Title = title
+		}
+
+		if disclaimer, ok := translate(r, b.Disclaimers); ok {
+			c.Disclaimer = disclaimer
+		}
+	}
+
+	if cfg.Renderer != nil {
+		if disclaimer, ok := translate(r, cfg.Renderer.Disclaimers); ok {
+			renderer := *cfg.Renderer
+			renderer.Disclaimer = disclaimer
+
+			c.Renderer = &renderer
+		}
+	}
+
+	return &c
+}
+
+// translate picks the entry of translations for the most preferred language
+// of the request: a regional tag (de-CH) falls back to its language (de),
+// and a language to any of its regions.
+func translate(r *http.Request, translations map[string]string) (string, bool) {
+	if len(translations) == 0 {
+		return "", false
+	}
+
+	tags := make(map[string]string, len(translations))
+	keys := make([]string, 0, len(translations))
+
+	for tag := range translations {
+		tags[strings.ToLower(tag)] = tag
+		keys = append(keys, strings.ToLower(tag))
+	}
+
+	// Sorted, so the pick among regions doesn't change between requests.
+	slices.Sort(keys)
+
+	for _, lang := range languages(r) {
+		if tag, ok := tags[lang]; ok {
+			return translations[tag], true
+		}
+
+		base, _, _ := strings.Cut(lang, "-")
+
+		if tag, ok := tags[base]; ok {
+			return translations[tag], true
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, base+"-") {
+				return translations[tags[key]], true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// languages lists the language tags of the request, lower case and most
+// preferred first: the ?lang= query, the lang cookie, then Accept-Language
+// by quality.
+func languages(r *http.Request) []string {
+	var result []string
+
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		result = append(result, strings.ToLower(lang))
+	}
+
+	if c, err := r.Cookie(langCookie); err == nil && c.Value != "" {
+		result = append(result, strings.ToLower(c.Value))
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var accepted []weighted
+
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				q = v
+			}
+		}
+
+		if q > 0 {
+			accepted = append(accepted, weighted{strings.ToLower(tag), q})
+		}
+	}
+
+	slices.SortStableFunc(accepted, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+
+		return 0
+	})
+
+	for _, a := range accepted {
+		result = append(result, a.tag)
+	}
+
+	return result
+}
@@ -0,0 +1,82 @@
+// Package replicas serves the nodes that run as replicas of this instance:
+// their configuration and the health reports they send.
+package replicas
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/rbac"
+	"github.com/adrianliechti/wingman-chat/pkg/replica"
+	"github.com/adrianliechti/wingman-chat/pkg/server/public"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+)
+
+// maxReportSize bounds heartbeat payloads.
+const maxReportSize = 64 << 10
+
+type Handler struct {
+	store    *config.Store
+	registry *replica.Registry
+}
+
+func New(store *config.Store, registry *replica.Registry) *Handler {
+	return &Handler{
+		store:    store,
+		registry: registry,
+	}
+}
+
+func (h *Handler) Attach(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimRight(prefix, "/") + "/replica"
+
+	mux.HandleFunc("GET "+prefix+"/config", h.handleConfig)
+	mux.HandleFunc("POST "+prefix+"/heartbeat", h.handleHeartbeat)
+}
+
+// handleConfig returns /config.json as the caller (the node's identity) sees
+// it. Branding assets are made absolute, so the node's users load them from
+// here.
+func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := public.Config(rbac.Filter(tenant.Config(r.Context(), h.store), rbac.ForRequest(r, h.store)))
+
+	if b := cfg.Branding; b != nil {
+		origin := auth.ExternalURL(r)
+
+		for _, v := range []*string{&b.Logo, &b.LogoDark, &b.Icon, &b.IconDark} {
+			if strings.HasPrefix(*v, "/") {
+				*v = origin + *v
+			}
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+func (h *Handler) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var report replica.Report
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxReportSize)).Decode(&report); err != nil || report.ID == "" {
+		http.Error(w, "invalid report", http.StatusBadRequest)
+		return
+	}
+
+	var identity string
+
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		identity = user.ID
+	}
+
+	if !h.registry.Record(report, identity, r.RemoteAddr) {
+		http.Error(w, "too many replicas", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
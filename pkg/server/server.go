@@ -1,43 +1,491 @@
 package server
 
 import (
+	"context"
 	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 
+	"github.com/adrianliechti/wingman-chat/pkg/anomaly"
+	"github.com/adrianliechti/wingman-chat/pkg/apikeys"
+	"github.com/adrianliechti/wingman-chat/pkg/archive"
+	"github.com/adrianliechti/wingman-chat/pkg/auth"
+	"github.com/adrianliechti/wingman-chat/pkg/breaker"
+	"github.com/adrianliechti/wingman-chat/pkg/canary"
 	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/connections"
+	"github.com/adrianliechti/wingman-chat/pkg/cors"
+	"github.com/adrianliechti/wingman-chat/pkg/degrade"
+	"github.com/adrianliechti/wingman-chat/pkg/federation"
+	"github.com/adrianliechti/wingman-chat/pkg/guest"
+	"github.com/adrianliechti/wingman-chat/pkg/injection"
+	"github.com/adrianliechti/wingman-chat/pkg/keys"
+	"github.com/adrianliechti/wingman-chat/pkg/metrics"
+	"github.com/adrianliechti/wingman-chat/pkg/moderation"
+	"github.com/adrianliechti/wingman-chat/pkg/netacl"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+	"github.com/adrianliechti/wingman-chat/pkg/queue"
+	"github.com/adrianliechti/wingman-chat/pkg/rbac"
+	"github.com/adrianliechti/wingman-chat/pkg/realtime"
+	"github.com/adrianliechti/wingman-chat/pkg/redact"
+	"github.com/adrianliechti/wingman-chat/pkg/replica"
+	"github.com/adrianliechti/wingman-chat/pkg/security"
+	"github.com/adrianliechti/wingman-chat/pkg/server/admin"
 	"github.com/adrianliechti/wingman-chat/pkg/server/api"
+	connectionsapi "github.com/adrianliechti/wingman-chat/pkg/server/connections"
+	"github.com/adrianliechti/wingman-chat/pkg/server/database"
 	"github.com/adrianliechti/wingman-chat/pkg/server/drive"
+	"github.com/adrianliechti/wingman-chat/pkg/server/flags"
+	"github.com/adrianliechti/wingman-chat/pkg/server/guests"
+	"github.com/adrianliechti/wingman-chat/pkg/server/health"
+	"github.com/adrianliechti/wingman-chat/pkg/server/issues"
 	"github.com/adrianliechti/wingman-chat/pkg/server/library"
 	"github.com/adrianliechti/wingman-chat/pkg/server/otel"
 	"github.com/adrianliechti/wingman-chat/pkg/server/public"
+	"github.com/adrianliechti/wingman-chat/pkg/server/replicas"
+	"github.com/adrianliechti/wingman-chat/pkg/server/voices"
+	"github.com/adrianliechti/wingman-chat/pkg/statuspage"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+	"github.com/adrianliechti/wingman-chat/pkg/tokens"
 )
 
-func New(cfg *config.Config, prefix string, url *url.URL, token string, dist fs.FS, skillsDir, notebookDir string) http.Handler {
+type Options struct {
+	// Prefix is the path the platform API is proxied under (e.g. "/api").
+	Prefix string
+
+	// URL is the platform API; requests use the current of Tokens.
+	URL    *url.URL
+	Tokens *tokens.Tokens
+
+	// Transport connects to the platform; http.DefaultTransport when nil.
+	Transport http.RoundTripper
+
+	// AdminTokens enable the admin API for the holders of the tokens of
+	// each role; tenant admins use the adminToken of their tenant.
+	AdminTokens admin.Tokens
+
+	Dist fs.FS
+
+	SkillsDir   string
+	NotebookDir string
+
+	// Tenants enables multi-tenancy when set.
+	Tenants *tenant.Registry
+
+	// Login requires users to sign in (OIDC or SAML) when set.
+	Login auth.Login
+
+	// Sessions are the sessions of Login; the admin API manages them when
+	// they are tracked.
+	Sessions *auth.Sessions
+
+	// Devices gives browsers a signed device cookie, the id of anonymous
+	// callers, when set.
+	Devices *auth.Devices
+
+	// Proxy reads the identity headers of a trusted reverse proxy when set,
+	// requiring them unless it is optional. Without, those headers are
+	// stripped from every request.
+	Proxy *auth.Proxy
+
+	// Bearer requires a valid JWT (or a login session) for the API when set.
+	Bearer *auth.Bearer
+
+	// Keys stores the upstream keys users bring themselves when set.
+	Keys *keys.Store
+
+	// KeyHeader lets clients send their own upstream key in this header.
+	KeyHeader string
+
+	// GeoIPHeader names the header in which a reverse proxy tells the
+	// country of the client, for the regions of residency.yaml. It is only
+	// honoured on connections from the trusted networks of Proxy.
+	GeoIPHeader string
+
+	// PlatformRegion is the region the platform serves in, for the
+	// residency policies.
+	PlatformRegion string
+
+	// RateLimit are the default limits of the /api proxy per caller and
+	// model.
+	RateLimit api.RateLimit
+
+	// Archive records the interactions proxied by the API when set.
+	Archive *archive.Archive
+
+	// Anomalies flags unusual usage of the API and drives when set.
+	Anomalies *anomaly.Detector
+
+	// Canaries blocks tool calls carrying a canary string when set.
+	Canaries *canary.Tripwire
+
+	// Moderator checks the input of chat requests when set.
+	Moderator *moderation.Moderator
+
+	// Injection screens scraped content and tool results for planted
+	// instructions when set.
+	Injection *injection.Screener
+
+	// Security receives the security events of moderation, injection
+	// screening and canaries; the admin API reports their statistics.
+	Security *security.Monitor
+
+	// Redactor masks personal data in prompts to the platform when set.
+	Redactor *redact.Redactor
+
+	// JSONRetries enables the guaranteed-JSON mode of the proxy when
+	// positive.
+	JSONRetries int
+
+	// Realtime proxies the WebSocket sessions of the realtime API.
+	Realtime *realtime.Proxy
+
+	// StreamSmoothing coalesces streamed answers into one write per
+	// interval when set.
+	StreamSmoothing time.Duration
+
+	// StreamKeepAlive sends a comment on streamed answers idle for that
+	// long when set.
+	StreamKeepAlive time.Duration
+
+	// Retry repeats idempotent calls of the proxy that fail transiently.
+	Retry api.Retry
+
+	// BodyLimit caps the size of request bodies of the proxy.
+	BodyLimit api.BodyLimit
+
+	// Cache keeps answers of the proxy that are the same for the same request.
+	Cache api.Cache
+
+	// Queue limits the requests of the proxy in flight toward the upstream.
+	Queue *queue.Queue
+
+	// Metrics collects the usage of the proxy, served at /admin/metrics.
+	Metrics *metrics.Registry
+
+	// Headers selects the headers the proxy passes to the upstream and back.
+	Headers api.HeaderPolicy
+
+	// Status follows the status pages of the providers when set: failed
+	// answers of the platform name their incidents, and the pages post
+	// their webhooks to /statuspage/<name>.
+	Status *statuspage.Monitor
+
+	// Degradation turns features off while their services fail when set;
+	// /healthz/upstream reports which.
+	Degradation *degrade.Monitor
+
+	// Breaker answers requests to the platform with 503 right away while it
+	// keeps failing when set; /healthz/upstream reports its state.
+	Breaker *breaker.Breaker
+
+	// CORS lets the allowed web apps call the API from the browser when set.
+	CORS *cors.Policy
+
+	// APIKeys accepts keys minted through the admin API for the proxy.
+	APIKeys *apikeys.Store
+
+	// Connections lets users link accounts that tools use on their behalf.
+	Connections *connections.Manager
+
+	// Guests lets users mint links that grant people without an account a
+	// single model.
+	Guests *guest.Store
+
+	// Issues configures the built-in issue tracker tools.
+	Issues issues.Config
+
+	// Peer is the instance federated models and tools are proxied to.
+	Peer *federation.Peer
+
+	// Replicas tracks the nodes running as replicas of this instance.
+	Replicas *replica.Registry
+
+	// Replica runs this instance as a node of a hub when set: URL and Token
+	// point to the hub's API, which serves everything below Prefix.
+	Replica *replica.Node
+
+	// Health holds the checks of the /healthz probes; the upstream check is
+	// added here.
+	Health *health.Handler
+}
+
+// New returns the handler of the app, or an error when a feature's
+// configuration can't be served.
+func New(store *config.Store, opts Options) (http.Handler, error) {
 	mux := http.NewServeMux()
 
+	cfg := store.Config()
+
+	// The handlers describe the routes they attach in /openapi.json.
+	spec := openapi.New("Wingman", "1.0.0")
+
 	if cfg.Telemetry != nil {
 		otel.New().Attach(mux)
 	}
 
-	api.New(prefix, token, url).Attach(mux)
+	// A replica leaves everything below the prefix to the hub.
+	local := opts.Replica == nil
+
+	if local && opts.Connections != nil {
+		h := connectionsapi.New(opts.Connections)
+		h.Attach(mux, opts.Prefix)
+		h.Describe(spec, opts.Prefix)
+
+		opts.Issues.Connections = opts.Connections
+	}
+
+	if local && opts.Guests != nil {
+		h := guests.New(store, opts.Guests)
+		h.Attach(mux, opts.Prefix)
+		h.Describe(spec, opts.Prefix)
+	}
+
+	var tools []string
+
+	// Built-in tools ask the user as the elicitation policies of the
+	// request's tenant say.
+	confirm := func(id string) func(ctx context.Context, tool string) bool {
+		return func(ctx context.Context, tool string) bool {
+			return tenant.Config(ctx, store).ElicitationPolicy(id, tool) != config.ElicitNever
+		}
+	}
+
+	if local && opts.Issues.Enabled() {
+		opts.Issues.Confirm = confirm(issues.ID)
+
+		issues.New(opts.Issues).Attach(mux, opts.Prefix)
+		tools = append(tools, issues.ID)
+	}
+
+	if local && len(cfg.Databases) > 0 {
+		h, err := database.New(cfg.Databases, confirm(database.ID))
+
+		if err != nil {
+			return nil, err
+		}
+
+		h.Attach(mux, opts.Prefix)
+		h.Describe(spec, opts.Prefix)
+
+		tools = append(tools, database.ID)
+	}
+
+	if opts.Replicas != nil {
+		replicas.New(store, opts.Replicas).Attach(mux, opts.Prefix)
+	}
+
+	proxy := api.New(store, api.Options{
+		Prefix: opts.Prefix,
+
+		URL:    opts.URL,
+		Tokens: opts.Tokens,
+
+		Transport: opts.Transport,
+
+		Keys:      opts.Keys,
+		KeyHeader: opts.KeyHeader,
+
+		GeoIPHeader:    opts.GeoIPHeader,
+		PlatformRegion: opts.PlatformRegion,
+
+		Tools: tools,
+
+		Peer: opts.Peer,
+
+		RateLimit: opts.RateLimit,
+
+		Archive:   opts.Archive,
+		Anomalies: opts.Anomalies,
+		Canaries:  opts.Canaries,
+		Moderator: opts.Moderator,
+		Security:  opts.Security,
+		Redactor:  opts.Redactor,
+
+		JSONRetries: opts.JSONRetries,
+
+		Realtime: opts.Realtime,
+
+		StreamSmoothing: opts.StreamSmoothing,
+		StreamKeepAlive: opts.StreamKeepAlive,
+
+		Retry:   opts.Retry,
+		Breaker: opts.Breaker,
+
+		BodyLimit: opts.BodyLimit,
+		Headers:   opts.Headers,
+
+		Cache: opts.Cache,
+		Queue: opts.Queue,
+
+		Metrics: opts.Metrics,
+
+		Status: opts.Status,
+	})
+
+	proxy.Attach(mux)
+	proxy.Describe(spec)
+
+	if local && cfg.TTS != nil {
+		h := voices.New(store, opts.URL, opts.Tokens, opts.Transport)
+		h.Attach(mux, opts.Prefix)
+		h.Describe(spec, opts.Prefix)
+	}
+
+	if local && len(cfg.Drives) > 0 {
+		h := drive.New(cfg.Drives)
+		h.Attach(mux, opts.Prefix)
+		h.Describe(spec, opts.Prefix)
+	}
+
+	if opts.Replica != nil {
+		mux.HandleFunc("GET /replica/status", opts.Replica.HandleStatus)
+	}
+
+	if dirExists(opts.SkillsDir) {
+		h := library.NewSkills(opts.SkillsDir)
+		h.Attach(mux)
+		h.Describe(spec)
+	}
+
+	if dirExists(opts.NotebookDir) {
+		h := library.NewNotebooks(opts.NotebookDir)
+		h.Attach(mux)
+		h.Describe(spec)
+	}
+
+	features := flags.New(cfg.Flags)
+	features.Attach(mux)
+	features.Describe(spec)
+
+	if len(opts.AdminTokens) > 0 || opts.Tenants != nil {
+		h := admin.New(opts.AdminTokens, store, opts.Tenants, opts.APIKeys, opts.Guests, opts.Replicas, opts.Sessions, opts.Security, opts.Metrics)
+		h.Attach(mux)
+		h.Describe(spec)
+	}
+
+	if opts.Login != nil {
+		opts.Login.Attach(mux)
+	}
+
+	mux.Handle("GET /openapi.json", spec)
+
+	site := public.New(store, opts.Dist, opts.Degradation)
+	site.Attach(mux)
+	site.Describe(spec)
+
+	var handler http.Handler = mux
+
+	if opts.Degradation != nil {
+		handler = opts.Degradation.Wrap(opts.Prefix, handler)
+	}
+
+	handler = rbac.Enforce(opts.Prefix, store, handler)
+
+	if opts.Anomalies != nil {
+		handler = opts.Anomalies.Watch(opts.Prefix, handler)
+	}
+
+	if opts.Canaries != nil {
+		handler = opts.Canaries.Guard(opts.Prefix, handler)
+	}
+
+	if opts.Injection != nil {
+		handler = opts.Injection.Wrap(opts.Prefix, handler)
+	}
+
+	if opts.Bearer != nil {
+		handler = opts.Bearer.Require(opts.Prefix, handler)
+	}
+
+	if opts.Login != nil {
+		handler = opts.Login.Wrap(handler)
+	} else if opts.Proxy != nil && opts.Proxy.Optional {
+		handler = opts.Proxy.Identify(handler)
+	} else if opts.Proxy != nil {
+		handler = opts.Proxy.Require(handler)
+	}
+
+	if opts.Bearer != nil {
+		handler = opts.Bearer.Identify(handler)
+	}
+
+	if opts.APIKeys != nil {
+		handler = opts.APIKeys.Identify(opts.Prefix, handler)
+	}
+
+	if local && opts.Guests != nil {
+		handler = opts.Guests.Identify(opts.Prefix, handler)
+	}
+
+	if opts.Devices != nil {
+		handler = opts.Devices.Identify(handler)
+	}
+
+	if opts.Tenants != nil {
+		handler = opts.Tenants.Identify(handler)
+	}
+
+	if opts.CORS != nil {
+		handler = opts.CORS.Wrap(opts.Prefix, handler)
+	}
+
+	handler = netacl.Enforce(opts.Prefix, store, handler)
+
+	if opts.Replica != nil {
+		handler = opts.Replica.Track(handler)
+	}
+
+	if opts.Status != nil {
+		handler = opts.Status.Wrap(handler)
+	}
+
+	probes := opts.Health
+
+	if probes == nil {
+		probes = health.New()
+	}
+
+	if opts.Replica != nil {
+		probes.Startup("replica", opts.Replica.Loaded)
+	}
+
+	upstream := health.Upstream(opts.URL.String()+"/v1/models", opts.Tokens.Token, opts.Transport, 10*time.Second)
+
+	probes.Ready("upstream", upstream)
+	probes.Upstream("platform", upstream, nil)
 
-	if len(cfg.Drives) > 0 {
-		drive.New(cfg.Drives).Attach(mux, prefix)
+	if b := opts.Breaker; b != nil {
+		probes.Upstream("breaker", func(context.Context) error {
+			if b.RetryIn() > 0 {
+				return breaker.ErrOpen
+			}
+
+			return nil
+		}, func() any { return b.Status() })
 	}
 
-	if dirExists(skillsDir) {
-		library.NewSkills(skillsDir).Attach(mux)
+	if d := opts.Degradation; d != nil {
+		probes.Upstream("features", d.Check, func() any { return d.Down() })
 	}
 
-	if dirExists(notebookDir) {
-		library.NewNotebooks(notebookDir).Attach(mux)
+	probes.Describe(spec)
+
+	handler = probes.Wrap(handler)
+
+	// Identity and GeoIP headers of anyone but the trusted proxy are dropped
+	// first.
+	var proxyHeaders []string
+
+	if opts.GeoIPHeader != "" {
+		proxyHeaders = append(proxyHeaders, opts.GeoIPHeader)
 	}
 
-	public.New(cfg, dist).Attach(mux)
+	handler = opts.Proxy.Strip(handler, proxyHeaders...)
 
-	return mux
+	return handler, nil
 }
 
 func dirExists(path string) bool {
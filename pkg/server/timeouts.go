@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Timeouts bound how long a client may take to send a request and to take
+// the response, without cutting off long-running responses such as streamed
+// completions.
+type Timeouts struct {
+	// ReadHeader bounds reading the request headers, Read the whole request
+	// including its body.
+	ReadHeader time.Duration
+	Read       time.Duration
+
+	// Write bounds each write of the response rather than the response as a
+	// whole, so streams last as long as the model keeps answering.
+	Write time.Duration
+
+	// Idle bounds waiting for the next request on a kept-alive connection.
+	Idle time.Duration
+}
+
+// DefaultTimeouts give clients 10s for the headers, 5m for the body and 1m
+// per write, and keep idle connections for 2m.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		ReadHeader: 10 * time.Second,
+		Read:       5 * time.Minute,
+		Write:      time.Minute,
+		Idle:       2 * time.Minute,
+	}
+}
+
+// TimeoutsFromEnv reads SERVER_READ_HEADER_TIMEOUT, SERVER_READ_TIMEOUT,
+// SERVER_WRITE_TIMEOUT and SERVER_IDLE_TIMEOUT over the defaults; 0 disables
+// a timeout.
+func TimeoutsFromEnv() Timeouts {
+	t := DefaultTimeouts()
+
+	for key, d := range map[string]*time.Duration{
+		"SERVER_READ_HEADER_TIMEOUT": &t.ReadHeader,
+		"SERVER_READ_TIMEOUT":        &t.Read,
+		"SERVER_WRITE_TIMEOUT":       &t.Write,
+		"SERVER_IDLE_TIMEOUT":        &t.Idle,
+	} {
+		if v, err := time.ParseDuration(os.Getenv(key)); err == nil && v >= 0 {
+			*d = v
+		}
+	}
+
+	return t
+}
+
+// Server returns a server for handler enforcing the timeouts.
+func (t Timeouts) Server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler: t.wrap(handler),
+
+		ReadHeaderTimeout: t.ReadHeader,
+		ReadTimeout:       t.Read,
+		WriteTimeout:      t.Write,
+		IdleTimeout:       t.Idle,
+	}
+}
+
+// wrap moves the deadlines the server sets per request along: the read
+// deadline is lifted once the body is read, as it would otherwise cancel
+// responses that take longer, and the write deadline is pushed out before
+// every write. Event streams are flushed on every write and marked for
+// proxies in front not to buffer them.
+func (t Timeouts) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+
+		if t.Read > 0 {
+			if r.Body == nil || r.Body == http.NoBody {
+				rc.SetReadDeadline(time.Time{})
+			} else {
+				r.Body = &bodyReader{ReadCloser: r.Body, done: func() { rc.SetReadDeadline(time.Time{}) }}
+			}
+		}
+
+		sw := &streamWriter{ResponseWriter: w, rc: rc, timeout: t.Write}
+		sw.extend()
+
+		next.ServeHTTP(sw, r)
+
+		// The server still has to finish the response.
+		sw.extend()
+	})
+}
+
+// bodyReader calls done once the body has been read.
+type bodyReader struct {
+	io.ReadCloser
+
+	once sync.Once
+	done func()
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	if err == io.EOF {
+		b.once.Do(b.done)
+	}
+
+	return n, err
+}
+
+type streamWriter struct {
+	http.ResponseWriter
+
+	rc      *http.ResponseController
+	timeout time.Duration
+
+	wroteHeader bool
+	stream      bool
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.wroteHeader = true
+
+		if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediaType == "text/event-stream" {
+			w.stream = true
+			w.Header().Set("X-Accel-Buffering", "no")
+		}
+	}
+
+	w.extend()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.extend()
+
+	n, err := w.ResponseWriter.Write(p)
+
+	if err == nil && w.stream {
+		err = w.rc.Flush()
+	}
+
+	return n, err
+}
+
+func (w *streamWriter) Flush() {
+	w.extend()
+	w.rc.Flush()
+}
+
+// Hijack lifts the deadlines of connections taken over, e.g. by WebSockets.
+func (w *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.rc.Hijack()
+
+	if err == nil {
+		conn.SetDeadline(time.Time{})
+	}
+
+	return conn, brw, err
+}
+
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *streamWriter) extend() {
+	if w.timeout > 0 {
+		w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+}
@@ -0,0 +1,314 @@
+package voices
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/openapi"
+	"github.com/adrianliechti/wingman-chat/pkg/tenant"
+	"github.com/adrianliechti/wingman-chat/pkg/tokens"
+)
+
+// defaultPreviewText is spoken in voice previews unless tts.yaml sets one.
+const defaultPreviewText = "Hi! This is how I sound. How can I help you today?"
+
+// maxPreviews bounds the number of cached preview samples.
+const maxPreviews = 64
+
+// discoveryTTL is how long voices discovered upstream are reused.
+const discoveryTTL = 10 * time.Minute
+
+type voice struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Model   string `json:"model,omitempty"`
+	Preview string `json:"preview"`
+}
+
+type preview struct {
+	data        []byte
+	contentType string
+}
+
+type Handler struct {
+	client *http.Client
+
+	store *config.Store
+
+	url    *url.URL
+	tokens *tokens.Tokens
+
+	mu         sync.Mutex
+	previews   map[string]preview
+	discovered map[string][]voice
+	fetched    map[string]time.Time
+}
+
+// New talks to the platform through transport (http.DefaultTransport when
+// nil).
+func New(store *config.Store, url *url.URL, tokens *tokens.Tokens, transport http.RoundTripper) *Handler {
+	return &Handler{
+		client: &http.Client{Transport: transport},
+
+		store: store,
+
+		url:    url,
+		tokens: tokens,
+
+		previews:   make(map[string]preview),
+		discovered: make(map[string][]voice),
+		fetched:    make(map[string]time.Time),
+	}
+}
+
+func (h *Handler) Attach(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimRight(prefix, "/")
+
+	mux.HandleFunc("GET "+prefix+"/voices", func(w http.ResponseWriter, r *http.Request) {
+		h.handleList(w, r, prefix)
+	})
+
+	mux.HandleFunc("GET "+prefix+"/voices/{id}/preview", h.handlePreview)
+}
+
+func (h *Handler) Describe(spec *openapi.Spec, prefix string) {
+	prefix = strings.TrimRight(prefix, "/")
+
+	spec.Add("GET", prefix+"/voices", openapi.Operation{
+		Summary: "List the voices of the TTS model", Tag: "voices", Security: openapi.User,
+		Response: reflect.TypeFor[[]voice](),
+	})
+
+	spec.Add("GET", prefix+"/voices/{id}/preview", openapi.Operation{
+		Summary: "Synthesize a sample of a voice", Tag: "voices", Security: openapi.User,
+		Query:        []string{"model"},
+		Response:     map[string]any{"type": "string", "format": "binary"},
+		ResponseType: "audio/*",
+	})
+}
+
+// handleList returns the voices configured in tts.yaml merged with those the
+// upstream platform reports for the TTS model (when it supports listing).
+func (h *Handler) handleList(w http.ResponseWriter, r *http.Request, prefix string) {
+	tts := tenant.Config(r.Context(), h.store).TTS
+
+	result := []voice{}
+
+	if tts != nil {
+		seen := map[string]bool{}
+
+		for id, name := range tts.Voices {
+			seen[id] = true
+			result = append(result, voice{ID: id, Name: name, Model: tts.Model})
+		}
+
+		for _, v := range h.discover(r.Context(), tts.Model) {
+			if !seen[v.ID] {
+				result = append(result, v)
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	for i, v := range result {
+		query := url.Values{}
+
+		if v.Model != "" {
+			query.Set("model", v.Model)
+		}
+
+		result[i].Preview = prefix + "/voices/" + url.PathEscape(v.ID) + "/preview?" + query.Encode()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handlePreview synthesizes a short sample for a voice, cached per
+// model/voice/text.
+func (h *Handler) handlePreview(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	model := r.URL.Query().Get("model")
+	text := defaultPreviewText
+
+	if tts := tenant.Config(r.Context(), h.store).TTS; tts != nil {
+		if model == "" {
+			model = tts.Model
+		}
+
+		if tts.Preview != "" {
+			text = tts.Preview
+		}
+	}
+
+	key := model + "\x00" + id + "\x00" + text
+
+	h.mu.Lock()
+	p, ok := h.previews[key]
+	h.mu.Unlock()
+
+	if !ok {
+		var err error
+
+		if p, err = h.synthesize(r, model, id, text); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		h.mu.Lock()
+
+		if len(h.previews) >= maxPreviews {
+			for k := range h.previews {
+				delete(h.previews, k)
+				break
+			}
+		}
+
+		h.previews[key] = p
+		h.mu.Unlock()
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Type", p.contentType)
+	w.Write(p.data)
+}
+
+func (h *Handler) synthesize(r *http.Request, model, voice, text string) (preview, error) {
+	body, _ := json.Marshal(map[string]string{
+		"model": model,
+		"voice": voice,
+		"input": text,
+	})
+
+	req, err := h.request(r.Context(), http.MethodPost, "/v1/audio/speech", bytes.NewReader(body))
+
+	if err != nil {
+		return preview{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		return preview{}, err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return preview{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return preview{}, &upstreamError{resp.Status, strings.TrimSpace(string(data))}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+
+	if contentType == "" {
+		contentType = "audio/mpeg"
+	}
+
+	return preview{data, contentType}, nil
+}
+
+// discover asks the upstream for the voices of model. Platforms that don't
+// offer a voice listing simply contribute nothing.
+func (h *Handler) discover(ctx context.Context, model string) []voice {
+	h.mu.Lock()
+
+	if time.Since(h.fetched[model]) < discoveryTTL {
+		defer h.mu.Unlock()
+		return h.discovered[model]
+	}
+
+	h.fetched[model] = time.Now()
+	h.mu.Unlock()
+
+	var voices []voice
+
+	path := "/v1/audio/voices"
+
+	if model != "" {
+		path += "?model=" + url.QueryEscape(model)
+	}
+
+	req, err := h.request(ctx, http.MethodGet, path, nil)
+
+	if err == nil {
+		if resp, err := h.client.Do(req); err == nil {
+			defer resp.Body.Close()
+
+			var result struct {
+				Data []struct {
+					ID   string `json:"id"`
+					Name string `json:"name"`
+				} `json:"data"`
+			}
+
+			if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&result) == nil {
+				for _, v := range result.Data {
+					name := v.Name
+
+					if name == "" {
+						name = v.ID
+					}
+
+					voices = append(voices, voice{ID: v.ID, Name: name, Model: model})
+				}
+			}
+		}
+	}
+
+	h.mu.Lock()
+	h.discovered[model] = voices
+	h.mu.Unlock()
+
+	return voices
+}
+
+func (h *Handler) request(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(h.url.String(), "/")+path, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := h.tokens.Token()
+
+	if t := tenant.FromContext(ctx); t != nil && t.Token != "" {
+		token = t.Token
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+type upstreamError struct {
+	status  string
+	message string
+}
+
+func (e *upstreamError) Error() string {
+	return "upstream error (" + e.status + "): " + e.message
+}
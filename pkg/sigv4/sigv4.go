@@ -0,0 +1,194 @@
+// Package sigv4 signs requests to AWS services with Signature Version 4.
+//
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// FromEnv reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+func FromEnv() Credentials {
+	return Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Sign adds the Authorization header for service in region to req, whose
+// body is payload. Requests are left unsigned without an access key.
+func Sign(req *http.Request, payload []byte, creds Credentials, region, service string, now time.Time) {
+	if creds.AccessKey == "" {
+		return
+	}
+
+	now = now.UTC()
+
+	payloadHash := sha256.Sum256(payload)
+
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	req.Header.Set("Authorization", authorization(req, hex.EncodeToString(payloadHash[:]), creds, region, service, now))
+}
+
+// authorization returns the Authorization header of req as it is, signing
+// all its headers.
+func authorization(req *http.Request, payloadHash string, creds Credentials, region, service string, now time.Time) string {
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+
+	headers := map[string]string{"host": req.URL.Host}
+
+	for name, values := range req.Header {
+		if strings.EqualFold(name, "Authorization") {
+			continue
+		}
+
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	var canonical strings.Builder
+
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		canonicalPath(req, service),
+		canonicalQuery(req.URL.RawQuery),
+		canonical.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(request))
+
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	return "AWS4-HMAC-SHA256 Credential=" + creds.AccessKey + "/" + scope + ", SignedHeaders=" + signed + ", Signature=" + signature
+}
+
+// canonicalPath encodes the path as sent once more for services other than
+// S3, e.g. the %3A of Bedrock model ids as %253A.
+func canonicalPath(req *http.Request, service string) string {
+	path := req.URL.EscapedPath()
+
+	if path == "" {
+		return "/"
+	}
+
+	if service == "s3" {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+
+	for i, segment := range segments {
+		segments[i] = encode(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes the query parameters once each, sorted by name and
+// value.
+func canonicalQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+
+	var params [][2]string
+
+	for _, param := range strings.Split(query, "&") {
+		name, value, _ := strings.Cut(param, "=")
+
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+
+		params = append(params, [2]string{encode(name), encode(value)})
+	}
+
+	slices.SortFunc(params, func(a, b [2]string) int {
+		if c := strings.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a[1], b[1])
+	})
+
+	result := make([]string, len(params))
+
+	for i, p := range params {
+		result[i] = p[0] + "=" + p[1]
+	}
+
+	return strings.Join(result, "&")
+}
+
+// encode percent-encodes everything but the unreserved characters.
+func encode(s string) string {
+	var b strings.Builder
+
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,109 @@
+package sigv4
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The credentials, time and scope of AWS's Signature Version 4 test suite.
+var (
+	testCredentials = Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	testTime        = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+)
+
+// emptyHash is the SHA-256 of an empty payload.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestAuthorizationTestSuite(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		signed  string
+		want    string
+	}{
+		{"get-vanilla", "GET", "/", nil, "host;x-amz-date", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", "POST", "/", nil, "host;x-amz-date", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"get-vanilla-query-order-key-case", "GET", "/?Param2=value2&Param1=value1", nil, "host;x-amz-date", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-vanilla-empty-query-key", "GET", "/?Param1=value1", nil, "host;x-amz-date", "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{"post-vanilla-query", "POST", "/?Param1=value1", nil, "host;x-amz-date", "28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11"},
+		{"post-header-key-sort", "POST", "/", map[string]string{"My-Header1": "value1"}, "host;my-header1;x-amz-date", "c5410059b04c1ee005303aed430f6e6645f61f4dc9e1461ec8f8916fdf18852c"},
+		{"post-header-value-case", "POST", "/", map[string]string{"My-Header1": "VALUE1"}, "host;my-header1;x-amz-date", "cdbc9802e29d2942e5e10b5bccfdd67c5f22c7c4e8ae67b53629efa58b974b7d"},
+	} {
+		req := httptest.NewRequest(tc.method, "https://example.amazonaws.com"+tc.target, nil)
+		req.Header = http.Header{"X-Amz-Date": {"20150830T123600Z"}}
+
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+
+		got := authorization(req, emptyHash, testCredentials, "us-east-1", "service", testTime)
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" + tc.signed + ", Signature=" + tc.want
+
+		if got != want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, want)
+		}
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	for _, tc := range []struct {
+		target  string
+		service string
+		want    string
+	}{
+		{"", "bedrock", "/"},
+		{"/model/anthropic.claude-v2%3A1/invoke", "bedrock", "/model/anthropic.claude-v2%253A1/invoke"},
+		{"/example%20space/", "service", "/example%2520space/"},
+		{"/bucket/key%20name", "s3", "/bucket/key%20name"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com"+tc.target, nil)
+
+		if got := canonicalPath(req, tc.service); got != tc.want {
+			t.Errorf("%s %s: canonical path = %q, want %q", tc.service, tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/m/invoke", nil)
+
+	// Without credentials requests go out as they are, e.g. to an
+	// endpoint that doesn't need signing.
+	Sign(req, nil, Credentials{}, "us-east-1", "bedrock", testTime)
+
+	if h := req.Header.Get("Authorization"); h != "" {
+		t.Errorf("signed without credentials: %s", h)
+	}
+
+	creds := testCredentials
+	creds.SessionToken = "token"
+
+	Sign(req, []byte("{}"), creds, "us-east-1", "bedrock", testTime)
+	first := req.Header.Get("Authorization")
+
+	for header, want := range map[string]string{
+		"X-Amz-Date":           "20150830T123600Z",
+		"X-Amz-Content-Sha256": "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+		"X-Amz-Security-Token": "token",
+	} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	if want := "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,"; !strings.Contains(first, want) {
+		t.Errorf("authorization = %s, want %s", first, want)
+	}
+
+	// Signing again, e.g. for a retry, doesn't sign the old signature.
+	Sign(req, []byte("{}"), creds, "us-east-1", "bedrock", testTime)
+
+	if again := req.Header.Get("Authorization"); again != first {
+		t.Errorf("re-signed: %s, want %s", again, first)
+	}
+}
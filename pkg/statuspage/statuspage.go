@@ -0,0 +1,331 @@
+// Package statuspage follows the status pages of the providers behind the
+// platform (OpenAI, Anthropic and others hosted on Atlassian Statuspage), by
+// polling them and by receiving their webhooks, so an outage of a provider
+// can be told apart from one of the platform.
+package statuspage
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Indicators of the state of a page, from none to critical.
+const (
+	None     = "none"
+	Minor    = "minor"
+	Major    = "major"
+	Critical = "critical"
+)
+
+// Page is the status page of a provider; URL is its root, e.g.
+// https://status.openai.com.
+type Page struct {
+	Name string
+	URL  string
+}
+
+// Incident is the state of a provider that reports problems.
+type Incident struct {
+	Provider string `json:"provider"`
+
+	// Indicator is Minor, Major or Critical.
+	Indicator   string `json:"indicator"`
+	Description string `json:"description"`
+
+	// Incidents are the names of the unresolved incidents.
+	Incidents []string `json:"incidents,omitempty"`
+
+	Updated time.Time `json:"updated"`
+}
+
+// String describes the incident for users.
+func (i Incident) String() string {
+	s := i.Provider + " reports " + strings.ToLower(i.Description)
+
+	if len(i.Incidents) > 0 {
+		s += ": " + strings.Join(i.Incidents, "; ")
+	}
+
+	return s
+}
+
+type Monitor struct {
+	client *http.Client
+	pages  []Page
+
+	// Token authenticates the webhooks of the pages, passed as ?token=;
+	// webhooks are not accepted when empty.
+	Token string
+
+	mu    sync.RWMutex
+	state map[string]Incident
+}
+
+// FromEnv returns the monitor of the STATUS_PAGES (name=url, comma
+// separated), accepting webhooks with STATUS_WEBHOOK_TOKEN and polling
+// every STATUS_INTERVAL (default 5m; 0 for webhooks alone) until ctx is
+// done, or nil when there are none.
+func FromEnv(ctx context.Context) (*Monitor, error) {
+	entries := env.List("STATUS_PAGES")
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	var pages []Page
+
+	for _, entry := range entries {
+		name, u, ok := strings.Cut(entry, "=")
+
+		if !ok || name == "" || u == "" {
+			return nil, fmt.Errorf("STATUS_PAGES: invalid entry %q", entry)
+		}
+
+		pages = append(pages, Page{Name: name, URL: u})
+	}
+
+	m := New(pages)
+	m.Token = os.Getenv("STATUS_WEBHOOK_TOKEN")
+
+	interval := 5 * time.Minute
+
+	if d, err := time.ParseDuration(os.Getenv("STATUS_INTERVAL")); err == nil && d >= 0 {
+		interval = d
+	}
+
+	if interval > 0 {
+		m.Watch(ctx, interval)
+	}
+
+	return m, nil
+}
+
+func New(pages []Page) *Monitor {
+	return &Monitor{
+		client: &http.Client{Timeout: 15 * time.Second},
+		pages:  pages,
+
+		state: map[string]Incident{},
+	}
+}
+
+// Incidents returns the providers that currently report problems, by name.
+func (m *Monitor) Incidents() []Incident {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := []Incident{}
+
+	for _, i := range m.state {
+		if i.Indicator != None {
+			result = append(result, i)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b Incident) int { return strings.Compare(a.Provider, b.Provider) })
+
+	return result
+}
+
+// Check fails while a provider reports a major or critical outage.
+func (m *Monitor) Check(context.Context) error {
+	for _, i := range m.Incidents() {
+		if i.Indicator == Major || i.Indicator == Critical {
+			return errors.New(i.String())
+		}
+	}
+
+	return nil
+}
+
+// Watch polls the pages every interval until ctx is done.
+func (m *Monitor) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		for ctx.Err() == nil {
+			for _, p := range m.pages {
+				if err := m.poll(ctx, p); err != nil && ctx.Err() == nil {
+					slog.Warn("statuspage", "provider", p.Name, "error", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// summary is the part of /api/v2/summary.json of a Statuspage page that is
+// used.
+type summary struct {
+	Status struct {
+		Indicator   string `json:"indicator"`
+		Description string `json:"description"`
+	} `json:"status"`
+
+	Incidents []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	} `json:"incidents"`
+}
+
+func (m *Monitor) poll(ctx context.Context, p Page) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.URL, "/")+"/api/v2/summary.json", nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("status page answered " + resp.Status)
+	}
+
+	var s summary
+
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return err
+	}
+
+	i := Incident{
+		Provider: p.Name,
+
+		Indicator:   s.Status.Indicator,
+		Description: s.Status.Description,
+
+		Updated: time.Now(),
+	}
+
+	for _, incident := range s.Incidents {
+		if !resolved(incident.Status) {
+			i.Incidents = append(i.Incidents, incident.Name)
+		}
+	}
+
+	m.set(i)
+
+	return nil
+}
+
+func (m *Monitor) set(i Incident) {
+	if i.Indicator == "" {
+		i.Indicator = None
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous := m.state[i.Provider].Indicator
+
+	if previous == "" {
+		previous = None
+	}
+
+	if previous != i.Indicator {
+		slog.Warn("statuspage: incident", "provider", i.Provider, "indicator", i.Indicator, "description", i.Description)
+	}
+
+	m.state[i.Provider] = i
+}
+
+func resolved(status string) bool {
+	return status == "resolved" || status == "postmortem" || status == "completed"
+}
+
+// Wrap serves the webhooks of the pages at POST /statuspage/{name}, ahead of
+// authentication: Statuspage can't sign in, so it passes Token as ?token=.
+func (m *Monitor) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutPrefix(r.URL.Path, "/statuspage/")
+
+		if !ok || r.Method != http.MethodPost || m.Token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		m.handleWebhook(w, r, name)
+	})
+}
+
+// webhook is the part of the payload of a Statuspage webhook that is used;
+// incident updates carry the incident, component updates only the page.
+type webhook struct {
+	Page struct {
+		Indicator   string `json:"status_indicator"`
+		Description string `json:"status_description"`
+	} `json:"page"`
+
+	Incident *struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	} `json:"incident"`
+}
+
+func (m *Monitor) handleWebhook(w http.ResponseWriter, r *http.Request, name string) {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(m.Token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !slices.ContainsFunc(m.pages, func(p Page) bool { return p.Name == name }) {
+		http.Error(w, "unknown status page", http.StatusNotFound)
+		return
+	}
+
+	var payload webhook
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	incident := Incident{
+		Provider: name,
+
+		Indicator:   payload.Page.Indicator,
+		Description: payload.Page.Description,
+
+		Updated: time.Now(),
+	}
+
+	m.mu.RLock()
+	current := m.state[name]
+	m.mu.RUnlock()
+
+	// An update of one incident leaves the others open.
+	for _, n := range current.Incidents {
+		if payload.Incident == nil || n != payload.Incident.Name {
+			incident.Incidents = append(incident.Incidents, n)
+		}
+	}
+
+	if payload.Incident != nil && !resolved(payload.Incident.Status) {
+		incident.Incidents = append(incident.Incidents, payload.Incident.Name)
+	}
+
+	m.set(incident)
+
+	w.WriteHeader(http.StatusNoContent)
+}
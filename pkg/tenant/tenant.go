@@ -0,0 +1,273 @@
+// Package tenant lets a single deployment serve several tenants. Each tenant is
+// a subdirectory of the tenants path holding YAML files that are overlaid on
+// the base configuration, plus an optional tenant.yaml with tenant-only
+// settings such as the platform token.
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+type Tenant struct {
+	ID string
+
+	// Store holds the tenant's effective configuration.
+	Store *config.Store
+
+	// Token replaces the platform token for the tenant's proxied requests.
+	Token string
+
+	// AdminToken grants the tenant admin role of the admin API for this
+	// tenant.
+	AdminToken string
+}
+
+// settings is the content of tenant.yaml.
+type settings struct {
+	Token      string `yaml:"token,omitempty"`
+	AdminToken string `yaml:"adminToken,omitempty"`
+}
+
+// Registry resolves the tenant of a request from a header, or from the Host
+// when header is "Host".
+type Registry struct {
+	header string
+
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+}
+
+// New returns a registry without tenants.
+func New(header string) *Registry {
+	return &Registry{
+		header:  header,
+		tenants: make(map[string]*Tenant),
+	}
+}
+
+// FromEnv returns the registry resolving tenants from TENANT_HEADER, loaded
+// from TENANTS_PATH (default tenants) and overlaid on base, or nil when
+// TENANT_HEADER is not set. Without the directory the registry starts
+// empty if other tenants come later, e.g. from Kubernetes.
+func FromEnv(base *config.Config, later bool) (*Registry, error) {
+	header := os.Getenv("TENANT_HEADER")
+
+	if header == "" {
+		return nil, nil
+	}
+
+	r, err := Load(env.String("TENANTS_PATH", "tenants"), header, base)
+
+	if os.IsNotExist(err) && later {
+		return New(header), nil
+	}
+
+	return r, err
+}
+
+// Load reads every subdirectory of dir as a tenant overlaid on base.
+func Load(dir, header string, base *config.Config) (*Registry, error) {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := New(header)
+
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+
+		cfg, errs := config.Overlay(base, path)
+
+		for _, err := range errs {
+			slog.Warn("tenant", "tenant", e.Name(), "error", err)
+		}
+
+		if cfg == nil {
+			continue
+		}
+
+		var s settings
+
+		if data, err := os.ReadFile(filepath.Join(path, "tenant.yaml")); err == nil {
+			if err := config.Decode(data, &s); err != nil {
+				slog.Warn("tenant: tenant.yaml", "tenant", e.Name(), "error", err)
+			}
+		}
+
+		id := strings.ToLower(e.Name())
+
+		r.tenants[id] = &Tenant{
+			ID:    id,
+			Store: config.NewStore(cfg),
+			Token: s.Token,
+
+			AdminToken: s.AdminToken,
+		}
+	}
+
+	return r, nil
+}
+
+// Put adds or replaces the tenant id with the YAML files (keyed by file name,
+// as in a tenant directory) overlaid on base in order. Unchanged tenants are
+// left alone.
+func (r *Registry) Put(id string, base *config.Config, files ...map[string][]byte) []error {
+	id = strings.ToLower(id)
+
+	var errs []error
+	var s settings
+
+	cfg := base
+
+	for _, f := range files {
+		next, e := config.OverlayFiles(cfg, f)
+		errs = append(errs, e...)
+
+		if next == nil {
+			return errs
+		}
+
+		cfg = next
+
+		if data, ok := f["tenant.yaml"]; ok {
+			if err := config.Decode(data, &s); err != nil {
+				errs = append(errs, fmt.Errorf("tenant.yaml: %w", err))
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tenants[id]
+
+	if !ok {
+		r.tenants[id] = &Tenant{
+			ID:    id,
+			Store: config.NewStore(cfg),
+			Token: s.Token,
+
+			AdminToken: s.AdminToken,
+		}
+
+		return errs
+	}
+
+	if !reflect.DeepEqual(t.Store.Config(), cfg) {
+		t.Store.Update("tenant: "+id, func(c *config.Config) {
+			*c = *cfg
+		})
+	}
+
+	if t.Token != s.Token || t.AdminToken != s.AdminToken {
+		r.tenants[id] = &Tenant{
+			ID:    id,
+			Store: t.Store,
+			Token: s.Token,
+
+			AdminToken: s.AdminToken,
+		}
+	}
+
+	return errs
+}
+
+// Remove drops the tenant id; its requests fall back to the default
+// configuration.
+func (r *Registry) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.tenants, strings.ToLower(id))
+}
+
+// Tenants returns all known tenants.
+func (r *Registry) Tenants() []*Tenant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*Tenant, 0, len(r.tenants))
+
+	for _, t := range r.tenants {
+		result = append(result, t)
+	}
+
+	return result
+}
+
+// Resolve returns the tenant addressed by the request, or nil when the request
+// belongs to the default (base) configuration.
+func (r *Registry) Resolve(req *http.Request) *Tenant {
+	var id string
+
+	if strings.EqualFold(r.header, "Host") {
+		id = req.Host
+
+		if host, _, err := net.SplitHostPort(id); err == nil {
+			id = host
+		}
+	} else {
+		id = req.Header.Get(r.header)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.tenants[strings.ToLower(strings.TrimSpace(id))]
+}
+
+// Identify attaches the resolved tenant to the request context.
+func (r *Registry) Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if t := r.Resolve(req); t != nil {
+			req = req.WithContext(WithTenant(req.Context(), t))
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+type contextKey int
+
+const tenantKey contextKey = iota
+
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey, t)
+}
+
+// FromContext returns the tenant of the request, or nil for the default one.
+func FromContext(ctx context.Context) *Tenant {
+	if t, ok := ctx.Value(tenantKey).(*Tenant); ok {
+		return t
+	}
+
+	return nil
+}
+
+// Config returns the configuration for the request's tenant, falling back to
+// the default store.
+func Config(ctx context.Context, fallback *config.Store) *config.Config {
+	if t := FromContext(ctx); t != nil {
+		return t.Store.Config()
+	}
+
+	return fallback.Config()
+}
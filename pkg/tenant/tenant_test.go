@@ -0,0 +1,188 @@
+package tenant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"Acme/translator.yaml": "model: acme-translate\n",
+		"Acme/tenant.yaml":     "token: acme-token\nadminToken: acme-admin\n",
+		"globex/tts.yaml":      "model: globex-tts\n",
+		".hidden/tts.yaml":     "model: hidden\n",
+		"README.md":            "not a tenant",
+	})
+
+	base := &config.Config{Translator: &config.Translator{Model: "base-translate"}}
+
+	r, err := Load(dir, "X-Tenant", base)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(r.Tenants()); n != 2 {
+		t.Fatalf("tenants = %d, want acme and globex", n)
+	}
+
+	for _, tc := range []struct {
+		header     string
+		translator string
+		tts        bool
+		token      string
+	}{
+		{"acme", "acme-translate", false, "acme-token"},
+		{" ACME ", "acme-translate", false, "acme-token"},
+		{"globex", "base-translate", true, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/models", nil)
+		req.Header.Set("X-Tenant", tc.header)
+
+		tn := r.Resolve(req)
+
+		if tn == nil {
+			t.Errorf("%q: no tenant", tc.header)
+			continue
+		}
+
+		cfg := tn.Store.Config()
+
+		if cfg.Translator.Model != tc.translator || (cfg.TTS != nil) != tc.tts || tn.Token != tc.token {
+			t.Errorf("%q: tenant %s with %+v, token %q", tc.header, tn.ID, cfg.Translator, tn.Token)
+		}
+	}
+
+	if base.Translator.Model != "base-translate" {
+		t.Error("a tenant changed the base configuration")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		set    func(*http.Request)
+		want   string
+	}{
+		{"X-Tenant", func(r *http.Request) { r.Header.Set("X-Tenant", "acme") }, "acme"},
+		{"X-Tenant", func(r *http.Request) { r.Header.Set("X-Tenant", "initech") }, ""},
+		{"X-Tenant", func(r *http.Request) {}, ""},
+		{"Host", func(r *http.Request) { r.Host = "acme:8080" }, "acme"},
+		{"host", func(r *http.Request) { r.Host = "ACME" }, "acme"},
+		{"Host", func(r *http.Request) { r.Header.Set("X-Tenant", "acme") }, ""},
+	} {
+		r := New(tc.header)
+		r.Put("acme", &config.Config{})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "chat.example.com"
+		tc.set(req)
+
+		var got string
+
+		r.Identify(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if tn := FromContext(req.Context()); tn != nil {
+				got = tn.ID
+			}
+		})).ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != tc.want {
+			t.Errorf("%s: tenant = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestPut(t *testing.T) {
+	r := New("X-Tenant")
+	base := &config.Config{}
+
+	if errs := r.Put("Acme", base, map[string][]byte{"tts.yaml": []byte("model: tts-1\n"), "tenant.yaml": []byte("token: one\n")}); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	first := r.Tenants()[0]
+	snapshot := first.Store.Config()
+
+	// Putting the same files again leaves the tenant alone.
+	r.Put("acme", base, map[string][]byte{"tts.yaml": []byte("model: tts-1\n"), "tenant.yaml": []byte("token: one\n")})
+
+	if r.Tenants()[0] != first || first.Store.Config() != snapshot {
+		t.Error("an unchanged tenant was replaced")
+	}
+
+	// A changed configuration updates the store in place, a changed token
+	// the tenant.
+	r.Put("acme", base, map[string][]byte{"tts.yaml": []byte("model: tts-2\n"), "tenant.yaml": []byte("token: two\n")})
+
+	second := r.Tenants()[0]
+
+	if second.Store != first.Store || second.Store.Config().TTS.Model != "tts-2" || second.Token != "two" {
+		t.Errorf("tenant = %+v", second)
+	}
+
+	if errs := r.Put("acme", base, map[string][]byte{"tts.yaml": []byte("voice: alloy\n")}); len(errs) == 0 {
+		t.Error("an unknown key was accepted")
+	}
+
+	r.Remove("ACME")
+
+	if len(r.Tenants()) != 0 {
+		t.Error("the tenant wasn't removed")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	fallback := config.NewStore(&config.Config{Disclaimer: "default"})
+	acme := &Tenant{ID: "acme", Store: config.NewStore(&config.Config{Disclaimer: "acme"})}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if got := Config(req.Context(), fallback).Disclaimer; got != "default" {
+		t.Errorf("disclaimer = %q without a tenant", got)
+	}
+
+	if got := Config(WithTenant(req.Context(), acme), fallback).Disclaimer; got != "acme" {
+		t.Errorf("disclaimer = %q for acme", got)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	if r, err := FromEnv(&config.Config{}, false); r != nil || err != nil {
+		t.Errorf("registry = %v, %v without TENANT_HEADER", r, err)
+	}
+
+	t.Setenv("TENANT_HEADER", "X-Tenant")
+	t.Setenv("TENANTS_PATH", filepath.Join(t.TempDir(), "missing"))
+
+	if _, err := FromEnv(&config.Config{}, false); err == nil {
+		t.Error("a missing tenants directory was accepted")
+	}
+
+	// Tenants may come later, e.g. from Kubernetes.
+	if r, err := FromEnv(&config.Config{}, true); r == nil || err != nil {
+		t.Errorf("registry = %v, %v", r, err)
+	}
+}
@@ -0,0 +1,246 @@
+// Package tokens holds the platform tokens. Several tokens may be
+// configured, e.g. the old and the new one during a key rotation; the
+// current one is used until the platform rejects it with 401, and sources
+// are read again periodically, so tokens change without a restart.
+package tokens
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/config"
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+)
+
+// Source reads the tokens, in order of preference.
+type Source func(ctx context.Context) ([]string, error)
+
+// File reads one token per line from path; empty lines and lines starting
+// with # are skipped.
+func File(path string) Source {
+	return func(ctx context.Context) ([]string, error) {
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var tokens []string
+
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+
+			if line != "" && !strings.HasPrefix(line, "#") {
+				tokens = append(tokens, line)
+			}
+		}
+
+		return tokens, nil
+	}
+}
+
+type Tokens struct {
+	mu      sync.RWMutex
+	tokens  []string
+	current int
+}
+
+// New returns fixed tokens; empty ones are skipped.
+func New(tokens ...string) *Tokens {
+	t := &Tokens{}
+	t.set(tokens)
+
+	return t
+}
+
+// FromEnv returns the platform tokens: those in WINGMAN_TOKEN_FILE, read
+// again every WINGMAN_TOKEN_REFRESH (default 1m) until ctx is done, or else
+// the comma separated WINGMAN_TOKEN or OPENAI_API_KEY.
+func FromEnv(ctx context.Context) (*Tokens, error) {
+	if path := os.Getenv("WINGMAN_TOKEN_FILE"); path != "" {
+		return Watch(ctx, File(path), env.Duration("WINGMAN_TOKEN_REFRESH", time.Minute))
+	}
+
+	var tokens []string
+
+	for _, v := range strings.Split(config.PlatformToken(), ",") {
+		tokens = append(tokens, strings.TrimSpace(v))
+	}
+
+	return New(tokens...), nil
+}
+
+// Watch reads source, then again every interval until ctx is done. Reading
+// it the first time must succeed.
+func Watch(ctx context.Context, source Source, interval time.Duration) (*Tokens, error) {
+	tokens, err := source(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("tokens: source holds no tokens")
+	}
+
+	t := New(tokens...)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			tokens, err := source(ctx)
+
+			if err == nil && len(tokens) == 0 {
+				err = errors.New("source holds no tokens")
+			}
+
+			if err != nil {
+				// Keep the tokens we have.
+				slog.Warn("tokens: refresh", "error", err)
+				continue
+			}
+
+			t.set(tokens)
+		}
+	}()
+
+	return t, nil
+}
+
+// set replaces the tokens, staying with the current one if it is kept.
+func (t *Tokens) set(tokens []string) {
+	tokens = slices.DeleteFunc(slices.Clone(tokens), func(s string) bool { return s == "" })
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := ""
+
+	if len(t.tokens) > 0 {
+		current = t.tokens[t.current]
+	}
+
+	t.tokens = tokens
+	t.current = max(slices.Index(tokens, current), 0)
+}
+
+// Token returns the current token, or "" if there is none.
+func (t *Tokens) Token() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if len(t.tokens) == 0 {
+		return ""
+	}
+
+	return t.tokens[t.current]
+}
+
+// Rotate switches to the next token if rejected is the current one, and
+// returns the token to use now.
+func (t *Tokens) Rotate(rejected string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.tokens) == 0 {
+		return ""
+	}
+
+	if t.tokens[t.current] == rejected && len(t.tokens) > 1 {
+		slog.Warn("tokens: platform rejected token, switching to the next", "token", t.current+1, "tokens", len(t.tokens))
+		t.current = (t.current + 1) % len(t.tokens)
+	}
+
+	return t.tokens[t.current]
+}
+
+func (t *Tokens) has(token string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return slices.Contains(t.tokens, token)
+}
+
+// Transport retries requests made with one of the tokens once with the next
+// token when the platform answers 401. Requests with other credentials
+// (e.g. users' own keys) are passed through unchanged.
+func (t *Tokens) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok || token == "" || !t.has(token) {
+			return next.RoundTrip(r)
+		}
+
+		resp, err := next.RoundTrip(r)
+
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+
+		retry := t.Rotate(token)
+
+		if retry == token {
+			return resp, nil
+		}
+
+		var body io.ReadCloser = http.NoBody
+
+		if r.Body != nil && r.Body != http.NoBody {
+			if r.GetBody == nil {
+				// Can't send the body again.
+				return resp, nil
+			}
+
+			b, err := r.GetBody()
+
+			if err != nil {
+				return resp, nil
+			}
+
+			body = b
+		}
+
+		drain(resp)
+
+		r = r.Clone(r.Context())
+		r.Body = body
+		r.Header.Set("Authorization", "Bearer "+retry)
+
+		return next.RoundTrip(r)
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// drain reads a bit of the rejected response so its connection can be
+// reused.
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+}
@@ -10,6 +10,11 @@ const COLLECTION = "chats";
 // Each chat is stored as: /chats/{id}/chat.json with blobs in /chats/{id}/blobs/
 
 async function storeChat(chat: Chat): Promise<void> {
+  // Ephemeral sessions (guest links) keep chats in memory only
+  if (getConfig().chat?.ephemeral) {
+    return;
+  }
+
   try {
     // Extract blobs and store in chat's folder (blobs go to /chats/{id}/blobs/)
     const stored = await opfs.extractChatBlobs(chat);
@@ -122,6 +127,11 @@ export function useChats() {
   useEffect(() => {
     async function load() {
       try {
+        // Ephemeral sessions start empty and leave stored chats alone
+        if (getConfig().chat?.ephemeral) {
+          return;
+        }
+
         // Load index first
         const index = await loadChatIndex();
 
@@ -1,5 +1,5 @@
 import { useMatch, useNavigate } from "@tanstack/react-router";
-import { AppWindow, ArrowDown, ChevronLeft, Info, Plus as PlusIcon, Shapes } from "lucide-react";
+import { AppWindow, ArrowDown, ChevronLeft, Info, Plus as PlusIcon, Shapes, TriangleAlert } from "lucide-react";
 import { useCallback, useEffect, useLayoutEffect, useMemo, useRef, useState } from "react";
 import { AgentDrawer } from "@/features/agent/components/AgentDrawer";
 import { SkillCatalog } from "@/features/agent/components/SkillCatalog";
@@ -60,6 +60,32 @@ const Disclaimer = () => {
   );
 };
 
+// Features the server turned off while their services fail
+const DegradedNotice = () => {
+  const outages = useMemo(() => {
+    try {
+      return getConfig().degraded;
+    } catch {
+      return [];
+    }
+  }, []);
+
+  if (outages.length === 0) return null;
+
+  return (
+    <div className="mb-6 mx-auto max-w-2xl">
+      {outages.map((outage) => (
+        <div key={outage.feature} className="flex items-start justify-center gap-2 px-4 py-1">
+          <TriangleAlert size={16} className="text-amber-500 dark:text-amber-400 shrink-0" />
+          <div className="text-xs text-neutral-600 dark:text-neutral-400 text-left">
+            {outage.message ?? `${outage.feature} is temporarily unavailable`}
+          </div>
+        </div>
+      ))}
+    </div>
+  );
+};
+
 export function ChatPage() {
   const { messages, selectChat, chat, chats, chatsLoaded, isResponding, model, models, setModel } = useChat();
   const { isListening, stopVoice } = useVoice();
@@ -534,6 +560,7 @@ export function ChatPage() {
                 style={{ paddingBottom: chatInputHeight }}
               >
                 <Disclaimer />
+                <DegradedNotice />
 
                 <div>
                   {renderUnits.map((unit) => {
@@ -190,6 +190,7 @@ export interface CompactionConfig {
 interface ChatConfig {
   instructions?: string;
   retentionDays?: number;
+  ephemeral?: boolean;
   optimizer?: string;
   summarizer?: string;
   compaction?: CompactionConfig;
@@ -208,6 +209,20 @@ interface BridgeConfig {
   url?: string;
 }
 
+/** A feature the server turned off while its service fails. */
+export interface OutageConfig {
+  feature: string;
+  message?: string;
+}
+
+/** The data residency policy the server enforces. */
+export interface ResidencyConfig {
+  region?: string;
+  regions?: string[];
+  upstreams?: string[];
+  storage?: string[];
+}
+
 interface ConfigSchema {
   title: string;
   disclaimer: string;
@@ -246,6 +261,9 @@ interface ConfigSchema {
 
   chat?: ChatConfig;
   telemetry?: object;
+
+  residency?: ResidencyConfig;
+  degraded?: OutageConfig[];
 }
 
 const DEFAULT_TTS_VOICES: Record<string, string> = {
@@ -300,6 +318,9 @@ interface Config {
 
   telemetry: boolean;
 
+  residency: ResidencyConfig | null;
+  degraded: OutageConfig[];
+
   backgrounds: BackgroundPackConfig;
 }
 
@@ -381,6 +402,9 @@ export const loadConfig = async (): Promise<Config | undefined> => {
 
       telemetry: cfg.telemetry != null,
 
+      residency: cfg.residency ?? null,
+      degraded: cfg.degraded ?? [],
+
       backgrounds: cfg.backgrounds ?? {},
     };
 
@@ -537,6 +537,8 @@ export class Client {
       JSON.stringify({ categories, risks, history }),
       schema,
       "classify_chat",
+      undefined,
+      true,
     );
     return {
       title: result?.title ?? null,
@@ -869,19 +871,24 @@ export class Client {
     schema: T,
     name: string,
     parentContext?: AgentContext,
+    // Background calls (e.g. titles) queue behind interactive ones on the server.
+    background = false,
   ): Promise<z.infer<T> | null> {
     return traceGenAI(
       name,
       model,
       async () => {
         try {
-          const response = await this.oai.responses.parse({
-            model,
-            instructions,
-            input,
-            truncation: "auto",
-            text: { format: zodTextFormat(schema, name) },
-          });
+          const response = await this.oai.responses.parse(
+            {
+              model,
+              instructions,
+              input,
+              truncation: "auto",
+              text: { format: zodTextFormat(schema, name) },
+            },
+            background ? { headers: { "X-Priority": "background" } } : undefined,
+          );
           return { result: response.output_parsed ?? null };
         } catch (error) {
           if (isAbortError(error)) throw error;
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/wingman-chat/pkg/env"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serverTLS returns the TLS settings to serve with, or nil to serve plain
+// HTTP. TLS_CERT and TLS_KEY name certificate files; TLS_ACME_HOSTS instead
+// obtains certificates from an ACME CA (Let's Encrypt unless
+// TLS_ACME_DIRECTORY is set) for the listed host names only. The handler, when
+// not nil, answers plain HTTP on TLS_REDIRECT_ADDR: ACME http-01 challenges
+// and redirects to HTTPS.
+func serverTLS() (*tls.Config, http.Handler, error) {
+	certFile := os.Getenv("TLS_CERT")
+	keyFile := os.Getenv("TLS_KEY")
+	hosts := env.List("TLS_ACME_HOSTS")
+
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	switch {
+	case len(hosts) > 0:
+		if certFile != "" || keyFile != "" {
+			return nil, nil, errors.New("TLS_CERT/TLS_KEY and TLS_ACME_HOSTS are mutually exclusive")
+		}
+
+		cache := os.Getenv("TLS_ACME_CACHE")
+
+		if cache == "" {
+			cache = "certs"
+		}
+
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cache),
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Email:      os.Getenv("TLS_ACME_EMAIL"),
+		}
+
+		if u := os.Getenv("TLS_ACME_DIRECTORY"); u != "" {
+			m.Client = &acme.Client{DirectoryURL: u}
+		}
+
+		// TLSConfig also answers tls-alpn-01 challenges, so port 80 is optional.
+		return m.TLSConfig(), m.HTTPHandler(redirect), nil
+
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, nil, errors.New("TLS_CERT and TLS_KEY must be set together")
+		}
+
+		c, err := newCertFile(certFile, keyFile)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: c.get,
+		}, redirect, nil
+	}
+
+	return nil, nil, nil
+}
+
+// certFile serves a certificate from files and reloads it when they change,
+// e.g. after a renewal by cert-manager or certbot.
+type certFile struct {
+	certPath string
+	keyPath  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+func newCertFile(certPath, keyPath string) (*certFile, error) {
+	c := &certFile{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *certFile) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) > time.Minute {
+		c.checked = time.Now()
+
+		if info, err := os.Stat(c.certPath); err == nil && info.ModTime().After(c.modified) {
+			if err := c.load(); err != nil {
+				slog.Error("tls", "error", err)
+			}
+		}
+	}
+
+	return c.cert, nil
+}
+
+func (c *certFile) load() error {
+	info, err := os.Stat(c.certPath)
+
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+
+	if err != nil {
+		return err
+	}
+
+	c.cert = &cert
+	c.modified = info.ModTime()
+
+	return nil
+}